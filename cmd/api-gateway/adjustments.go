@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"credit-authorization-ledger/internal/transport"
+	"credit-authorization-ledger/pkg/events"
+)
+
+// incrementHandler accepts a request to raise the held amount of an existing authorization.
+func incrementHandler(p transport.Publisher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req events.IncrementalAuthorizationRequested
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.TransactionID == "" || req.Amount <= 0 {
+			http.Error(w, "TransactionID and a positive Amount are required", http.StatusBadRequest)
+			return
+		}
+
+		payload, err := json.Marshal(req)
+		if err != nil {
+			log.Printf("ERROR: Failed to serialize incremental authorization: %v", err)
+			http.Error(w, "Failed to serialize request", http.StatusInternalServerError)
+			return
+		}
+
+		err = p.Publish(r.Context(), "incremental-authorization-requested", req.TransactionID, payload)
+		if err != nil {
+			log.Printf("ERROR: Failed to publish incremental authorization: %v", err)
+			http.Error(w, "Failed to publish incremental authorization", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("Accepted incremental authorization for transaction: %s", req.TransactionID)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("Incremental authorization accepted"))
+	})
+}
+
+// reversalHandler accepts a request to void part or all of an authorization.
+// Omitting the amount reverses whatever is still held.
+func reversalHandler(p transport.Publisher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req events.AuthorizationReversalRequested
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.TransactionID == "" || req.Amount < 0 {
+			http.Error(w, "TransactionID is required and Amount cannot be negative", http.StatusBadRequest)
+			return
+		}
+
+		payload, err := json.Marshal(req)
+		if err != nil {
+			log.Printf("ERROR: Failed to serialize reversal: %v", err)
+			http.Error(w, "Failed to serialize request", http.StatusInternalServerError)
+			return
+		}
+
+		err = p.Publish(r.Context(), "authorization-reversal-requested", req.TransactionID, payload)
+		if err != nil {
+			log.Printf("ERROR: Failed to publish reversal: %v", err)
+			http.Error(w, "Failed to publish reversal", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("Accepted reversal for transaction: %s", req.TransactionID)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("Authorization reversal accepted"))
+	})
+}
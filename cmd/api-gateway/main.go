@@ -5,8 +5,9 @@ import (
 	"log"
 	"net/http"
 
+	"credit-authorization-ledger/internal/config"
 	"credit-authorization-ledger/internal/idempotency"
-	"credit-authorization-ledger/internal/kafka"
+	"credit-authorization-ledger/internal/transport"
 	"credit-authorization-ledger/pkg/events"
 
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -14,6 +15,8 @@ import (
 )
 
 func main() {
+	cfg := config.Load()
+
 	// Initialize AWS session for DynamoDB
 	sess := session.Must(session.NewSessionWithOptions(session.Options{
 		// In a real app, you'd configure the endpoint for local vs. cloud
@@ -24,17 +27,27 @@ func main() {
 	dynamoClient := dynamodb.New(sess)
 	idempotencyStore := idempotency.NewDynamoDBStore(dynamoClient, "idempotency_keys")
 
-	// Initialize Kafka Producer
-	// The broker address is typically loaded from config, but hardcoded here for simplicity in main.
-	kafkaProducer, err := kafka.NewProducer([]string{"kafka:9092"})
+	// Requests start sagas over Kafka or SQS, as MESSAGE_TRANSPORT selects.
+	publisher, err := transport.NewPublisher(transport.Config{
+		Kind:     cfg.MessageTransport,
+		Brokers:  cfg.KafkaBrokers,
+		TopicARN: cfg.SNSTopicARN,
+		Region:   cfg.AWSRegion,
+		Endpoint: cfg.AWSEndpoint,
+	})
 	if err != nil {
-		log.Fatalf("could not create kafka producer: %v", err)
+		log.Fatalf("could not create publisher: %v", err)
 	}
-	defer kafkaProducer.Close()
+	defer publisher.Close()
 
 	// Create the final handler, wrapping the business logic with idempotency middleware
-	finalHandler := idempotency.Middleware(idempotencyStore)(authorizeHandler(kafkaProducer))
-	http.Handle("/authorize", finalHandler)
+	withIdempotency := idempotency.Middleware(idempotencyStore)
+	http.Handle("/authorize", withIdempotency(authorizeHandler(publisher)))
+	http.Handle("/authorize/increment", withIdempotency(incrementHandler(publisher)))
+	http.Handle("/authorize/reverse", withIdempotency(reversalHandler(publisher)))
+	http.Handle("/refunds", withIdempotency(refundHandler(publisher)))
+	http.Handle("/payments", withIdempotency(paymentHandler(publisher)))
+	http.Handle("/payments/return", withIdempotency(paymentReturnHandler(publisher)))
 
 	log.Println("API Gateway listening on :8080")
 	if err := http.ListenAndServe(":8080", nil); err != nil {
@@ -42,8 +55,8 @@ func main() {
 	}
 }
 
-// authorizeHandler now accepts the Kafka producer to publish messages.
-func authorizeHandler(p *kafka.Producer) http.Handler {
+// authorizeHandler publishes authorization requests through p.
+func authorizeHandler(p transport.Publisher) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var req events.AuthorizationRequested
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -56,21 +69,25 @@ func authorizeHandler(p *kafka.Producer) http.Handler {
 			http.Error(w, "TransactionID and UserID are required", http.StatusBadRequest)
 			return
 		}
+		if req.Currency != "" && len(req.Currency) != 3 {
+			http.Error(w, "Currency must be a three-letter ISO 4217 code", http.StatusBadRequest)
+			return
+		}
 
-		// Marshal the event struct into a JSON payload for Kafka.
+		// Marshal the event struct into a JSON payload.
 		payload, err := json.Marshal(req)
 		if err != nil {
-			log.Printf("ERROR: Failed to serialize request for Kafka: %v", err)
+			log.Printf("ERROR: Failed to serialize request: %v", err)
 			http.Error(w, "Failed to serialize request", http.StatusInternalServerError)
 			return
 		}
 
 		// Publish the event to the initial topic that the SAGA orchestrator listens to.
-		// The transaction ID is used as the Kafka message key to ensure related events
+		// The transaction ID is used as the message key to ensure related events
 		// are processed in order by the same partition if needed.
 		err = p.Publish(r.Context(), "credit-authorization-requested", req.TransactionID, payload)
 		if err != nil {
-			log.Printf("ERROR: Failed to publish authorization request to Kafka: %v", err)
+			log.Printf("ERROR: Failed to publish authorization request: %v", err)
 			http.Error(w, "Failed to publish authorization request", http.StatusInternalServerError)
 			return
 		}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"credit-authorization-ledger/internal/transport"
+	"credit-authorization-ledger/pkg/events"
+)
+
+// paymentHandler starts a payment saga for a cardholder paying down their
+// balance. Clients may supply their own payment_id; otherwise one is
+// generated and returned.
+func paymentHandler(p transport.Publisher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req events.PaymentRequested
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.UserID == "" || req.Amount <= 0 {
+			http.Error(w, "UserID and a positive Amount are required", http.StatusBadRequest)
+			return
+		}
+		if req.PaymentID == "" {
+			id, err := newID()
+			if err != nil {
+				log.Printf("ERROR: Failed to generate payment ID: %v", err)
+				http.Error(w, "Failed to generate payment ID", http.StatusInternalServerError)
+				return
+			}
+			req.PaymentID = id
+		}
+
+		payload, err := json.Marshal(req)
+		if err != nil {
+			log.Printf("ERROR: Failed to serialize payment: %v", err)
+			http.Error(w, "Failed to serialize request", http.StatusInternalServerError)
+			return
+		}
+
+		// Keyed by cardholder so their payments are allocated in order.
+		err = p.Publish(r.Context(), "payment-requested", req.UserID, payload)
+		if err != nil {
+			log.Printf("ERROR: Failed to publish payment: %v", err)
+			http.Error(w, "Failed to publish payment", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("Accepted payment %s for cardholder: %s", req.PaymentID, req.UserID)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(fmt.Sprintf("Payment %s accepted", req.PaymentID)))
+	})
+}
+
+// paymentReturnHandler reverses a payment that was returned by the paying bank.
+func paymentReturnHandler(p transport.Publisher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req events.PaymentReturnRequested
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.PaymentID == "" || req.UserID == "" {
+			http.Error(w, "PaymentID and UserID are required", http.StatusBadRequest)
+			return
+		}
+
+		payload, err := json.Marshal(req)
+		if err != nil {
+			log.Printf("ERROR: Failed to serialize payment return: %v", err)
+			http.Error(w, "Failed to serialize request", http.StatusInternalServerError)
+			return
+		}
+
+		// Keyed by cardholder, like the payment, so a return is never handled
+		// before the payment it reverses.
+		err = p.Publish(r.Context(), "payment-return-requested", req.UserID, payload)
+		if err != nil {
+			log.Printf("ERROR: Failed to publish payment return: %v", err)
+			http.Error(w, "Failed to publish payment return", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("Accepted return of payment: %s", req.PaymentID)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("Payment return accepted"))
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"credit-authorization-ledger/internal/transport"
+	"credit-authorization-ledger/pkg/events"
+)
+
+// refundHandler starts a refund saga for a captured transaction. Clients may
+// supply their own refund_id; otherwise one is generated and returned.
+func refundHandler(p transport.Publisher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req events.RefundRequested
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.TransactionID == "" || req.Amount <= 0 {
+			http.Error(w, "TransactionID and a positive Amount are required", http.StatusBadRequest)
+			return
+		}
+		if req.RefundID == "" {
+			id, err := newID()
+			if err != nil {
+				log.Printf("ERROR: Failed to generate refund ID: %v", err)
+				http.Error(w, "Failed to generate refund ID", http.StatusInternalServerError)
+				return
+			}
+			req.RefundID = id
+		}
+
+		payload, err := json.Marshal(req)
+		if err != nil {
+			log.Printf("ERROR: Failed to serialize refund: %v", err)
+			http.Error(w, "Failed to serialize request", http.StatusInternalServerError)
+			return
+		}
+
+		// Keyed by the original transaction so the refund is ordered after its authorization.
+		err = p.Publish(r.Context(), "refund-requested", req.TransactionID, payload)
+		if err != nil {
+			log.Printf("ERROR: Failed to publish refund: %v", err)
+			http.Error(w, "Failed to publish refund", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("Accepted refund %s for transaction: %s", req.RefundID, req.TransactionID)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(fmt.Sprintf("Refund %s accepted", req.RefundID)))
+	})
+}
+
+// newID returns a random 128-bit identifier in hex.
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
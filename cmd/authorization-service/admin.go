@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"credit-authorization-ledger/internal/authorization"
+)
+
+type reviewRequest struct {
+	TransactionID string `json:"transaction_id"`
+	Reviewer      string `json:"reviewer"`
+	Notes         string `json:"notes"`
+}
+
+// decodeReviewRequest reads a POSTed reviewRequest, writing an error
+// response and returning false if it is unusable.
+func decodeReviewRequest(w http.ResponseWriter, r *http.Request) (reviewRequest, bool) {
+	var req reviewRequest
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return req, false
+	}
+	if req.TransactionID == "" || req.Reviewer == "" {
+		http.Error(w, "TransactionID and Reviewer are required", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+// writeReviewError maps review queue errors onto HTTP status codes.
+func writeReviewError(w http.ResponseWriter, transactionID string, err error) {
+	switch {
+	case errors.Is(err, authorization.ErrReviewNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, authorization.ErrReviewNotPending), errors.Is(err, authorization.ErrReviewClaimed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("ERROR: Review operation failed for %s: %v", transactionID, err)
+		http.Error(w, "Review operation failed", http.StatusInternalServerError)
+	}
+}
+
+// listReviewsHandler returns the review queue as JSON. Undecided reviews are
+// listed unless ?status= asks for a specific one.
+func listReviewsHandler(svc *authorization.Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		items, err := svc.ListReviews(r.Context(), r.URL.Query().Get("status"), limit)
+		if err != nil {
+			log.Printf("ERROR: Failed to list reviews: %v", err)
+			http.Error(w, "Failed to list reviews", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	})
+}
+
+// claimReviewHandler assigns a review to a reviewer so others cannot decide it.
+func claimReviewHandler(svc *authorization.Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeReviewRequest(w, r)
+		if !ok {
+			return
+		}
+
+		if err := svc.ClaimReview(r.Context(), req.TransactionID, req.Reviewer); err != nil {
+			writeReviewError(w, req.TransactionID, err)
+			return
+		}
+
+		log.Printf("Review for transaction %s claimed by %s", req.TransactionID, req.Reviewer)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Review claimed"))
+	})
+}
+
+// reviewDecisionHandler approves or declines a queued authorization with
+// the reviewer's notes, resuming its saga.
+func reviewDecisionHandler(svc *authorization.Service, approve bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeReviewRequest(w, r)
+		if !ok {
+			return
+		}
+
+		err := svc.DecideReview(r.Context(), authorization.ReviewDecision{
+			TransactionID: req.TransactionID,
+			Reviewer:      req.Reviewer,
+			Approve:       approve,
+			Notes:         req.Notes,
+		})
+		if err != nil {
+			writeReviewError(w, req.TransactionID, err)
+			return
+		}
+
+		log.Printf("Review decision (approve: %t) by %s applied for transaction: %s", approve, req.Reviewer, req.TransactionID)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Review decision applied"))
+	})
+}
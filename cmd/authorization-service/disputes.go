@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"credit-authorization-ledger/internal/authorization"
+)
+
+type openDisputeRequest struct {
+	TransactionID string  `json:"transaction_id"`
+	Amount        float64 `json:"amount"`
+	Reason        string  `json:"reason"`
+}
+
+type disputeActionRequest struct {
+	DisputeID string `json:"dispute_id"`
+	Notes     string `json:"notes"`
+}
+
+// writeDisputeError maps dispute errors onto HTTP status codes.
+func writeDisputeError(w http.ResponseWriter, id string, err error) {
+	switch {
+	case errors.Is(err, authorization.ErrDisputeNotFound), errors.Is(err, authorization.ErrDisputeNotCaptured):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, authorization.ErrDisputeInvalidAmount):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, authorization.ErrDisputeNotPending), errors.Is(err, authorization.ErrDisputeInvalidTransition):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("ERROR: Dispute operation failed for %s: %v", id, err)
+		http.Error(w, "Dispute operation failed", http.StatusInternalServerError)
+	}
+}
+
+func writeDispute(w http.ResponseWriter, d authorization.Dispute) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+// disputesHandler lists disputes on GET, undecided ones unless ?status= asks
+// for a specific status, and opens a dispute on POST.
+func disputesHandler(svc *authorization.Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			listDisputes(svc, w, r)
+		case http.MethodPost:
+			openDispute(svc, w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func listDisputes(svc *authorization.Service, w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	items, err := svc.ListDisputes(r.Context(), r.URL.Query().Get("status"), limit)
+	if err != nil {
+		log.Printf("ERROR: Failed to list disputes: %v", err)
+		http.Error(w, "Failed to list disputes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+func openDispute(svc *authorization.Service, w http.ResponseWriter, r *http.Request) {
+	var req openDisputeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.TransactionID == "" || req.Reason == "" {
+		http.Error(w, "TransactionID and Reason are required", http.StatusBadRequest)
+		return
+	}
+
+	d, err := svc.OpenDispute(r.Context(), req.TransactionID, req.Amount, req.Reason)
+	if err != nil {
+		writeDisputeError(w, req.TransactionID, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeDispute(w, d)
+}
+
+// decodeDisputeAction reads a POSTed disputeActionRequest, writing an error
+// response and returning false if it is unusable.
+func decodeDisputeAction(w http.ResponseWriter, r *http.Request) (disputeActionRequest, bool) {
+	var req disputeActionRequest
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return req, false
+	}
+	if req.DisputeID == "" {
+		http.Error(w, "DisputeID is required", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+// requestEvidenceHandler asks the cardholder for evidence on an opened dispute.
+func requestEvidenceHandler(svc *authorization.Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeDisputeAction(w, r)
+		if !ok {
+			return
+		}
+
+		d, err := svc.RequestEvidence(r.Context(), req.DisputeID, req.Notes)
+		if err != nil {
+			writeDisputeError(w, req.DisputeID, err)
+			return
+		}
+		writeDispute(w, d)
+	})
+}
+
+// resolveDisputeHandler decides a dispute for or against the cardholder.
+func resolveDisputeHandler(svc *authorization.Service, won bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeDisputeAction(w, r)
+		if !ok {
+			return
+		}
+
+		d, err := svc.ResolveDispute(r.Context(), req.DisputeID, won, req.Notes)
+		if err != nil {
+			writeDisputeError(w, req.DisputeID, err)
+			return
+		}
+		writeDispute(w, d)
+	})
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"log"
-	"os"
-	"os/signal"
-	"syscall"
+	"net/http"
+	"time"
 
 	"credit-authorization-ledger/internal/authorization"
 	"credit-authorization-ledger/internal/config"
 	"credit-authorization-ledger/internal/database"
-	"credit-authorization-ledger/internal/kafka"
+	"credit-authorization-ledger/internal/fx"
+	"credit-authorization-ledger/internal/leader"
+	"credit-authorization-ledger/internal/server"
 	"credit-authorization-ledger/internal/tracing"
+	"credit-authorization-ledger/internal/transport"
 
 	"go.opentelemetry.io/otel"
 )
@@ -26,23 +30,194 @@ func main() {
 	}
 	defer db.Close()
 
-	// Run database migrations for the authorization service
-	database.RunMigrations(db, "internal/authorization/migrations")
+	// Run database migrations for the authorization service and the outbox it writes to
+	database.RunMigrations(db, "internal/database/migrations", "outbox_schema_migrations")
+	database.RunMigrations(db, "internal/authorization/migrations", "authorization_schema_migrations")
 
-	authService := authorization.NewService(db)
+	// Decline rules come from a file when one is configured, otherwise from Postgres.
+	var ruleConfigs []authorization.RuleConfig
+	if cfg.RulesFile != "" {
+		ruleConfigs, err = authorization.LoadRulesFromFile(cfg.RulesFile)
+	} else {
+		ruleConfigs, err = authorization.LoadRulesFromDB(context.Background(), db)
+	}
+	if err != nil {
+		log.Fatalf("failed to load authorization rules: %v", err)
+	}
+	rules, err := authorization.NewEngine(ruleConfigs, cfg.RulesShadowMode)
+	if err != nil {
+		log.Fatalf("invalid authorization rules: %v", err)
+	}
+	log.Printf("Loaded %d authorization rules (shadow mode: %t)", len(ruleConfigs), cfg.RulesShadowMode)
 
-	kafkaConsumer := kafka.NewConsumer(cfg.KafkaBrokers, "authorization-group")
-	defer kafkaConsumer.Close()
+	velocityLimits := authorization.DefaultVelocityLimits
+	if cfg.VelocityLimitsFile != "" {
+		if velocityLimits, err = authorization.LoadVelocityLimits(cfg.VelocityLimitsFile); err != nil {
+			log.Fatalf("failed to load velocity limits: %v", err)
+		}
+	}
+	velocity := authorization.NewVelocityChecker(authorization.NewPostgresCounterStore(db), velocityLimits)
 
-	// Subscribe to the topic where authorization requests are sent
-	go kafkaConsumer.Consume([]string{"authorization-requests"}, authService.HandleAuthorizationRequest)
+	fxPolicy := authorization.FXPolicy{BillingCurrency: cfg.BillingCurrency, Markup: cfg.FXMarkup}
+	var rates fx.RateProvider
+	if cfg.FXRatesFile != "" {
+		if rates, err = fx.LoadRateProvider(cfg.FXRatesFile); err != nil {
+			log.Fatalf("failed to load exchange rates: %v", err)
+		}
+	}
+
+	authService := authorization.NewService(db, cfg.DefaultCreditLimit,
+		authorization.WithRuleEngine(rules),
+		authorization.WithVelocityChecker(velocity),
+		authorization.WithReviewPolicy(authorization.ReviewPolicy{
+			Timeout:        cfg.ReviewTimeout,
+			DefaultApprove: cfg.ReviewDefaultDecision == "approve",
+		}),
+		authorization.WithDisputePolicy(authorization.DisputePolicy{
+			ResponseWindow: cfg.DisputeResponseWindow,
+			EvidenceWindow: cfg.DisputeEvidenceWindow,
+		}),
+		authorization.WithFX(rates, fxPolicy),
+	)
+
+	// Commands arrive over Kafka or SQS, as MESSAGE_TRANSPORT selects.
+	consumer, err := transport.NewConsumer(transport.Config{
+		Kind:             cfg.MessageTransport,
+		Brokers:          cfg.KafkaBrokers,
+		GroupID:          "authorization-group",
+		PartitionWorkers: cfg.KafkaPartitionWorkers,
+		MaxInFlight:      cfg.KafkaMaxInFlight,
+		QueueURL:         cfg.AuthorizationQueueURL,
+		Region:           cfg.AWSRegion,
+		Endpoint:         cfg.AWSEndpoint,
+	})
+	if err != nil {
+		log.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	// The consumer stops when this context is cancelled on shutdown.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Subscribe to the topics where authorization commands are sent
+	topics := []string{
+		"authorization-requests",
+		"incremental-authorization-requests",
+		"authorization-reversal-requests",
+		"refund-authorization-requests",
+		"refund-completion-requests",
+		"refund-compensation-requests",
+		"payment-credit-requests",
+		"payment-return-credit-requests",
+		"dispute-compensation-requests",
+		"capture-requests",
+	}
+	consumed := make(chan struct{})
+	go func() {
+		defer close(consumed)
+		consumer.Consume(ctx, topics, authService.HandleMessage)
+	}()
+
+	// Each periodic job runs on one replica at a time, elected through Postgres.
+	elector := leader.New(db)
+
+	// Velocity history outside the longest window is no longer needed.
+	go elector.RunWhileLeader(ctx, "velocity-prune", func(ctx context.Context) {
+		runEvery(ctx, time.Hour, func() {
+			if err := velocity.Prune(ctx); err != nil {
+				log.Printf("error pruning velocity history: %v", err)
+			}
+		})
+	})
+
+	// Reviews nobody decided in time get the default decision.
+	go elector.RunWhileLeader(ctx, "review-expiry", func(ctx context.Context) {
+		runEvery(ctx, time.Minute, func() {
+			n, err := authService.ExpireReviews(ctx)
+			if err != nil {
+				log.Printf("error expiring reviews: %v", err)
+			}
+			if n > 0 {
+				log.Printf("Applied default decision to %d timed out reviews", n)
+			}
+		})
+	})
+
+	// Disputes past their deadline are decided against whoever failed to respond.
+	go elector.RunWhileLeader(ctx, "dispute-expiry", func(ctx context.Context) {
+		runEvery(ctx, time.Minute, func() {
+			n, err := authService.ExpireDisputes(ctx)
+			if err != nil {
+				log.Printf("error expiring disputes: %v", err)
+			}
+			if n > 0 {
+				log.Printf("Decided %d disputes past their deadline", n)
+			}
+		})
+	})
+
+	// Holds that never cleared are released back to the cardholder.
+	if cfg.HoldExpiry > 0 {
+		go elector.RunWhileLeader(ctx, "hold-expiry", func(ctx context.Context) {
+			runEvery(ctx, time.Minute, func() {
+				n, err := authService.ExpireHolds(ctx, cfg.HoldExpiry)
+				if err != nil {
+					log.Printf("error expiring holds: %v", err)
+				}
+				if n > 0 {
+					log.Printf("Released %d holds that never cleared", n)
+				}
+			})
+		})
+	}
+
+	// Refunds the ledger has not answered are asked about again.
+	go elector.RunWhileLeader(ctx, "saga-timeout", func(ctx context.Context) {
+		runEvery(ctx, time.Minute, func() {
+			n, err := authService.RetryStalledRefunds(ctx, cfg.SagaTimeout)
+			if err != nil {
+				log.Printf("error retrying stalled refunds: %v", err)
+			}
+			if n > 0 {
+				log.Printf("Retried %d refunds that timed out", n)
+			}
+		})
+	})
 
 	log.Println("Authorization service started...")
 
-	// Graceful shutdown
-	sigterm := make(chan os.Signal, 1)
-	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
-	<-sigterm
+	// The admin API runs until shutdown is signalled.
+	mux := http.NewServeMux()
+	mux.Handle("/admin/reviews", listReviewsHandler(authService))
+	mux.Handle("/admin/reviews/claim", claimReviewHandler(authService))
+	mux.Handle("/admin/reviews/approve", reviewDecisionHandler(authService, true))
+	mux.Handle("/admin/reviews/decline", reviewDecisionHandler(authService, false))
+	mux.Handle("/admin/disputes", disputesHandler(authService))
+	mux.Handle("/admin/disputes/request-evidence", requestEvidenceHandler(authService))
+	mux.Handle("/admin/disputes/win", resolveDisputeHandler(authService, true))
+	mux.Handle("/admin/disputes/lose", resolveDisputeHandler(authService, false))
+	mux.Handle("/debug/vars", expvar.Handler())
+	server.Start(&http.Server{Addr: cfg.AuthorizationAdminAddr, Handler: mux})
 
 	log.Println("Authorization service shutting down...")
+
+	// Stop consuming and wait for the messages already fetched.
+	cancel()
+	<-consumed
+}
+
+// runEvery calls fn at once, so a newly elected leader does not wait out a
+// whole interval, and then on every tick of interval until ctx is cancelled.
+func runEvery(ctx context.Context, interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		fn()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
 }
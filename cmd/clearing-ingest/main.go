@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"credit-authorization-ledger/internal/clearing"
+	"credit-authorization-ledger/internal/config"
+	"credit-authorization-ledger/internal/database"
+	"credit-authorization-ledger/internal/fx"
+)
+
+// clearing-ingest reads a card network clearing file, matches its records
+// against authorizations, requests a capture for each one that can be posted
+// and writes a settlement report.
+func main() {
+	file := flag.String("file", "", "clearing file to ingest")
+	format := flag.String("format", "csv", "clearing file format ("+strings.Join(clearing.Formats(), ", ")+")")
+	reportPath := flag.String("report", "", "file to write the JSON settlement report to (default stdout)")
+	flag.Parse()
+
+	if *file == "" {
+		log.Fatal("-file is required")
+	}
+	parser, err := clearing.NewParser(*format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatalf("failed to open clearing file: %v", err)
+	}
+	records, err := parser.Parse(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("failed to parse clearing file: %v", err)
+	}
+
+	cfg := config.Load()
+
+	db, err := database.NewPostgres(cfg.PostgresURL)
+	if err != nil {
+		log.Fatalf("failed to connect to postgres: %v", err)
+	}
+	defer db.Close()
+
+	// Capture requests are written to the outbox alongside each record.
+	database.RunMigrations(db, "internal/database/migrations", "outbox_schema_migrations")
+	database.RunMigrations(db, "internal/clearing/migrations", "clearing_schema_migrations")
+
+	// Records settled in a foreign transaction currency are converted at the
+	// settlement date's rate.
+	var rates fx.RateProvider
+	if cfg.FXRatesFile != "" {
+		if rates, err = fx.LoadRateProvider(cfg.FXRatesFile); err != nil {
+			log.Fatalf("failed to load exchange rates: %v", err)
+		}
+	}
+
+	ingester := clearing.NewIngester(db, cfg.BillingCurrency, rates)
+	report, ingestErr := ingester.Ingest(context.Background(), filepath.Base(*file), records)
+	if ingestErr != nil {
+		// Records before the failure are committed; rerunning skips them.
+		log.Printf("ingestion stopped: %v", ingestErr)
+	}
+	log.Printf("Ingested %d of %d clearing records: %v", len(report.Lines), report.Records, report.Counts)
+
+	if err := writeReport(report, *reportPath); err != nil {
+		log.Fatalf("failed to write report: %v", err)
+	}
+	if ingestErr != nil {
+		os.Exit(1)
+	}
+}
+
+// writeReport writes the report to path, or to stdout if path is empty.
+func writeReport(report *clearing.Report, path string) error {
+	if path == "" {
+		return report.Write(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := report.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
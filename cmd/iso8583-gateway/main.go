@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"credit-authorization-ledger/internal/acquirer"
+	"credit-authorization-ledger/internal/config"
+	"credit-authorization-ledger/internal/database"
+	"credit-authorization-ledger/internal/kafka"
+	"credit-authorization-ledger/internal/tracing"
+	"credit-authorization-ledger/internal/transport"
+
+	"go.opentelemetry.io/otel"
+)
+
+// iso8583-gateway accepts ISO 8583 authorization and reversal requests from
+// acquirers over TCP and answers them with the saga's decision.
+func main() {
+	cfg := config.Load()
+	tracer := tracing.InitTracer("iso8583-gateway")
+	otel.SetTracerProvider(tracer)
+
+	db, err := database.NewPostgres(cfg.PostgresURL)
+	if err != nil {
+		log.Fatalf("failed to connect to postgres: %v", err)
+	}
+	defer db.Close()
+
+	database.RunMigrations(db, "internal/acquirer/migrations", "acquirer_schema_migrations")
+
+	// Requests start sagas over Kafka or SQS, as MESSAGE_TRANSPORT selects.
+	publisher, err := transport.NewPublisher(transport.Config{
+		Kind:     cfg.MessageTransport,
+		Brokers:  cfg.KafkaBrokers,
+		TopicARN: cfg.SNSTopicARN,
+		Region:   cfg.AWSRegion,
+		Endpoint: cfg.AWSEndpoint,
+	})
+	if err != nil {
+		log.Fatalf("failed to create publisher: %v", err)
+	}
+	defer publisher.Close()
+
+	gateway := acquirer.NewGateway(publisher, acquirer.NewPostgresCardResolver(db),
+		acquirer.NewPostgresRequestStore(db), cfg.ISO8583ResponseTimeout)
+
+	// Every instance must see every outcome to answer the requests it holds,
+	// so each one tails the outcome topics outside any consumer group. The
+	// outcome topics are read from Kafka whichever transport is selected.
+	outcomes := kafka.NewTailConsumer(cfg.KafkaBrokers)
+	defer outcomes.Close()
+
+	// Outcomes are read until the requests in flight have been answered.
+	consumeCtx, stopConsuming := context.WithCancel(context.Background())
+	defer stopConsuming()
+	consumed := make(chan struct{})
+	go func() {
+		defer close(consumed)
+		if err := outcomes.Consume(consumeCtx, acquirer.OutcomeTopics, gateway.HandleOutcome); err != nil {
+			log.Fatalf("failed to consume outcomes: %v", err)
+		}
+	}()
+
+	ln, err := net.Listen("tcp", cfg.ISO8583Addr)
+	if err != nil {
+		log.Fatalf("failed to listen on %s: %v", cfg.ISO8583Addr, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		sigterm := make(chan os.Signal, 1)
+		signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
+		<-sigterm
+		log.Println("ISO 8583 gateway shutting down...")
+		cancel()
+	}()
+
+	// Serve stops accepting when ctx is cancelled and returns once the
+	// requests already read are answered.
+	log.Printf("ISO 8583 gateway listening on %s", cfg.ISO8583Addr)
+	if err := gateway.Serve(ctx, ln); err != nil {
+		log.Fatalf("ISO 8583 gateway stopped: %v", err)
+	}
+	stopConsuming()
+	<-consumed
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"credit-authorization-ledger/internal/ledger"
+)
+
+// balanceHandler returns an account's balance as JSON, either now or as of
+// the RFC 3339 timestamp in ?as_of=.
+func balanceHandler(svc *ledger.Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		accountID := r.URL.Query().Get("account_id")
+		if accountID == "" {
+			http.Error(w, "account_id is required", http.StatusBadRequest)
+			return
+		}
+		asOf := time.Now()
+		if v := r.URL.Query().Get("as_of"); v != "" {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				http.Error(w, "Invalid as_of, expected an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			asOf = t
+		}
+
+		balance, err := svc.BalanceAt(r.Context(), accountID, asOf)
+		if err != nil {
+			log.Printf("ERROR: Failed to compute balance for %s: %v", accountID, err)
+			http.Error(w, "Failed to compute balance", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(balance)
+	})
+}
+
+// statementHandler returns a statement by ?statement_id=, as JSON or, with
+// ?format=csv, as CSV for mailing.
+func statementHandler(svc *ledger.Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		statementID := r.URL.Query().Get("statement_id")
+		if statementID == "" {
+			http.Error(w, "statement_id is required", http.StatusBadRequest)
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "json" && format != "csv" {
+			http.Error(w, "format must be json or csv", http.StatusBadRequest)
+			return
+		}
+
+		st, err := svc.GetStatement(r.Context(), statementID)
+		if errors.Is(err, ledger.ErrStatementNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to load statement %s: %v", statementID, err)
+			http.Error(w, "Failed to load statement", http.StatusInternalServerError)
+			return
+		}
+
+		if format == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			err = st.WriteCSV(w)
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			err = st.WriteJSON(w)
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to write statement %s: %v", statementID, err)
+		}
+	})
+}
+
+// billingCycleHandler sets an account's statement day and payment terms.
+func billingCycleHandler(svc *ledger.Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ledger.BillingCycle
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.AccountID == "" {
+			http.Error(w, "account_id is required", http.StatusBadRequest)
+			return
+		}
+
+		cycle, err := svc.SetBillingCycle(r.Context(), req)
+		if errors.Is(err, ledger.ErrInvalidBillingCycle) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to set billing cycle for %s: %v", req.AccountID, err)
+			http.Error(w, "Failed to set billing cycle", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("Billing cycle for %s set to day %d, due after %d days", cycle.AccountID, cycle.StatementDay, cycle.PaymentDueDays)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cycle)
+	})
+}
@@ -2,16 +2,19 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"log"
-	"os"
-	"os/signal"
-	"syscall"
+	"net/http"
+	"time"
 
 	"credit-authorization-ledger/internal/config"
 	"credit-authorization-ledger/internal/database"
-	"credit-authorization-ledger/internal/kafka"
+	"credit-authorization-ledger/internal/leader"
 	"credit-authorization-ledger/internal/ledger"
+	"credit-authorization-ledger/internal/server"
 	"credit-authorization-ledger/internal/tracing"
+	"credit-authorization-ledger/internal/transport"
 
 	"go.opentelemetry.io/otel"
 )
@@ -27,23 +30,142 @@ func main() {
 	}
 	defer db.Close()
 
-	// Run database migrations for the ledger service
-	database.RunMigrations(db, "internal/ledger/migrations")
+	// Run database migrations for the ledger service and the outbox it writes to
+	database.RunMigrations(db, "internal/database/migrations", "outbox_schema_migrations")
+	database.RunMigrations(db, "internal/ledger/migrations", "ledger_schema_migrations")
 
-	ledgerService := ledger.NewService(db)
+	allocationOrder, err := ledger.ParseAllocationOrder(cfg.PaymentAllocationOrder)
+	if err != nil {
+		log.Fatalf("invalid payment allocation order: %v", err)
+	}
+
+	ledgerService := ledger.NewService(db,
+		ledger.WithStatementPolicy(ledger.StatementPolicy{
+			StatementDay:          cfg.StatementDay,
+			PaymentDueDays:        cfg.PaymentDueDays,
+			MinimumPaymentPercent: cfg.MinimumPaymentPercent,
+			MinimumPaymentFloor:   cfg.MinimumPaymentFloor,
+		}),
+		ledger.WithAccrualPolicy(ledger.AccrualPolicy{
+			APR:          cfg.InterestAPR,
+			DayCount:     cfg.InterestDayCount,
+			LateFee:      cfg.LateFee,
+			OverLimitFee: cfg.OverLimitFee,
+			AnnualFee:    cfg.AnnualFee,
+			LookbackDays: cfg.AccrualLookbackDays,
+		}),
+		ledger.WithAllocationOrder(allocationOrder),
+	)
+
+	// Commands arrive over Kafka or SQS, as MESSAGE_TRANSPORT selects.
+	consumer, err := transport.NewConsumer(transport.Config{
+		Kind:             cfg.MessageTransport,
+		Brokers:          cfg.KafkaBrokers,
+		GroupID:          "ledger-group",
+		PartitionWorkers: cfg.KafkaPartitionWorkers,
+		MaxInFlight:      cfg.KafkaMaxInFlight,
+		QueueURL:         cfg.LedgerQueueURL,
+		Region:           cfg.AWSRegion,
+		Endpoint:         cfg.AWSEndpoint,
+	})
+	if err != nil {
+		log.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	// The consumer stops when this context is cancelled on shutdown.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	kafkaConsumer := kafka.NewConsumer(cfg.KafkaBrokers, "ledger-group")
-	defer kafkaConsumer.Close()
+	// Subscribe to the topics where the orchestrator requests ledger updates,
+	// and to the credit limits the over-limit fee is charged against
+	topics := []string{
+		"ledger-update-requests",
+		"ledger-increment-requests",
+		"ledger-reversal-requests",
+		"refund-ledger-requests",
+		"payment-ledger-requests",
+		"payment-return-ledger-requests",
+		"dispute-ledger-requests",
+		"capture-ledger-requests",
+		"credit-limit-changed",
+	}
+	consumed := make(chan struct{})
+	go func() {
+		defer close(consumed)
+		consumer.Consume(ctx, topics, ledgerService.HandleMessage)
+	}()
+
+	// Each periodic job runs on one replica at a time, elected through Postgres.
+	elector := leader.New(db)
+
+	// Snapshots keep point-in-time balance queries from summing whole histories.
+	go elector.RunWhileLeader(ctx, "balance-snapshots", func(ctx context.Context) {
+		runEvery(ctx, cfg.BalanceSnapshotInterval, func() {
+			n, err := ledgerService.TakeSnapshots(ctx)
+			if err != nil {
+				log.Printf("error taking balance snapshots: %v", err)
+			}
+			if n > 0 {
+				log.Printf("Took balance snapshots for %d accounts", n)
+			}
+		})
+	})
+
+	// Billing cycles that have ended are closed into statements.
+	go elector.RunWhileLeader(ctx, "statement-close", func(ctx context.Context) {
+		runEvery(ctx, time.Hour, func() {
+			n, err := ledgerService.CloseDueCycles(ctx)
+			if err != nil {
+				log.Printf("error closing billing cycles: %v", err)
+			}
+			if n > 0 {
+				log.Printf("Generated %d statements", n)
+			}
+		})
+	})
 
-	// Subscribe to the topic where successful authorizations are published
-	go kafkaConsumer.Consume([]string{"authorization-succeeded"}, ledgerService.HandleCreditLedger)
+	// Interest and fees for completed days; charges already made are skipped.
+	go elector.RunWhileLeader(ctx, "interest-accrual", func(ctx context.Context) {
+		runEvery(ctx, time.Hour, func() {
+			n, err := ledgerService.RunAccruals(ctx)
+			if err != nil {
+				log.Printf("error accruing interest and fees: %v", err)
+			}
+			if n > 0 {
+				log.Printf("Posted %d interest and fee charges", n)
+			}
+		})
+	})
 
 	log.Println("Ledger service started...")
 
-	// Graceful shutdown
-	sigterm := make(chan os.Signal, 1)
-	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
-	<-sigterm
+	// The admin API runs until shutdown is signalled.
+	mux := http.NewServeMux()
+	mux.Handle("/balances", balanceHandler(ledgerService))
+	mux.Handle("/statements", statementHandler(ledgerService))
+	mux.Handle("/billing-cycles", billingCycleHandler(ledgerService))
+	mux.Handle("/debug/vars", expvar.Handler())
+	server.Start(&http.Server{Addr: cfg.LedgerAdminAddr, Handler: mux})
 
 	log.Println("Ledger service shutting down...")
+
+	// Stop consuming and wait for the messages already fetched.
+	cancel()
+	<-consumed
+}
+
+// runEvery calls fn at once, so a newly elected leader does not wait out a
+// whole interval, and then on every tick of interval until ctx is cancelled.
+func runEvery(ctx context.Context, interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		fn()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
 }
\ No newline at end of file
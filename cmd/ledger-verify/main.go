@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"credit-authorization-ledger/internal/config"
+	"credit-authorization-ledger/internal/database"
+	"credit-authorization-ledger/internal/ledger"
+)
+
+// ledger-verify recomputes the ledger's hash chain and balance snapshots. It
+// exits non-zero if any entry was modified, removed or reordered, or if a
+// snapshot has drifted from the entries it summarises.
+func main() {
+	cfg := config.Load()
+
+	db, err := database.NewPostgres(cfg.PostgresURL)
+	if err != nil {
+		log.Fatalf("failed to connect to postgres: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	ok := true
+
+	checked, brk, err := ledger.VerifyChain(ctx, db)
+	if err != nil {
+		log.Fatalf("failed to verify ledger chain: %v", err)
+	}
+	if brk != nil {
+		log.Printf("Ledger chain BROKEN after checking %d entries: %s", checked, brk)
+		ok = false
+	} else {
+		log.Printf("Ledger chain intact: %d entries verified", checked)
+	}
+
+	drifts, err := ledger.CheckSnapshots(ctx, db)
+	if err != nil {
+		log.Fatalf("failed to check balance snapshots: %v", err)
+	}
+	for _, d := range drifts {
+		log.Printf("Balance snapshot DRIFT for account %s through entry %d (as of %s): snapshot %.2f, entries %.2f",
+			d.AccountID, d.ThroughEntryID, d.AsOf.Format(time.RFC3339), d.Snapshot, d.Recomputed)
+	}
+	if len(drifts) == 0 {
+		log.Println("Balance snapshots consistent")
+	}
+
+	if !ok || len(drifts) > 0 {
+		os.Exit(1)
+	}
+}
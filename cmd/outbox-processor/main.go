@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"log"
-	"os"
+	"net/http"
 	"os/signal"
 	"syscall"
 	"time"
@@ -12,6 +14,7 @@ import (
 	"credit-authorization-ledger/internal/kafka"
 	"credit-authorization-ledger/internal/outbox"
 	"credit-authorization-ledger/internal/tracing"
+	"credit-authorization-ledger/internal/transport"
 )
 
 func main() {
@@ -25,34 +28,102 @@ func main() {
 	defer db.Close()
 
 	// The outbox table migration is run by other services, but it could be run here too for standalone safety.
-	// database.RunMigrations(db, "internal/database/migrations")
+	// database.RunMigrations(db, "internal/database/migrations", "outbox_schema_migrations")
 
-	kafkaProducer, err := kafka.NewProducer(cfg.KafkaBrokers)
+	// Only messages every in-sync replica has are marked published.
+	producerOpts := []kafka.ProducerOption{kafka.WithRequireAll()}
+	switch cfg.OutboxKafkaDelivery {
+	case "at-least-once":
+	case "ordered":
+		producerOpts = append(producerOpts, kafka.WithOrderedDelivery(cfg.OutboxBatchSize))
+	default:
+		log.Fatalf("unknown outbox kafka delivery %q", cfg.OutboxKafkaDelivery)
+	}
+	kafkaProducer, err := kafka.NewProducer(cfg.KafkaBrokers, producerOpts...)
 	if err != nil {
 		log.Fatalf("failed to create kafka producer: %v", err)
 	}
 	defer kafkaProducer.Close()
 
-	processor := outbox.NewProcessor(db, kafkaProducer)
+	// Topics are published to Kafka unless OUTBOX_ROUTES sends them elsewhere.
+	routes, err := outbox.ParseRoutes(cfg.OutboxRoutes)
+	if err != nil {
+		log.Fatalf("invalid outbox routes: %v", err)
+	}
+	sess, err := transport.NewAWSSession(cfg.AWSRegion, cfg.AWSEndpoint)
+	if err != nil {
+		log.Fatalf("failed to create aws session: %v", err)
+	}
+	publisher, err := outbox.NewRouter(routes, outbox.NewDestinations(kafkaProducer, sess).Open)
+	if err != nil {
+		log.Fatalf("failed to set up outbox destinations: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	// Use a ticker to periodically process messages
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
+	// Each mode relays everything written to the outbox; only one may run.
+	opts := outbox.RelayOptions{
+		BatchSize:    cfg.OutboxBatchSize,
+		PollInterval: cfg.OutboxPollInterval,
+		MaxAttempts:  cfg.OutboxMaxAttempts,
+	}
+	var relay interface{ Run(context.Context) }
+	switch cfg.OutboxRelayMode {
+	case "poll":
+		relay = outbox.NewRelay(db, publisher, opts)
+	case "replication":
+		replication := outbox.NewReplicationRelay(db, publisher, cfg.OutboxReplicationSlot, opts)
+		if err := replication.EnsureSlot(ctx); err != nil {
+			log.Fatalf("failed to set up replication slot: %v", err)
+		}
+		relay = replication
+	default:
+		log.Fatalf("unknown outbox relay mode %q", cfg.OutboxRelayMode)
+	}
+
+	// Published messages past the retention period are archived hourly.
+	var archiveTicks <-chan time.Time
+	archiver := outbox.NewArchiver(db, cfg.OutboxArchiveDir, cfg.OutboxRetention)
+	if cfg.OutboxRetention > 0 {
+		archiveTicker := time.NewTicker(time.Hour)
+		defer archiveTicker.Stop()
+		archiveTicks = archiveTicker.C
+	}
+
+	// Partition leadership and the other expvars are served for monitoring.
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	admin := &http.Server{Addr: cfg.OutboxAdminAddr, Handler: mux}
+	go func() {
+		if err := admin.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("could not start admin server: %v", err)
+		}
+	}()
 
-	shutdown := make(chan os.Signal, 1)
-	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
+	log.Printf("Outbox processor started in %s mode...", cfg.OutboxRelayMode)
 
-	log.Println("Outbox processor started...")
+	relayed := make(chan struct{})
+	go func() {
+		relay.Run(ctx)
+		close(relayed)
+	}()
 
 	for {
 		select {
-		case <-ticker.C:
-			if err := processor.ProcessOutboxMessages(); err != nil {
-				log.Printf("error processing outbox messages: %v", err)
+		case <-archiveTicks:
+			if _, err := archiver.Archive(ctx); err != nil {
+				log.Printf("error archiving outbox messages: %v", err)
 			}
-		case <-shutdown:
+		case <-ctx.Done():
 			log.Println("Outbox processor shutting down...")
+			<-relayed
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := admin.Shutdown(shutdownCtx); err != nil {
+				log.Printf("error shutting down admin server: %v", err)
+			}
 			return
 		}
 	}
-}
\ No newline at end of file
+}
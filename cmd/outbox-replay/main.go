@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"credit-authorization-ledger/internal/config"
+	"credit-authorization-ledger/internal/database"
+	"credit-authorization-ledger/internal/kafka"
+	"credit-authorization-ledger/internal/outbox"
+	"credit-authorization-ledger/internal/transport"
+)
+
+// outbox-replay republishes events that were already relayed from the
+// outbox to their destinations, selected by ID range, topic, key or time
+// window. Events still in the outbox table and events in its archives are
+// both searched.
+func main() {
+	fromID := flag.Int64("from-id", 0, "first outbox ID to replay")
+	toID := flag.Int64("to-id", 0, "last outbox ID to replay")
+	topic := flag.String("topic", "", "only replay events on this topic")
+	key := flag.String("key", "", "only replay events with this key")
+	since := flag.String("since", "", "only replay events written at or after this RFC 3339 time")
+	until := flag.String("until", "", "only replay events written before this RFC 3339 time")
+	archiveDir := flag.String("archive", "", "also search the archives in this directory (default OUTBOX_ARCHIVE_DIR)")
+	dryRun := flag.Bool("dry-run", false, "list the events without publishing them")
+	flag.Parse()
+
+	filter := outbox.ReplayFilter{FromID: *fromID, ToID: *toID, Topic: *topic, Key: *key}
+	var err error
+	if *since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, *since); err != nil {
+			log.Fatalf("invalid -since: %v", err)
+		}
+	}
+	if *until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, *until); err != nil {
+			log.Fatalf("invalid -until: %v", err)
+		}
+	}
+	if filter.IsZero() {
+		log.Fatal("refusing to replay every event; pass at least one of -from-id, -to-id, -topic, -key, -since or -until")
+	}
+
+	cfg := config.Load()
+	if *archiveDir == "" {
+		*archiveDir = cfg.OutboxArchiveDir
+	}
+
+	db, err := database.NewPostgres(cfg.PostgresURL)
+	if err != nil {
+		log.Fatalf("failed to connect to postgres: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	stored, err := outbox.FindPublished(ctx, db, filter)
+	if err != nil {
+		log.Fatalf("failed to search the outbox: %v", err)
+	}
+	archived, err := outbox.ReadArchives(*archiveDir, filter)
+	if err != nil {
+		log.Fatalf("failed to search the outbox archives: %v", err)
+	}
+	messages := outbox.MergeByID(stored, archived)
+	log.Printf("Found %d events to replay (%d in the outbox, %d archived)", len(messages), len(stored), len(archived))
+
+	if *dryRun {
+		for _, msg := range messages {
+			log.Printf("%d %s %s %s", msg.ID, msg.CreatedAt.Format(time.RFC3339), msg.Topic, msg.Key)
+		}
+		return
+	}
+
+	producer, err := kafka.NewProducer(cfg.KafkaBrokers, kafka.WithRequireAll())
+	if err != nil {
+		log.Fatalf("failed to create kafka producer: %v", err)
+	}
+	defer producer.Close()
+
+	// Topics are published to Kafka unless OUTBOX_ROUTES sends them elsewhere.
+	routes, err := outbox.ParseRoutes(cfg.OutboxRoutes)
+	if err != nil {
+		log.Fatalf("invalid outbox routes: %v", err)
+	}
+	sess, err := transport.NewAWSSession(cfg.AWSRegion, cfg.AWSEndpoint)
+	if err != nil {
+		log.Fatalf("failed to create aws session: %v", err)
+	}
+	publisher, err := outbox.NewRouter(routes, outbox.NewDestinations(producer, sess).Open)
+	if err != nil {
+		log.Fatalf("failed to set up outbox destinations: %v", err)
+	}
+
+	for i, msg := range messages {
+		if err := publisher.PublishBatch(ctx, []outbox.OutboxMessage{msg})[0]; err != nil {
+			// Events are replayed in ID order, so the replay can resume from here.
+			log.Fatalf("failed to replay event %d after replaying %d: %v", msg.ID, i, err)
+		}
+	}
+	log.Printf("Replayed %d events", len(messages))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"credit-authorization-ledger/internal/config"
+	"credit-authorization-ledger/internal/database"
+	"credit-authorization-ledger/internal/leader"
+	"credit-authorization-ledger/internal/reconciliation"
+)
+
+// reconcile matches authorizations with their ledger entries and writes a
+// report of every break. With -heal, missing ledger entries are re-emitted
+// through the outbox.
+func main() {
+	reportPath := flag.String("report", "", "file to write the JSON report to (default stdout)")
+	heal := flag.Bool("heal", false, "re-emit authorization-succeeded for missing ledger entries")
+	grace := flag.Duration("grace", 15*time.Minute, "skip transactions with activity more recent than this")
+	staleAfter := flag.Duration("stale-after", 48*time.Hour, "report authorizations and refunds pending longer than this")
+	flag.Parse()
+
+	cfg := config.Load()
+
+	db, err := database.NewPostgres(cfg.PostgresURL)
+	if err != nil {
+		log.Fatalf("failed to connect to postgres: %v", err)
+	}
+	defer db.Close()
+
+	// Runs started on several replicas, e.g. by cron, wait for each other
+	// rather than healing the same breaks at once. The run happens once: if
+	// the lease is lost part way, the run is abandoned rather than started
+	// again on re-election.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	leader.New(db).RunWhileLeader(ctx, "reconciliation", func(ctx context.Context) {
+		defer cancel()
+		reconcile(ctx, db, *grace, *staleAfter, *heal, *reportPath)
+	})
+}
+
+func reconcile(ctx context.Context, db *sql.DB, grace, staleAfter time.Duration, heal bool, reportPath string) {
+	reconciler := reconciliation.NewReconciler(db, grace, staleAfter)
+
+	report, err := reconciler.Run(ctx)
+	if err != nil {
+		log.Fatalf("reconciliation failed: %v", err)
+	}
+	log.Printf("Reconciliation found %d breaks: %v", len(report.Breaks), report.Counts)
+
+	if heal {
+		n, err := reconciler.Heal(ctx, report)
+		if err != nil {
+			log.Printf("error healing breaks: %v", err)
+		}
+		log.Printf("Re-emitted %d missing ledger entries", n)
+	}
+
+	out := os.Stdout
+	if reportPath != "" {
+		f, err := os.Create(reportPath)
+		if err != nil {
+			log.Fatalf("failed to create report: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := report.Write(out); err != nil {
+		log.Fatalf("failed to write report: %v", err)
+	}
+}
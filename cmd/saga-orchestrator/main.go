@@ -1,15 +1,16 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"credit-authorization-ledger/internal/config"
-	"credit-authorization-ledger/internal/kafka"
 	"credit-authorization-ledger/internal/saga"
 	"credit-authorization-ledger/internal/tracing"
+	"credit-authorization-ledger/internal/transport"
 
 	"go.opentelemetry.io/otel"
 )
@@ -19,23 +20,81 @@ func main() {
 	tracer := tracing.InitTracer("saga-orchestrator")
 	otel.SetTracerProvider(tracer)
 
-	producer, err := kafka.NewProducer(cfg.KafkaBrokers)
+	// Events arrive, and commands leave, over Kafka or SQS, as
+	// MESSAGE_TRANSPORT selects.
+	transportConfig := transport.Config{
+		Kind:             cfg.MessageTransport,
+		Brokers:          cfg.KafkaBrokers,
+		GroupID:          "saga-orchestrator-group",
+		PartitionWorkers: cfg.KafkaPartitionWorkers,
+		MaxInFlight:      cfg.KafkaMaxInFlight,
+		QueueURL:         cfg.SagaQueueURL,
+		TopicARN:         cfg.SNSTopicARN,
+		Region:           cfg.AWSRegion,
+		Endpoint:         cfg.AWSEndpoint,
+	}
+	publisher, err := transport.NewPublisher(transportConfig)
+	if err != nil {
+		log.Fatalf("failed to create publisher: %v", err)
+	}
+	defer publisher.Close()
+
+	orchestrator := saga.NewOrchestrator(publisher)
+
+	consumer, err := transport.NewConsumer(transportConfig)
 	if err != nil {
-		log.Fatalf("failed to create kafka producer: %v", err)
+		log.Fatalf("failed to create consumer: %v", err)
 	}
+	defer consumer.Close()
 
-	orchestrator := saga.NewOrchestrator(producer)
-	consumer := kafka.NewConsumer(cfg.KafkaBrokers, "saga-orchestrator-group")
+	// The consumer stops when this context is cancelled on shutdown.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	// The orchestrator listens to the initial request and the outcomes of each step
 	topics := []string{
 		"credit-authorization-requested", // Initial trigger
 		"authorization-succeeded",
 		"authorization-failed",
+		"authorization-review-required",
 		"ledger-update-succeeded",
 		"ledger-update-failed",
+		"incremental-authorization-requested",
+		"incremental-authorization-succeeded",
+		"incremental-authorization-failed",
+		"authorization-reversal-requested",
+		"authorization-reversal-succeeded",
+		"authorization-reversal-failed",
+		"refund-requested",
+		"refund-authorized",
+		"refund-ledger-posted",
+		"refund-ledger-failed",
+		"refund-succeeded",
+		"refund-failed",
+		"payment-requested",
+		"payment-posted",
+		"payment-succeeded",
+		"payment-failed",
+		"payment-return-requested",
+		"payment-return-posted",
+		"payment-returned",
+		"payment-return-failed",
+		"dispute-opened",
+		"dispute-evidence-requested",
+		"dispute-won",
+		"dispute-lost",
+		"dispute-ledger-posted",
+		"dispute-ledger-failed",
+		"dispute-failed",
+		"capture-requested",
+		"capture-succeeded",
+		"capture-failed",
 	}
-	go consumer.Consume(topics, orchestrator.HandleMessage)
+	consumed := make(chan struct{})
+	go func() {
+		defer close(consumed)
+		consumer.Consume(ctx, topics, orchestrator.HandleMessage)
+	}()
 
 	log.Println("SAGA Orchestrator started...")
 
@@ -44,4 +103,8 @@ func main() {
 	<-sigterm
 
 	log.Println("SAGA Orchestrator shutting down...")
+
+	// Stop consuming and wait for the messages already fetched.
+	cancel()
+	<-consumed
 }
\ No newline at end of file
@@ -0,0 +1,35 @@
+package acquirer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// ErrUnknownCard is returned for PAN tokens that belong to no cardholder.
+var ErrUnknownCard = errors.New("unknown card")
+
+// CardResolver finds the cardholder a PAN token was issued to.
+type CardResolver interface {
+	Resolve(ctx context.Context, token string) (userID string, err error)
+}
+
+// PostgresCardResolver resolves tokens from the card_tokens table.
+type PostgresCardResolver struct {
+	db *sql.DB
+}
+
+// NewPostgresCardResolver creates a resolver backed by db.
+func NewPostgresCardResolver(db *sql.DB) *PostgresCardResolver {
+	return &PostgresCardResolver{db: db}
+}
+
+// Resolve implements CardResolver.
+func (r *PostgresCardResolver) Resolve(ctx context.Context, token string) (string, error) {
+	var userID string
+	err := r.db.QueryRowContext(ctx, "SELECT user_id FROM card_tokens WHERE token = $1", token).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUnknownCard
+	}
+	return userID, err
+}
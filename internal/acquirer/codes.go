@@ -0,0 +1,70 @@
+package acquirer
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Response codes sent in field 39.
+const (
+	ResponseApproved           = "00"
+	ResponseReferToIssuer      = "01"
+	ResponseDoNotHonor         = "05"
+	ResponseInvalidTransaction = "12"
+	ResponseInvalidAmount      = "13"
+	ResponseInvalidCard        = "14"
+	ResponseNoOriginal         = "25"
+	ResponseFormatError        = "30"
+	ResponseInsufficientFunds  = "51"
+	ResponseIssuerUnavailable  = "91"
+	ResponseSystemError        = "96"
+)
+
+// currency is an ISO 4217 currency and the number of minor units its
+// amounts are expressed in on the wire.
+type currency struct {
+	Code     string
+	Exponent int
+}
+
+// currencies maps the ISO 4217 numeric codes of field 49 to the alphabetic
+// codes used everywhere else.
+var currencies = map[string]currency{
+	"036": {"AUD", 2},
+	"124": {"CAD", 2},
+	"392": {"JPY", 0},
+	"484": {"MXN", 2},
+	"756": {"CHF", 2},
+	"826": {"GBP", 2},
+	"840": {"USD", 2},
+	"978": {"EUR", 2},
+}
+
+// countries maps the ISO 3166 numeric codes of field 19 to the alpha-2
+// codes authorization rules match on.
+var countries = map[string]string{
+	"036": "AU",
+	"124": "CA",
+	"250": "FR",
+	"276": "DE",
+	"392": "JP",
+	"484": "MX",
+	"756": "CH",
+	"826": "GB",
+	"840": "US",
+}
+
+// parseAmount converts field 4, in minor units of the currency in field 49,
+// to an amount and its alphabetic currency code.
+func parseAmount(amount, currencyCode string) (float64, string, error) {
+	cur, ok := currencies[currencyCode]
+	if !ok {
+		return 0, "", fmt.Errorf("unsupported currency %q", currencyCode)
+	}
+	minor, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid amount %q", amount)
+	}
+	return float64(minor) / math.Pow10(cur.Exponent), cur.Code, nil
+}
@@ -0,0 +1,441 @@
+// Package acquirer connects card acquirers speaking ISO 8583 over TCP to
+// the authorization saga. Authorization (0100) and reversal (0400) requests
+// are published as events, and each is answered (0110, 0410) once the
+// authorization service has decided, or with a timeout response code.
+package acquirer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"credit-authorization-ledger/internal/authorization"
+	"credit-authorization-ledger/internal/iso8583"
+	"credit-authorization-ledger/pkg/events"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// OutcomeTopics are the topics HandleOutcome answers pending requests from.
+var OutcomeTopics = []string{
+	"authorization-succeeded",
+	"authorization-failed",
+	"authorization-review-required",
+	"authorization-reversal-succeeded",
+	"authorization-reversal-failed",
+}
+
+// Kinds of pending request. A transaction can have an authorization and a
+// reversal in flight at the same time.
+const (
+	kindAuthorization = "authorization"
+	kindReversal      = "reversal"
+)
+
+// Fields copied from a request into its response.
+var (
+	authorizationEcho = []int{
+		iso8583.FieldPAN, iso8583.FieldProcessingCode, iso8583.FieldAmount, iso8583.FieldTransmissionDateTime,
+		iso8583.FieldSTAN, iso8583.FieldLocalTime, iso8583.FieldLocalDate, iso8583.FieldAcquiringInstitutionID,
+		iso8583.FieldRRN, iso8583.FieldTerminalID, iso8583.FieldMerchantID, iso8583.FieldCurrencyCode,
+	}
+	reversalEcho = append(authorizationEcho, iso8583.FieldOriginalDataElements)
+)
+
+// requiredFields must be present in every request.
+var requiredFields = []int{
+	iso8583.FieldPAN, iso8583.FieldAmount, iso8583.FieldSTAN, iso8583.FieldRRN, iso8583.FieldCurrencyCode,
+}
+
+// Publisher sends an event to a topic, keyed by transaction.
+type Publisher interface {
+	Publish(ctx context.Context, topic, key string, payload []byte) error
+}
+
+// Gateway answers ISO 8583 requests from the outcome of the saga they start.
+type Gateway struct {
+	publisher Publisher
+	cards     CardResolver
+	requests  RequestStore
+	timeout   time.Duration
+
+	mu sync.Mutex
+	// pending are the channels of the requests waiting for each decision.
+	pending map[string][]chan string
+}
+
+// NewGateway creates a gateway that answers requests the saga has not
+// decided within timeout with response code 91. Requests are recorded in
+// requests, so retransmissions are answered like the original.
+func NewGateway(publisher Publisher, cards CardResolver, requests RequestStore, timeout time.Duration) *Gateway {
+	return &Gateway{
+		publisher: publisher,
+		cards:     cards,
+		requests:  requests,
+		timeout:   timeout,
+		pending:   map[string][]chan string{},
+	}
+}
+
+// Serve accepts acquirer connections on ln until ctx is cancelled, then
+// stops reading new requests, answers those in flight and returns.
+func (g *Gateway) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	var conns sync.WaitGroup
+	defer conns.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		conns.Add(1)
+		go func() {
+			defer conns.Done()
+			g.serveConn(ctx, conn)
+		}()
+	}
+}
+
+// serveConn handles the requests of one connection concurrently, since
+// acquirers send the next request without waiting for a response.
+func (g *Gateway) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	remote := conn.RemoteAddr()
+	log.Printf("Acquirer connected from %s", remote)
+
+	// Cancellation unblocks the read loop but leaves the connection open for
+	// the responses still owed.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	var writeMu sync.Mutex
+	write := func(resp *iso8583.Message) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if err := iso8583.WriteMessage(conn, resp); err != nil {
+			log.Printf("error writing %s to acquirer %s: %v", resp.MTI, remote, err)
+		}
+	}
+
+	var inFlight sync.WaitGroup
+	defer inFlight.Wait()
+	for {
+		frame, err := iso8583.ReadFrame(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				log.Printf("error reading from acquirer %s: %v", remote, err)
+			}
+			log.Printf("Acquirer %s disconnected", remote)
+			return
+		}
+		req, err := iso8583.Decode(frame)
+		if err != nil {
+			log.Printf("malformed message from acquirer %s: %v", remote, err)
+			if resp := formatError(req); resp != nil {
+				write(resp)
+			}
+			continue
+		}
+
+		inFlight.Add(1)
+		go func() {
+			defer inFlight.Done()
+			// Requests already read are answered even during shutdown.
+			if resp := g.Handle(context.Background(), req); resp != nil {
+				write(resp)
+			}
+		}()
+	}
+}
+
+// formatError answers a request that could not be decoded with response
+// code 30, echoing the fields that were. Only authorization and reversal
+// requests are answered.
+func formatError(req *iso8583.Message) *iso8583.Message {
+	if req == nil {
+		return nil
+	}
+	var resp *iso8583.Message
+	switch req.MTI {
+	case iso8583.MTIAuthorizationRequest:
+		resp = req.Response(authorizationEcho...)
+	case iso8583.MTIReversalRequest:
+		resp = req.Response(reversalEcho...)
+	default:
+		return nil
+	}
+	resp.Set(iso8583.FieldResponseCode, ResponseFormatError)
+	return resp
+}
+
+// Handle answers one request. Messages other than 0100 and 0400 get no
+// response.
+func (g *Gateway) Handle(ctx context.Context, req *iso8583.Message) *iso8583.Message {
+	switch req.MTI {
+	case iso8583.MTIAuthorizationRequest:
+		return g.authorize(ctx, req)
+	case iso8583.MTIReversalRequest:
+		return g.reverse(ctx, req)
+	default:
+		log.Printf("Ignoring unsupported message type %s", req.MTI)
+		return nil
+	}
+}
+
+func (g *Gateway) authorize(ctx context.Context, req *iso8583.Message) *iso8583.Message {
+	resp := req.Response(authorizationEcho...)
+	code, event := g.authorizationRequested(ctx, req)
+	if code == "" {
+		code = g.await(ctx, kindAuthorization, event.TransactionID, "credit-authorization-requested", event)
+	}
+
+	resp.Set(iso8583.FieldResponseCode, code)
+	if code == ResponseApproved {
+		resp.Set(iso8583.FieldAuthorizationID, approvalCode(event.TransactionID))
+	}
+	return resp
+}
+
+// authorizationRequested maps a 0100 onto the event that starts the saga,
+// or returns the response code it is refused with.
+func (g *Gateway) authorizationRequested(ctx context.Context, req *iso8583.Message) (string, events.AuthorizationRequested) {
+	var event events.AuthorizationRequested
+	if !hasFields(req, requiredFields) {
+		return ResponseFormatError, event
+	}
+
+	amountField, _ := req.Get(iso8583.FieldAmount)
+	currencyField, _ := req.Get(iso8583.FieldCurrencyCode)
+	amount, currencyCode, err := parseAmount(amountField, currencyField)
+	if err != nil {
+		log.Printf("Refusing authorization: %v", err)
+		return ResponseInvalidTransaction, event
+	}
+	if amount <= 0 {
+		return ResponseInvalidAmount, event
+	}
+
+	token, _ := req.Get(iso8583.FieldPAN)
+	userID, err := g.cards.Resolve(ctx, token)
+	if errors.Is(err, ErrUnknownCard) {
+		return ResponseInvalidCard, event
+	}
+	if err != nil {
+		log.Printf("error resolving card token: %v", err)
+		return ResponseSystemError, event
+	}
+
+	merchantID, _ := req.Get(iso8583.FieldMerchantID)
+	mcc, _ := req.Get(iso8583.FieldMCC)
+	country, _ := req.Get(iso8583.FieldAcquirerCountry)
+	event = events.AuthorizationRequested{
+		TransactionID:        transactionID(req),
+		UserID:               userID,
+		Amount:               amount,
+		Currency:             currencyCode,
+		MerchantID:           merchantID,
+		MerchantCategoryCode: mcc,
+		MerchantCountry:      countries[country],
+	}
+	return "", event
+}
+
+// reverse voids the whole of the authorization a 0400 refers to. Partial
+// reversals are not supported over ISO 8583.
+func (g *Gateway) reverse(ctx context.Context, req *iso8583.Message) *iso8583.Message {
+	resp := req.Response(reversalEcho...)
+	code := ResponseFormatError
+	if hasFields(req, requiredFields) {
+		txID := transactionID(req)
+		code = g.await(ctx, kindReversal, txID, "authorization-reversal-requested",
+			events.AuthorizationReversalRequested{TransactionID: txID})
+	}
+	resp.Set(iso8583.FieldResponseCode, code)
+	return resp
+}
+
+// await publishes event and waits for the response code its outcome maps
+// to. A retransmitted request, on this instance or another, does not start
+// the saga again: it is answered with the original's response code, or
+// waits for it if the original is still undecided.
+func (g *Gateway) await(ctx context.Context, kind, transactionID, topic string, event interface{}) string {
+	key := kind + ":" + transactionID
+	decided := make(chan string, 1)
+	g.mu.Lock()
+	g.pending[key] = append(g.pending[key], decided)
+	g.mu.Unlock()
+	defer g.stopWaiting(key, decided)
+
+	started, code, err := g.requests.Start(ctx, kind, transactionID)
+	if err != nil {
+		log.Printf("error recording %s for %s: %v", kind, transactionID, err)
+		return ResponseSystemError
+	}
+	if code != "" {
+		log.Printf("Answering retransmitted %s for %s with %s", kind, transactionID, code)
+		return code
+	}
+	if started {
+		if err := g.publish(ctx, kind, transactionID, topic, event); err != nil {
+			log.Printf("error publishing %s for %s: %v", kind, transactionID, err)
+			if err := g.requests.Abandon(ctx, kind, transactionID); err != nil {
+				log.Printf("error abandoning %s for %s: %v", kind, transactionID, err)
+			}
+			return ResponseSystemError
+		}
+	} else {
+		log.Printf("Waiting for the %s decision on retransmitted %s", kind, transactionID)
+	}
+
+	timer := time.NewTimer(g.timeout)
+	defer timer.Stop()
+	select {
+	case code := <-decided:
+		return code
+	case <-timer.C:
+		log.Printf("Timed out waiting for the %s decision on %s", kind, transactionID)
+		return ResponseIssuerUnavailable
+	case <-ctx.Done():
+		return ResponseIssuerUnavailable
+	}
+}
+
+func (g *Gateway) publish(ctx context.Context, kind, transactionID, topic string, event interface{}) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return g.publisher.Publish(ctx, topic, transactionID, payload)
+}
+
+func (g *Gateway) stopWaiting(key string, decided chan string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	waiting := g.pending[key]
+	for i, c := range waiting {
+		if c == decided {
+			waiting = append(waiting[:i], waiting[i+1:]...)
+			break
+		}
+	}
+	if len(waiting) == 0 {
+		delete(g.pending, key)
+	} else {
+		g.pending[key] = waiting
+	}
+}
+
+// HandleOutcome records the response code an outcome decides and answers
+// the requests waiting for it. Outcomes of requests no gateway started are
+// ignored.
+func (g *Gateway) HandleOutcome(ctx context.Context, msg kafka.Message) error {
+	var outcome struct {
+		TransactionID string `json:"transaction_id"`
+		Reason        string `json:"reason"`
+	}
+	if err := json.Unmarshal(msg.Value, &outcome); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return err
+	}
+
+	var kind, code string
+	switch msg.Topic {
+	case "authorization-succeeded":
+		kind, code = kindAuthorization, ResponseApproved
+	case "authorization-review-required":
+		// The amount is held, but the acquirer cannot wait for a reviewer.
+		kind, code = kindAuthorization, ResponseReferToIssuer
+	case "authorization-failed":
+		kind, code = kindAuthorization, declineCode(outcome.Reason)
+	case "authorization-reversal-succeeded":
+		kind, code = kindReversal, ResponseApproved
+	case "authorization-reversal-failed":
+		kind, code = kindReversal, ResponseInvalidTransaction
+		if outcome.Reason == authorization.ReasonNotFound {
+			code = ResponseNoOriginal
+		}
+	default:
+		return fmt.Errorf("unexpected topic %s", msg.Topic)
+	}
+
+	// Recorded even if no one here is waiting, so a retransmission after
+	// the original timed out gets the decision.
+	if err := g.requests.Answer(ctx, kind, outcome.TransactionID, code); err != nil {
+		return err
+	}
+	g.decide(kind, outcome.TransactionID, code)
+	return nil
+}
+
+func (g *Gateway) decide(kind, transactionID, code string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, decided := range g.pending[kind+":"+transactionID] {
+		select {
+		case decided <- code:
+		default:
+		}
+	}
+}
+
+// declineCode maps the reason an authorization was declined to field 39.
+func declineCode(reason string) string {
+	switch reason {
+	case authorization.ReasonInsufficientCredit:
+		return ResponseInsufficientFunds
+	case authorization.ReasonInvalidAmount:
+		return ResponseInvalidAmount
+	case authorization.ReasonUnsupportedCurrency:
+		return ResponseInvalidTransaction
+	default:
+		return ResponseDoNotHonor
+	}
+}
+
+// transactionID identifies a transaction by its retrieval reference number,
+// qualified by the acquiring institution when one is sent. A reversal
+// carries the same fields as the authorization it voids.
+func transactionID(req *iso8583.Message) string {
+	rrn, _ := req.Get(iso8583.FieldRRN)
+	if acquirer, ok := req.Get(iso8583.FieldAcquiringInstitutionID); ok && acquirer != "" {
+		return acquirer + ":" + strings.TrimSpace(rrn)
+	}
+	return strings.TrimSpace(rrn)
+}
+
+// approvalCode derives the field 38 authorization ID from the transaction,
+// so a retransmitted approval carries the same code.
+func approvalCode(transactionID string) string {
+	return fmt.Sprintf("%06d", crc32.ChecksumIEEE([]byte(transactionID))%1000000)
+}
+
+func hasFields(m *iso8583.Message, fields []int) bool {
+	for _, f := range fields {
+		if v, ok := m.Get(f); !ok || v == "" {
+			return false
+		}
+	}
+	return true
+}
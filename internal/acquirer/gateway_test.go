@@ -0,0 +1,302 @@
+package acquirer
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"credit-authorization-ledger/internal/authorization"
+	"credit-authorization-ledger/internal/iso8583"
+	"credit-authorization-ledger/pkg/events"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type published struct {
+	topic, key string
+	payload    []byte
+}
+
+// fakePublisher hands every published event to the test.
+type fakePublisher chan published
+
+func (p fakePublisher) Publish(ctx context.Context, topic, key string, payload []byte) error {
+	p <- published{topic, key, payload}
+	return nil
+}
+
+type fakeCards map[string]string
+
+func (c fakeCards) Resolve(ctx context.Context, token string) (string, error) {
+	if userID, ok := c[token]; ok {
+		return userID, nil
+	}
+	return "", ErrUnknownCard
+}
+
+// memoryRequests is a RequestStore in memory.
+type memoryRequests struct {
+	mu    sync.Mutex
+	codes map[string]string
+}
+
+func newMemoryRequests() *memoryRequests {
+	return &memoryRequests{codes: map[string]string{}}
+}
+
+func (r *memoryRequests) Start(ctx context.Context, kind, transactionID string) (bool, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	code, ok := r.codes[kind+":"+transactionID]
+	if !ok {
+		r.codes[kind+":"+transactionID] = ""
+	}
+	return !ok, code, nil
+}
+
+func (r *memoryRequests) Answer(ctx context.Context, kind, transactionID, code string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.codes[kind+":"+transactionID]; ok && c == "" {
+		r.codes[kind+":"+transactionID] = code
+	}
+	return nil
+}
+
+func (r *memoryRequests) Abandon(ctx context.Context, kind, transactionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.codes, kind+":"+transactionID)
+	return nil
+}
+
+func authorizationRequest() *iso8583.Message {
+	m := iso8583.NewMessage(iso8583.MTIAuthorizationRequest)
+	m.Set(iso8583.FieldPAN, "tok_4111abcd")
+	m.Set(iso8583.FieldAmount, "000000012550")
+	m.Set(iso8583.FieldSTAN, "000123")
+	m.Set(iso8583.FieldMCC, "5411")
+	m.Set(iso8583.FieldAcquirerCountry, "826")
+	m.Set(iso8583.FieldAcquiringInstitutionID, "123456")
+	m.Set(iso8583.FieldRRN, "406112000123")
+	m.Set(iso8583.FieldMerchantID, "MERCHANT-42")
+	m.Set(iso8583.FieldCurrencyCode, "978")
+	return m
+}
+
+func newTestGateway(timeout time.Duration) (*Gateway, fakePublisher) {
+	p := make(fakePublisher, 10)
+	return NewGateway(p, fakeCards{"tok_4111abcd": "user-1"}, newMemoryRequests(), timeout), p
+}
+
+// handle runs a request in the background, as a connection would.
+func handle(g *Gateway, req *iso8583.Message) <-chan *iso8583.Message {
+	resp := make(chan *iso8583.Message, 1)
+	go func() { resp <- g.Handle(context.Background(), req) }()
+	return resp
+}
+
+func outcome(t *testing.T, g *Gateway, topic string, event interface{}) {
+	t.Helper()
+	value, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.HandleOutcome(context.Background(), kafka.Message{Topic: topic, Value: value}); err != nil {
+		t.Fatalf("HandleOutcome(%s): %v", topic, err)
+	}
+}
+
+func responseCode(t *testing.T, resp <-chan *iso8583.Message) (*iso8583.Message, string) {
+	t.Helper()
+	select {
+	case m := <-resp:
+		code, _ := m.Get(iso8583.FieldResponseCode)
+		return m, code
+	case <-time.After(5 * time.Second):
+		t.Fatal("no response")
+		return nil, ""
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	const txID = "123456:406112000123"
+	tests := []struct {
+		topic  string
+		reason string
+		want   string
+	}{
+		{"authorization-succeeded", "", ResponseApproved},
+		{"authorization-review-required", "", ResponseReferToIssuer},
+		{"authorization-failed", authorization.ReasonInsufficientCredit, ResponseInsufficientFunds},
+		{"authorization-failed", authorization.ReasonUnsupportedCurrency, ResponseInvalidTransaction},
+		{"authorization-failed", "card frozen", ResponseDoNotHonor},
+	}
+	for _, tt := range tests {
+		g, p := newTestGateway(5 * time.Second)
+		resp := handle(g, authorizationRequest())
+
+		pub := <-p
+		var req events.AuthorizationRequested
+		if err := json.Unmarshal(pub.payload, &req); err != nil {
+			t.Fatal(err)
+		}
+		want := events.AuthorizationRequested{
+			TransactionID: txID, UserID: "user-1", Amount: 125.5, Currency: "EUR",
+			MerchantID: "MERCHANT-42", MerchantCategoryCode: "5411", MerchantCountry: "GB",
+		}
+		if pub.topic != "credit-authorization-requested" || pub.key != txID || req != want {
+			t.Fatalf("published %s %s %+v, want %+v", pub.topic, pub.key, req, want)
+		}
+
+		outcome(t, g, tt.topic, events.AuthorizationFailed{TransactionID: txID, Reason: tt.reason})
+		m, code := responseCode(t, resp)
+		if code != tt.want {
+			t.Errorf("%s %q: response code %s, want %s", tt.topic, tt.reason, code, tt.want)
+		}
+		authID, approved := m.Get(iso8583.FieldAuthorizationID)
+		if approved != (code == ResponseApproved) || (approved && authID != approvalCode(txID)) {
+			t.Errorf("%s: authorization ID %q", tt.topic, authID)
+		}
+		if rrn, _ := m.Get(iso8583.FieldRRN); m.MTI != iso8583.MTIAuthorizationResponse || rrn != "406112000123" {
+			t.Errorf("response %s with RRN %q, want 0110 echoing the request", m.MTI, rrn)
+		}
+	}
+}
+
+func TestRefusedWithoutPublishing(t *testing.T) {
+	missing := authorizationRequest()
+	missing.Set(iso8583.FieldRRN, "")
+	zero := authorizationRequest()
+	zero.Set(iso8583.FieldAmount, "000000000000")
+	unknownCard := authorizationRequest()
+	unknownCard.Set(iso8583.FieldPAN, "tok_0000")
+	unknownCurrency := authorizationRequest()
+	unknownCurrency.Set(iso8583.FieldCurrencyCode, "999")
+
+	tests := []struct {
+		name string
+		req  *iso8583.Message
+		want string
+	}{
+		{"missing field", missing, ResponseFormatError},
+		{"zero amount", zero, ResponseInvalidAmount},
+		{"unknown card", unknownCard, ResponseInvalidCard},
+		{"unknown currency", unknownCurrency, ResponseInvalidTransaction},
+	}
+	g, p := newTestGateway(time.Second)
+	for _, tt := range tests {
+		if _, code := responseCode(t, handle(g, tt.req)); code != tt.want {
+			t.Errorf("%s: response code %s, want %s", tt.name, code, tt.want)
+		}
+	}
+	if len(p) != 0 {
+		t.Errorf("published %d events for refused requests", len(p))
+	}
+}
+
+func TestRetransmission(t *testing.T) {
+	const txID = "123456:406112000123"
+	g, p := newTestGateway(50 * time.Millisecond)
+
+	// The original times out before the saga decides.
+	if _, code := responseCode(t, handle(g, authorizationRequest())); code != ResponseIssuerUnavailable {
+		t.Fatalf("response code %s, want %s", code, ResponseIssuerUnavailable)
+	}
+	<-p
+	outcome(t, g, "authorization-succeeded", events.AuthorizationSucceeded{TransactionID: txID})
+
+	// Its retransmission is answered with the decision without starting the
+	// saga again.
+	m, code := responseCode(t, handle(g, authorizationRequest()))
+	if authID, _ := m.Get(iso8583.FieldAuthorizationID); code != ResponseApproved || authID != approvalCode(txID) {
+		t.Errorf("retransmission answered %s with authorization ID %q, want the original approval", code, authID)
+	}
+	if len(p) != 0 {
+		t.Error("retransmission was published again")
+	}
+
+	// Retransmissions of an undecided request all wait for its decision.
+	g, p = newTestGateway(5 * time.Second)
+	first := handle(g, authorizationRequest())
+	<-p
+	second := handle(g, authorizationRequest())
+	outcome(t, g, "authorization-failed", events.AuthorizationFailed{TransactionID: txID, Reason: authorization.ReasonInsufficientCredit})
+	for _, resp := range []<-chan *iso8583.Message{first, second} {
+		if _, code := responseCode(t, resp); code != ResponseInsufficientFunds {
+			t.Errorf("response code %s, want %s", code, ResponseInsufficientFunds)
+		}
+	}
+	if len(p) != 0 {
+		t.Error("retransmission was published again")
+	}
+}
+
+func TestReverse(t *testing.T) {
+	const txID = "123456:406112000123"
+	g, p := newTestGateway(5 * time.Second)
+	req := authorizationRequest()
+	req.MTI = iso8583.MTIReversalRequest
+	req.Set(iso8583.FieldOriginalDataElements, "010000012303011200000000012345600000000000")
+
+	resp := handle(g, req)
+	if pub := <-p; pub.topic != "authorization-reversal-requested" || pub.key != txID {
+		t.Fatalf("published %s %s, want a reversal of %s", pub.topic, pub.key, txID)
+	}
+	outcome(t, g, "authorization-reversal-failed", events.AuthorizationReversalFailed{TransactionID: txID, Reason: authorization.ReasonNotFound})
+	m, code := responseCode(t, resp)
+	if original, _ := m.Get(iso8583.FieldOriginalDataElements); m.MTI != iso8583.MTIReversalResponse || code != ResponseNoOriginal || original == "" {
+		t.Errorf("reversal answered %s %s, want 0410 %s echoing field 90", m.MTI, code, ResponseNoOriginal)
+	}
+}
+
+func TestMalformedFrameAnswered(t *testing.T) {
+	g, _ := newTestGateway(time.Second)
+	server, client := net.Pipe()
+	defer client.Close()
+	go g.serveConn(context.Background(), server)
+
+	frame, err := authorizationRequest().Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A truncated final field makes the frame fail to decode.
+	frame = frame[:len(frame)-1]
+	if _, err := client.Write(append([]byte{byte(len(frame) >> 8), byte(len(frame))}, frame...)); err != nil {
+		t.Fatal(err)
+	}
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := iso8583.ReadMessage(client)
+	if err != nil {
+		t.Fatalf("reading the response: %v", err)
+	}
+	code, _ := resp.Get(iso8583.FieldResponseCode)
+	stan, _ := resp.Get(iso8583.FieldSTAN)
+	if resp.MTI != iso8583.MTIAuthorizationResponse || code != ResponseFormatError || stan != "000123" {
+		t.Errorf("malformed request answered %s %s with STAN %q, want 0110 %s echoing the STAN", resp.MTI, code, stan, ResponseFormatError)
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		amount, currency string
+		want             float64
+		code             string
+	}{
+		{"000000012550", "840", 125.5, "USD"},
+		{"000000012550", "392", 12550, "JPY"},
+	}
+	for _, tt := range tests {
+		got, code, err := parseAmount(tt.amount, tt.currency)
+		if err != nil || got != tt.want || code != tt.code {
+			t.Errorf("parseAmount(%s, %s) = %v %s, %v; want %v %s", tt.amount, tt.currency, got, code, err, tt.want, tt.code)
+		}
+	}
+	if _, _, err := parseAmount("12a", "840"); err == nil {
+		t.Error("parseAmount accepted a non-numeric amount")
+	}
+}
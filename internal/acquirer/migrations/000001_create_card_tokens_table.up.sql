@@ -0,0 +1,9 @@
+-- Maps the PAN tokens acquirers send in ISO 8583 messages to cardholders.
+-- Tokens are provisioned by the card issuing process, never by the gateway.
+CREATE TABLE IF NOT EXISTS card_tokens (
+    token VARCHAR(19) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_card_tokens_user_id ON card_tokens (user_id);
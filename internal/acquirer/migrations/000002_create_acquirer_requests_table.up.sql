@@ -0,0 +1,13 @@
+-- Every authorization and reversal the gateway has started, with the
+-- response code it was decided with. A retransmitted request is answered
+-- from here instead of starting the saga again.
+CREATE TABLE IF NOT EXISTS acquirer_requests (
+    kind VARCHAR(20) NOT NULL,
+    transaction_id VARCHAR(255) NOT NULL,
+    response_code CHAR(2),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    answered_at TIMESTAMPTZ,
+    PRIMARY KEY (kind, transaction_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_acquirer_requests_created_at ON acquirer_requests (created_at);
@@ -0,0 +1,73 @@
+package acquirer
+
+import (
+	"context"
+	"database/sql"
+)
+
+// RequestStore remembers the requests a gateway has started and the
+// response code each was decided with, across instances and restarts.
+type RequestStore interface {
+	// Start records a request. It reports false if the request was already
+	// started, along with its response code once one is recorded.
+	Start(ctx context.Context, kind, transactionID string) (started bool, code string, err error)
+	// Answer records the response code a started request was decided with.
+	// The first code recorded stands.
+	Answer(ctx context.Context, kind, transactionID, code string) error
+	// Abandon forgets a request that could not be started, so it can be
+	// started again.
+	Abandon(ctx context.Context, kind, transactionID string) error
+}
+
+// PostgresRequestStore keeps requests in the acquirer_requests table.
+type PostgresRequestStore struct {
+	db *sql.DB
+}
+
+// NewPostgresRequestStore creates a request store backed by db.
+func NewPostgresRequestStore(db *sql.DB) *PostgresRequestStore {
+	return &PostgresRequestStore{db: db}
+}
+
+// Start implements RequestStore.
+func (s *PostgresRequestStore) Start(ctx context.Context, kind, transactionID string) (bool, string, error) {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO acquirer_requests (kind, transaction_id)
+		VALUES ($1, $2)
+		ON CONFLICT (kind, transaction_id) DO NOTHING
+	`, kind, transactionID)
+	if err != nil {
+		return false, "", err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, "", err
+	}
+	if n == 1 {
+		return true, "", nil
+	}
+
+	var code string
+	err = s.db.QueryRowContext(ctx,
+		"SELECT COALESCE(response_code, '') FROM acquirer_requests WHERE kind = $1 AND transaction_id = $2",
+		kind, transactionID).Scan(&code)
+	return false, code, err
+}
+
+// Answer implements RequestStore.
+func (s *PostgresRequestStore) Answer(ctx context.Context, kind, transactionID, code string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE acquirer_requests
+		SET response_code = $3, answered_at = NOW()
+		WHERE kind = $1 AND transaction_id = $2 AND response_code IS NULL
+	`, kind, transactionID, code)
+	return err
+}
+
+// Abandon implements RequestStore.
+func (s *PostgresRequestStore) Abandon(ctx context.Context, kind, transactionID string) error {
+	_, err := s.db.ExecContext(ctx,
+		"DELETE FROM acquirer_requests WHERE kind = $1 AND transaction_id = $2 AND response_code IS NULL",
+		kind, transactionID)
+	return err
+}
@@ -0,0 +1,194 @@
+package authorization
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"credit-authorization-ledger/internal/outbox"
+	"credit-authorization-ledger/internal/transport"
+	"credit-authorization-ledger/pkg/events"
+
+	"go.opentelemetry.io/otel"
+)
+
+// HandleIncrementalAuthorization raises the held amount of an open
+// authorization, re-checking the cardholder's available credit.
+func (s *Service) HandleIncrementalAuthorization(ctx context.Context, msg transport.Message) error {
+	tr := otel.Tracer("authorization-service")
+	ctx, span := tr.Start(ctx, "HandleIncrementalAuthorization")
+	defer span.End()
+
+	var req events.IncrementalAuthorizationRequested
+	if err := json.Unmarshal(msg.Value, &req); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	log.Printf("Incrementing authorization %s by %f", req.TransactionID, req.Amount)
+
+	result, reason, err := s.increment(ctx, tx, req)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		log.Printf("Declining incremental authorization for %s: %s", req.TransactionID, reason)
+		failedEvent := events.IncrementalAuthorizationFailed{TransactionID: req.TransactionID, Reason: reason}
+		if err := outbox.NewWriter(tx).Write(ctx, "incremental-authorization-failed", req.TransactionID, failedEvent); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	if err := outbox.NewWriter(tx).Write(ctx, "incremental-authorization-succeeded", req.TransactionID, result); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Service) increment(ctx context.Context, tx *sql.Tx, req events.IncrementalAuthorizationRequested) (events.IncrementalAuthorizationSucceeded, string, error) {
+	var result events.IncrementalAuthorizationSucceeded
+
+	auth, err := lockAuthorization(ctx, tx, req.TransactionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return result, ReasonNotFound, nil
+	}
+	if err != nil {
+		return result, "", err
+	}
+	if auth.Status != StatusSucceeded {
+		return result, ReasonNotOpen, nil
+	}
+
+	reason, err := s.reserveCredit(ctx, tx, auth.UserID, req.Amount)
+	if err != nil || reason != "" {
+		return result, reason, err
+	}
+
+	var total float64
+	err = tx.QueryRowContext(ctx,
+		"UPDATE authorizations SET amount = amount + $2 WHERE transaction_id = $1 RETURNING amount",
+		req.TransactionID, req.Amount).Scan(&total)
+	if err != nil {
+		return result, "", err
+	}
+	changeID, err := recordAmountChange(ctx, tx, req.TransactionID, ChangeIncrement, req.Amount, auth.Amount, total)
+	if err != nil {
+		return result, "", err
+	}
+
+	result = events.IncrementalAuthorizationSucceeded{
+		TransactionID:   req.TransactionID,
+		ChangeID:        changeID,
+		UserID:          auth.UserID,
+		IncrementAmount: req.Amount,
+		TotalAmount:     total,
+	}
+	return result, "", nil
+}
+
+// HandleAuthorizationReversal voids part or all of an open authorization
+// and returns the released amount to the cardholder's available credit.
+func (s *Service) HandleAuthorizationReversal(ctx context.Context, msg transport.Message) error {
+	tr := otel.Tracer("authorization-service")
+	ctx, span := tr.Start(ctx, "HandleAuthorizationReversal")
+	defer span.End()
+
+	var req events.AuthorizationReversalRequested
+	if err := json.Unmarshal(msg.Value, &req); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	log.Printf("Reversing authorization %s (amount %f)", req.TransactionID, req.Amount)
+
+	result, reason, err := s.reverse(ctx, tx, req)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		log.Printf("Rejecting reversal for %s: %s", req.TransactionID, reason)
+		failedEvent := events.AuthorizationReversalFailed{TransactionID: req.TransactionID, Reason: reason}
+		if err := outbox.NewWriter(tx).Write(ctx, "authorization-reversal-failed", req.TransactionID, failedEvent); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	if err := outbox.NewWriter(tx).Write(ctx, "authorization-reversal-succeeded", req.TransactionID, result); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Service) reverse(ctx context.Context, tx *sql.Tx, req events.AuthorizationReversalRequested) (events.AuthorizationReversalSucceeded, string, error) {
+	var result events.AuthorizationReversalSucceeded
+
+	if req.Amount < 0 {
+		return result, ReasonInvalidAmount, nil
+	}
+
+	auth, err := lockAuthorization(ctx, tx, req.TransactionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return result, ReasonNotFound, nil
+	}
+	if err != nil {
+		return result, "", err
+	}
+	if auth.Status != StatusSucceeded {
+		return result, ReasonNotOpen, nil
+	}
+
+	amount := req.Amount
+	if amount == 0 {
+		amount = auth.OpenAmount
+	}
+
+	// A reversal that brings the hold to zero closes the authorization.
+	// Amounts that have already been refunded can no longer be reversed.
+	var remaining float64
+	err = tx.QueryRowContext(ctx, `
+		UPDATE authorizations
+		SET amount = amount - $2,
+		    status = CASE WHEN amount - $2 = 0 THEN $3 ELSE status END
+		WHERE transaction_id = $1 AND amount - refunded_amount - disputed_amount >= $2
+		RETURNING amount
+	`, req.TransactionID, amount, StatusReversed).Scan(&remaining)
+	if errors.Is(err, sql.ErrNoRows) {
+		return result, ReasonReversalExceedsTotal, nil
+	}
+	if err != nil {
+		return result, "", err
+	}
+
+	if err := releaseCredit(ctx, tx, auth.UserID, amount); err != nil {
+		return result, "", err
+	}
+	changeID, err := recordAmountChange(ctx, tx, req.TransactionID, ChangeReversal, -amount, auth.Amount, remaining)
+	if err != nil {
+		return result, "", err
+	}
+
+	result = events.AuthorizationReversalSucceeded{
+		TransactionID:   req.TransactionID,
+		ChangeID:        changeID,
+		UserID:          auth.UserID,
+		ReversedAmount:  amount,
+		RemainingAmount: remaining,
+	}
+	return result, "", nil
+}
@@ -0,0 +1,267 @@
+package authorization
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"credit-authorization-ledger/internal/outbox"
+	"credit-authorization-ledger/internal/transport"
+	"credit-authorization-ledger/pkg/events"
+
+	"go.opentelemetry.io/otel"
+)
+
+// ReasonCaptureBelowRefunds is reported when a transaction clears for less
+// than has already been refunded or disputed.
+const ReasonCaptureBelowRefunds = "cleared amount is below refunded and disputed amounts"
+
+// Change types recorded when a clearing record finalizes an authorization.
+const (
+	ChangeCapture   = "CAPTURE"
+	ChangeForcePost = "FORCE_POST"
+)
+
+// HandleCapture finalizes a transaction at the amount its clearing record
+// settled. The hold moves to the cleared amount, whatever the cardholder's
+// available credit, since the network has already settled it. For
+// foreign-currency transactions that is the amount re-converted at the
+// settlement date's rate. Transactions with no authorization, or a reversed
+// one, are force-posted.
+func (s *Service) HandleCapture(ctx context.Context, msg transport.Message) error {
+	tr := otel.Tracer("authorization-service")
+	ctx, span := tr.Start(ctx, "HandleCapture")
+	defer span.End()
+
+	var event events.CaptureRequested
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	auth, err := lockAuthorization(ctx, tx, event.TransactionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s.forcePost(ctx, tx, event)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Redelivered requests are ignored once the transaction is captured.
+	if auth.Captured {
+		log.Printf("Transaction %s already captured", event.TransactionID)
+		return nil
+	}
+
+	if auth.Status != StatusSucceeded && auth.Status != StatusReversed {
+		return failCapture(ctx, tx, event.TransactionID, fmt.Sprintf("authorization is %s", auth.Status))
+	}
+
+	// A reversed authorization that clears anyway is reopened.
+	res, err := tx.ExecContext(ctx, `
+		UPDATE authorizations
+		SET amount = $2, status = $3, captured_at = NOW(), force_posted = (status = $4),
+		    capture_fx_rate = NULLIF($5::numeric, 0)
+		WHERE transaction_id = $1 AND refunded_amount + disputed_amount <= $2
+	`, event.TransactionID, event.Amount, StatusSucceeded, StatusReversed, event.FXRate)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return failCapture(ctx, tx, event.TransactionID, ReasonCaptureBelowRefunds)
+	}
+
+	adjustment := math.Round((event.Amount-auth.Amount)*100) / 100
+	if adjustment > 0 {
+		err = reclaimCredit(ctx, tx, auth.UserID, adjustment)
+	} else {
+		err = releaseCredit(ctx, tx, auth.UserID, -adjustment)
+	}
+	if err != nil {
+		return err
+	}
+	change := ChangeCapture
+	if auth.Status == StatusReversed {
+		change = ChangeForcePost
+	}
+	if _, err := recordAmountChange(ctx, tx, event.TransactionID, change, adjustment, auth.Amount, event.Amount); err != nil {
+		return err
+	}
+
+	log.Printf("Captured transaction %s at %f (adjustment %f) from %s", event.TransactionID, event.Amount, adjustment, event.ClearingFile)
+	if event.FXRate != 0 {
+		log.Printf("Transaction %s cleared for %f %s at %f", event.TransactionID, event.TransactionAmount, event.TransactionCurrency, event.FXRate)
+	}
+	successEvent := events.CaptureSucceeded{
+		TransactionID: event.TransactionID,
+		UserID:        auth.UserID,
+		Amount:        event.Amount,
+		Adjustment:    adjustment,
+		ForcePosted:   auth.Status == StatusReversed,
+	}
+	if err := outbox.NewWriter(tx).Write(ctx, "capture-succeeded", event.TransactionID, successEvent); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// forcePost records a cleared transaction that was never authorized and
+// charges it to the cardholder.
+func (s *Service) forcePost(ctx context.Context, tx *sql.Tx, event events.CaptureRequested) error {
+	if _, err := s.ensureAccount(ctx, tx, event.UserID); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO authorizations (transaction_id, user_id, amount, status, billing_currency, captured_at, force_posted)
+		VALUES ($1, $2, $3, $4, $5, NOW(), TRUE)
+	`, event.TransactionID, event.UserID, event.Amount, StatusSucceeded, s.fx.BillingCurrency)
+	if err != nil {
+		return err
+	}
+	if err := reclaimCredit(ctx, tx, event.UserID, event.Amount); err != nil {
+		return err
+	}
+	if _, err := recordAmountChange(ctx, tx, event.TransactionID, ChangeForcePost, event.Amount, 0, event.Amount); err != nil {
+		return err
+	}
+
+	log.Printf("Force-posted unauthorized transaction %s of %f for %s from %s", event.TransactionID, event.Amount, event.UserID, event.ClearingFile)
+	successEvent := events.CaptureSucceeded{
+		TransactionID: event.TransactionID,
+		UserID:        event.UserID,
+		Amount:        event.Amount,
+		Adjustment:    event.Amount,
+		ForcePosted:   true,
+	}
+	if err := outbox.NewWriter(tx).Write(ctx, "capture-succeeded", event.TransactionID, successEvent); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func failCapture(ctx context.Context, tx *sql.Tx, transactionID, reason string) error {
+	log.Printf("Rejecting capture of transaction %s: %s", transactionID, reason)
+	failedEvent := events.CaptureFailed{TransactionID: transactionID, Reason: reason}
+	if err := outbox.NewWriter(tx).Write(ctx, "capture-failed", transactionID, failedEvent); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// holdExpiryBatch is how many expired holds ExpireHolds reads at a time.
+const holdExpiryBatch = 100
+
+// ExpireHolds reverses authorizations that have not cleared within after of
+// being made and returns how many were reversed. Their open amount goes back
+// to the cardholder's available credit, and the ledger reverses it through
+// authorization-reversal-succeeded. A transaction that clears later anyway
+// is force-posted. Each hold is reversed in its own transaction and locked
+// with SKIP LOCKED. A hold that fails is logged and skipped rather than
+// holding up the ones behind it.
+func (s *Service) ExpireHolds(ctx context.Context, after time.Duration) (int, error) {
+	expired, failed := 0, 0
+	var lastCreated time.Time
+	lastID := ""
+	for {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT transaction_id, created_at FROM authorizations
+			WHERE status = $1 AND captured_at IS NULL AND created_at < NOW() - $2 * INTERVAL '1 second'
+			AND amount - refunded_amount - disputed_amount > 0 AND (created_at, transaction_id) > ($3, $4)
+			ORDER BY created_at, transaction_id
+			LIMIT $5
+		`, StatusSucceeded, after.Seconds(), lastCreated, lastID, holdExpiryBatch)
+		if err != nil {
+			return expired, err
+		}
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id, &lastCreated); err != nil {
+				rows.Close()
+				return expired, err
+			}
+			ids = append(ids, id)
+			lastID = id
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return expired, err
+		}
+
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return expired, err
+			}
+			done, err := s.expireHold(ctx, id, after)
+			if err != nil {
+				log.Printf("failed to expire hold on transaction %s: %v", id, err)
+				failed++
+				continue
+			}
+			if done {
+				expired++
+			}
+		}
+		if len(ids) < holdExpiryBatch {
+			break
+		}
+	}
+	if failed > 0 {
+		return expired, fmt.Errorf("%d expired holds could not be released", failed)
+	}
+	return expired, nil
+}
+
+// expireHold reverses one expired hold. It reports false if the transaction
+// was captured or reversed, or is locked, by someone else.
+func (s *Service) expireHold(ctx context.Context, transactionID string, after time.Duration) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT transaction_id FROM authorizations
+		WHERE transaction_id = $1 AND status = $2 AND captured_at IS NULL AND created_at < NOW() - $3 * INTERVAL '1 second'
+		AND amount - refunded_amount - disputed_amount > 0
+		FOR UPDATE SKIP LOCKED
+	`, transactionID, StatusSucceeded, after.Seconds()).Scan(&transactionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	result, reason, err := s.reverse(ctx, tx, events.AuthorizationReversalRequested{TransactionID: transactionID})
+	if err != nil {
+		return false, err
+	}
+	if reason != "" {
+		log.Printf("Not releasing expired hold on transaction %s: %s", transactionID, reason)
+		return false, nil
+	}
+
+	log.Printf("Hold on transaction %s expired uncaptured, releasing %f", transactionID, result.ReversedAmount)
+	if err := outbox.NewWriter(tx).Write(ctx, "authorization-reversal-succeeded", transactionID, result); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,289 @@
+package authorization
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"credit-authorization-ledger/internal/dbtest"
+	"credit-authorization-ledger/internal/transport"
+	"credit-authorization-ledger/pkg/events"
+)
+
+type fakeAuthorization struct {
+	userID        string
+	status        string
+	amount        float64
+	refunded      float64
+	captured      bool
+	forcePosted   bool
+	captureFXRate float64
+}
+
+// fakeDB serves the capture and refund handlers' statements from
+// in-memory authorizations, credit accounts and refunds. Transactions
+// apply immediately.
+type fakeDB struct {
+	authorizations map[string]*fakeAuthorization
+	available      map[string]float64
+	// refunds are the status of each refund, followed by the reason it
+	// failed.
+	refunds map[string]string
+	// changes are the audit trail entries, as "type amount".
+	changes []string
+	outbox  []string
+	// captures are the capture-succeeded events written to the outbox.
+	captures []events.CaptureSucceeded
+}
+
+func (f *fakeDB) Query(s *dbtest.Session, query string, args []driver.NamedValue) (driver.Rows, error) {
+	switch {
+	case strings.Contains(query, "FROM authorizations") && strings.Contains(query, "FOR UPDATE"):
+		a, ok := f.authorizations[args[0].Value.(string)]
+		if !ok {
+			return dbtest.Rows(), nil
+		}
+		return dbtest.Rows([]driver.Value{
+			a.userID, a.amount, a.amount - a.refunded, a.status, int64(0), "", a.amount, "USD", 1.0, 0.0, a.captured,
+		}), nil
+	case strings.HasPrefix(query, "SELECT status FROM refunds"):
+		if r, ok := f.refunds[args[0].Value.(string)]; ok {
+			return dbtest.Rows([]driver.Value{r}), nil
+		}
+		return dbtest.Rows(), nil
+	case strings.HasPrefix(query, "SELECT status FROM credit_accounts"):
+		return dbtest.Rows([]driver.Value{CardStatusActive}), nil
+	case strings.Contains(query, "INSERT INTO authorization_amount_changes"):
+		f.changes = append(f.changes, fmt.Sprintf("%s %.2f", args[1].Value, args[2].Value))
+		return dbtest.Rows([]driver.Value{int64(len(f.changes))}), nil
+	}
+	return nil, fmt.Errorf("unexpected query %q", query)
+}
+
+func (f *fakeDB) Exec(s *dbtest.Session, query string, args []driver.NamedValue) (driver.Result, error) {
+	switch {
+	case strings.Contains(query, "refunded_amount = refunded_amount + $2"):
+		a := f.authorizations[args[0].Value.(string)]
+		if amount := args[1].Value.(float64); a.refunded+amount <= a.amount {
+			a.refunded += amount
+			return driver.RowsAffected(1), nil
+		}
+		return driver.RowsAffected(0), nil
+	case strings.Contains(query, "INSERT INTO refunds"):
+		status := args[4].Value.(string)
+		if len(args) > 5 {
+			status += ": " + args[5].Value.(string)
+		}
+		f.refunds[args[0].Value.(string)] = status
+	case strings.Contains(query, "UPDATE authorizations"):
+		a := f.authorizations[args[0].Value.(string)]
+		amount := args[1].Value.(float64)
+		if a.refunded > amount {
+			return driver.RowsAffected(0), nil
+		}
+		a.forcePosted = a.status == args[3].Value
+		a.amount, a.status, a.captured, a.captureFXRate = amount, args[2].Value.(string), true, args[4].Value.(float64)
+	case strings.Contains(query, "INSERT INTO authorizations"):
+		f.authorizations[args[0].Value.(string)] = &fakeAuthorization{
+			userID: args[1].Value.(string), amount: args[2].Value.(float64), status: args[3].Value.(string),
+			captured: true, forcePosted: true,
+		}
+	case strings.Contains(query, "INSERT INTO credit_accounts"):
+		if _, ok := f.available[args[0].Value.(string)]; ok {
+			return driver.RowsAffected(0), nil
+		}
+		f.available[args[0].Value.(string)] = args[1].Value.(float64)
+	case strings.Contains(query, "available_credit - $2"):
+		f.available[args[0].Value.(string)] -= args[1].Value.(float64)
+	case strings.Contains(query, "available_credit + $2"):
+		f.available[args[0].Value.(string)] += args[1].Value.(float64)
+	case strings.Contains(query, "INSERT INTO outbox"):
+		f.outbox = append(f.outbox, args[0].Value.(string))
+		if args[0].Value == "capture-succeeded" {
+			var ev events.CaptureSucceeded
+			if err := json.Unmarshal(args[2].Value.([]byte), &ev); err != nil {
+				return nil, err
+			}
+			f.captures = append(f.captures, ev)
+		}
+	default:
+		return nil, fmt.Errorf("unexpected statement %q", query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func TestHandleCapture(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeDB{
+		authorizations: map[string]*fakeAuthorization{
+			"tx-up":       {userID: "user-1", status: StatusSucceeded, amount: 40},
+			"tx-down":     {userID: "user-1", status: StatusSucceeded, amount: 40},
+			"tx-reversed": {userID: "user-1", status: StatusReversed},
+			"tx-refunded": {userID: "user-1", status: StatusSucceeded, amount: 40, refunded: 30},
+			"tx-review":   {userID: "user-1", status: StatusPendingReview, amount: 40},
+		},
+		available: map[string]float64{"user-1": 100},
+	}
+	s := NewService(dbtest.Open(f), 500)
+
+	capture := func(ev events.CaptureRequested) {
+		t.Helper()
+		value, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.HandleCapture(ctx, transport.Message{Topic: "capture-requests", Key: []byte(ev.TransactionID), Value: value}); err != nil {
+			t.Fatalf("HandleCapture(%s): %v", ev.TransactionID, err)
+		}
+	}
+
+	// A foreign-currency transaction re-converted at a higher rate.
+	capture(events.CaptureRequested{TransactionID: "tx-up", Amount: 44, TransactionCurrency: "EUR", TransactionAmount: 40, FXRate: 1.1})
+	capture(events.CaptureRequested{TransactionID: "tx-down", Amount: 35.5})
+	capture(events.CaptureRequested{TransactionID: "tx-reversed", Amount: 10})
+	capture(events.CaptureRequested{TransactionID: "tx-unknown", UserID: "user-2", Amount: 25})
+	capture(events.CaptureRequested{TransactionID: "tx-refunded", Amount: 20})
+	capture(events.CaptureRequested{TransactionID: "tx-review", Amount: 40})
+	// Redelivered captures change nothing.
+	capture(events.CaptureRequested{TransactionID: "tx-up", Amount: 44, FXRate: 1.1})
+
+	if got := strings.Join(f.outbox, " "); got != "capture-succeeded capture-succeeded capture-succeeded credit-limit-changed capture-succeeded capture-failed capture-failed" {
+		t.Errorf("outbox = %s", got)
+	}
+	want := []events.CaptureSucceeded{
+		{TransactionID: "tx-up", UserID: "user-1", Amount: 44, Adjustment: 4},
+		{TransactionID: "tx-down", UserID: "user-1", Amount: 35.5, Adjustment: -4.5},
+		{TransactionID: "tx-reversed", UserID: "user-1", Amount: 10, Adjustment: 10, ForcePosted: true},
+		{TransactionID: "tx-unknown", UserID: "user-2", Amount: 25, Adjustment: 25, ForcePosted: true},
+	}
+	if fmt.Sprint(f.captures) != fmt.Sprint(want) {
+		t.Errorf("captures = %+v, want %+v", f.captures, want)
+	}
+	if got := strings.Join(f.changes, ", "); got != "CAPTURE 4.00, CAPTURE -4.50, FORCE_POST 10.00, FORCE_POST 25.00" {
+		t.Errorf("amount changes = %s", got)
+	}
+
+	// user-1's credit moves by each adjustment: 100 - 4 + 4.5 - 10.
+	if f.available["user-1"] != 90.5 || f.available["user-2"] != 475 {
+		t.Errorf("available credit = %v, want user-1 90.50 and user-2 475.00", f.available)
+	}
+	if a := f.authorizations["tx-up"]; a.amount != 44 || a.captureFXRate != 1.1 || !a.captured {
+		t.Errorf("tx-up = %+v, want captured at 44.00 and rate 1.1", a)
+	}
+	if a := f.authorizations["tx-reversed"]; a.status != StatusSucceeded || !a.forcePosted {
+		t.Errorf("tx-reversed = %+v, want reopened and force-posted", a)
+	}
+	if a := f.authorizations["tx-refunded"]; a.captured || a.amount != 40 {
+		t.Errorf("tx-refunded = %+v, want it left uncaptured below its refunds", a)
+	}
+}
+
+func TestExpireHoldsPostgres(t *testing.T) {
+	ctx := context.Background()
+	db := dbtest.Postgres(t, dbtest.Authorization, dbtest.Outbox)
+	_, err := db.Exec(`
+		INSERT INTO authorizations (transaction_id, user_id, amount, status, captured_at, created_at) VALUES
+		('tx-expired', 'user-1', 40, $1, NULL, NOW() - INTERVAL '2 hours'),
+		('tx-locked', 'user-1', 30, $1, NULL, NOW() - INTERVAL '2 hours'),
+		('tx-captured', 'user-1', 20, $1, NOW(), NOW() - INTERVAL '2 hours'),
+		('tx-reversed', 'user-1', 0, $2, NULL, NOW() - INTERVAL '2 hours'),
+		('tx-fresh', 'user-1', 10, $1, NULL, NOW())
+	`, StatusSucceeded, StatusReversed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO credit_accounts (user_id, credit_limit, available_credit) VALUES ('user-1', 500, 400)"); err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(db, 500)
+
+	// A hold locked by a capture in flight is skipped, not waited on.
+	locker, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer locker.Rollback()
+	if _, err := locker.Exec("SELECT 1 FROM authorizations WHERE transaction_id = 'tx-locked' FOR UPDATE"); err != nil {
+		t.Fatal(err)
+	}
+
+	expire := func(want int) {
+		t.Helper()
+		n, err := s.ExpireHolds(ctx, time.Hour)
+		if err != nil {
+			t.Fatalf("ExpireHolds: %v", err)
+		}
+		if n != want {
+			t.Errorf("ExpireHolds expired %d holds, want %d", n, want)
+		}
+	}
+	expire(1)
+	// Released holds are not released again.
+	expire(0)
+	if err := locker.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	expire(1)
+
+	rows, err := db.Query("SELECT transaction_id, status, amount FROM authorizations ORDER BY transaction_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var id, status string
+		var amount float64
+		if err := rows.Scan(&id, &status, &amount); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s %s %.2f", id, status, amount))
+	}
+	want := []string{
+		"tx-captured " + StatusSucceeded + " 20.00",
+		"tx-expired " + StatusReversed + " 0.00",
+		"tx-fresh " + StatusSucceeded + " 10.00",
+		"tx-locked " + StatusReversed + " 0.00",
+		"tx-reversed " + StatusReversed + " 0.00",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("authorizations =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	var available float64
+	if err := db.QueryRow("SELECT available_credit FROM credit_accounts WHERE user_id = 'user-1'").Scan(&available); err != nil {
+		t.Fatal(err)
+	}
+	if available != 470 {
+		t.Errorf("available credit = %.2f, want 470.00", available)
+	}
+
+	var keys []string
+	rows, err = db.Query("SELECT key, payload FROM outbox WHERE topic = 'authorization-reversal-succeeded' ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var payload []byte
+		if err := rows.Scan(&key, &payload); err != nil {
+			t.Fatal(err)
+		}
+		var ev events.AuthorizationReversalSucceeded
+		if err := json.Unmarshal(payload, &ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.TransactionID != key || ev.ChangeID == 0 || ev.RemainingAmount != 0 {
+			t.Errorf("reversal of %s = %+v, want it closed with a change ID", key, ev)
+		}
+		keys = append(keys, key)
+	}
+	if got := strings.Join(keys, " "); got != "tx-expired tx-locked" {
+		t.Errorf("reversals published for %s, want tx-expired tx-locked", got)
+	}
+}
@@ -0,0 +1,154 @@
+package authorization
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"credit-authorization-ledger/internal/fx"
+	"credit-authorization-ledger/internal/outbox"
+	"credit-authorization-ledger/pkg/events"
+)
+
+// Decline reasons reported in failure events.
+const (
+	ReasonInvalidAmount        = "invalid amount"
+	ReasonInsufficientCredit   = "insufficient available credit"
+	ReasonNotFound             = "authorization not found"
+	ReasonNotOpen              = "authorization is not open"
+	ReasonReversalExceedsTotal = "reversal exceeds authorized amount"
+	ReasonNotCaptured          = "transaction is not captured"
+	ReasonRefundExceedsCapture = "refunds exceed captured amount"
+)
+
+// Change types recorded in authorization_amount_changes.
+const (
+	ChangeInitial   = "INITIAL"
+	ChangeIncrement = "INCREMENT"
+	ChangeReversal  = "REVERSAL"
+	// ChangeReviewDeclined releases the hold of an authorization declined in manual review.
+	ChangeReviewDeclined = "REVIEW_DECLINED"
+)
+
+// authorizationRecord is the locked state of an existing authorization.
+type authorizationRecord struct {
+	TransactionID string
+	UserID        string
+	Amount        float64
+	// OpenAmount is the part of Amount that has not been refunded or disputed.
+	OpenAmount float64
+	Status     string
+	RiskScore  int
+	// Captured is set once the transaction has cleared.
+	Captured bool
+	// Conversion is how Amount was converted from the transaction currency.
+	Conversion fx.Conversion
+}
+
+// lockAuthorization loads an authorization and locks its row until tx ends.
+// It returns sql.ErrNoRows if the transaction is unknown.
+func lockAuthorization(ctx context.Context, tx *sql.Tx, transactionID string) (authorizationRecord, error) {
+	rec := authorizationRecord{TransactionID: transactionID}
+	conv := &rec.Conversion
+	err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(user_id, ''), amount, amount - refunded_amount - disputed_amount, status, risk_score,
+		       COALESCE(transaction_currency, ''), COALESCE(transaction_amount, amount),
+		       COALESCE(billing_currency, ''), COALESCE(fx_rate, 1), COALESCE(fx_markup, 0),
+		       captured_at IS NOT NULL
+		FROM authorizations
+		WHERE transaction_id = $1
+		FOR UPDATE
+	`, transactionID).Scan(&rec.UserID, &rec.Amount, &rec.OpenAmount, &rec.Status, &rec.RiskScore,
+		&conv.TransactionCurrency, &conv.TransactionAmount, &conv.BillingCurrency, &conv.Rate, &conv.Markup,
+		&rec.Captured)
+	return rec, err
+}
+
+// ensureAccount returns the card status of the cardholder's credit account.
+// Cardholders without an account get one with the default limit, which is
+// announced with credit-limit-changed.
+func (s *Service) ensureAccount(ctx context.Context, tx *sql.Tx, userID string) (string, error) {
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO credit_accounts (user_id, credit_limit, available_credit)
+		VALUES ($1, $2, $2)
+		ON CONFLICT (user_id) DO NOTHING
+	`, userID, s.defaultCreditLimit)
+	if err != nil {
+		return "", err
+	}
+	opened, err := res.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if opened == 1 {
+		limitEvent := events.CreditLimitChanged{UserID: userID, CreditLimit: s.defaultCreditLimit, ChangedAt: time.Now().UTC()}
+		if err := outbox.NewWriter(tx).Write(ctx, "credit-limit-changed", userID, limitEvent); err != nil {
+			return "", err
+		}
+	}
+
+	var status string
+	err = tx.QueryRowContext(ctx, "SELECT status FROM credit_accounts WHERE user_id = $1", userID).Scan(&status)
+	return status, err
+}
+
+// reserveCredit deducts amount from the cardholder's available credit. A
+// non-empty reason is returned, and nothing is changed, if the hold cannot
+// be placed.
+func (s *Service) reserveCredit(ctx context.Context, tx *sql.Tx, userID string, amount float64) (string, error) {
+	if amount <= 0 {
+		return ReasonInvalidAmount, nil
+	}
+
+	// The comparison is done in SQL so it runs on NUMERIC rather than floats.
+	res, err := tx.ExecContext(ctx, `
+		UPDATE credit_accounts
+		SET available_credit = available_credit - $2, updated_at = NOW()
+		WHERE user_id = $1 AND available_credit >= $2
+	`, userID, amount)
+	if err != nil {
+		return "", err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return ReasonInsufficientCredit, nil
+	}
+	return "", nil
+}
+
+// releaseCredit returns amount to the cardholder's available credit.
+func releaseCredit(ctx context.Context, tx *sql.Tx, userID string, amount float64) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE credit_accounts
+		SET available_credit = available_credit + $2, updated_at = NOW()
+		WHERE user_id = $1
+	`, userID, amount)
+	return err
+}
+
+// reclaimCredit takes amount back out of the cardholder's available credit
+// without checking the limit. It is used to compensate steps that have
+// already released credit, so it must not fail for lack of funds.
+func reclaimCredit(ctx context.Context, tx *sql.Tx, userID string, amount float64) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE credit_accounts
+		SET available_credit = available_credit - $2, updated_at = NOW()
+		WHERE user_id = $1
+	`, userID, amount)
+	return err
+}
+
+// recordAmountChange appends an entry to the audit trail of held amounts
+// and returns its change ID.
+func recordAmountChange(ctx context.Context, tx *sql.Tx, transactionID, changeType string, amount, previous, current float64) (int64, error) {
+	var changeID int64
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO authorization_amount_changes (transaction_id, change_type, amount, previous_amount, new_amount)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING change_id
+	`, transactionID, changeType, amount, previous, current).Scan(&changeID)
+	return changeID, err
+}
@@ -0,0 +1,466 @@
+package authorization
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"credit-authorization-ledger/internal/outbox"
+	"credit-authorization-ledger/internal/transport"
+	"credit-authorization-ledger/pkg/events"
+
+	"go.opentelemetry.io/otel"
+)
+
+// Dispute statuses. OPENED and EVIDENCE_REQUESTED are undecided; the
+// cardholder keeps the provisional credit unless the dispute is LOST. FAILED
+// disputes were rejected by the ledger and never took effect.
+const (
+	DisputeStatusOpened            = "OPENED"
+	DisputeStatusEvidenceRequested = "EVIDENCE_REQUESTED"
+	DisputeStatusWon               = "WON"
+	DisputeStatusLost              = "LOST"
+	DisputeStatusFailed            = "FAILED"
+)
+
+// SystemDisputeResolver is recorded in the notes of disputes decided by
+// their deadline.
+const SystemDisputeResolver = "system"
+
+var (
+	ErrDisputeNotFound          = errors.New("dispute not found")
+	ErrDisputeNotPending        = errors.New("dispute is already decided")
+	ErrDisputeInvalidTransition = errors.New("evidence can only be requested on an opened dispute")
+	ErrDisputeNotCaptured       = errors.New("transaction not found or not captured")
+	ErrDisputeInvalidAmount     = errors.New("dispute amount must be positive and within the undisputed captured amount")
+)
+
+// DisputePolicy sets how long each undecided dispute state lasts. A merchant
+// that does not respond within ResponseWindow loses the dispute; a
+// cardholder who does not provide evidence within EvidenceWindow loses it.
+type DisputePolicy struct {
+	ResponseWindow time.Duration
+	EvidenceWindow time.Duration
+}
+
+// DefaultDisputePolicy gives merchants 30 days to respond and cardholders 10
+// days to provide evidence.
+var DefaultDisputePolicy = DisputePolicy{
+	ResponseWindow: 30 * 24 * time.Hour,
+	EvidenceWindow: 10 * 24 * time.Hour,
+}
+
+// WithDisputePolicy overrides DefaultDisputePolicy.
+func WithDisputePolicy(p DisputePolicy) Option {
+	return func(s *Service) {
+		s.disputes = p
+	}
+}
+
+// Dispute is a chargeback raised on a captured transaction.
+type Dispute struct {
+	DisputeID     string     `json:"dispute_id"`
+	TransactionID string     `json:"transaction_id"`
+	UserID        string     `json:"user_id"`
+	Amount        float64    `json:"amount"`
+	Status        string     `json:"status"`
+	Reason        string     `json:"reason"`
+	Notes         string     `json:"notes,omitempty"`
+	Deadline      *time.Time `json:"deadline,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ResolvedAt    *time.Time `json:"resolved_at,omitempty"`
+}
+
+// transitionTopics maps a dispute status to the topic announcing it.
+var transitionTopics = map[string]string{
+	DisputeStatusOpened:            "dispute-opened",
+	DisputeStatusEvidenceRequested: "dispute-evidence-requested",
+	DisputeStatusWon:               "dispute-won",
+	DisputeStatusLost:              "dispute-lost",
+	DisputeStatusFailed:            "dispute-failed",
+}
+
+func newDisputeID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// OpenDispute disputes amount of a captured transaction and provisionally
+// credits it back to the cardholder's available credit. The dispute saga
+// then posts the provisional credit to the ledger.
+func (s *Service) OpenDispute(ctx context.Context, transactionID string, amount float64, reason string) (Dispute, error) {
+	d := Dispute{TransactionID: transactionID, Amount: amount, Status: DisputeStatusOpened, Reason: reason}
+	id, err := newDisputeID()
+	if err != nil {
+		return d, err
+	}
+	d.DisputeID = id
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return d, err
+	}
+	defer tx.Rollback()
+
+	auth, err := lockAuthorization(ctx, tx, transactionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return d, ErrDisputeNotCaptured
+	}
+	if err != nil {
+		return d, err
+	}
+	if auth.Status != StatusSucceeded {
+		return d, ErrDisputeNotCaptured
+	}
+	// Compare in SQL so the check runs on NUMERIC rather than floats.
+	res, err := tx.ExecContext(ctx, `
+		UPDATE authorizations
+		SET disputed_amount = disputed_amount + $2
+		WHERE transaction_id = $1 AND $2 > 0 AND refunded_amount + disputed_amount + $2 <= amount
+	`, transactionID, amount)
+	if err != nil {
+		return d, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return d, err
+	}
+	if n == 0 {
+		return d, ErrDisputeInvalidAmount
+	}
+	d.UserID = auth.UserID
+
+	if err := releaseCredit(ctx, tx, auth.UserID, amount); err != nil {
+		return d, err
+	}
+
+	now := time.Now()
+	deadline := now.Add(s.disputes.ResponseWindow)
+	d.Deadline, d.CreatedAt = &deadline, now
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO disputes (dispute_id, transaction_id, user_id, amount, status, reason, deadline, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, d.DisputeID, d.TransactionID, d.UserID, d.Amount, d.Status, d.Reason, deadline, now)
+	if err != nil {
+		return d, err
+	}
+
+	if err := announceDispute(ctx, tx, d); err != nil {
+		return d, err
+	}
+	log.Printf("Opened dispute %s for %f of transaction %s: %s", d.DisputeID, amount, transactionID, reason)
+	return d, tx.Commit()
+}
+
+// RequestEvidence asks the cardholder to back an opened dispute, which is
+// lost if they have not by the evidence deadline.
+func (s *Service) RequestEvidence(ctx context.Context, disputeID, notes string) (Dispute, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Dispute{}, err
+	}
+	defer tx.Rollback()
+
+	d, err := lockDispute(ctx, tx, disputeID)
+	if err != nil {
+		return d, err
+	}
+	if d.Status != DisputeStatusOpened {
+		return d, ErrDisputeInvalidTransition
+	}
+
+	deadline := time.Now().Add(s.disputes.EvidenceWindow)
+	if err := transitionDispute(ctx, tx, &d, DisputeStatusEvidenceRequested, notes, &deadline); err != nil {
+		return d, err
+	}
+	return d, tx.Commit()
+}
+
+// ResolveDispute decides an undecided dispute. A won dispute keeps the
+// provisional credit; a lost one takes it back from the cardholder.
+func (s *Service) ResolveDispute(ctx context.Context, disputeID string, won bool, notes string) (Dispute, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Dispute{}, err
+	}
+	defer tx.Rollback()
+
+	d, err := lockDispute(ctx, tx, disputeID)
+	if err != nil {
+		return d, err
+	}
+	if err := s.resolveDispute(ctx, tx, &d, won, notes); err != nil {
+		return d, err
+	}
+	return d, tx.Commit()
+}
+
+func (s *Service) resolveDispute(ctx context.Context, tx *sql.Tx, d *Dispute, won bool, notes string) error {
+	if d.Status != DisputeStatusOpened && d.Status != DisputeStatusEvidenceRequested {
+		return ErrDisputeNotPending
+	}
+
+	if won {
+		return transitionDispute(ctx, tx, d, DisputeStatusWon, notes, nil)
+	}
+	if err := undoProvisionalCredit(ctx, tx, *d); err != nil {
+		return err
+	}
+	return transitionDispute(ctx, tx, d, DisputeStatusLost, notes, nil)
+}
+
+// disputeExpiryBatch is how many timed out disputes ExpireDisputes reads at
+// a time.
+const disputeExpiryBatch = 100
+
+// ExpireDisputes decides disputes past their deadline and returns how many
+// were decided: the cardholder wins if the merchant never responded and
+// loses if they never provided evidence. Each dispute is decided in its own
+// transaction and locked with SKIP LOCKED, so several replicas can run it at
+// once. A dispute that fails is logged and skipped rather than holding up
+// the ones behind it.
+func (s *Service) ExpireDisputes(ctx context.Context) (int, error) {
+	expired, failed := 0, 0
+	var lastDeadline time.Time
+	lastID := ""
+	for {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT dispute_id, deadline FROM disputes
+			WHERE status IN ($1, $2) AND deadline < NOW() AND (deadline, dispute_id) > ($3, $4)
+			ORDER BY deadline, dispute_id
+			LIMIT $5
+		`, DisputeStatusOpened, DisputeStatusEvidenceRequested, lastDeadline, lastID, disputeExpiryBatch)
+		if err != nil {
+			return expired, err
+		}
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id, &lastDeadline); err != nil {
+				rows.Close()
+				return expired, err
+			}
+			ids = append(ids, id)
+			lastID = id
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return expired, err
+		}
+
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return expired, err
+			}
+			done, err := s.expireDispute(ctx, id)
+			if err != nil {
+				log.Printf("failed to expire dispute %s: %v", id, err)
+				failed++
+				continue
+			}
+			if done {
+				expired++
+			}
+		}
+		if len(ids) < disputeExpiryBatch {
+			break
+		}
+	}
+	if failed > 0 {
+		return expired, fmt.Errorf("%d timed out disputes could not be decided", failed)
+	}
+	return expired, nil
+}
+
+// expireDispute decides one timed out dispute. It reports false if the
+// dispute was decided, or is locked, by someone else.
+func (s *Service) expireDispute(ctx context.Context, disputeID string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT dispute_id FROM disputes
+		WHERE dispute_id = $1 AND status IN ($2, $3) AND deadline < NOW()
+		FOR UPDATE SKIP LOCKED
+	`, disputeID, DisputeStatusOpened, DisputeStatusEvidenceRequested).Scan(&disputeID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	d, err := lockDispute(ctx, tx, disputeID)
+	if err != nil {
+		return false, err
+	}
+	won := d.Status == DisputeStatusOpened
+	notes := fmt.Sprintf("%s: evidence deadline passed", SystemDisputeResolver)
+	if won {
+		notes = fmt.Sprintf("%s: merchant response deadline passed", SystemDisputeResolver)
+	}
+	log.Printf("Dispute %s timed out in status %s (won: %t)", disputeID, d.Status, won)
+	if err := s.resolveDispute(ctx, tx, &d, won, notes); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// ListDisputes returns disputes, newest first. An empty status lists every
+// undecided dispute.
+func (s *Service) ListDisputes(ctx context.Context, status string, limit int) ([]Dispute, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT dispute_id, transaction_id, user_id, amount, status, reason, COALESCE(notes, ''),
+		       deadline, created_at, resolved_at
+		FROM disputes
+		WHERE ($1 = '' AND status IN ($2, $3)) OR status = $1
+		ORDER BY created_at DESC
+		LIMIT $4
+	`, status, DisputeStatusOpened, DisputeStatusEvidenceRequested, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	disputes := []Dispute{}
+	for rows.Next() {
+		d, err := scanDispute(rows)
+		if err != nil {
+			return nil, err
+		}
+		disputes = append(disputes, d)
+	}
+	return disputes, rows.Err()
+}
+
+// HandleDisputeCompensation fails a dispute whose provisional credit the
+// ledger could not post, taking the credit back. The dispute may already
+// have been decided by then, as the ledger rejects the decision too: a won
+// dispute still holds the credit, a lost one has already given it back.
+func (s *Service) HandleDisputeCompensation(ctx context.Context, msg transport.Message) error {
+	tr := otel.Tracer("authorization-service")
+	ctx, span := tr.Start(ctx, "HandleDisputeCompensation")
+	defer span.End()
+
+	var event events.DisputeLedgerFailed
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	d, err := lockDispute(ctx, tx, event.DisputeID)
+	if err != nil {
+		return err
+	}
+	if d.Status == DisputeStatusFailed {
+		log.Printf("Dispute %s already compensated: %s", d.DisputeID, event.Reason)
+		return nil
+	}
+
+	log.Printf("Compensating dispute %s in status %s: %s", d.DisputeID, d.Status, event.Reason)
+	if d.Status != DisputeStatusLost {
+		if err := undoProvisionalCredit(ctx, tx, d); err != nil {
+			return err
+		}
+	}
+	if err := transitionDispute(ctx, tx, &d, DisputeStatusFailed, event.Reason, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// undoProvisionalCredit takes a dispute's amount back out of the
+// cardholder's available credit and frees it for refunds and reversals.
+func undoProvisionalCredit(ctx context.Context, tx *sql.Tx, d Dispute) error {
+	_, err := tx.ExecContext(ctx,
+		"UPDATE authorizations SET disputed_amount = disputed_amount - $2 WHERE transaction_id = $1",
+		d.TransactionID, d.Amount)
+	if err != nil {
+		return err
+	}
+	return reclaimCredit(ctx, tx, d.UserID, d.Amount)
+}
+
+// transitionDispute moves a locked dispute to status and announces it.
+// Decided disputes have no deadline.
+func transitionDispute(ctx context.Context, tx *sql.Tx, d *Dispute, status, notes string, deadline *time.Time) error {
+	decided := status != DisputeStatusOpened && status != DisputeStatusEvidenceRequested
+	_, err := tx.ExecContext(ctx, `
+		UPDATE disputes
+		SET status = $2, notes = $3, deadline = $4, updated_at = NOW(),
+		    resolved_at = CASE WHEN $5 THEN NOW() END
+		WHERE dispute_id = $1
+	`, d.DisputeID, status, notes, deadline, decided)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Dispute %s moved from %s to %s", d.DisputeID, d.Status, status)
+	d.Status, d.Notes, d.Deadline = status, notes, deadline
+	if decided {
+		now := time.Now()
+		d.ResolvedAt = &now
+	}
+	return announceDispute(ctx, tx, *d)
+}
+
+func announceDispute(ctx context.Context, tx *sql.Tx, d Dispute) error {
+	event := events.DisputeTransitioned{
+		DisputeID:     d.DisputeID,
+		TransactionID: d.TransactionID,
+		UserID:        d.UserID,
+		Amount:        d.Amount,
+		Status:        d.Status,
+		Reason:        d.Reason,
+	}
+	return outbox.NewWriter(tx).Write(ctx, transitionTopics[d.Status], d.TransactionID, event)
+}
+
+// lockDispute loads a dispute and locks its row until tx ends.
+func lockDispute(ctx context.Context, tx *sql.Tx, disputeID string) (Dispute, error) {
+	row := tx.QueryRowContext(ctx, `
+		SELECT dispute_id, transaction_id, user_id, amount, status, reason, COALESCE(notes, ''),
+		       deadline, created_at, resolved_at
+		FROM disputes
+		WHERE dispute_id = $1
+		FOR UPDATE
+	`, disputeID)
+	d, err := scanDispute(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return d, ErrDisputeNotFound
+	}
+	return d, err
+}
+
+// scanDispute reads the columns selected by lockDispute and ListDisputes.
+func scanDispute(row interface{ Scan(...interface{}) error }) (Dispute, error) {
+	var d Dispute
+	var deadline, resolvedAt sql.NullTime
+	err := row.Scan(&d.DisputeID, &d.TransactionID, &d.UserID, &d.Amount, &d.Status, &d.Reason, &d.Notes,
+		&deadline, &d.CreatedAt, &resolvedAt)
+	if deadline.Valid {
+		d.Deadline = &deadline.Time
+	}
+	if resolvedAt.Valid {
+		d.ResolvedAt = &resolvedAt.Time
+	}
+	return d, err
+}
@@ -0,0 +1,71 @@
+package authorization
+
+import (
+	"context"
+	"strings"
+
+	"credit-authorization-ledger/internal/fx"
+	"credit-authorization-ledger/pkg/events"
+)
+
+// ReasonUnsupportedCurrency declines authorizations in a currency without an
+// exchange rate to the billing currency.
+const ReasonUnsupportedCurrency = "unsupported transaction currency"
+
+// FXPolicy sets the currency cardholders are billed in and the markup added
+// to the exchange rate for transactions in any other currency.
+type FXPolicy struct {
+	BillingCurrency string
+	Markup          float64
+}
+
+// DefaultFXPolicy bills in US dollars with no markup.
+var DefaultFXPolicy = FXPolicy{BillingCurrency: "USD"}
+
+// WithFX converts foreign-currency authorizations into the billing currency
+// at rates from p. Without it, only billing-currency authorizations are
+// accepted.
+func WithFX(p fx.RateProvider, policy FXPolicy) Option {
+	return func(s *Service) {
+		s.rates = p
+		s.fx = policy
+	}
+}
+
+// convert turns the requested amount into the billing currency.
+func (s *Service) convert(ctx context.Context, req events.AuthorizationRequested) (fx.Conversion, error) {
+	currency := req.Currency
+	if currency == "" {
+		currency = s.fx.BillingCurrency
+	}
+	if len(currency) != 3 {
+		return fx.Conversion{BillingCurrency: s.fx.BillingCurrency}, fx.ErrRateUnavailable
+	}
+	if s.rates == nil && !strings.EqualFold(currency, s.fx.BillingCurrency) {
+		return fx.Conversion{
+			TransactionCurrency: strings.ToUpper(currency),
+			TransactionAmount:   req.Amount,
+			BillingCurrency:     s.fx.BillingCurrency,
+		}, fx.ErrRateUnavailable
+	}
+	return fx.Convert(ctx, s.rates, req.Amount, currency, s.fx.BillingCurrency, s.fx.Markup)
+}
+
+// succeededEvent builds authorization-succeeded, including the conversion
+// of foreign-currency authorizations.
+func succeededEvent(transactionID, userID string, amount float64, riskScore int, conv fx.Conversion) events.AuthorizationSucceeded {
+	e := events.AuthorizationSucceeded{
+		TransactionID: transactionID,
+		UserID:        userID,
+		Amount:        amount,
+		RiskScore:     riskScore,
+	}
+	if conv.Foreign() {
+		e.TransactionCurrency = conv.TransactionCurrency
+		e.TransactionAmount = conv.TransactionAmount
+		e.BillingCurrency = conv.BillingCurrency
+		e.FXRate = conv.Rate
+		e.FXMarkup = conv.Markup
+	}
+	return e
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS authorization_amount_changes;
+DROP TABLE IF EXISTS credit_accounts;
+ALTER TABLE authorizations DROP COLUMN IF EXISTS user_id;
@@ -0,0 +1,21 @@
+ALTER TABLE authorizations ADD COLUMN IF NOT EXISTS user_id VARCHAR(255);
+
+CREATE TABLE IF NOT EXISTS credit_accounts (
+    user_id VARCHAR(255) PRIMARY KEY,
+    credit_limit NUMERIC(12, 2) NOT NULL,
+    available_credit NUMERIC(12, 2) NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Every change to the held amount of an authorization, including the initial hold.
+CREATE TABLE IF NOT EXISTS authorization_amount_changes (
+    change_id BIGSERIAL PRIMARY KEY,
+    transaction_id VARCHAR(255) NOT NULL,
+    change_type VARCHAR(50) NOT NULL,
+    amount NUMERIC(12, 2) NOT NULL,
+    previous_amount NUMERIC(12, 2) NOT NULL,
+    new_amount NUMERIC(12, 2) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_authorization_amount_changes_transaction_id ON authorization_amount_changes (transaction_id);
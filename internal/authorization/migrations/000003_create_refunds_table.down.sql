@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS refunds;
+ALTER TABLE authorizations DROP COLUMN IF EXISTS refunded_amount;
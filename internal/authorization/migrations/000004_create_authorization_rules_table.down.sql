@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS authorization_rules;
+ALTER TABLE authorizations DROP COLUMN IF EXISTS merchant_country;
+ALTER TABLE authorizations DROP COLUMN IF EXISTS merchant_category_code;
+ALTER TABLE credit_accounts DROP COLUMN IF EXISTS status;
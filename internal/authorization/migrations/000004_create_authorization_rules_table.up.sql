@@ -0,0 +1,15 @@
+ALTER TABLE credit_accounts ADD COLUMN IF NOT EXISTS status VARCHAR(50) NOT NULL DEFAULT 'ACTIVE';
+
+ALTER TABLE authorizations ADD COLUMN IF NOT EXISTS merchant_category_code VARCHAR(4);
+ALTER TABLE authorizations ADD COLUMN IF NOT EXISTS merchant_country VARCHAR(2);
+
+-- Decline rules evaluated in ascending position order.
+CREATE TABLE IF NOT EXISTS authorization_rules (
+    name VARCHAR(255) PRIMARY KEY,
+    rule_type VARCHAR(100) NOT NULL,
+    position INT NOT NULL,
+    params JSONB NOT NULL DEFAULT '{}',
+    shadow BOOLEAN NOT NULL DEFAULT FALSE,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
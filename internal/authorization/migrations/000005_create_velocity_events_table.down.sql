@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS velocity_events;
+ALTER TABLE authorizations DROP COLUMN IF EXISTS risk_score;
+ALTER TABLE authorizations DROP COLUMN IF EXISTS merchant_id;
@@ -0,0 +1,15 @@
+ALTER TABLE authorizations ADD COLUMN IF NOT EXISTS merchant_id VARCHAR(255);
+ALTER TABLE authorizations ADD COLUMN IF NOT EXISTS risk_score INT NOT NULL DEFAULT 0;
+
+-- Sliding-window history for velocity limits. Rows older than the longest
+-- configured window are pruned.
+CREATE TABLE IF NOT EXISTS velocity_events (
+    transaction_id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    merchant_id VARCHAR(255),
+    amount NUMERIC(12, 2) NOT NULL,
+    occurred_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_velocity_events_user_id ON velocity_events (user_id, occurred_at);
+CREATE INDEX IF NOT EXISTS idx_velocity_events_occurred_at ON velocity_events (occurred_at);
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS review_queue (
+    transaction_id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    amount NUMERIC(12, 2) NOT NULL,
+    risk_score INT NOT NULL DEFAULT 0,
+    reason TEXT NOT NULL DEFAULT '',
+    status VARCHAR(50) NOT NULL,
+    claimed_by VARCHAR(255),
+    claimed_at TIMESTAMPTZ,
+    decided_by VARCHAR(255),
+    decided_at TIMESTAMPTZ,
+    notes TEXT,
+    deadline TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_review_queue_status ON review_queue (status, created_at);
+CREATE INDEX IF NOT EXISTS idx_review_queue_deadline ON review_queue (deadline) WHERE status IN ('OPEN', 'CLAIMED');
+
+-- Authorizations parked before the queue existed.
+INSERT INTO review_queue (transaction_id, user_id, amount, risk_score, status, deadline)
+SELECT transaction_id, COALESCE(user_id, ''), amount, risk_score, 'OPEN', NOW() + INTERVAL '24 hours'
+FROM authorizations
+WHERE status = 'PENDING_REVIEW'
+ON CONFLICT (transaction_id) DO NOTHING;
@@ -0,0 +1,11 @@
+-- Available credit restored by each payment, so a returned payment takes
+-- back exactly what it gave.
+CREATE TABLE IF NOT EXISTS payment_credits (
+    payment_id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    amount NUMERIC(12, 2) NOT NULL,
+    restored_credit NUMERIC(12, 2) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+ALTER TABLE authorizations DROP COLUMN IF EXISTS disputed_amount;
+DROP TABLE IF EXISTS disputes;
@@ -0,0 +1,23 @@
+-- Chargeback disputes raised on captured transactions. The deadline is when
+-- the current state expires: the merchant's response window while OPENED,
+-- the cardholder's evidence window while EVIDENCE_REQUESTED.
+CREATE TABLE IF NOT EXISTS disputes (
+    dispute_id VARCHAR(255) PRIMARY KEY,
+    transaction_id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    amount NUMERIC(12, 2) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    notes TEXT,
+    deadline TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_disputes_transaction_id ON disputes (transaction_id);
+CREATE INDEX IF NOT EXISTS idx_disputes_deadline ON disputes (deadline) WHERE status IN ('OPENED', 'EVIDENCE_REQUESTED');
+
+-- Provisionally credited or won disputes, which refunds and reversals
+-- cannot take again.
+ALTER TABLE authorizations ADD COLUMN IF NOT EXISTS disputed_amount NUMERIC(12, 2) NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+ALTER TABLE authorizations DROP COLUMN IF EXISTS capture_fx_rate;
+ALTER TABLE authorizations DROP COLUMN IF EXISTS fx_markup;
+ALTER TABLE authorizations DROP COLUMN IF EXISTS fx_rate;
+ALTER TABLE authorizations DROP COLUMN IF EXISTS billing_currency;
+ALTER TABLE authorizations DROP COLUMN IF EXISTS transaction_amount;
+ALTER TABLE authorizations DROP COLUMN IF EXISTS transaction_currency;
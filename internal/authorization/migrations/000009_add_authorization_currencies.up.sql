@@ -0,0 +1,11 @@
+-- Authorizations may be made in a currency other than the cardholder's
+-- billing currency. amount is always in the billing currency; the original
+-- amount and the rate and markup used to convert it are kept alongside.
+ALTER TABLE authorizations ADD COLUMN IF NOT EXISTS transaction_currency CHAR(3);
+ALTER TABLE authorizations ADD COLUMN IF NOT EXISTS transaction_amount NUMERIC(12, 2);
+ALTER TABLE authorizations ADD COLUMN IF NOT EXISTS billing_currency CHAR(3);
+ALTER TABLE authorizations ADD COLUMN IF NOT EXISTS fx_rate NUMERIC(18, 8);
+ALTER TABLE authorizations ADD COLUMN IF NOT EXISTS fx_markup NUMERIC(6, 4);
+-- The settlement date's rate a transaction cleared in its transaction
+-- currency was re-converted at on capture.
+ALTER TABLE authorizations ADD COLUMN IF NOT EXISTS capture_fx_rate NUMERIC(18, 8);
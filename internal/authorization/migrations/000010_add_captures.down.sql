@@ -0,0 +1,2 @@
+ALTER TABLE authorizations DROP COLUMN IF EXISTS force_posted;
+ALTER TABLE authorizations DROP COLUMN IF EXISTS captured_at;
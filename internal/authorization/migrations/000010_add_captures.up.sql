@@ -0,0 +1,4 @@
+-- Set once a clearing record has finalized the authorization's amount.
+ALTER TABLE authorizations ADD COLUMN IF NOT EXISTS captured_at TIMESTAMPTZ;
+-- Cleared transactions with no open authorization are posted anyway.
+ALTER TABLE authorizations ADD COLUMN IF NOT EXISTS force_posted BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,2 @@
+-- Announced limits cannot be taken back; the ledger's copy stays as it is.
+SELECT 1;
@@ -0,0 +1,6 @@
+-- The ledger keeps its own copy of each cardholder's credit limit, fed by
+-- credit-limit-changed. Announce the accounts opened before the event was.
+INSERT INTO outbox (topic, key, payload)
+SELECT 'credit-limit-changed', user_id,
+       convert_to(json_build_object('user_id', user_id, 'credit_limit', credit_limit, 'changed_at', updated_at)::text, 'UTF8')
+FROM credit_accounts;
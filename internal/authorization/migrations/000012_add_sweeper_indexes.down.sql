@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_refunds_pending;
+DROP INDEX IF EXISTS idx_authorizations_uncaptured;
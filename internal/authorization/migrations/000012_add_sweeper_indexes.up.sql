@@ -0,0 +1,5 @@
+-- The hold-expiry sweeper looks for old uncaptured authorizations, and the
+-- saga-timeout sweeper for refunds left pending.
+CREATE INDEX IF NOT EXISTS idx_authorizations_uncaptured ON authorizations (created_at, transaction_id)
+WHERE status = 'SUCCEEDED' AND captured_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_refunds_pending ON refunds (updated_at, refund_id) WHERE status = 'PENDING';
@@ -0,0 +1,154 @@
+package authorization
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"credit-authorization-ledger/internal/outbox"
+	"credit-authorization-ledger/internal/transport"
+	"credit-authorization-ledger/pkg/events"
+
+	"go.opentelemetry.io/otel"
+)
+
+// Payment credit statuses stored in the payment_credits table.
+const (
+	PaymentCreditApplied  = "APPLIED"
+	PaymentCreditReturned = "RETURNED"
+)
+
+// HandlePaymentCredit restores available credit for the principal part of a
+// posted payment, up to the cardholder's credit limit.
+func (s *Service) HandlePaymentCredit(ctx context.Context, msg transport.Message) error {
+	tr := otel.Tracer("authorization-service")
+	ctx, span := tr.Start(ctx, "HandlePaymentCredit")
+	defer span.End()
+
+	var event events.PaymentPosted
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Redelivered requests are ignored once the payment has been applied.
+	var existing string
+	err = tx.QueryRowContext(ctx, "SELECT status FROM payment_credits WHERE payment_id = $1", event.PaymentID).Scan(&existing)
+	if err == nil {
+		log.Printf("Payment %s already applied with status %s", event.PaymentID, existing)
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	// Overpayments leave a credit balance on the ledger but never raise
+	// available credit above the limit.
+	var restored float64
+	err = tx.QueryRowContext(ctx, `
+		SELECT GREATEST(LEAST($2, credit_limit - available_credit), 0)
+		FROM credit_accounts
+		WHERE user_id = $1
+		FOR UPDATE
+	`, event.UserID, event.PrincipalAmount).Scan(&restored)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err := releaseCredit(ctx, tx, event.UserID, restored); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO payment_credits (payment_id, user_id, amount, restored_credit, status)
+		VALUES ($1, $2, $3, $4, $5)
+	`, event.PaymentID, event.UserID, event.Amount, restored, PaymentCreditApplied)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Payment %s restored %f of available credit for %s", event.PaymentID, restored, event.UserID)
+	successEvent := events.PaymentSucceeded{
+		PaymentID:      event.PaymentID,
+		UserID:         event.UserID,
+		Amount:         event.Amount,
+		RestoredCredit: restored,
+	}
+	if err := outbox.NewWriter(tx).Write(ctx, "payment-succeeded", event.UserID, successEvent); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// HandlePaymentReturnCredit takes back the available credit a returned
+// payment restored.
+func (s *Service) HandlePaymentReturnCredit(ctx context.Context, msg transport.Message) error {
+	tr := otel.Tracer("authorization-service")
+	ctx, span := tr.Start(ctx, "HandlePaymentReturnCredit")
+	defer span.End()
+
+	var event events.PaymentReturnPosted
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var restored float64
+	err = tx.QueryRowContext(ctx,
+		"SELECT status, restored_credit FROM payment_credits WHERE payment_id = $1 FOR UPDATE",
+		event.PaymentID).Scan(&status, &restored)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// The payment was returned before its credit step ran; record it as
+		// returned so that step does nothing when it arrives.
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO payment_credits (payment_id, user_id, amount, restored_credit, status)
+			VALUES ($1, $2, $3, 0, $4)
+		`, event.PaymentID, event.UserID, event.Amount, PaymentCreditReturned)
+		if err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case status == PaymentCreditReturned:
+		log.Printf("Payment %s already returned", event.PaymentID)
+		return nil
+	default:
+		if err := reclaimCredit(ctx, tx, event.UserID, restored); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			"UPDATE payment_credits SET status = $2, updated_at = NOW() WHERE payment_id = $1",
+			event.PaymentID, PaymentCreditReturned)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Returned payment %s reclaimed %f of available credit for %s", event.PaymentID, restored, event.UserID)
+	returnedEvent := events.PaymentReturned{
+		PaymentID:       event.PaymentID,
+		UserID:          event.UserID,
+		Amount:          event.Amount,
+		ReclaimedCredit: restored,
+		Reason:          event.Reason,
+	}
+	if err := outbox.NewWriter(tx).Write(ctx, "payment-returned", event.UserID, returnedEvent); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
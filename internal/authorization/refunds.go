@@ -0,0 +1,325 @@
+package authorization
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"credit-authorization-ledger/internal/outbox"
+	"credit-authorization-ledger/internal/transport"
+	"credit-authorization-ledger/pkg/events"
+
+	"go.opentelemetry.io/otel"
+)
+
+// Refund statuses stored in the refunds table.
+const (
+	RefundStatusPending   = "PENDING"
+	RefundStatusSucceeded = "SUCCEEDED"
+	RefundStatusFailed    = "FAILED"
+)
+
+// HandleRefundRequest is the first step of the refund saga. It checks that
+// the refund fits within the captured amount, records it as pending and
+// restores the cardholder's available credit.
+func (s *Service) HandleRefundRequest(ctx context.Context, msg transport.Message) error {
+	tr := otel.Tracer("authorization-service")
+	ctx, span := tr.Start(ctx, "HandleRefundRequest")
+	defer span.End()
+
+	var req events.RefundRequested
+	if err := json.Unmarshal(msg.Value, &req); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Redelivered requests are ignored once the refund has been recorded.
+	var existing string
+	err = tx.QueryRowContext(ctx, "SELECT status FROM refunds WHERE refund_id = $1", req.RefundID).Scan(&existing)
+	if err == nil {
+		log.Printf("Refund %s already recorded with status %s", req.RefundID, existing)
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	log.Printf("Authorizing refund %s of %f for transaction %s", req.RefundID, req.Amount, req.TransactionID)
+
+	result, reason, err := s.refund(ctx, tx, req)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		log.Printf("Rejecting refund %s: %s", req.RefundID, reason)
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO refunds (refund_id, transaction_id, user_id, amount, status, reason)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, req.RefundID, req.TransactionID, result.UserID, req.Amount, RefundStatusFailed, reason)
+		if err != nil {
+			return err
+		}
+		failedEvent := events.RefundFailed{RefundID: req.RefundID, TransactionID: req.TransactionID, Reason: reason}
+		if err := outbox.NewWriter(tx).Write(ctx, "refund-failed", req.TransactionID, failedEvent); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	if err := outbox.NewWriter(tx).Write(ctx, "refund-authorized", req.TransactionID, result); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Service) refund(ctx context.Context, tx *sql.Tx, req events.RefundRequested) (events.RefundAuthorized, string, error) {
+	result := events.RefundAuthorized{RefundID: req.RefundID, TransactionID: req.TransactionID, Amount: req.Amount}
+
+	auth, err := lockAuthorization(ctx, tx, req.TransactionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return result, ReasonNotFound, nil
+	}
+	if err != nil {
+		return result, "", err
+	}
+	result.UserID = auth.UserID
+
+	if req.Amount <= 0 {
+		return result, ReasonInvalidAmount, nil
+	}
+	// Only what has cleared can be refunded. An uncaptured hold is released
+	// by reversing it instead.
+	if auth.Status != StatusSucceeded || !auth.Captured {
+		return result, ReasonNotCaptured, nil
+	}
+
+	// Pending refunds count towards the total so concurrent refunds cannot
+	// together exceed the captured amount.
+	res, err := tx.ExecContext(ctx, `
+		UPDATE authorizations
+		SET refunded_amount = refunded_amount + $2
+		WHERE transaction_id = $1 AND refunded_amount + disputed_amount + $2 <= amount
+	`, req.TransactionID, req.Amount)
+	if err != nil {
+		return result, "", err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return result, "", err
+	}
+	if n == 0 {
+		return result, ReasonRefundExceedsCapture, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO refunds (refund_id, transaction_id, user_id, amount, status)
+		VALUES ($1, $2, $3, $4, $5)
+	`, req.RefundID, req.TransactionID, auth.UserID, req.Amount, RefundStatusPending)
+	if err != nil {
+		return result, "", err
+	}
+
+	if err := releaseCredit(ctx, tx, auth.UserID, req.Amount); err != nil {
+		return result, "", err
+	}
+	return result, "", nil
+}
+
+// HandleRefundCompletion marks a pending refund as succeeded once the ledger
+// has reversed its postings.
+func (s *Service) HandleRefundCompletion(ctx context.Context, msg transport.Message) error {
+	tr := otel.Tracer("authorization-service")
+	ctx, span := tr.Start(ctx, "HandleRefundCompletion")
+	defer span.End()
+
+	var posted events.RefundLedgerPosted
+	if err := json.Unmarshal(msg.Value, &posted); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	succeeded := events.RefundSucceeded{RefundID: posted.RefundID}
+	err = tx.QueryRowContext(ctx, `
+		UPDATE refunds SET status = $2, updated_at = NOW()
+		WHERE refund_id = $1 AND status = $3
+		RETURNING transaction_id, user_id, amount
+	`, posted.RefundID, RefundStatusSucceeded, RefundStatusPending).Scan(&succeeded.TransactionID, &succeeded.UserID, &succeeded.Amount)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Refund %s is not pending, ignoring completion", posted.RefundID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := outbox.NewWriter(tx).Write(ctx, "refund-succeeded", succeeded.TransactionID, succeeded); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// HandleRefundCompensation undoes a pending refund after the ledger rejected
+// it: the refunded amount and the restored credit are taken back.
+func (s *Service) HandleRefundCompensation(ctx context.Context, msg transport.Message) error {
+	tr := otel.Tracer("authorization-service")
+	ctx, span := tr.Start(ctx, "HandleRefundCompensation")
+	defer span.End()
+
+	var failed events.RefundLedgerFailed
+	if err := json.Unmarshal(msg.Value, &failed); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var transactionID, userID string
+	var amount float64
+	err = tx.QueryRowContext(ctx, `
+		UPDATE refunds SET status = $2, reason = $3, updated_at = NOW()
+		WHERE refund_id = $1 AND status = $4
+		RETURNING transaction_id, user_id, amount
+	`, failed.RefundID, RefundStatusFailed, failed.Reason, RefundStatusPending).Scan(&transactionID, &userID, &amount)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Refund %s is not pending, ignoring compensation", failed.RefundID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Compensating refund %s for transaction %s: %s", failed.RefundID, transactionID, failed.Reason)
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE authorizations SET refunded_amount = refunded_amount - $2 WHERE transaction_id = $1",
+		transactionID, amount)
+	if err != nil {
+		return err
+	}
+	if err := reclaimCredit(ctx, tx, userID, amount); err != nil {
+		return err
+	}
+
+	failedEvent := events.RefundFailed{RefundID: failed.RefundID, TransactionID: transactionID, Reason: failed.Reason}
+	if err := outbox.NewWriter(tx).Write(ctx, "refund-failed", transactionID, failedEvent); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// stalledRefundBatch is how many stalled refunds RetryStalledRefunds reads
+// at a time.
+const stalledRefundBatch = 100
+
+// RetryStalledRefunds asks the ledger again for refunds that have been
+// pending for longer than after, and returns how many were retried. The
+// refund-authorized event is emitted again, and the ledger confirms a
+// refund it has already posted, so a lost message cannot leave a refund
+// pending forever. Each refund is retried in its own transaction and
+// locked with SKIP LOCKED. A refund that fails is logged and skipped rather
+// than holding up the ones behind it.
+func (s *Service) RetryStalledRefunds(ctx context.Context, after time.Duration) (int, error) {
+	retried, failed := 0, 0
+	var lastUpdated time.Time
+	lastID := ""
+	for {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT refund_id, updated_at FROM refunds
+			WHERE status = $1 AND updated_at < NOW() - $2 * INTERVAL '1 second' AND (updated_at, refund_id) > ($3, $4)
+			ORDER BY updated_at, refund_id
+			LIMIT $5
+		`, RefundStatusPending, after.Seconds(), lastUpdated, lastID, stalledRefundBatch)
+		if err != nil {
+			return retried, err
+		}
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id, &lastUpdated); err != nil {
+				rows.Close()
+				return retried, err
+			}
+			ids = append(ids, id)
+			lastID = id
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return retried, err
+		}
+
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return retried, err
+			}
+			done, err := s.retryRefund(ctx, id, after)
+			if err != nil {
+				log.Printf("failed to retry refund %s: %v", id, err)
+				failed++
+				continue
+			}
+			if done {
+				retried++
+			}
+		}
+		if len(ids) < stalledRefundBatch {
+			break
+		}
+	}
+	if failed > 0 {
+		return retried, fmt.Errorf("%d stalled refunds could not be retried", failed)
+	}
+	return retried, nil
+}
+
+// retryRefund emits refund-authorized again for one stalled refund and
+// restarts its timeout. It reports false if the refund was completed, or is
+// locked, by someone else.
+func (s *Service) retryRefund(ctx context.Context, refundID string, after time.Duration) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	event := events.RefundAuthorized{RefundID: refundID}
+	err = tx.QueryRowContext(ctx, `
+		SELECT transaction_id, user_id, amount FROM refunds
+		WHERE refund_id = $1 AND status = $2 AND updated_at < NOW() - $3 * INTERVAL '1 second'
+		FOR UPDATE SKIP LOCKED
+	`, refundID, RefundStatusPending, after.Seconds()).Scan(&event.TransactionID, &event.UserID, &event.Amount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE refunds SET updated_at = NOW() WHERE refund_id = $1", refundID); err != nil {
+		return false, err
+	}
+	log.Printf("Refund %s for transaction %s timed out waiting for the ledger, retrying", refundID, event.TransactionID)
+	if err := outbox.NewWriter(tx).Write(ctx, "refund-authorized", event.TransactionID, event); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
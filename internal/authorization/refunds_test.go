@@ -0,0 +1,209 @@
+package authorization
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"credit-authorization-ledger/internal/dbtest"
+	"credit-authorization-ledger/internal/transport"
+	"credit-authorization-ledger/pkg/events"
+)
+
+// requestRefund delivers a refund request to s.
+func requestRefund(t *testing.T, s *Service, req events.RefundRequested) {
+	t.Helper()
+	value, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.HandleRefundRequest(context.Background(), transport.Message{Topic: "refund-requests", Key: []byte(req.TransactionID), Value: value}); err != nil {
+		t.Fatalf("HandleRefundRequest(%s): %v", req.RefundID, err)
+	}
+}
+
+func TestHandleRefundRequest(t *testing.T) {
+	f := &fakeDB{
+		authorizations: map[string]*fakeAuthorization{
+			"tx-captured": {userID: "user-1", status: StatusSucceeded, amount: 40, captured: true},
+			"tx-held":     {userID: "user-1", status: StatusSucceeded, amount: 40},
+			"tx-reversed": {userID: "user-1", status: StatusReversed, captured: true},
+		},
+		available: map[string]float64{"user-1": 100},
+		refunds:   map[string]string{},
+	}
+	s := NewService(dbtest.Open(f), 500)
+
+	requestRefund(t, s, events.RefundRequested{RefundID: "r-1", TransactionID: "tx-captured", Amount: 25})
+	// A redelivered request changes nothing.
+	requestRefund(t, s, events.RefundRequested{RefundID: "r-1", TransactionID: "tx-captured", Amount: 25})
+	requestRefund(t, s, events.RefundRequested{RefundID: "r-2", TransactionID: "tx-captured", Amount: 20})
+	// An uncaptured hold is released by reversing it, not refunded.
+	requestRefund(t, s, events.RefundRequested{RefundID: "r-3", TransactionID: "tx-held", Amount: 10})
+	requestRefund(t, s, events.RefundRequested{RefundID: "r-4", TransactionID: "tx-reversed", Amount: 10})
+
+	want := map[string]string{
+		"r-1": RefundStatusPending,
+		"r-2": RefundStatusFailed + ": " + ReasonRefundExceedsCapture,
+		"r-3": RefundStatusFailed + ": " + ReasonNotCaptured,
+		"r-4": RefundStatusFailed + ": " + ReasonNotCaptured,
+	}
+	for id, status := range want {
+		if f.refunds[id] != status {
+			t.Errorf("refund %s = %q, want %q", id, f.refunds[id], status)
+		}
+	}
+	if got := strings.Join(f.outbox, " "); got != "refund-authorized refund-failed refund-failed refund-failed" {
+		t.Errorf("outbox = %s", got)
+	}
+	if f.authorizations["tx-captured"].refunded != 25 || f.authorizations["tx-held"].refunded != 0 {
+		t.Errorf("refunded amounts = %v and %v, want 25 and 0", f.authorizations["tx-captured"].refunded, f.authorizations["tx-held"].refunded)
+	}
+	if f.available["user-1"] != 125 {
+		t.Errorf("available credit = %.2f, want 125.00", f.available["user-1"])
+	}
+}
+
+func TestHandleRefundRequestPostgres(t *testing.T) {
+	db := dbtest.Postgres(t, dbtest.Authorization, dbtest.Outbox)
+	_, err := db.Exec(`
+		INSERT INTO authorizations (transaction_id, user_id, amount, status, captured_at) VALUES
+		('tx-captured', 'user-1', 40, $1, NOW()),
+		('tx-held', 'user-1', 40, $1, NULL)
+	`, StatusSucceeded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO credit_accounts (user_id, credit_limit, available_credit) VALUES ('user-1', 500, 420)"); err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(db, 500)
+
+	requestRefund(t, s, events.RefundRequested{RefundID: "r-1", TransactionID: "tx-captured", Amount: 25})
+	requestRefund(t, s, events.RefundRequested{RefundID: "r-1", TransactionID: "tx-captured", Amount: 25})
+	// 25 is pending, so only 15 more fits.
+	requestRefund(t, s, events.RefundRequested{RefundID: "r-2", TransactionID: "tx-captured", Amount: 15.01})
+	requestRefund(t, s, events.RefundRequested{RefundID: "r-3", TransactionID: "tx-captured", Amount: 15})
+	requestRefund(t, s, events.RefundRequested{RefundID: "r-4", TransactionID: "tx-held", Amount: 10})
+
+	rows, err := db.Query("SELECT refund_id, status, COALESCE(reason, '') FROM refunds ORDER BY refund_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var id, status, reason string
+		if err := rows.Scan(&id, &status, &reason); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, strings.TrimSuffix(id+" "+status+" "+reason, " "))
+	}
+	want := []string{
+		"r-1 " + RefundStatusPending,
+		"r-2 " + RefundStatusFailed + " " + ReasonRefundExceedsCapture,
+		"r-3 " + RefundStatusPending,
+		"r-4 " + RefundStatusFailed + " " + ReasonNotCaptured,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("refunds =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	var refunded, available float64
+	err = db.QueryRow(`
+		SELECT a.refunded_amount, c.available_credit FROM authorizations a JOIN credit_accounts c USING (user_id)
+		WHERE a.transaction_id = 'tx-captured'
+	`).Scan(&refunded, &available)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refunded != 40 || available != 460 {
+		t.Errorf("refunded %.2f with %.2f available, want 40.00 and 460.00", refunded, available)
+	}
+}
+
+func TestRetryStalledRefundsPostgres(t *testing.T) {
+	ctx := context.Background()
+	db := dbtest.Postgres(t, dbtest.Authorization, dbtest.Outbox)
+	_, err := db.Exec(`
+		INSERT INTO refunds (refund_id, transaction_id, user_id, amount, status, updated_at) VALUES
+		('r-stalled', 'tx-1', 'user-1', 25, $1, NOW() - INTERVAL '2 hours'),
+		('r-done', 'tx-1', 'user-1', 10, $2, NOW() - INTERVAL '2 hours'),
+		('r-fresh', 'tx-2', 'user-1', 5, $1, NOW())
+	`, RefundStatusPending, RefundStatusSucceeded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(db, 500)
+
+	retry := func(want int) {
+		t.Helper()
+		n, err := s.RetryStalledRefunds(ctx, time.Hour)
+		if err != nil {
+			t.Fatalf("RetryStalledRefunds: %v", err)
+		}
+		if n != want {
+			t.Errorf("RetryStalledRefunds retried %d refunds, want %d", n, want)
+		}
+	}
+	retry(1)
+	// Retrying restarts the refund's timeout.
+	retry(0)
+
+	var payload []byte
+	if err := db.QueryRow("SELECT payload FROM outbox WHERE topic = 'refund-authorized'").Scan(&payload); err != nil {
+		t.Fatal(err)
+	}
+	var ev events.RefundAuthorized
+	if err := json.Unmarshal(payload, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if want := (events.RefundAuthorized{RefundID: "r-stalled", TransactionID: "tx-1", UserID: "user-1", Amount: 25}); ev != want {
+		t.Errorf("refund-authorized = %+v, want %+v", ev, want)
+	}
+
+	// The ledger confirms the retried refund, and may confirm it more than
+	// once; it completes once.
+	value, err := json.Marshal(events.RefundLedgerPosted{RefundID: "r-stalled", TransactionID: "tx-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.HandleRefundCompletion(ctx, transport.Message{Topic: "refund-completion-requests", Key: []byte("tx-1"), Value: value}); err != nil {
+			t.Fatalf("HandleRefundCompletion: %v", err)
+		}
+	}
+
+	// Once they time out again, only refunds still pending are retried.
+	if _, err := db.Exec("UPDATE refunds SET updated_at = NOW() - INTERVAL '2 hours'"); err != nil {
+		t.Fatal(err)
+	}
+	retry(1)
+
+	rows, err := db.Query("SELECT topic, key FROM outbox ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var topic, key string
+		if err := rows.Scan(&topic, &key); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, topic+" "+key)
+	}
+	want := []string{"refund-authorized tx-1", "refund-succeeded tx-1", "refund-authorized tx-2"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("outbox = %s, want %s", strings.Join(got, ", "), strings.Join(want, ", "))
+	}
+	var status string
+	if err := db.QueryRow("SELECT status FROM refunds WHERE refund_id = 'r-stalled'").Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status != RefundStatusSucceeded {
+		t.Errorf("r-stalled = %s, want %s", status, RefundStatusSucceeded)
+	}
+}
@@ -0,0 +1,331 @@
+package authorization
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"credit-authorization-ledger/internal/outbox"
+	"credit-authorization-ledger/pkg/events"
+)
+
+// Review queue statuses.
+const (
+	ReviewStatusOpen     = "OPEN"
+	ReviewStatusClaimed  = "CLAIMED"
+	ReviewStatusApproved = "APPROVED"
+	ReviewStatusDeclined = "DECLINED"
+)
+
+// SystemReviewer is recorded as the reviewer of reviews that time out.
+const SystemReviewer = "system"
+
+var (
+	ErrReviewNotFound   = errors.New("review not found")
+	ErrReviewNotPending = errors.New("review is already decided")
+	ErrReviewClaimed    = errors.New("review is claimed by another reviewer")
+)
+
+// ReviewPolicy controls how long a review may stay undecided and what
+// happens when it times out.
+type ReviewPolicy struct {
+	Timeout        time.Duration
+	DefaultApprove bool
+}
+
+// DefaultReviewPolicy declines reviews left undecided for a day.
+var DefaultReviewPolicy = ReviewPolicy{Timeout: 24 * time.Hour}
+
+// ReviewItem is an entry in the manual review queue.
+type ReviewItem struct {
+	TransactionID string     `json:"transaction_id"`
+	UserID        string     `json:"user_id"`
+	Amount        float64    `json:"amount"`
+	RiskScore     int        `json:"risk_score"`
+	Reason        string     `json:"reason"`
+	Status        string     `json:"status"`
+	ClaimedBy     string     `json:"claimed_by,omitempty"`
+	ClaimedAt     *time.Time `json:"claimed_at,omitempty"`
+	DecidedBy     string     `json:"decided_by,omitempty"`
+	DecidedAt     *time.Time `json:"decided_at,omitempty"`
+	Notes         string     `json:"notes,omitempty"`
+	Deadline      time.Time  `json:"deadline"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// ReviewDecision is a reviewer's verdict on a queued authorization.
+type ReviewDecision struct {
+	TransactionID string
+	Reviewer      string
+	Approve       bool
+	Notes         string
+}
+
+// enqueueReview adds a parked authorization to the review queue.
+func (s *Service) enqueueReview(ctx context.Context, tx *sql.Tx, ev events.AuthorizationReviewRequired) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO review_queue (transaction_id, user_id, amount, risk_score, reason, status, deadline)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, ev.TransactionID, ev.UserID, ev.Amount, ev.RiskScore, ev.Reason, ReviewStatusOpen, time.Now().Add(s.review.Timeout))
+	return err
+}
+
+// ListReviews returns queued reviews, oldest first. An empty status lists
+// every undecided review.
+func (s *Service) ListReviews(ctx context.Context, status string, limit int) ([]ReviewItem, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT transaction_id, user_id, amount, risk_score, reason, status,
+		       COALESCE(claimed_by, ''), claimed_at, COALESCE(decided_by, ''), decided_at,
+		       COALESCE(notes, ''), deadline, created_at
+		FROM review_queue
+		WHERE ($1 = '' AND status IN ($2, $3)) OR status = $1
+		ORDER BY created_at ASC
+		LIMIT $4
+	`, status, ReviewStatusOpen, ReviewStatusClaimed, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []ReviewItem{}
+	for rows.Next() {
+		var item ReviewItem
+		var claimedAt, decidedAt sql.NullTime
+		err := rows.Scan(&item.TransactionID, &item.UserID, &item.Amount, &item.RiskScore, &item.Reason, &item.Status,
+			&item.ClaimedBy, &claimedAt, &item.DecidedBy, &decidedAt,
+			&item.Notes, &item.Deadline, &item.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if claimedAt.Valid {
+			item.ClaimedAt = &claimedAt.Time
+		}
+		if decidedAt.Valid {
+			item.DecidedAt = &decidedAt.Time
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// ClaimReview assigns an undecided review to reviewer. Claiming a review
+// already held by the same reviewer is a no-op.
+func (s *Service) ClaimReview(ctx context.Context, transactionID, reviewer string) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE review_queue
+		SET status = $3, claimed_by = $2, claimed_at = NOW()
+		WHERE transaction_id = $1 AND (status = $4 OR (status = $3 AND claimed_by = $2))
+	`, transactionID, reviewer, ReviewStatusClaimed, ReviewStatusOpen)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	var status string
+	err = s.db.QueryRowContext(ctx, "SELECT status FROM review_queue WHERE transaction_id = $1", transactionID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrReviewNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if status == ReviewStatusClaimed {
+		return ErrReviewClaimed
+	}
+	return ErrReviewNotPending
+}
+
+// DecideReview resumes an authorization parked for manual review. Approval
+// keeps the hold and continues the saga with authorization-succeeded; a
+// decline releases the hold and ends it with authorization-failed. A review
+// claimed by someone else cannot be decided.
+func (s *Service) DecideReview(ctx context.Context, d ReviewDecision) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status, claimedBy string
+	err = tx.QueryRowContext(ctx,
+		"SELECT status, COALESCE(claimed_by, '') FROM review_queue WHERE transaction_id = $1 FOR UPDATE",
+		d.TransactionID).Scan(&status, &claimedBy)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrReviewNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if status != ReviewStatusOpen && status != ReviewStatusClaimed {
+		return ErrReviewNotPending
+	}
+	if status == ReviewStatusClaimed && claimedBy != d.Reviewer {
+		return ErrReviewClaimed
+	}
+
+	if err := s.applyReviewDecision(ctx, tx, d); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// reviewExpiryBatch is how many timed out reviews ExpireReviews reads at a
+// time.
+const reviewExpiryBatch = 100
+
+// ExpireReviews applies the default decision to reviews past their deadline
+// and returns how many were decided. Each review is decided in its own
+// transaction and locked with SKIP LOCKED, so several replicas can run it at
+// once. A review that fails is logged and skipped rather than holding up
+// the ones behind it.
+func (s *Service) ExpireReviews(ctx context.Context) (int, error) {
+	expired, failed := 0, 0
+	var lastDeadline time.Time
+	lastID := ""
+	for {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT transaction_id, deadline FROM review_queue
+			WHERE status IN ($1, $2) AND deadline < NOW() AND (deadline, transaction_id) > ($3, $4)
+			ORDER BY deadline, transaction_id
+			LIMIT $5
+		`, ReviewStatusOpen, ReviewStatusClaimed, lastDeadline, lastID, reviewExpiryBatch)
+		if err != nil {
+			return expired, err
+		}
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id, &lastDeadline); err != nil {
+				rows.Close()
+				return expired, err
+			}
+			ids = append(ids, id)
+			lastID = id
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return expired, err
+		}
+
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return expired, err
+			}
+			done, err := s.expireReview(ctx, id)
+			if err != nil {
+				log.Printf("failed to expire review for transaction %s: %v", id, err)
+				failed++
+				continue
+			}
+			if done {
+				expired++
+			}
+		}
+		if len(ids) < reviewExpiryBatch {
+			break
+		}
+	}
+	if failed > 0 {
+		return expired, fmt.Errorf("%d timed out reviews could not be decided", failed)
+	}
+	return expired, nil
+}
+
+// expireReview applies the default decision to one timed out review. It
+// reports false if the review was decided, or is locked, by someone else.
+func (s *Service) expireReview(ctx context.Context, transactionID string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT transaction_id FROM review_queue
+		WHERE transaction_id = $1 AND status IN ($2, $3) AND deadline < NOW()
+		FOR UPDATE SKIP LOCKED
+	`, transactionID, ReviewStatusOpen, ReviewStatusClaimed).Scan(&transactionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	log.Printf("Review for transaction %s timed out, applying default decision (approve: %t)", transactionID, s.review.DefaultApprove)
+	err = s.applyReviewDecision(ctx, tx, ReviewDecision{
+		TransactionID: transactionID,
+		Reviewer:      SystemReviewer,
+		Approve:       s.review.DefaultApprove,
+		Notes:         "review timed out",
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// applyReviewDecision resolves the parked authorization and closes its queue
+// entry. The queue row must already be locked by tx.
+func (s *Service) applyReviewDecision(ctx context.Context, tx *sql.Tx, d ReviewDecision) error {
+	auth, err := lockAuthorization(ctx, tx, d.TransactionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrReviewNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if auth.Status != StatusPendingReview {
+		return ErrReviewNotPending
+	}
+
+	queueStatus := ReviewStatusDeclined
+	if d.Approve {
+		queueStatus = ReviewStatusApproved
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE review_queue
+		SET status = $2, decided_by = $3, decided_at = NOW(), notes = $4
+		WHERE transaction_id = $1
+	`, d.TransactionID, queueStatus, d.Reviewer, d.Notes)
+	if err != nil {
+		return err
+	}
+
+	if d.Approve {
+		log.Printf("Review by %s approved transaction %s", d.Reviewer, d.TransactionID)
+		_, err = tx.ExecContext(ctx, "UPDATE authorizations SET status = $2 WHERE transaction_id = $1", d.TransactionID, StatusSucceeded)
+		if err != nil {
+			return err
+		}
+		successEvent := succeededEvent(d.TransactionID, auth.UserID, auth.Amount, auth.RiskScore, auth.Conversion)
+		return outbox.NewWriter(tx).Write(ctx, "authorization-succeeded", d.TransactionID, successEvent)
+	}
+
+	log.Printf("Review by %s declined transaction %s: %s", d.Reviewer, d.TransactionID, d.Notes)
+	_, err = tx.ExecContext(ctx, "UPDATE authorizations SET status = $2 WHERE transaction_id = $1", d.TransactionID, StatusDeclined)
+	if err != nil {
+		return err
+	}
+	if err := releaseCredit(ctx, tx, auth.UserID, auth.Amount); err != nil {
+		return err
+	}
+	if _, err := recordAmountChange(ctx, tx, d.TransactionID, ChangeReviewDeclined, -auth.Amount, auth.Amount, 0); err != nil {
+		return err
+	}
+	failedEvent := events.AuthorizationFailed{
+		TransactionID: d.TransactionID,
+		Reason:        fmt.Sprintf("declined in manual review: %s", d.Notes),
+		RiskScore:     auth.RiskScore,
+	}
+	return outbox.NewWriter(tx).Write(ctx, "authorization-failed", d.TransactionID, failedEvent)
+}
@@ -0,0 +1,151 @@
+package authorization
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// RuleInput is what a rule sees when deciding on an authorization.
+type RuleInput struct {
+	TransactionID        string
+	UserID               string
+	Amount               float64
+	MerchantCategoryCode string
+	MerchantCountry      string
+	CardStatus           string
+	Time                 time.Time
+}
+
+// Rule is a single declarative decline check. Evaluate returns a non-empty
+// reason if the authorization should be declined.
+type Rule interface {
+	Evaluate(in RuleInput) string
+}
+
+// RuleFactory builds a rule from its JSON parameters.
+type RuleFactory func(params json.RawMessage) (Rule, error)
+
+var ruleTypes = map[string]RuleFactory{}
+
+// RegisterRuleType makes a rule type available to rule configurations.
+// It is meant to be called from init functions.
+func RegisterRuleType(ruleType string, factory RuleFactory) {
+	ruleTypes[ruleType] = factory
+}
+
+// RuleConfig describes one configured rule, as stored in a rules file or
+// in the authorization_rules table.
+type RuleConfig struct {
+	Name   string          `json:"name"`
+	Type   string          `json:"type"`
+	Params json.RawMessage `json:"params"`
+	// Shadow rules are evaluated and logged but never decline.
+	Shadow bool `json:"shadow"`
+}
+
+// Decision identifies the rule that declined an authorization.
+type Decision struct {
+	Rule   string
+	Reason string
+}
+
+// String formats the decision for events.AuthorizationFailed.Reason.
+func (d Decision) String() string {
+	return fmt.Sprintf("declined by rule %s: %s", d.Rule, d.Reason)
+}
+
+type configuredRule struct {
+	RuleConfig
+	rule Rule
+}
+
+// Engine evaluates rules in order and stops at the first enforced decline.
+type Engine struct {
+	rules  []configuredRule
+	shadow bool
+}
+
+// NewEngine builds an engine from rule configurations. If shadow is set,
+// every rule runs in shadow mode regardless of its own configuration.
+func NewEngine(configs []RuleConfig, shadow bool) (*Engine, error) {
+	e := &Engine{shadow: shadow}
+	for _, cfg := range configs {
+		factory, ok := ruleTypes[cfg.Type]
+		if !ok {
+			return nil, fmt.Errorf("rule %s: unknown rule type %q", cfg.Name, cfg.Type)
+		}
+		params := cfg.Params
+		if len(params) == 0 {
+			params = json.RawMessage("{}")
+		}
+		rule, err := factory(params)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", cfg.Name, err)
+		}
+		e.rules = append(e.rules, configuredRule{RuleConfig: cfg, rule: rule})
+	}
+	return e, nil
+}
+
+// Evaluate runs the rules against in. It returns the decision of the first
+// enforced rule that declines, or nil if the authorization may proceed.
+// Declines from shadow rules are only logged.
+func (e *Engine) Evaluate(in RuleInput) *Decision {
+	for _, r := range e.rules {
+		reason := r.rule.Evaluate(in)
+		if reason == "" {
+			continue
+		}
+		decision := Decision{Rule: r.Name, Reason: reason}
+		if e.shadow || r.Shadow {
+			log.Printf("Shadow rule decision for transaction %s: %s", in.TransactionID, decision)
+			continue
+		}
+		return &decision
+	}
+	return nil
+}
+
+// LoadRulesFromFile reads an ordered JSON array of rule configurations.
+func LoadRulesFromFile(path string) ([]RuleConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []RuleConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("parsing rules file %s: %w", path, err)
+	}
+	return configs, nil
+}
+
+// LoadRulesFromDB reads the enabled rules from the authorization_rules table.
+func LoadRulesFromDB(ctx context.Context, db *sql.DB) ([]RuleConfig, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT name, rule_type, params, shadow
+		FROM authorization_rules
+		WHERE enabled
+		ORDER BY position ASC, name ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var configs []RuleConfig
+	for rows.Next() {
+		var cfg RuleConfig
+		var params []byte
+		if err := rows.Scan(&cfg.Name, &cfg.Type, &params, &cfg.Shadow); err != nil {
+			return nil, err
+		}
+		cfg.Params = params
+		configs = append(configs, cfg)
+	}
+	return configs, rows.Err()
+}
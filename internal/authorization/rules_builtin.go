@@ -0,0 +1,198 @@
+package authorization
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Card statuses stored in credit_accounts.
+const (
+	CardStatusActive = "ACTIVE"
+	CardStatusFrozen = "FROZEN"
+	CardStatusClosed = "CLOSED"
+)
+
+func init() {
+	RegisterRuleType("blocked_merchant_categories", newBlockedMerchantCategoriesRule)
+	RegisterRuleType("max_transaction_amount", newMaxTransactionAmountRule)
+	RegisterRuleType("country_restriction", newCountryRestrictionRule)
+	RegisterRuleType("card_status", newCardStatusRule)
+	RegisterRuleType("time_of_day", newTimeOfDayRule)
+}
+
+// blockedMerchantCategoriesRule declines merchant category codes on a block list.
+//
+//	{"codes": ["7995", "6051"]}
+type blockedMerchantCategoriesRule struct {
+	codes map[string]bool
+}
+
+func newBlockedMerchantCategoriesRule(params json.RawMessage) (Rule, error) {
+	var p struct {
+		Codes []string `json:"codes"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	return &blockedMerchantCategoriesRule{codes: toSet(p.Codes)}, nil
+}
+
+func (r *blockedMerchantCategoriesRule) Evaluate(in RuleInput) string {
+	if r.codes[in.MerchantCategoryCode] {
+		return fmt.Sprintf("merchant category %s is blocked", in.MerchantCategoryCode)
+	}
+	return ""
+}
+
+// maxTransactionAmountRule caps the amount of a single transaction, with
+// optional per-user overrides.
+//
+//	{"default_max": 1000, "per_user": {"user-6789": 250}}
+type maxTransactionAmountRule struct {
+	DefaultMax float64            `json:"default_max"`
+	PerUser    map[string]float64 `json:"per_user"`
+}
+
+func newMaxTransactionAmountRule(params json.RawMessage) (Rule, error) {
+	r := &maxTransactionAmountRule{}
+	if err := json.Unmarshal(params, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *maxTransactionAmountRule) Evaluate(in RuleInput) string {
+	max, ok := r.PerUser[in.UserID]
+	if !ok {
+		max = r.DefaultMax
+	}
+	if max > 0 && in.Amount > max {
+		return fmt.Sprintf("amount %.2f exceeds single transaction limit %.2f", in.Amount, max)
+	}
+	return ""
+}
+
+// countryRestrictionRule declines merchant countries outside an allow list
+// or on a block list.
+//
+//	{"allowed": ["US", "ES"]} or {"blocked": ["KP"]}
+type countryRestrictionRule struct {
+	allowed map[string]bool
+	blocked map[string]bool
+}
+
+func newCountryRestrictionRule(params json.RawMessage) (Rule, error) {
+	var p struct {
+		Allowed []string `json:"allowed"`
+		Blocked []string `json:"blocked"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	return &countryRestrictionRule{allowed: toSet(p.Allowed), blocked: toSet(p.Blocked)}, nil
+}
+
+func (r *countryRestrictionRule) Evaluate(in RuleInput) string {
+	if r.blocked[in.MerchantCountry] {
+		return fmt.Sprintf("merchant country %s is blocked", in.MerchantCountry)
+	}
+	if len(r.allowed) > 0 && !r.allowed[in.MerchantCountry] {
+		return fmt.Sprintf("merchant country %q is not allowed", in.MerchantCountry)
+	}
+	return ""
+}
+
+// cardStatusRule declines cards that are not usable. Frozen and closed cards
+// are declined unless other statuses are configured.
+//
+//	{"declined_statuses": ["FROZEN", "CLOSED"]}
+type cardStatusRule struct {
+	declined map[string]bool
+}
+
+func newCardStatusRule(params json.RawMessage) (Rule, error) {
+	var p struct {
+		DeclinedStatuses []string `json:"declined_statuses"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	if len(p.DeclinedStatuses) == 0 {
+		p.DeclinedStatuses = []string{CardStatusFrozen, CardStatusClosed}
+	}
+	return &cardStatusRule{declined: toSet(p.DeclinedStatuses)}, nil
+}
+
+func (r *cardStatusRule) Evaluate(in RuleInput) string {
+	if r.declined[in.CardStatus] {
+		return fmt.Sprintf("card is %s", in.CardStatus)
+	}
+	return ""
+}
+
+// timeOfDayRule declines authorizations inside a daily window. The window
+// may wrap past midnight.
+//
+//	{"timezone": "Europe/Madrid", "start": "23:00", "end": "06:00"}
+type timeOfDayRule struct {
+	loc        *time.Location
+	start, end int // minutes since midnight
+}
+
+func newTimeOfDayRule(params json.RawMessage) (Rule, error) {
+	var p struct {
+		Timezone string `json:"timezone"`
+		Start    string `json:"start"`
+		End      string `json:"end"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	loc := time.UTC
+	if p.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(p.Timezone); err != nil {
+			return nil, err
+		}
+	}
+	start, err := parseClock(p.Start)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(p.End)
+	if err != nil {
+		return nil, err
+	}
+	return &timeOfDayRule{loc: loc, start: start, end: end}, nil
+}
+
+func (r *timeOfDayRule) Evaluate(in RuleInput) string {
+	t := in.Time.In(r.loc)
+	now := t.Hour()*60 + t.Minute()
+	inWindow := now >= r.start && now < r.end
+	if r.start > r.end {
+		inWindow = now >= r.start || now < r.end
+	}
+	if inWindow {
+		return fmt.Sprintf("transactions are not allowed at %s", t.Format("15:04 MST"))
+	}
+	return ""
+}
+
+// parseClock parses "HH:MM" into minutes since midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q: %w", s, err)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
@@ -0,0 +1,169 @@
+package authorization
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuiltinRules(t *testing.T) {
+	noon := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	base := RuleInput{
+		UserID:               "user-1",
+		Amount:               100,
+		MerchantCategoryCode: "5411",
+		MerchantCountry:      "ES",
+		CardStatus:           CardStatusActive,
+		Time:                 noon,
+	}
+	with := func(change func(*RuleInput)) RuleInput {
+		in := base
+		change(&in)
+		return in
+	}
+
+	tests := []struct {
+		name     string
+		ruleType string
+		params   string
+		in       RuleInput
+		declined string // substring of the reason, or "" to allow
+	}{
+		{"allowed category", "blocked_merchant_categories", `{"codes": ["7995"]}`, base, ""},
+		{"blocked category", "blocked_merchant_categories", `{"codes": ["7995", "6051"]}`,
+			with(func(in *RuleInput) { in.MerchantCategoryCode = "6051" }), "merchant category 6051 is blocked"},
+
+		{"under default max", "max_transaction_amount", `{"default_max": 100}`, base, ""},
+		{"over default max", "max_transaction_amount", `{"default_max": 99.99}`, base, "exceeds single transaction limit 99.99"},
+		{"per-user max overrides default", "max_transaction_amount", `{"default_max": 1000, "per_user": {"user-1": 50}}`,
+			base, "exceeds single transaction limit 50.00"},
+		{"other user's max", "max_transaction_amount", `{"default_max": 1000, "per_user": {"user-2": 50}}`, base, ""},
+		{"no max", "max_transaction_amount", `{}`, with(func(in *RuleInput) { in.Amount = 1e6 }), ""},
+
+		{"allowed country", "country_restriction", `{"allowed": ["US", "ES"]}`, base, ""},
+		{"country not allowed", "country_restriction", `{"allowed": ["US"]}`, base, `merchant country "ES" is not allowed`},
+		{"blocked country", "country_restriction", `{"blocked": ["KP"]}`,
+			with(func(in *RuleInput) { in.MerchantCountry = "KP" }), "merchant country KP is blocked"},
+		{"country not blocked", "country_restriction", `{"blocked": ["KP"]}`, base, ""},
+
+		{"active card", "card_status", `{}`, base, ""},
+		{"frozen card", "card_status", `{}`, with(func(in *RuleInput) { in.CardStatus = CardStatusFrozen }), "card is FROZEN"},
+		{"closed card", "card_status", `{}`, with(func(in *RuleInput) { in.CardStatus = CardStatusClosed }), "card is CLOSED"},
+		{"configured statuses", "card_status", `{"declined_statuses": ["CLOSED"]}`,
+			with(func(in *RuleInput) { in.CardStatus = CardStatusFrozen }), ""},
+
+		{"outside window", "time_of_day", `{"start": "23:00", "end": "06:00"}`, base, ""},
+		{"inside window past midnight", "time_of_day", `{"start": "23:00", "end": "06:00"}`,
+			with(func(in *RuleInput) { in.Time = time.Date(2024, 3, 1, 2, 30, 0, 0, time.UTC) }), "not allowed at 02:30"},
+		{"window end is exclusive", "time_of_day", `{"start": "23:00", "end": "06:00"}`,
+			with(func(in *RuleInput) { in.Time = time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC) }), ""},
+		{"daytime window", "time_of_day", `{"start": "09:00", "end": "17:00"}`, base, "not allowed at 12:00"},
+		{"window in rule timezone", "time_of_day", `{"timezone": "Asia/Tokyo", "start": "20:00", "end": "23:00"}`,
+			base, "not allowed at 21:00 JST"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ruleTypes[tt.ruleType](json.RawMessage(tt.params))
+			if err != nil {
+				t.Fatalf("building %s: %v", tt.ruleType, err)
+			}
+			reason := rule.Evaluate(tt.in)
+			if tt.declined == "" && reason != "" {
+				t.Errorf("declined with %q, want allowed", reason)
+			}
+			if tt.declined != "" && !strings.Contains(reason, tt.declined) {
+				t.Errorf("reason = %q, want %q", reason, tt.declined)
+			}
+		})
+	}
+}
+
+func TestBuiltinRuleParams(t *testing.T) {
+	for _, cfg := range []RuleConfig{
+		{Name: "bad-json", Type: "blocked_merchant_categories", Params: json.RawMessage(`{"codes": "7995"}`)},
+		{Name: "bad-clock", Type: "time_of_day", Params: json.RawMessage(`{"start": "25:00", "end": "06:00"}`)},
+		{Name: "bad-zone", Type: "time_of_day", Params: json.RawMessage(`{"timezone": "Mars/Olympus", "start": "01:00", "end": "02:00"}`)},
+		{Name: "unknown", Type: "horoscope"},
+	} {
+		if _, err := NewEngine([]RuleConfig{cfg}, false); err == nil || !strings.Contains(err.Error(), cfg.Name) {
+			t.Errorf("NewEngine(%s) = %v, want an error naming the rule", cfg.Name, err)
+		}
+	}
+}
+
+// countingRule declines with its reason and counts its evaluations.
+type countingRule struct {
+	reason string
+	calls  *int
+}
+
+func (r countingRule) Evaluate(RuleInput) string {
+	*r.calls++
+	return r.reason
+}
+
+func TestEngineOrderAndShadow(t *testing.T) {
+	calls := map[string]*int{}
+	rule := func(name, reason string, shadow bool) configuredRule {
+		calls[name] = new(int)
+		return configuredRule{RuleConfig: RuleConfig{Name: name, Shadow: shadow}, rule: countingRule{reason, calls[name]}}
+	}
+
+	e := &Engine{rules: []configuredRule{
+		rule("allow", "", false),
+		rule("shadow-decline", "would decline", true),
+		rule("first-decline", "declined first", false),
+		rule("second-decline", "declined second", false),
+	}}
+	d := e.Evaluate(RuleInput{TransactionID: "tx-1"})
+	if d == nil || d.Rule != "first-decline" || d.String() != "declined by rule first-decline: declined first" {
+		t.Fatalf("Evaluate = %v, want the first enforced decline", d)
+	}
+	// Rules run in order, shadow rules do not stop evaluation, and nothing
+	// runs after the first enforced decline.
+	for name, want := range map[string]int{"allow": 1, "shadow-decline": 1, "first-decline": 1, "second-decline": 0} {
+		if *calls[name] != want {
+			t.Errorf("%s ran %d times, want %d", name, *calls[name], want)
+		}
+	}
+
+	// In engine-wide shadow mode every rule runs and none declines.
+	e.shadow = true
+	if d := e.Evaluate(RuleInput{TransactionID: "tx-2"}); d != nil {
+		t.Errorf("Evaluate in shadow mode = %v, want nil", d)
+	}
+	if *calls["second-decline"] != 1 {
+		t.Errorf("second-decline ran %d times in shadow mode, want 1", *calls["second-decline"])
+	}
+}
+
+func TestLoadRulesFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	data := `[
+		{"name": "no-gambling", "type": "blocked_merchant_categories", "params": {"codes": ["7995"]}},
+		{"name": "frozen", "type": "card_status", "shadow": true}
+	]`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	configs, err := LoadRulesFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadRulesFromFile: %v", err)
+	}
+	e, err := NewEngine(configs, false)
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+
+	// The frozen-card rule only logs, so the gambling rule decides.
+	d := e.Evaluate(RuleInput{MerchantCategoryCode: "7995", CardStatus: CardStatusFrozen})
+	if d == nil || d.Rule != "no-gambling" {
+		t.Errorf("Evaluate = %v, want a decline by no-gambling", d)
+	}
+	if d := e.Evaluate(RuleInput{MerchantCategoryCode: "5411", CardStatus: CardStatusFrozen}); d != nil {
+		t.Errorf("Evaluate = %v for a shadow-only decline, want nil", d)
+	}
+}
@@ -4,25 +4,102 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"time"
 
+	"credit-authorization-ledger/internal/fx"
 	"credit-authorization-ledger/internal/outbox"
+	"credit-authorization-ledger/internal/transport"
 	"credit-authorization-ledger/pkg/events"
 
-	"github.com/segmentio/kafka-go"
 	"go.opentelemetry.io/otel"
 )
 
+// Authorization statuses stored in the authorizations table.
+const (
+	StatusSucceeded     = "SUCCEEDED"
+	StatusDeclined      = "DECLINED"
+	StatusReversed      = "REVERSED"
+	StatusPendingReview = "PENDING_REVIEW"
+)
+
 type Service struct {
-	db *sql.DB
+	db                 *sql.DB
+	defaultCreditLimit float64
+	rules              *Engine
+	velocity           *VelocityChecker
+	review             ReviewPolicy
+	disputes           DisputePolicy
+	rates              fx.RateProvider
+	fx                 FXPolicy
+}
+
+// Option configures optional Service dependencies.
+type Option func(*Service)
+
+// WithRuleEngine makes new authorizations pass through the given decline rules.
+func WithRuleEngine(e *Engine) Option {
+	return func(s *Service) {
+		s.rules = e
+	}
+}
+
+// WithVelocityChecker makes new authorizations pass through velocity limits,
+// which may decline them or send them to manual review.
+func WithVelocityChecker(v *VelocityChecker) Option {
+	return func(s *Service) {
+		s.velocity = v
+	}
+}
+
+// WithReviewPolicy overrides DefaultReviewPolicy for authorizations sent to manual review.
+func WithReviewPolicy(p ReviewPolicy) Option {
+	return func(s *Service) {
+		s.review = p
+	}
+}
+
+// NewService creates the authorization service. Cardholders seen for the
+// first time are given a credit account with defaultCreditLimit.
+func NewService(db *sql.DB, defaultCreditLimit float64, opts ...Option) *Service {
+	s := &Service{db: db, defaultCreditLimit: defaultCreditLimit, review: DefaultReviewPolicy, disputes: DefaultDisputePolicy, fx: DefaultFXPolicy}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
-func NewService(db *sql.DB) *Service {
-	return &Service{db: db}
+// HandleMessage dispatches a message to the handler for its topic.
+func (s *Service) HandleMessage(ctx context.Context, msg transport.Message) error {
+	switch msg.Topic {
+	case "authorization-requests":
+		return s.HandleAuthorizationRequest(ctx, msg)
+	case "incremental-authorization-requests":
+		return s.HandleIncrementalAuthorization(ctx, msg)
+	case "authorization-reversal-requests":
+		return s.HandleAuthorizationReversal(ctx, msg)
+	case "refund-authorization-requests":
+		return s.HandleRefundRequest(ctx, msg)
+	case "refund-completion-requests":
+		return s.HandleRefundCompletion(ctx, msg)
+	case "refund-compensation-requests":
+		return s.HandleRefundCompensation(ctx, msg)
+	case "payment-credit-requests":
+		return s.HandlePaymentCredit(ctx, msg)
+	case "payment-return-credit-requests":
+		return s.HandlePaymentReturnCredit(ctx, msg)
+	case "capture-requests":
+		return s.HandleCapture(ctx, msg)
+	case "dispute-compensation-requests":
+		return s.HandleDisputeCompensation(ctx, msg)
+	}
+	return fmt.Errorf("unexpected topic %q", msg.Topic)
 }
 
 // HandleAuthorizationRequest processes the authorization request.
-func (s *Service) HandleAuthorizationRequest(ctx context.Context, msg kafka.Message) error {
+func (s *Service) HandleAuthorizationRequest(ctx context.Context, msg transport.Message) error {
 	tr := otel.Tracer("authorization-service")
 	ctx, span := tr.Start(ctx, "HandleAuthorizationRequest")
 	defer span.End()
@@ -40,28 +117,156 @@ func (s *Service) HandleAuthorizationRequest(ctx context.Context, msg kafka.Mess
 	defer tx.Rollback() // Rollback is a no-op if tx is committed
 
 	// --- Business Logic ---
-	// In a real application, you would check for credit limits, fraud, etc.
-	// For this example, we'll just assume it succeeds.
-	log.Printf("Authorizing transaction %s for amount %f", reqEvent.TransactionID, reqEvent.Amount)
+	// The amount is converted to the billing currency, then decline rules
+	// run, then velocity limits, then the converted amount is held against
+	// the cardholder's available credit.
+	log.Printf("Authorizing transaction %s for amount %f %s", reqEvent.TransactionID, reqEvent.Amount, reqEvent.Currency)
 
-	// Save authorization status to the database
-	_, err = tx.ExecContext(ctx, "INSERT INTO authorizations (transaction_id, amount, status) VALUES ($1, $2, $3)",
-		reqEvent.TransactionID, reqEvent.Amount, "SUCCEEDED")
+	// Authorizations for a cardholder are serialized, so each velocity check
+	// sees every earlier authorization that was held.
+	if err := lockCardholder(ctx, tx, reqEvent.UserID); err != nil {
+		return err
+	}
+
+	cardStatus, err := s.ensureAccount(ctx, tx, reqEvent.UserID)
 	if err != nil {
 		return err
 	}
 
-	// --- Transactional Outbox ---
-	// Create the success event
-	successEvent := events.AuthorizationSucceeded{
+	conv, err := s.convert(ctx, reqEvent)
+	if errors.Is(err, fx.ErrRateUnavailable) {
+		// Nothing is held, and the amount has no billing-currency value.
+		reqEvent.Amount = 0
+		return s.decline(ctx, tx, reqEvent, conv, ReasonUnsupportedCurrency, 0)
+	}
+	if err != nil {
+		return err
+	}
+	if conv.Foreign() {
+		log.Printf("Converted transaction %s from %f %s to %f %s at %f plus %.4f markup", reqEvent.TransactionID,
+			conv.TransactionAmount, conv.TransactionCurrency, conv.BillingAmount, conv.BillingCurrency, conv.Rate, conv.Markup)
+	}
+	reqEvent.Amount = conv.BillingAmount
+
+	if s.rules != nil {
+		decision := s.rules.Evaluate(RuleInput{
+			TransactionID:        reqEvent.TransactionID,
+			UserID:               reqEvent.UserID,
+			Amount:               reqEvent.Amount,
+			MerchantCategoryCode: reqEvent.MerchantCategoryCode,
+			MerchantCountry:      reqEvent.MerchantCountry,
+			CardStatus:           cardStatus,
+			Time:                 time.Now(),
+		})
+		if decision != nil {
+			return s.decline(ctx, tx, reqEvent, conv, decision.String(), 0)
+		}
+	}
+
+	velocityEvent := VelocityEvent{
+		UserID:        reqEvent.UserID,
 		TransactionID: reqEvent.TransactionID,
+		MerchantID:    reqEvent.MerchantID,
+		Amount:        reqEvent.Amount,
+		OccurredAt:    time.Now(),
+	}
+	assessment := Assessment{Outcome: OutcomeApprove}
+	if s.velocity != nil {
+		if assessment, err = s.velocity.Assess(ctx, tx, velocityEvent); err != nil {
+			return err
+		}
+		if assessment.Outcome == OutcomeDecline {
+			return s.decline(ctx, tx, reqEvent, conv, assessment.Reason, assessment.RiskScore)
+		}
 	}
 
-	// Add the event to the outbox as part of the same transaction
-	if err := outbox.AddToOutbox(tx, "authorization-succeeded", reqEvent.TransactionID, successEvent); err != nil {
+	reason, err := s.reserveCredit(ctx, tx, reqEvent.UserID, reqEvent.Amount)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		return s.decline(ctx, tx, reqEvent, conv, reason, assessment.RiskScore)
+	}
+
+	status := StatusSucceeded
+	if assessment.Outcome == OutcomeReview {
+		status = StatusPendingReview
+	}
+
+	// Save authorization status to the database
+	if err := insertAuthorization(ctx, tx, reqEvent, conv, status, assessment.RiskScore); err != nil {
+		return err
+	}
+	if _, err := recordAmountChange(ctx, tx, reqEvent.TransactionID, ChangeInitial, reqEvent.Amount, 0, reqEvent.Amount); err != nil {
+		return err
+	}
+
+	// The hold is placed, so the authorization counts towards future velocity
+	// checks.
+	if s.velocity != nil {
+		if err := s.velocity.Record(ctx, tx, velocityEvent); err != nil {
+			return err
+		}
+	}
+
+	// --- Transactional Outbox ---
+	// Add the outcome event to the outbox as part of the same transaction.
+	// Authorizations sent to review hold the amount but park the saga until
+	// a reviewer decides or the review times out.
+	if status == StatusPendingReview {
+		log.Printf("Transaction %s sent to manual review: %s", reqEvent.TransactionID, assessment.Reason)
+		reviewEvent := events.AuthorizationReviewRequired{
+			TransactionID: reqEvent.TransactionID,
+			UserID:        reqEvent.UserID,
+			Amount:        reqEvent.Amount,
+			RiskScore:     assessment.RiskScore,
+			Reason:        assessment.Reason,
+		}
+		if err := s.enqueueReview(ctx, tx, reviewEvent); err != nil {
+			return err
+		}
+		err = outbox.NewWriter(tx).Write(ctx, "authorization-review-required", reqEvent.TransactionID, reviewEvent)
+	} else {
+		successEvent := succeededEvent(reqEvent.TransactionID, reqEvent.UserID, reqEvent.Amount, assessment.RiskScore, conv)
+		err = outbox.NewWriter(tx).Write(ctx, "authorization-succeeded", reqEvent.TransactionID, successEvent)
+	}
+	if err != nil {
 		return err
 	}
 
 	// Commit the transaction
 	return tx.Commit()
-}
\ No newline at end of file
+}
+
+// lockCardholder takes a transaction-scoped advisory lock on the cardholder,
+// released when tx commits or rolls back.
+func lockCardholder(ctx context.Context, tx *sql.Tx, userID string) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", userID)
+	return err
+}
+
+// decline records a declined authorization and emits authorization-failed.
+func (s *Service) decline(ctx context.Context, tx *sql.Tx, req events.AuthorizationRequested, conv fx.Conversion, reason string, riskScore int) error {
+	log.Printf("Declining transaction %s: %s", req.TransactionID, reason)
+	if err := insertAuthorization(ctx, tx, req, conv, StatusDeclined, riskScore); err != nil {
+		return err
+	}
+	failedEvent := events.AuthorizationFailed{TransactionID: req.TransactionID, Reason: reason, RiskScore: riskScore}
+	if err := outbox.NewWriter(tx).Write(ctx, "authorization-failed", req.TransactionID, failedEvent); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertAuthorization records an authorization of req.Amount in the billing
+// currency. The conversion is left out if the currency was not supported.
+func insertAuthorization(ctx context.Context, tx *sql.Tx, req events.AuthorizationRequested, conv fx.Conversion, status string, riskScore int) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO authorizations (transaction_id, user_id, amount, status, merchant_id, merchant_category_code, merchant_country, risk_score,
+		                            transaction_currency, transaction_amount, billing_currency, fx_rate, fx_markup)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), $8,
+		        NULLIF($9, ''), NULLIF($10, 0), NULLIF($11, ''), NULLIF($12, 0), $13)
+	`, req.TransactionID, req.UserID, req.Amount, status, req.MerchantID, req.MerchantCategoryCode, req.MerchantCountry, riskScore,
+		conv.TransactionCurrency, conv.TransactionAmount, conv.BillingCurrency, conv.Rate, conv.Markup)
+	return err
+}
@@ -0,0 +1,175 @@
+package authorization
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// Velocity outcomes.
+const (
+	OutcomeApprove = "APPROVE"
+	OutcomeReview  = "REVIEW"
+	OutcomeDecline = "DECLINE"
+)
+
+// VelocityLimit bounds a cardholder's activity inside a sliding window. Zero
+// maxima are not checked. Action is the outcome when the limit is exceeded.
+type VelocityLimit struct {
+	Name                 string
+	Window               time.Duration
+	MaxCount             int
+	MaxAmount            float64
+	MaxDistinctMerchants int
+	Action               string
+}
+
+// UnmarshalJSON reads a limit with its window written as a Go duration, e.g.
+//
+//	{"name": "per-minute", "window": "1m", "max_count": 5, "action": "DECLINE"}
+func (l *VelocityLimit) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Name                 string  `json:"name"`
+		Window               string  `json:"window"`
+		MaxCount             int     `json:"max_count"`
+		MaxAmount            float64 `json:"max_amount"`
+		MaxDistinctMerchants int     `json:"max_distinct_merchants"`
+		Action               string  `json:"action"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	window, err := time.ParseDuration(raw.Window)
+	if err != nil {
+		return fmt.Errorf("velocity limit %s: %w", raw.Name, err)
+	}
+	if raw.Action != OutcomeDecline && raw.Action != OutcomeReview {
+		return fmt.Errorf("velocity limit %s: action must be %s or %s", raw.Name, OutcomeDecline, OutcomeReview)
+	}
+	*l = VelocityLimit{
+		Name:                 raw.Name,
+		Window:               window,
+		MaxCount:             raw.MaxCount,
+		MaxAmount:            raw.MaxAmount,
+		MaxDistinctMerchants: raw.MaxDistinctMerchants,
+		Action:               raw.Action,
+	}
+	return nil
+}
+
+// DefaultVelocityLimits are used when no limits file is configured.
+var DefaultVelocityLimits = []VelocityLimit{
+	{Name: "count-per-minute", Window: time.Minute, MaxCount: 5, Action: OutcomeDecline},
+	{Name: "count-per-hour", Window: time.Hour, MaxCount: 30, Action: OutcomeReview},
+	{Name: "amount-per-day", Window: 24 * time.Hour, MaxAmount: 5000, Action: OutcomeReview},
+	{Name: "merchants-per-hour", Window: time.Hour, MaxDistinctMerchants: 5, Action: OutcomeReview},
+}
+
+// LoadVelocityLimits reads a JSON array of velocity limits.
+func LoadVelocityLimits(path string) ([]VelocityLimit, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var limits []VelocityLimit
+	if err := json.Unmarshal(data, &limits); err != nil {
+		return nil, fmt.Errorf("parsing velocity limits file %s: %w", path, err)
+	}
+	return limits, nil
+}
+
+// Assessment is the velocity verdict on one authorization.
+type Assessment struct {
+	Outcome string
+	// RiskScore runs from 0 to 100: how close the cardholder is to their
+	// tightest limit once this authorization is counted.
+	RiskScore int
+	// Limit and Reason describe the limit that caused a review or decline.
+	Limit  string
+	Reason string
+}
+
+// VelocityChecker evaluates velocity limits against a CounterStore.
+type VelocityChecker struct {
+	store  CounterStore
+	limits []VelocityLimit
+	now    func() time.Time
+}
+
+func NewVelocityChecker(store CounterStore, limits []VelocityLimit) *VelocityChecker {
+	return &VelocityChecker{store: store, limits: limits, now: time.Now}
+}
+
+// Assess counts ev against every limit, reading history through q. A
+// decline limit takes precedence over a review limit.
+func (c *VelocityChecker) Assess(ctx context.Context, q Querier, ev VelocityEvent) (Assessment, error) {
+	result := Assessment{Outcome: OutcomeApprove}
+	var utilisation float64
+	now := c.now()
+
+	for _, limit := range c.limits {
+		stats, err := c.store.Stats(ctx, q, ev.UserID, ev.MerchantID, now.Add(-limit.Window))
+		if err != nil {
+			return result, err
+		}
+
+		// Count the authorization being assessed as part of the window.
+		count := stats.Count + 1
+		amount := stats.Amount + ev.Amount
+		merchants := stats.DistinctMerchants
+		if ev.MerchantID != "" && !stats.MerchantSeen {
+			merchants++
+		}
+
+		var reason string
+		if limit.MaxCount > 0 {
+			utilisation = math.Max(utilisation, float64(count)/float64(limit.MaxCount))
+			if count > limit.MaxCount {
+				reason = fmt.Sprintf("%d authorizations in %s exceeds %d", count, limit.Window, limit.MaxCount)
+			}
+		}
+		if limit.MaxAmount > 0 {
+			utilisation = math.Max(utilisation, amount/limit.MaxAmount)
+			if amount > limit.MaxAmount {
+				reason = fmt.Sprintf("%.2f authorized in %s exceeds %.2f", amount, limit.Window, limit.MaxAmount)
+			}
+		}
+		if limit.MaxDistinctMerchants > 0 {
+			utilisation = math.Max(utilisation, float64(merchants)/float64(limit.MaxDistinctMerchants))
+			if merchants > limit.MaxDistinctMerchants {
+				reason = fmt.Sprintf("%d distinct merchants in %s exceeds %d", merchants, limit.Window, limit.MaxDistinctMerchants)
+			}
+		}
+
+		if reason == "" || result.Outcome == OutcomeDecline {
+			continue
+		}
+		if limit.Action == OutcomeDecline || result.Outcome == OutcomeApprove {
+			result.Outcome = limit.Action
+			result.Limit = limit.Name
+			result.Reason = fmt.Sprintf("velocity limit %s: %s", limit.Name, reason)
+		}
+	}
+
+	result.RiskScore = int(math.Min(100, math.Round(utilisation*100)))
+	return result, nil
+}
+
+// Record counts ev towards future assessments, writing through q.
+func (c *VelocityChecker) Record(ctx context.Context, q Querier, ev VelocityEvent) error {
+	return c.store.Record(ctx, q, ev)
+}
+
+// Prune drops history older than the longest configured window.
+func (c *VelocityChecker) Prune(ctx context.Context) error {
+	var longest time.Duration
+	for _, limit := range c.limits {
+		if limit.Window > longest {
+			longest = limit.Window
+		}
+	}
+	return c.store.Prune(ctx, c.now().Add(-longest))
+}
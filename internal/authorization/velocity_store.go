@@ -0,0 +1,135 @@
+package authorization
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// VelocityEvent is one authorization counted towards a cardholder's velocity.
+type VelocityEvent struct {
+	UserID        string
+	TransactionID string
+	MerchantID    string
+	Amount        float64
+	OccurredAt    time.Time
+}
+
+// WindowStats summarises a cardholder's authorizations inside a window.
+type WindowStats struct {
+	Count             int
+	Amount            float64
+	DistinctMerchants int
+	// MerchantSeen reports whether the merchant asked about is among them.
+	MerchantSeen bool
+}
+
+// Querier is satisfied by both *sql.DB and *sql.Tx.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// CounterStore keeps per-cardholder authorization history for sliding
+// window velocity checks. Record and Stats run through q, the caller's
+// transaction, so an authorization is assessed and counted atomically with
+// its hold; stores that do not keep history in the database ignore q.
+type CounterStore interface {
+	Record(ctx context.Context, q Querier, ev VelocityEvent) error
+	// Stats returns the cardholder's activity at or after since.
+	Stats(ctx context.Context, q Querier, userID, merchantID string, since time.Time) (WindowStats, error)
+	// Prune drops activity older than before.
+	Prune(ctx context.Context, before time.Time) error
+}
+
+// PostgresCounterStore keeps velocity history in the velocity_events table.
+type PostgresCounterStore struct {
+	db *sql.DB
+}
+
+func NewPostgresCounterStore(db *sql.DB) *PostgresCounterStore {
+	return &PostgresCounterStore{db: db}
+}
+
+func (s *PostgresCounterStore) Record(ctx context.Context, q Querier, ev VelocityEvent) error {
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO velocity_events (user_id, transaction_id, merchant_id, amount, occurred_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5)
+		ON CONFLICT (transaction_id) DO NOTHING
+	`, ev.UserID, ev.TransactionID, ev.MerchantID, ev.Amount, ev.OccurredAt)
+	return err
+}
+
+func (s *PostgresCounterStore) Stats(ctx context.Context, q Querier, userID, merchantID string, since time.Time) (WindowStats, error) {
+	var stats WindowStats
+	err := q.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(amount), 0), COUNT(DISTINCT merchant_id),
+		       COUNT(*) FILTER (WHERE merchant_id = $3) > 0
+		FROM velocity_events
+		WHERE user_id = $1 AND occurred_at >= $2
+	`, userID, since, merchantID).Scan(&stats.Count, &stats.Amount, &stats.DistinctMerchants, &stats.MerchantSeen)
+	return stats, err
+}
+
+func (s *PostgresCounterStore) Prune(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM velocity_events WHERE occurred_at < $1", before)
+	return err
+}
+
+// MemoryCounterStore is an in-process CounterStore for tests and local runs.
+type MemoryCounterStore struct {
+	mu     sync.Mutex
+	events map[string][]VelocityEvent
+}
+
+func NewMemoryCounterStore() *MemoryCounterStore {
+	return &MemoryCounterStore{events: make(map[string][]VelocityEvent)}
+}
+
+func (s *MemoryCounterStore) Record(ctx context.Context, q Querier, ev VelocityEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.events[ev.UserID] {
+		if existing.TransactionID == ev.TransactionID {
+			return nil
+		}
+	}
+	s.events[ev.UserID] = append(s.events[ev.UserID], ev)
+	return nil
+}
+
+func (s *MemoryCounterStore) Stats(ctx context.Context, q Querier, userID, merchantID string, since time.Time) (WindowStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var stats WindowStats
+	merchants := make(map[string]bool)
+	for _, ev := range s.events[userID] {
+		if ev.OccurredAt.Before(since) {
+			continue
+		}
+		stats.Count++
+		stats.Amount += ev.Amount
+		if ev.MerchantID != "" {
+			merchants[ev.MerchantID] = true
+		}
+	}
+	stats.DistinctMerchants = len(merchants)
+	stats.MerchantSeen = merchants[merchantID]
+	return stats, nil
+}
+
+func (s *MemoryCounterStore) Prune(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for userID, evs := range s.events {
+		kept := evs[:0]
+		for _, ev := range evs {
+			if !ev.OccurredAt.Before(before) {
+				kept = append(kept, ev)
+			}
+		}
+		s.events[userID] = kept
+	}
+	return nil
+}
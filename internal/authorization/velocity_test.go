@@ -0,0 +1,74 @@
+package authorization
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestVelocityCheckerOutcomes(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	store := NewMemoryCounterStore()
+	checker := NewVelocityChecker(store, []VelocityLimit{
+		{Name: "count-per-minute", Window: time.Minute, MaxCount: 2, Action: OutcomeDecline},
+		{Name: "amount-per-hour", Window: time.Hour, MaxAmount: 100, Action: OutcomeReview},
+		{Name: "merchants-per-hour", Window: time.Hour, MaxDistinctMerchants: 2, Action: OutcomeReview},
+	})
+	checker.now = func() time.Time { return now }
+
+	record := func(id, merchant string, amount float64, at time.Time) {
+		t.Helper()
+		ev := VelocityEvent{UserID: "user-1", TransactionID: id, MerchantID: merchant, Amount: amount, OccurredAt: at}
+		if err := checker.Record(ctx, nil, ev); err != nil {
+			t.Fatalf("Record(%s): %v", id, err)
+		}
+	}
+	assess := func(merchant string, amount float64) Assessment {
+		t.Helper()
+		a, err := checker.Assess(ctx, nil, VelocityEvent{UserID: "user-1", TransactionID: "tx-new", MerchantID: merchant, Amount: amount})
+		if err != nil {
+			t.Fatalf("Assess: %v", err)
+		}
+		return a
+	}
+
+	if a := assess("m-1", 10); a.Outcome != OutcomeApprove || a.RiskScore != 50 {
+		t.Errorf("first authorization = %+v, want APPROVE with risk 50", a)
+	}
+
+	// Older activity only counts towards the hourly windows.
+	record("tx-1", "m-1", 40, now.Add(-30*time.Minute))
+	if a := assess("m-1", 70); a.Outcome != OutcomeReview || a.Limit != "amount-per-hour" {
+		t.Errorf("over hourly amount = %+v, want REVIEW by amount-per-hour", a)
+	}
+	if a := assess("m-2", 10); a.Outcome != OutcomeApprove {
+		t.Errorf("second merchant = %+v, want APPROVE", a)
+	}
+
+	record("tx-2", "m-2", 10, now.Add(-20*time.Minute))
+	if a := assess("m-3", 10); a.Outcome != OutcomeReview || a.Limit != "merchants-per-hour" {
+		t.Errorf("third merchant = %+v, want REVIEW by merchants-per-hour", a)
+	}
+
+	// A decline limit wins over review limits.
+	record("tx-3", "m-1", 5, now.Add(-20*time.Second))
+	record("tx-4", "m-1", 5, now.Add(-10*time.Second))
+	if a := assess("m-3", 70); a.Outcome != OutcomeDecline || a.Limit != "count-per-minute" || a.RiskScore != 100 {
+		t.Errorf("third in a minute = %+v, want DECLINE by count-per-minute with risk 100", a)
+	}
+
+	// Pruning to the longest window keeps everything here but drops older history.
+	record("tx-0", "m-9", 1000, now.Add(-2*time.Hour))
+	if err := checker.Prune(ctx); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	stats, err := store.Stats(ctx, nil, "user-1", "", now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if stats.Count != 4 {
+		t.Errorf("after prune Count = %d, want 4", stats.Count)
+	}
+}
@@ -0,0 +1,88 @@
+package clearing
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterFormat("csv", func() Parser { return CSVParser{} })
+}
+
+// csvColumns are the columns of the CSV layout. The first row names them,
+// in any order; merchant_id may be left out or empty.
+//
+//	transaction_id,user_id,amount,currency,merchant_id,settlement_date
+//	tx-12345,user-6789,99.99,USD,m-42,2024-03-01
+var csvColumns = []string{"transaction_id", "user_id", "amount", "currency", "merchant_id", "settlement_date"}
+
+// CSVParser reads clearing files in the CSV layout described by csvColumns.
+// Amounts are decimals and settlement dates are YYYY-MM-DD.
+type CSVParser struct{}
+
+func (CSVParser) Parse(r io.Reader) ([]Record, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("clearing file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range csvColumns {
+		if _, ok := index[name]; !ok && name != "merchant_id" {
+			return nil, fmt.Errorf("clearing file header is missing column %q", name)
+		}
+	}
+	cr.FieldsPerRecord = len(header)
+
+	var records []Record
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		field := func(name string) string {
+			if i, ok := index[name]; ok {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		rec := Record{
+			TransactionID: field("transaction_id"),
+			UserID:        field("user_id"),
+			Currency:      strings.ToUpper(field("currency")),
+			MerchantID:    field("merchant_id"),
+			Line:          line,
+		}
+		if rec.TransactionID == "" {
+			return nil, fmt.Errorf("line %d: transaction_id is required", line)
+		}
+		if len(rec.Currency) != 3 {
+			return nil, fmt.Errorf("line %d: invalid currency %q", line, rec.Currency)
+		}
+		if rec.Amount, err = strconv.ParseFloat(field("amount"), 64); err != nil {
+			return nil, fmt.Errorf("line %d: invalid amount %q", line, field("amount"))
+		}
+		if rec.SettlementDate, err = time.Parse("2006-01-02", field("settlement_date")); err != nil {
+			return nil, fmt.Errorf("line %d: invalid settlement_date %q", line, field("settlement_date"))
+		}
+		records = append(records, rec)
+	}
+}
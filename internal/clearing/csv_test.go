@@ -0,0 +1,67 @@
+package clearing
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSVParser(t *testing.T) {
+	p, err := NewParser("csv")
+	if err != nil {
+		t.Fatalf("NewParser: %v", err)
+	}
+
+	input := `settlement_date,transaction_id,user_id,amount,currency
+2024-03-01,tx-1,user-1,99.99,usd
+2024-03-01,tx-2,,12.50,USD
+`
+	records, err := p.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Parse returned %d records, want 2", len(records))
+	}
+
+	want := Record{
+		TransactionID:  "tx-1",
+		UserID:         "user-1",
+		Amount:         99.99,
+		Currency:       "USD",
+		SettlementDate: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		Line:           2,
+	}
+	if records[0] != want {
+		t.Errorf("first record = %+v, want %+v", records[0], want)
+	}
+	if records[1].UserID != "" || records[1].Line != 3 {
+		t.Errorf("second record = %+v, want no user on line 3", records[1])
+	}
+}
+
+func TestCSVParserErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"empty file", "", "empty"},
+		{"missing column", "transaction_id,amount,currency\n", `missing column "user_id"`},
+		{"bad amount", "transaction_id,user_id,amount,currency,settlement_date\ntx-1,u,abc,USD,2024-03-01\n", "line 2: invalid amount"},
+		{"bad date", "transaction_id,user_id,amount,currency,settlement_date\ntx-1,u,1,USD,03/01/2024\n", "line 2: invalid settlement_date"},
+		{"no transaction", "transaction_id,user_id,amount,currency,settlement_date\n,u,1,USD,2024-03-01\n", "line 2: transaction_id is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CSVParser{}.Parse(strings.NewReader(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewParser("visa-base-ii"); err == nil {
+		t.Error("NewParser accepted an unregistered format")
+	}
+}
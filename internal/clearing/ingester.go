@@ -0,0 +1,279 @@
+package clearing
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"credit-authorization-ledger/internal/authorization"
+	"credit-authorization-ledger/internal/fx"
+	"credit-authorization-ledger/internal/outbox"
+	"credit-authorization-ledger/pkg/events"
+)
+
+// Outcomes of matching a clearing record.
+const (
+	// OutcomeMatched is a record that cleared for exactly the held amount.
+	OutcomeMatched = "MATCHED"
+	// OutcomeAdjusted is a record that cleared for a different amount than
+	// was held; the capture moves the hold to the cleared amount.
+	OutcomeAdjusted = "ADJUSTED"
+	// OutcomeForcePosted is a record without an open authorization, posted
+	// anyway because the network has settled it.
+	OutcomeForcePosted = "FORCE_POSTED"
+	// OutcomeRejected is a record that cannot be posted and needs an operator.
+	OutcomeRejected = "REJECTED"
+	// OutcomeDuplicate is a record already ingested from an earlier file.
+	OutcomeDuplicate = "DUPLICATE"
+)
+
+// ReportLine is the outcome of one clearing record. Records settled in a
+// foreign transaction currency also carry the settled amount and the rate
+// it was converted at.
+type ReportLine struct {
+	Line             int     `json:"line"`
+	TransactionID    string  `json:"transaction_id"`
+	UserID           string  `json:"user_id,omitempty"`
+	ClearedAmount    float64 `json:"cleared_amount"`
+	AuthorizedAmount float64 `json:"authorized_amount"`
+	OriginalAmount   float64 `json:"original_amount,omitempty"`
+	OriginalCurrency string  `json:"original_currency,omitempty"`
+	FXRate           float64 `json:"fx_rate,omitempty"`
+	Outcome          string  `json:"outcome"`
+	Detail           string  `json:"detail,omitempty"`
+}
+
+// Report summarizes the settlement of one clearing file. Totals are in the
+// billing currency and leave out rejected and duplicate records.
+type Report struct {
+	File        string         `json:"file"`
+	GeneratedAt time.Time      `json:"generated_at"`
+	Records     int            `json:"records"`
+	Counts      map[string]int `json:"counts"`
+	// SettlementTotal is what the network settled for the captured records.
+	SettlementTotal float64 `json:"settlement_total"`
+	// AuthorizedTotal is what was held for the matched and adjusted records.
+	AuthorizedTotal float64 `json:"authorized_total"`
+	// AdjustmentTotal is the net change to held amounts from adjusted records.
+	AdjustmentTotal  float64      `json:"adjustment_total"`
+	ForcePostedTotal float64      `json:"force_posted_total"`
+	Lines            []ReportLine `json:"lines"`
+}
+
+// Write encodes the report as indented JSON.
+func (r *Report) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (r *Report) add(l ReportLine) {
+	r.Counts[l.Outcome]++
+	r.Lines = append(r.Lines, l)
+	switch l.Outcome {
+	case OutcomeMatched, OutcomeAdjusted:
+		r.SettlementTotal = round2(r.SettlementTotal + l.ClearedAmount)
+		r.AuthorizedTotal = round2(r.AuthorizedTotal + l.AuthorizedAmount)
+		r.AdjustmentTotal = round2(r.AdjustmentTotal + l.ClearedAmount - l.AuthorizedAmount)
+	case OutcomeForcePosted:
+		r.SettlementTotal = round2(r.SettlementTotal + l.ClearedAmount)
+		r.ForcePostedTotal = round2(r.ForcePostedTotal + l.ClearedAmount)
+	}
+}
+
+// round2 rounds to cents.
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// Ingester matches clearing records against authorizations and requests a
+// capture for each one that can be posted.
+type Ingester struct {
+	db              *sql.DB
+	billingCurrency string
+	rates           fx.RateProvider
+}
+
+// NewIngester creates an ingester for records settled in billingCurrency.
+// Records of foreign-currency authorizations may also be settled in their
+// transaction currency; they are converted at rates from rates, which may
+// be nil to reject them.
+func NewIngester(db *sql.DB, billingCurrency string, rates fx.RateProvider) *Ingester {
+	return &Ingester{db: db, billingCurrency: billingCurrency, rates: rates}
+}
+
+// Ingest records every clearing record of file and returns the settlement
+// report. Each record is stored with its capture request in one
+// transaction, so an interrupted run can be repeated.
+func (i *Ingester) Ingest(ctx context.Context, file string, records []Record) (*Report, error) {
+	report := &Report{File: file, GeneratedAt: time.Now().UTC(), Records: len(records), Counts: map[string]int{}}
+	for _, rec := range records {
+		line, err := i.ingest(ctx, file, rec)
+		if err != nil {
+			return report, fmt.Errorf("line %d, transaction %s: %w", rec.Line, rec.TransactionID, err)
+		}
+		report.add(line)
+	}
+	return report, nil
+}
+
+func (i *Ingester) ingest(ctx context.Context, file string, rec Record) (ReportLine, error) {
+	line := ReportLine{Line: rec.Line, TransactionID: rec.TransactionID, UserID: rec.UserID, ClearedAmount: rec.Amount}
+
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return line, err
+	}
+	defer tx.Rollback()
+
+	// A rejected record is matched again when the transaction reappears,
+	// e.g. in a corrected file; any other outcome is final.
+	var previousFile, previousOutcome string
+	err = tx.QueryRowContext(ctx,
+		"SELECT file_name, outcome FROM clearing_records WHERE transaction_id = $1 FOR UPDATE",
+		rec.TransactionID).Scan(&previousFile, &previousOutcome)
+	switch {
+	case err == nil && previousOutcome != OutcomeRejected:
+		return duplicate(line, previousFile), nil
+	case err != nil && !errors.Is(err, sql.ErrNoRows):
+		return line, err
+	}
+
+	if err := i.match(ctx, tx, rec, &line); err != nil {
+		return line, err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO clearing_records (transaction_id, file_name, user_id, amount, currency, merchant_id, settlement_date, outcome, detail)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, $9)
+		ON CONFLICT (transaction_id) DO UPDATE
+		SET file_name = EXCLUDED.file_name, user_id = EXCLUDED.user_id, amount = EXCLUDED.amount,
+		    currency = EXCLUDED.currency, merchant_id = EXCLUDED.merchant_id,
+		    settlement_date = EXCLUDED.settlement_date, outcome = EXCLUDED.outcome,
+		    detail = EXCLUDED.detail, ingested_at = NOW()
+		WHERE clearing_records.outcome = $10
+	`, rec.TransactionID, file, line.UserID, rec.Amount, rec.Currency, rec.MerchantID, rec.SettlementDate, line.Outcome, line.Detail,
+		OutcomeRejected)
+	if err != nil {
+		return line, err
+	}
+	// Another run cleared the transaction since it was looked up.
+	if n, err := res.RowsAffected(); err != nil {
+		return line, err
+	} else if n == 0 {
+		return duplicate(line, "another run"), nil
+	}
+
+	if line.Outcome != OutcomeRejected {
+		captureEvent := events.CaptureRequested{
+			TransactionID:       rec.TransactionID,
+			UserID:              line.UserID,
+			Amount:              line.ClearedAmount,
+			ClearingFile:        file,
+			SettlementDate:      rec.SettlementDate,
+			TransactionCurrency: line.OriginalCurrency,
+			TransactionAmount:   line.OriginalAmount,
+			FXRate:              line.FXRate,
+		}
+		if err := outbox.NewWriter(tx).Write(ctx, "capture-requested", rec.TransactionID, captureEvent); err != nil {
+			return line, err
+		}
+	}
+	return line, tx.Commit()
+}
+
+// duplicate reports line as already cleared by file.
+func duplicate(line ReportLine, file string) ReportLine {
+	line.Outcome = OutcomeDuplicate
+	line.Detail = fmt.Sprintf("already cleared in %s", file)
+	return line
+}
+
+// match decides the outcome of rec from the authorization it clears.
+func (i *Ingester) match(ctx context.Context, tx *sql.Tx, rec Record, line *ReportLine) error {
+	line.Outcome = OutcomeRejected
+	if rec.Amount <= 0 {
+		line.Detail = "cleared amount must be positive"
+		return nil
+	}
+
+	var userID, status, currency string
+	var amount, markup float64
+	err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(user_id, ''), status, amount, COALESCE(transaction_currency, ''), COALESCE(fx_markup, 0)
+		FROM authorizations
+		WHERE transaction_id = $1
+	`, rec.TransactionID).Scan(&userID, &status, &amount, &currency, &markup)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if rec.Currency != i.billingCurrency {
+			line.Detail = fmt.Sprintf("settled in %s, not the billing currency %s", rec.Currency, i.billingCurrency)
+			return nil
+		}
+		if rec.UserID == "" {
+			line.Detail = "no authorization and no user_id to force-post to"
+			return nil
+		}
+		line.Outcome = OutcomeForcePosted
+		line.Detail = "no authorization"
+		return nil
+	case err != nil:
+		return err
+	}
+
+	if rec.Currency != i.billingCurrency {
+		converted, err := i.convert(ctx, rec, currency, markup, line)
+		if err != nil || !converted {
+			return err
+		}
+	}
+
+	line.UserID = userID
+	switch status {
+	case authorization.StatusSucceeded:
+		line.AuthorizedAmount = amount
+		line.Outcome = OutcomeMatched
+		if math.Abs(line.ClearedAmount-amount) >= 0.005 {
+			line.Outcome = OutcomeAdjusted
+			line.Detail = fmt.Sprintf("authorized %.2f, cleared %.2f", amount, line.ClearedAmount)
+		}
+	case authorization.StatusReversed:
+		line.Outcome = OutcomeForcePosted
+		line.Detail = "authorization was reversed"
+	default:
+		line.Detail = fmt.Sprintf("authorization is %s", status)
+	}
+	return nil
+}
+
+// convert sets the cleared amount of a record settled in its authorization's
+// transaction currency to the billing amount at the settlement date's rate,
+// plus the markup the authorization was converted with. It reports false,
+// leaving the record rejected, if the record cannot be converted.
+func (i *Ingester) convert(ctx context.Context, rec Record, transactionCurrency string, markup float64, line *ReportLine) (bool, error) {
+	if rec.Currency != transactionCurrency {
+		line.Detail = fmt.Sprintf("settled in %s, not the billing currency %s or the transaction currency", rec.Currency, i.billingCurrency)
+		return false, nil
+	}
+	if i.rates == nil {
+		line.Detail = fmt.Sprintf("settled in %s without exchange rates to convert it", rec.Currency)
+		return false, nil
+	}
+
+	conv, err := fx.ConvertOn(ctx, i.rates, rec.Amount, rec.Currency, i.billingCurrency, markup, rec.SettlementDate)
+	if errors.Is(err, fx.ErrRateUnavailable) {
+		line.Detail = err.Error()
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	line.ClearedAmount = conv.BillingAmount
+	line.OriginalAmount, line.OriginalCurrency, line.FXRate = rec.Amount, rec.Currency, conv.Rate
+	return true, nil
+}
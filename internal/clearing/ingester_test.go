@@ -0,0 +1,191 @@
+package clearing
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"credit-authorization-ledger/internal/authorization"
+	"credit-authorization-ledger/internal/dbtest"
+	"credit-authorization-ledger/internal/fx"
+	"credit-authorization-ledger/pkg/events"
+)
+
+func TestConvertAtSettlementDate(t *testing.T) {
+	ctx := context.Background()
+	rates, err := fx.NewRateHistory(map[string]map[string]float64{
+		"2024-03-01": {"EUR/USD": 1.08},
+		"2024-03-04": {"EUR/USD": 1.10},
+	})
+	if err != nil {
+		t.Fatalf("NewRateHistory: %v", err)
+	}
+	i := NewIngester(nil, "USD", rates)
+
+	rec := Record{TransactionID: "tx-1", Amount: 80, Currency: "EUR", SettlementDate: time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)}
+	line := ReportLine{ClearedAmount: rec.Amount}
+	if ok, err := i.convert(ctx, rec, "EUR", 0.03, &line); !ok || err != nil {
+		t.Fatalf("convert = %t, %v (%s)", ok, err, line.Detail)
+	}
+	// 80 EUR at 1.10 plus 3%, not the 1.08 the authorization may have used.
+	if line.ClearedAmount != 90.64 || line.OriginalAmount != 80 || line.OriginalCurrency != "EUR" || line.FXRate != 1.10 {
+		t.Errorf("converted line = %+v, want 90.64 from 80 EUR at 1.10", line)
+	}
+
+	tests := []struct {
+		name        string
+		ingester    *Ingester
+		rec         Record
+		authorized  string
+		wantDetail  string
+		wantCleared float64
+	}{
+		{"another currency", i, Record{Amount: 80, Currency: "GBP", SettlementDate: rec.SettlementDate}, "EUR", "not the billing currency USD or the transaction currency", 80},
+		{"no rates", NewIngester(nil, "USD", nil), rec, "EUR", "without exchange rates", 80},
+		{"before the rate history", i, Record{Amount: 80, Currency: "EUR", SettlementDate: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)}, "EUR", "exchange rate unavailable", 80},
+	}
+	for _, tt := range tests {
+		line := ReportLine{ClearedAmount: tt.rec.Amount}
+		ok, err := tt.ingester.convert(ctx, tt.rec, tt.authorized, 0, &line)
+		if ok || err != nil || !strings.Contains(line.Detail, tt.wantDetail) || line.ClearedAmount != tt.wantCleared {
+			t.Errorf("%s: convert = %t, %v, %+v; want it rejected with %q", tt.name, ok, err, line, tt.wantDetail)
+		}
+	}
+}
+
+// fakeDB serves the ingester's statements from in-memory authorizations
+// and clearing records. Transactions apply immediately.
+type fakeDB struct {
+	// authorizations are the user ID, status, amount, transaction currency
+	// and markup of each authorization, by transaction ID.
+	authorizations map[string][]driver.Value
+	// records are the file and outcome of each stored clearing record.
+	records  map[string][2]string
+	captures []events.CaptureRequested
+}
+
+func (f *fakeDB) Query(s *dbtest.Session, query string, args []driver.NamedValue) (driver.Rows, error) {
+	id := args[0].Value.(string)
+	switch {
+	case strings.Contains(query, "FROM clearing_records"):
+		if r, ok := f.records[id]; ok {
+			return dbtest.Rows([]driver.Value{r[0], r[1]}), nil
+		}
+		return dbtest.Rows(), nil
+	case strings.Contains(query, "FROM authorizations"):
+		if row, ok := f.authorizations[id]; ok {
+			return dbtest.Rows(row), nil
+		}
+		return dbtest.Rows(), nil
+	}
+	return nil, fmt.Errorf("unexpected query %q", query)
+}
+
+func (f *fakeDB) Exec(s *dbtest.Session, query string, args []driver.NamedValue) (driver.Result, error) {
+	switch {
+	case strings.Contains(query, "INSERT INTO clearing_records"):
+		id := args[0].Value.(string)
+		if r, ok := f.records[id]; ok && r[1] != args[9].Value {
+			return driver.RowsAffected(0), nil
+		}
+		f.records[id] = [2]string{args[1].Value.(string), args[7].Value.(string)}
+	case strings.Contains(query, "INSERT INTO outbox") && args[0].Value == "capture-requested":
+		var ev events.CaptureRequested
+		if err := json.Unmarshal(args[2].Value.([]byte), &ev); err != nil {
+			return nil, err
+		}
+		f.captures = append(f.captures, ev)
+	default:
+		return nil, fmt.Errorf("unexpected statement %q", query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func TestIngest(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	f := &fakeDB{
+		authorizations: map[string][]driver.Value{
+			"tx-matched":  {"user-1", authorization.StatusSucceeded, 50.0, "", 0.0},
+			"tx-adjusted": {"user-1", authorization.StatusSucceeded, 20.0, "", 0.0},
+			"tx-reversed": {"user-2", authorization.StatusReversed, 0.0, "", 0.0},
+			"tx-review":   {"user-2", authorization.StatusPendingReview, 30.0, "", 0.0},
+			"tx-eur":      {"user-3", authorization.StatusSucceeded, 86.4, "EUR", 0.0},
+		},
+		records: map[string][2]string{},
+	}
+	rates, err := fx.NewStaticProvider(map[string]float64{"EUR/USD": 1.10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := NewIngester(dbtest.Open(f), "USD", rates)
+
+	rec := func(id, user string, amount float64, currency string) Record {
+		return Record{TransactionID: id, UserID: user, Amount: amount, Currency: currency, SettlementDate: day}
+	}
+	report, err := i.Ingest(ctx, "day-1.csv", []Record{
+		rec("tx-matched", "", 50, "USD"),
+		rec("tx-adjusted", "", 22.5, "USD"),
+		rec("tx-reversed", "", 10, "USD"),
+		rec("tx-unknown", "user-4", 5, "USD"),
+		rec("tx-review", "", 30, "USD"),
+		rec("tx-eur", "", 80, "EUR"),
+		rec("tx-gbp", "user-4", 5, "GBP"),
+	})
+	if err != nil {
+		t.Fatalf("Ingest: %v", err)
+	}
+
+	var outcomes []string
+	for _, l := range report.Lines {
+		outcomes = append(outcomes, l.TransactionID+":"+l.Outcome)
+	}
+	want := "tx-matched:MATCHED tx-adjusted:ADJUSTED tx-reversed:FORCE_POSTED tx-unknown:FORCE_POSTED " +
+		"tx-review:REJECTED tx-eur:ADJUSTED tx-gbp:REJECTED"
+	if got := strings.Join(outcomes, " "); got != want {
+		t.Errorf("outcomes = %s, want %s", got, want)
+	}
+	// The euro transaction is converted at the settlement date's rate: 80 at
+	// 1.10 is 88.00 against the 86.40 authorized.
+	if eur := report.Lines[5]; eur.ClearedAmount != 88 || eur.OriginalAmount != 80 || eur.FXRate != 1.10 {
+		t.Errorf("euro line = %+v, want 88.00 converted from 80 EUR at 1.10", eur)
+	}
+	if report.SettlementTotal != 175.5 || report.AuthorizedTotal != 156.4 || report.AdjustmentTotal != 4.1 || report.ForcePostedTotal != 15 {
+		t.Errorf("totals = %.2f settled, %.2f authorized, %.2f adjusted, %.2f force-posted; want 175.50, 156.40, 4.10, 15.00",
+			report.SettlementTotal, report.AuthorizedTotal, report.AdjustmentTotal, report.ForcePostedTotal)
+	}
+
+	if len(f.captures) != 5 {
+		t.Fatalf("requested %d captures, want one per posted record: %+v", len(f.captures), f.captures)
+	}
+	if c := f.captures[4]; c.TransactionID != "tx-eur" || c.Amount != 88 || c.TransactionCurrency != "EUR" || c.TransactionAmount != 80 || c.FXRate != 1.10 {
+		t.Errorf("euro capture = %+v, want 88.00 from 80 EUR at 1.10", c)
+	}
+	if c := f.captures[3]; c.UserID != "user-4" || c.Amount != 5 || c.FXRate != 0 {
+		t.Errorf("force-post capture = %+v, want 5.00 for user-4", c)
+	}
+
+	// Once reviewed, the rejected transaction is matched from a corrected
+	// file. The others stay cleared by the first file.
+	f.authorizations["tx-review"][1] = authorization.StatusSucceeded
+	report, err = i.Ingest(ctx, "day-1-corrected.csv", []Record{
+		rec("tx-matched", "", 50, "USD"),
+		rec("tx-review", "", 30, "USD"),
+	})
+	if err != nil {
+		t.Fatalf("Ingest: %v", err)
+	}
+	if report.Counts[OutcomeDuplicate] != 1 || report.Counts[OutcomeMatched] != 1 || report.Lines[0].Detail != "already cleared in day-1.csv" {
+		t.Errorf("corrected file outcomes = %+v, want tx-matched duplicate and tx-review matched", report.Lines)
+	}
+	if len(f.captures) != 6 || f.captures[5].TransactionID != "tx-review" {
+		t.Errorf("captures after the corrected file = %+v, want one more for tx-review", f.captures)
+	}
+	if r := f.records["tx-review"]; r != [2]string{"day-1-corrected.csv", OutcomeMatched} {
+		t.Errorf("tx-review clearing record = %v, want it replaced by the corrected file", r)
+	}
+}
@@ -0,0 +1,17 @@
+-- Every clearing record ingested, with how it was matched. A transaction
+-- clears once, so records already ingested from an earlier file are skipped.
+-- A rejected record is replaced when its transaction is ingested again.
+CREATE TABLE IF NOT EXISTS clearing_records (
+    transaction_id VARCHAR(255) PRIMARY KEY,
+    file_name VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL DEFAULT '',
+    amount NUMERIC(12, 2) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    merchant_id VARCHAR(255),
+    settlement_date DATE NOT NULL,
+    outcome VARCHAR(50) NOT NULL,
+    detail TEXT NOT NULL DEFAULT '',
+    ingested_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_clearing_records_file_name ON clearing_records (file_name);
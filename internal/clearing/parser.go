@@ -0,0 +1,59 @@
+// Package clearing ingests the daily clearing files card networks send to
+// finalize transaction amounts.
+package clearing
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Record is one cleared transaction. Amount is what the network settled,
+// in Currency.
+type Record struct {
+	TransactionID  string
+	UserID         string
+	Amount         float64
+	Currency       string
+	MerchantID     string
+	SettlementDate time.Time
+	// Line is where the record appeared in its file, for error messages.
+	Line int
+}
+
+// Parser reads the records of one clearing file format.
+type Parser interface {
+	Parse(r io.Reader) ([]Record, error)
+}
+
+// ParserFactory builds a parser for a format.
+type ParserFactory func() Parser
+
+var formats = map[string]ParserFactory{}
+
+// RegisterFormat makes a clearing file format available to NewParser. It is
+// meant to be called from init functions.
+func RegisterFormat(name string, factory ParserFactory) {
+	formats[name] = factory
+}
+
+// NewParser returns a parser for the named format.
+func NewParser(format string) (Parser, error) {
+	factory, ok := formats[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown clearing file format %q (known: %s)", format, strings.Join(Formats(), ", "))
+	}
+	return factory(), nil
+}
+
+// Formats lists the registered format names.
+func Formats() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
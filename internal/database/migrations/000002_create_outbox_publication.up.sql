@@ -0,0 +1,9 @@
+-- The replication relay decodes outbox inserts from this publication.
+-- Polling relays ignore it; it costs nothing until a slot subscribes.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_publication WHERE pubname = 'outbox_publication') THEN
+        CREATE PUBLICATION outbox_publication FOR TABLE outbox WITH (publish = 'insert');
+    END IF;
+END
+$$;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS outbox_dead;
+DROP INDEX IF EXISTS idx_outbox_unpublished_key;
+ALTER TABLE outbox
+    DROP COLUMN IF EXISTS next_attempt_at,
+    DROP COLUMN IF EXISTS last_error,
+    DROP COLUMN IF EXISTS attempts;
@@ -0,0 +1,20 @@
+-- Messages that fail to publish are retried with backoff. After too many
+-- failures they are moved to outbox_dead so the rest of the queue flows.
+ALTER TABLE outbox
+    ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS last_error TEXT,
+    ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+-- Finds earlier unpublished messages for a key, which hold back later ones.
+CREATE INDEX IF NOT EXISTS idx_outbox_unpublished_key ON outbox (key, id) WHERE published_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS outbox_dead (
+    id BIGINT PRIMARY KEY,
+    topic VARCHAR(255) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    payload BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    attempts INT NOT NULL,
+    last_error TEXT,
+    quarantined_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
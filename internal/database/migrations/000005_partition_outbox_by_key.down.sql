@@ -0,0 +1,31 @@
+ALTER TABLE outbox RENAME TO outbox_partitioned;
+ALTER TABLE outbox_partitioned RENAME CONSTRAINT outbox_pkey TO outbox_partitioned_pkey;
+DROP INDEX IF EXISTS idx_outbox_id;
+DROP INDEX IF EXISTS idx_outbox_unpublished;
+DROP INDEX IF EXISTS idx_outbox_published_at;
+DROP INDEX IF EXISTS idx_outbox_unpublished_key;
+
+CREATE TABLE outbox (
+    id BIGINT PRIMARY KEY DEFAULT nextval('outbox_id_seq'),
+    topic VARCHAR(255) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    payload BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    published_at TIMESTAMPTZ,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_outbox_unpublished ON outbox (created_at) WHERE published_at IS NULL;
+CREATE INDEX idx_outbox_published_at ON outbox (published_at) WHERE published_at IS NOT NULL;
+CREATE INDEX idx_outbox_unpublished_key ON outbox (key, id) WHERE published_at IS NULL;
+
+INSERT INTO outbox (id, topic, key, payload, created_at, published_at, attempts, last_error, next_attempt_at)
+SELECT id, topic, key, payload, created_at, published_at, attempts, last_error, next_attempt_at FROM outbox_partitioned;
+
+ALTER SEQUENCE outbox_id_seq OWNED BY outbox.id;
+DROP TABLE outbox_partitioned;
+
+ALTER PUBLICATION outbox_publication SET (publish_via_partition_root = false);
+ALTER PUBLICATION outbox_publication ADD TABLE outbox;
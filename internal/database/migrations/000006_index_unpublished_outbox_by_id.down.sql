@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_outbox_unpublished ON outbox (created_at) WHERE published_at IS NULL;
+DROP INDEX IF EXISTS idx_outbox_unpublished_id;
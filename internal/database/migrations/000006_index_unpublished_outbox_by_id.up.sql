@@ -0,0 +1,3 @@
+-- The relay takes unpublished messages in ID order.
+CREATE INDEX IF NOT EXISTS idx_outbox_unpublished_id ON outbox (id) WHERE published_at IS NULL;
+DROP INDEX IF EXISTS idx_outbox_unpublished;
@@ -24,8 +24,11 @@ func NewPostgres(url string) (*sql.DB, error) {
 	return db, nil
 }
 
-func RunMigrations(db *sql.DB, migrationsPath string) {
-	driver, err := postgres.WithInstance(db, &postgres.Config{})
+// RunMigrations applies the migrations in migrationsPath. Each set of
+// migrations tracks its version in its own migrationsTable, since several
+// services share one database.
+func RunMigrations(db *sql.DB, migrationsPath, migrationsTable string) {
+	driver, err := postgres.WithInstance(db, &postgres.Config{MigrationsTable: migrationsTable})
 	if err != nil {
 		log.Fatalf("could not create migration driver: %v", err)
 	}
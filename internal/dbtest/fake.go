@@ -0,0 +1,157 @@
+// Package dbtest helps test code that talks to Postgres through
+// database/sql. Open serves a package's statements from an in-memory fake,
+// for tests of a service's logic. Postgres runs the real migrations and
+// queries against a database named by TEST_POSTGRES_URL, for tests of the
+// SQL itself.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+// Session is one connection to a fake database, as a backend is in
+// Postgres.
+type Session struct {
+	// ID is the session's backend PID. The first session is 1.
+	ID   int64
+	dead int32
+}
+
+// Kill ends the session, as pg_terminate_backend would. Its statements then
+// fail with driver.ErrBadConn, so database/sql discards the connection.
+func (s *Session) Kill() { atomic.StoreInt32(&s.dead, 1) }
+
+func (s *Session) killed() bool { return atomic.LoadInt32(&s.dead) == 1 }
+
+// Handler serves the statements a fake database receives, with the session
+// each came from. Statements are matched on their text, so a handler only
+// needs to know the statements of the code under test.
+type Handler interface {
+	Query(s *Session, query string, args []driver.NamedValue) (driver.Rows, error)
+	Exec(s *Session, query string, args []driver.NamedValue) (driver.Result, error)
+}
+
+// TxHandler is implemented by handlers that follow transactions. Without
+// it, statements apply immediately and rolling back undoes nothing.
+type TxHandler interface {
+	Begin(s *Session, opts driver.TxOptions) error
+	Commit(s *Session) error
+	Rollback(s *Session) error
+}
+
+// SessionCloser is implemented by handlers that clean up after a session,
+// such as by releasing its advisory locks.
+type SessionCloser interface {
+	Close(s *Session)
+}
+
+// Open returns a database whose statements are served by h.
+func Open(h Handler) *sql.DB {
+	return sql.OpenDB(&connector{h: h})
+}
+
+type connector struct {
+	h        Handler
+	sessions int64
+}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{h: c.h, s: &Session{ID: atomic.AddInt64(&c.sessions, 1)}}, nil
+}
+
+func (c *connector) Driver() driver.Driver { return nil }
+
+type conn struct {
+	h Handler
+	s *Session
+}
+
+func (c *conn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+
+func (c *conn) Close() error {
+	if closer, ok := c.h.(SessionCloser); ok {
+		closer.Close(c.s)
+	}
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if c.s.killed() {
+		return nil, driver.ErrBadConn
+	}
+	if txh, ok := c.h.(TxHandler); ok {
+		if err := txh.Begin(c.s, opts); err != nil {
+			return nil, err
+		}
+	}
+	return tx{c}, nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.s.killed() {
+		return nil, driver.ErrBadConn
+	}
+	return c.h.Query(c.s, query, args)
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.s.killed() {
+		return nil, driver.ErrBadConn
+	}
+	return c.h.Exec(c.s, query, args)
+}
+
+type tx struct{ c *conn }
+
+func (t tx) Commit() error {
+	if t.c.s.killed() {
+		return driver.ErrBadConn
+	}
+	if txh, ok := t.c.h.(TxHandler); ok {
+		return txh.Commit(t.c.s)
+	}
+	return nil
+}
+
+func (t tx) Rollback() error {
+	if txh, ok := t.c.h.(TxHandler); ok {
+		return txh.Rollback(t.c.s)
+	}
+	return nil
+}
+
+// Rows returns a query result with one slice of values per row.
+func Rows(values ...[]driver.Value) driver.Rows {
+	return &rows{values: values}
+}
+
+type rows struct {
+	values [][]driver.Value
+}
+
+func (r *rows) Columns() []string {
+	if len(r.values) == 0 {
+		return []string{"result"}
+	}
+	return make([]string, len(r.values[0]))
+}
+
+func (r *rows) Close() error { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
@@ -0,0 +1,120 @@
+package dbtest
+
+import (
+	"database/sql/driver"
+	"strings"
+	"sync"
+)
+
+// Locks is a fake of Postgres's session-level advisory locks, enough for the
+// leader package. Locks are named by the second argument of each statement,
+// the job name that the leader package hashes. A handler passes advisory
+// lock statements to Query and Exec, and closed sessions to Close.
+type Locks struct {
+	mu   sync.Mutex
+	held map[string]*Session
+}
+
+// Query serves pg_try_advisory_lock, which also returns the session's PID,
+// and the pg_locks lookup of whether the session with the PID in the third
+// argument, or s if it is 0, holds a lock. It reports false if query is
+// neither.
+func (l *Locks) Query(s *Session, query string, args []driver.NamedValue) (driver.Rows, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case strings.HasPrefix(query, "SELECT pg_try_advisory_lock"):
+		name := args[1].Value.(string)
+		locked := l.held[name] == nil || l.held[name] == s
+		if locked {
+			l.take(name, s)
+		}
+		return Rows([]driver.Value{locked, s.ID}), true
+	case strings.Contains(query, "FROM pg_locks"):
+		pid := args[2].Value.(int64)
+		if pid == 0 {
+			pid = s.ID
+		}
+		holder := l.held[args[1].Value.(string)]
+		return Rows([]driver.Value{holder != nil && holder.ID == pid}), true
+	}
+	return nil, false
+}
+
+// Exec serves pg_advisory_unlock. It reports false if query is not
+// pg_advisory_unlock.
+func (l *Locks) Exec(s *Session, query string, args []driver.NamedValue) (driver.Result, bool) {
+	if !strings.HasPrefix(query, "SELECT pg_advisory_unlock") {
+		return nil, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if name := args[1].Value.(string); l.held[name] == s {
+		delete(l.held, name)
+	}
+	return driver.RowsAffected(1), true
+}
+
+// Close releases the locks s holds.
+func (l *Locks) Close(s *Session) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for name, holder := range l.held {
+		if holder == s {
+			delete(l.held, name)
+		}
+	}
+}
+
+// Holder returns the session holding name's lock, or nil.
+func (l *Locks) Holder(name string) *Session {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.held[name]
+}
+
+// Held returns the names of the locks that are held.
+func (l *Locks) Held() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var names []string
+	for name := range l.held {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Take gives name's lock to s, as if another process had taken it.
+func (l *Locks) Take(name string, s *Session) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.take(name, s)
+}
+
+func (l *Locks) take(name string, s *Session) {
+	if l.held == nil {
+		l.held = map[string]*Session{}
+	}
+	l.held[name] = s
+}
+
+// Release frees name's lock, as if its holder had unlocked it.
+func (l *Locks) Release(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.held, name)
+}
+
+// Terminate kills the session holding name's lock, which releases it.
+func (l *Locks) Terminate(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s := l.held[name]; s != nil {
+		s.Kill()
+		for other, holder := range l.held {
+			if holder == s {
+				delete(l.held, other)
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Package fx converts amounts between currencies for cardholders who
+// transact in a currency other than the one they are billed in.
+package fx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrRateUnavailable is returned when a provider has no rate for a pair.
+var ErrRateUnavailable = errors.New("exchange rate unavailable")
+
+// Rate is how many units of To one unit of From buys.
+type Rate struct {
+	From string
+	To   string
+	Rate float64
+	AsOf time.Time
+}
+
+// RateProvider supplies exchange rates.
+type RateProvider interface {
+	Rate(ctx context.Context, from, to string) (Rate, error)
+}
+
+// HistoricalProvider also supplies the rates that were in effect on a past
+// day, e.g. a transaction's settlement date.
+type HistoricalProvider interface {
+	RateProvider
+	RateOn(ctx context.Context, from, to string, day time.Time) (Rate, error)
+}
+
+// StaticProvider serves a fixed table of rates. Each pair is also served
+// inverted, so only one direction needs listing.
+type StaticProvider struct {
+	rates map[string]float64
+	asOf  time.Time
+}
+
+// NewStaticProvider serves rates keyed by pair, e.g. "EUR/USD": 1.08 for
+// one euro buying 1.08 dollars.
+func NewStaticProvider(rates map[string]float64) (*StaticProvider, error) {
+	p := &StaticProvider{rates: map[string]float64{}, asOf: time.Now()}
+	for pair, rate := range rates {
+		from, to, ok := strings.Cut(strings.ToUpper(pair), "/")
+		if !ok || len(from) != 3 || len(to) != 3 {
+			return nil, fmt.Errorf("invalid currency pair %q", pair)
+		}
+		if rate <= 0 {
+			return nil, fmt.Errorf("rate for %s must be positive", pair)
+		}
+		p.rates[from+"/"+to] = rate
+	}
+	return p, nil
+}
+
+// LoadStaticProvider reads a JSON object of pair to rate from path.
+func LoadStaticProvider(path string) (*StaticProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rates map[string]float64
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return NewStaticProvider(rates)
+}
+
+// LoadRateProvider reads a rates file in either form: a JSON object of pair
+// to rate, served by a StaticProvider, or one of such objects keyed by the
+// day they take effect, served by a RateHistory.
+func LoadRateProvider(path string) (RateProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rates map[string]float64
+	if err := json.Unmarshal(data, &rates); err == nil {
+		return NewStaticProvider(rates)
+	}
+	var days map[string]map[string]float64
+	if err := json.Unmarshal(data, &days); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return NewRateHistory(days)
+}
+
+// Rate returns the rate for from/to, or the inverse of to/from.
+func (p *StaticProvider) Rate(ctx context.Context, from, to string) (Rate, error) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+	r := Rate{From: from, To: to, AsOf: p.asOf}
+	switch {
+	case from == to:
+		r.Rate = 1
+	case p.rates[from+"/"+to] > 0:
+		r.Rate = p.rates[from+"/"+to]
+	case p.rates[to+"/"+from] > 0:
+		r.Rate = 1 / p.rates[to+"/"+from]
+	default:
+		return r, fmt.Errorf("%w for %s/%s", ErrRateUnavailable, from, to)
+	}
+	return r, nil
+}
+
+// RateHistory serves the rates in effect on each day from a table of rates
+// per effective day. A day's rates apply until the next listed day.
+type RateHistory struct {
+	days      []time.Time
+	providers []*StaticProvider
+}
+
+// NewRateHistory serves rates keyed by the day they take effect, e.g.
+// "2024-03-01": {"EUR/USD": 1.08}.
+func NewRateHistory(days map[string]map[string]float64) (*RateHistory, error) {
+	if len(days) == 0 {
+		return nil, errors.New("rate history has no days")
+	}
+	h := &RateHistory{}
+	for day := range days {
+		d, err := time.Parse("2006-01-02", day)
+		if err != nil {
+			return nil, fmt.Errorf("invalid effective day %q", day)
+		}
+		h.days = append(h.days, d)
+	}
+	sort.Slice(h.days, func(i, j int) bool { return h.days[i].Before(h.days[j]) })
+	for _, d := range h.days {
+		p, err := NewStaticProvider(days[d.Format("2006-01-02")])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", d.Format("2006-01-02"), err)
+		}
+		p.asOf = d
+		h.providers = append(h.providers, p)
+	}
+	return h, nil
+}
+
+// Rate returns the latest rate for from/to.
+func (h *RateHistory) Rate(ctx context.Context, from, to string) (Rate, error) {
+	return h.providers[len(h.providers)-1].Rate(ctx, from, to)
+}
+
+// RateOn returns the rate for from/to in effect on day. There is none
+// before the first listed day.
+func (h *RateHistory) RateOn(ctx context.Context, from, to string, day time.Time) (Rate, error) {
+	// The first effective day after day; the one before it applies.
+	i := sort.Search(len(h.days), func(i int) bool { return h.days[i].After(day) })
+	if i == 0 {
+		return Rate{From: from, To: to}, fmt.Errorf("%w for %s/%s on %s", ErrRateUnavailable, from, to, day.Format("2006-01-02"))
+	}
+	return h.providers[i-1].Rate(ctx, from, to)
+}
+
+// Conversion records how a transaction amount became a billing amount.
+type Conversion struct {
+	TransactionCurrency string
+	TransactionAmount   float64
+	BillingCurrency     string
+	BillingAmount       float64
+	// Rate is the provider's rate; Markup the fraction added on top of it,
+	// e.g. 0.03 for 3%.
+	Rate   float64
+	Markup float64
+}
+
+// Foreign reports whether the transaction was in another currency.
+func (c Conversion) Foreign() bool {
+	return c.TransactionCurrency != c.BillingCurrency
+}
+
+// Convert turns amount in from into to at the provider's rate plus markup,
+// rounded to cents. No markup is charged when the currencies match. On error
+// the returned Conversion still names both currencies but has no rate.
+func Convert(ctx context.Context, p RateProvider, amount float64, from, to string, markup float64) (Conversion, error) {
+	return convert(amount, from, to, markup, func(from, to string) (Rate, error) {
+		return p.Rate(ctx, from, to)
+	})
+}
+
+// ConvertOn is Convert at the rate in effect on day. Providers without a
+// history convert at their current rate.
+func ConvertOn(ctx context.Context, p RateProvider, amount float64, from, to string, markup float64, day time.Time) (Conversion, error) {
+	h, ok := p.(HistoricalProvider)
+	if !ok {
+		return Convert(ctx, p, amount, from, to, markup)
+	}
+	return convert(amount, from, to, markup, func(from, to string) (Rate, error) {
+		return h.RateOn(ctx, from, to, day)
+	})
+}
+
+func convert(amount float64, from, to string, markup float64, rate func(from, to string) (Rate, error)) (Conversion, error) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+	c := Conversion{TransactionCurrency: from, TransactionAmount: amount, BillingCurrency: to}
+	if from == to {
+		c.BillingAmount, c.Rate = amount, 1
+		return c, nil
+	}
+
+	r, err := rate(from, to)
+	if err != nil {
+		return c, err
+	}
+	c.Rate, c.Markup = r.Rate, markup
+	c.BillingAmount = math.Round(amount*r.Rate*(1+markup)*100) / 100
+	return c, nil
+}
@@ -0,0 +1,126 @@
+package fx
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConvert(t *testing.T) {
+	ctx := context.Background()
+	p, err := NewStaticProvider(map[string]float64{"EUR/USD": 1.08, "usd/jpy": 150})
+	if err != nil {
+		t.Fatalf("NewStaticProvider: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		amount   float64
+		from, to string
+		markup   float64
+		want     float64
+		rate     float64
+	}{
+		{"direct pair with markup", 100, "EUR", "USD", 0.03, 111.24, 1.08},
+		{"inverted pair", 1500, "JPY", "USD", 0, 10, 1.0 / 150},
+		{"lower case codes", 10, "eur", "usd", 0, 10.8, 1.08},
+		{"same currency skips markup", 42.5, "USD", "USD", 0.03, 42.5, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Convert(ctx, p, tt.amount, tt.from, tt.to, tt.markup)
+			if err != nil {
+				t.Fatalf("Convert: %v", err)
+			}
+			if c.BillingAmount != tt.want || c.Rate != tt.rate {
+				t.Errorf("Convert = %.2f at %v, want %.2f at %v", c.BillingAmount, c.Rate, tt.want, tt.rate)
+			}
+		})
+	}
+
+	if c, _ := Convert(ctx, p, 1, "USD", "USD", 0.03); c.Foreign() || c.Markup != 0 {
+		t.Errorf("same-currency conversion = %+v, want no markup and not foreign", c)
+	}
+	if _, err := Convert(ctx, p, 1, "GBP", "USD", 0); !errors.Is(err, ErrRateUnavailable) {
+		t.Errorf("unknown pair error = %v, want ErrRateUnavailable", err)
+	}
+}
+
+func TestNewStaticProviderRejectsBadRates(t *testing.T) {
+	for _, rates := range []map[string]float64{
+		{"EURUSD": 1.08},
+		{"EUR/USD": 0},
+		{"EURO/USD": 1.08},
+	} {
+		if _, err := NewStaticProvider(rates); err == nil {
+			t.Errorf("NewStaticProvider(%v) succeeded, want error", rates)
+		}
+	}
+}
+
+func TestConvertOn(t *testing.T) {
+	ctx := context.Background()
+	h, err := NewRateHistory(map[string]map[string]float64{
+		"2024-03-01": {"EUR/USD": 1.08},
+		"2024-03-04": {"EUR/USD": 1.10},
+	})
+	if err != nil {
+		t.Fatalf("NewRateHistory: %v", err)
+	}
+
+	tests := []struct {
+		day  time.Time
+		want float64
+	}{
+		{time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), 108},
+		{time.Date(2024, 3, 3, 23, 0, 0, 0, time.UTC), 108},
+		{time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), 110},
+		{time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), 110},
+	}
+	for _, tt := range tests {
+		c, err := ConvertOn(ctx, h, 100, "EUR", "USD", 0, tt.day)
+		if err != nil || c.BillingAmount != tt.want {
+			t.Errorf("ConvertOn(%s) = %.2f, %v; want %.2f", tt.day.Format("2006-01-02"), c.BillingAmount, err, tt.want)
+		}
+	}
+	if _, err := ConvertOn(ctx, h, 100, "EUR", "USD", 0, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)); !errors.Is(err, ErrRateUnavailable) {
+		t.Errorf("conversion before the history error = %v, want ErrRateUnavailable", err)
+	}
+	if c, _ := Convert(ctx, h, 100, "USD", "EUR", 0); c.BillingAmount != 90.91 {
+		t.Errorf("Convert at the latest rate = %.2f, want 90.91", c.BillingAmount)
+	}
+
+	// Providers without a history convert at their current rate.
+	p, _ := NewStaticProvider(map[string]float64{"EUR/USD": 1.2})
+	if c, _ := ConvertOn(ctx, p, 100, "EUR", "USD", 0, time.Time{}); c.BillingAmount != 120 {
+		t.Errorf("ConvertOn with a static provider = %.2f, want 120", c.BillingAmount)
+	}
+}
+
+func TestLoadRateProvider(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	if p, err := LoadRateProvider(write("static.json", `{"EUR/USD": 1.08}`)); err != nil {
+		t.Errorf("loading static rates: %v", err)
+	} else if _, ok := p.(*StaticProvider); !ok {
+		t.Errorf("static rates loaded as %T", p)
+	}
+	if p, err := LoadRateProvider(write("history.json", `{"2024-03-01": {"EUR/USD": 1.08}}`)); err != nil {
+		t.Errorf("loading a rate history: %v", err)
+	} else if _, ok := p.(HistoricalProvider); !ok {
+		t.Errorf("rate history loaded as %T", p)
+	}
+	if _, err := LoadRateProvider(write("bad.json", `{"March": {"EUR/USD": 1.08}}`)); err == nil {
+		t.Error("LoadRateProvider accepted an invalid effective day")
+	}
+}
@@ -0,0 +1,253 @@
+// Package iso8583 encodes and decodes ISO 8583 financial messages with
+// ASCII data elements and binary bitmaps, framed on the wire by a two-byte
+// big-endian length header.
+package iso8583
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Message type indicators handled by the acquirer adapter.
+const (
+	MTIAuthorizationRequest  = "0100"
+	MTIAuthorizationResponse = "0110"
+	MTIReversalRequest       = "0400"
+	MTIReversalResponse      = "0410"
+)
+
+// maxFrameLength is the largest message the two-byte header can describe.
+const maxFrameLength = 1<<16 - 1
+
+// Message is an ISO 8583 message: a message type indicator and the data
+// elements present, keyed by field number.
+type Message struct {
+	MTI    string
+	fields map[int]string
+}
+
+// NewMessage returns an empty message of type mti.
+func NewMessage(mti string) *Message {
+	return &Message{MTI: mti, fields: map[int]string{}}
+}
+
+// Set stores a data element. Values are validated when the message is
+// encoded.
+func (m *Message) Set(field int, value string) {
+	m.fields[field] = value
+}
+
+// Get returns a data element and whether it is present.
+func (m *Message) Get(field int) (string, bool) {
+	v, ok := m.fields[field]
+	return v, ok
+}
+
+// Fields lists the data elements present, in ascending order.
+func (m *Message) Fields() []int {
+	fields := make([]int, 0, len(m.fields))
+	for f := range m.fields {
+		fields = append(fields, f)
+	}
+	sort.Ints(fields)
+	return fields
+}
+
+// Response builds the response to m: the MTI's function digit is moved on
+// from request to response and the given fields are copied across.
+func (m *Message) Response(echo ...int) *Message {
+	mti := []byte(m.MTI)
+	if len(mti) == 4 {
+		mti[2]++
+	}
+	resp := NewMessage(string(mti))
+	for _, f := range echo {
+		if v, ok := m.fields[f]; ok {
+			resp.fields[f] = v
+		}
+	}
+	return resp
+}
+
+// Encode renders the message: MTI, primary bitmap, secondary bitmap when any
+// field above 64 is present, then each data element in order.
+func (m *Message) Encode() ([]byte, error) {
+	if len(m.MTI) != 4 || !isDigits(m.MTI) {
+		return nil, fmt.Errorf("invalid MTI %q", m.MTI)
+	}
+
+	fields := m.Fields()
+	bitmap := make([]byte, 8)
+	if len(fields) > 0 && fields[len(fields)-1] > 64 {
+		bitmap = make([]byte, 16)
+		bitmap[0] |= 0x80
+	}
+	for _, f := range fields {
+		if f < 2 || f > 128 {
+			return nil, fmt.Errorf("field %d cannot be set", f)
+		}
+		bitmap[(f-1)/8] |= 0x80 >> ((f - 1) % 8)
+	}
+
+	out := append([]byte(m.MTI), bitmap...)
+	for _, f := range fields {
+		spec, ok := Spec[f]
+		if !ok {
+			return nil, fmt.Errorf("field %d has no specification", f)
+		}
+		encoded, err := encodeField(spec, m.fields[f])
+		if err != nil {
+			return nil, fmt.Errorf("field %d (%s): %w", f, spec.Name, err)
+		}
+		out = append(out, encoded...)
+	}
+	return out, nil
+}
+
+func encodeField(spec FieldSpec, value string) (string, error) {
+	if spec.Type == Numeric && !isDigits(value) {
+		return "", fmt.Errorf("value %q is not numeric", value)
+	}
+	if spec.Type == AlphaNumericSpecial && !isPrintable(value) {
+		return "", fmt.Errorf("value %q has non-printable characters", value)
+	}
+	if len(value) > spec.Length {
+		return "", fmt.Errorf("value is %d characters, more than %d", len(value), spec.Length)
+	}
+
+	if spec.LengthDigits > 0 {
+		return fmt.Sprintf("%0*d%s", spec.LengthDigits, len(value), value), nil
+	}
+	if spec.Type == Numeric {
+		return strings.Repeat("0", spec.Length-len(value)) + value, nil
+	}
+	return value + strings.Repeat(" ", spec.Length-len(value)), nil
+}
+
+// Decode parses an encoded message. Trailing spaces are removed from
+// fixed-length alphanumeric fields; numeric fields keep their zero padding.
+// On error the message still holds the MTI and the fields decoded before
+// the error, so the sender can be answered. It is nil if the MTI or the
+// primary bitmap cannot be read.
+func Decode(data []byte) (*Message, error) {
+	if len(data) < 12 {
+		return nil, errors.New("message too short for MTI and bitmap")
+	}
+	m := NewMessage(string(data[:4]))
+	if !isDigits(m.MTI) {
+		return nil, fmt.Errorf("invalid MTI %q", m.MTI)
+	}
+
+	bitmap := data[4:12]
+	pos := 12
+	if bitmap[0]&0x80 != 0 {
+		if len(data) < 20 {
+			return m, errors.New("message too short for secondary bitmap")
+		}
+		bitmap = data[4:20]
+		pos = 20
+	}
+
+	for f := 2; f <= len(bitmap)*8; f++ {
+		if bitmap[(f-1)/8]&(0x80>>((f-1)%8)) == 0 {
+			continue
+		}
+		spec, ok := Spec[f]
+		if !ok {
+			return m, fmt.Errorf("field %d has no specification", f)
+		}
+
+		length := spec.Length
+		if spec.LengthDigits > 0 {
+			if pos+spec.LengthDigits > len(data) {
+				return m, fmt.Errorf("field %d: truncated length", f)
+			}
+			n, err := strconv.Atoi(string(data[pos : pos+spec.LengthDigits]))
+			if err != nil || n > spec.Length {
+				return m, fmt.Errorf("field %d: invalid length %q", f, data[pos:pos+spec.LengthDigits])
+			}
+			length = n
+			pos += spec.LengthDigits
+		}
+		if pos+length > len(data) {
+			return m, fmt.Errorf("field %d: truncated value", f)
+		}
+
+		value := string(data[pos : pos+length])
+		pos += length
+		if spec.Type == Numeric && !isDigits(value) {
+			return m, fmt.Errorf("field %d: value %q is not numeric", f, value)
+		}
+		if spec.Type == AlphaNumericSpecial && spec.LengthDigits == 0 {
+			value = strings.TrimRight(value, " ")
+		}
+		m.fields[f] = value
+	}
+
+	if pos != len(data) {
+		return m, fmt.Errorf("%d unexpected bytes after the last field", len(data)-pos)
+	}
+	return m, nil
+}
+
+// ReadFrame reads the body of one length-framed message from r. Errors
+// come only from r, so a frame that fails to decode leaves the stream
+// positioned at the next message.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint16(header[:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ReadMessage reads and decodes one length-framed message from r.
+func ReadMessage(r io.Reader) (*Message, error) {
+	data, err := ReadFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(data)
+}
+
+// WriteMessage encodes m and writes it to w with its length header.
+func WriteMessage(w io.Writer, m *Message) error {
+	data, err := m.Encode()
+	if err != nil {
+		return err
+	}
+	if len(data) > maxFrameLength {
+		return fmt.Errorf("message of %d bytes is too long to frame", len(data))
+	}
+	frame := make([]byte, 2, 2+len(data))
+	binary.BigEndian.PutUint16(frame, uint16(len(data)))
+	_, err = w.Write(append(frame, data...))
+	return err
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isPrintable(s string) bool {
+	for _, c := range s {
+		if c < ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
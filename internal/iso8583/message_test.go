@@ -0,0 +1,179 @@
+package iso8583
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func authorizationRequest() *Message {
+	m := NewMessage(MTIAuthorizationRequest)
+	m.Set(FieldPAN, "tok_4111abcd")
+	m.Set(FieldProcessingCode, "000000")
+	m.Set(FieldAmount, "000000012550")
+	m.Set(FieldTransmissionDateTime, "0301120000")
+	m.Set(FieldSTAN, "000123")
+	m.Set(FieldMCC, "5411")
+	m.Set(FieldAcquirerCountry, "840")
+	m.Set(FieldAcquiringInstitutionID, "123456")
+	m.Set(FieldRRN, "406112000123")
+	m.Set(FieldTerminalID, "TERM01")
+	m.Set(FieldMerchantID, "MERCHANT-42")
+	m.Set(FieldCurrencyCode, "840")
+	return m
+}
+
+func TestRoundTrip(t *testing.T) {
+	reversal := NewMessage(MTIReversalRequest)
+	reversal.Set(FieldPAN, "tok_4111abcd")
+	reversal.Set(FieldAmount, "000000012550")
+	reversal.Set(FieldSTAN, "000124")
+	reversal.Set(FieldRRN, "406112000123")
+	reversal.Set(FieldCurrencyCode, "840")
+	reversal.Set(FieldOriginalDataElements, "010000012303011200000000012345600000000000")
+	reversal.Set(FieldAccountIdentification, "acct-1")
+
+	for _, m := range []*Message{authorizationRequest(), reversal} {
+		t.Run(m.MTI, func(t *testing.T) {
+			data, err := m.Encode()
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			got, err := Decode(data)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if got.MTI != m.MTI || !reflect.DeepEqual(got.fields, m.fields) {
+				t.Errorf("Decode(Encode(m)) = %s %v, want %s %v", got.MTI, got.fields, m.MTI, m.fields)
+			}
+		})
+	}
+}
+
+func TestEncodeLayout(t *testing.T) {
+	m := NewMessage(MTIAuthorizationResponse)
+	m.Set(FieldAmount, "1050")
+	m.Set(FieldSTAN, "123")
+	m.Set(FieldResponseCode, "0")
+	m.Set(FieldPAN, "tok")
+
+	data, err := m.Encode()
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	// Bits 2, 4, 11 and 39 of the primary bitmap.
+	want := "0110" + "\x50\x20\x00\x00\x02\x00\x00\x00" + "03tok" + "000000001050" + "000123" + "0 "
+	if string(data) != want {
+		t.Errorf("Encode = %q, want %q", data, want)
+	}
+
+	m.Set(FieldOriginalDataElements, "1")
+	data, err = m.Encode()
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if data[4]&0x80 == 0 || len(data) != len(want)+8+42 {
+		t.Errorf("Encode with field 90 = %q, want a secondary bitmap", data)
+	}
+	if data[4+11]&0x40 == 0 {
+		t.Errorf("secondary bitmap %x does not mark field 90", data[12:20])
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		field int
+		value string
+		want  string
+	}{
+		{"non-numeric amount", FieldAmount, "12.50", "not numeric"},
+		{"amount too long", FieldAmount, "1234567890123", "more than 12"},
+		{"control characters", FieldMerchantID, "M\n1", "non-printable"},
+		{"unknown field", 64, "x", "no specification"},
+		{"bitmap field", 1, "x", "cannot be set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMessage(MTIAuthorizationRequest)
+			m.Set(tt.field, tt.value)
+			_, err := m.Encode()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Encode error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewMessage("01A0").Encode(); err == nil {
+		t.Error("Encode accepted a non-numeric MTI")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid, err := authorizationRequest().Encode()
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"too short", valid[:10], "too short"},
+		{"truncated", valid[:len(valid)-3], "truncated"},
+		{"trailing bytes", append(append([]byte{}, valid...), "xx"...), "unexpected bytes"},
+		{"bad MTI", append([]byte("AB00"), valid[4:]...), "invalid MTI"},
+		{"unknown field", []byte("0100\x00\x00\x00\x00\x00\x00\x00\x01"), "no specification"},
+		{"bad length", []byte("0100\x40\x00\x00\x00\x00\x00\x00\x0099tok"), "invalid length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Decode error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+
+	// A message that fails after its MTI keeps the fields read so far, so
+	// the sender can still be answered.
+	m, err := Decode(valid[:len(valid)-3])
+	if err == nil || m == nil || m.MTI != MTIAuthorizationRequest {
+		t.Fatalf("Decode of a truncated message = %v, %v; want the partial message", m, err)
+	}
+	if pan, _ := m.Get(FieldPAN); pan == "" {
+		t.Errorf("partial message has fields %v, want the PAN read before the truncation", m.Fields())
+	}
+}
+
+func TestFraming(t *testing.T) {
+	var buf bytes.Buffer
+	first := authorizationRequest()
+	second := first.Response(FieldSTAN, FieldRRN, FieldAmount)
+	second.Set(FieldResponseCode, "00")
+	for _, m := range []*Message{first, second} {
+		if err := WriteMessage(&buf, m); err != nil {
+			t.Fatalf("WriteMessage: %v", err)
+		}
+	}
+
+	got, err := ReadMessage(&buf)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if !reflect.DeepEqual(got, first) {
+		t.Errorf("first message = %+v, want %+v", got, first)
+	}
+	got, err = ReadMessage(&buf)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if got.MTI != MTIAuthorizationResponse || len(got.Fields()) != 4 {
+		t.Errorf("second message = %s %v, want a 0110 with four fields", got.MTI, got.Fields())
+	}
+	if _, err := ReadMessage(&buf); err == nil {
+		t.Error("ReadMessage returned a message from an empty stream")
+	}
+}
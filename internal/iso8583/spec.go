@@ -0,0 +1,70 @@
+package iso8583
+
+// Data element types.
+const (
+	// Numeric fields hold digits only. Fixed-length numeric values are
+	// left-padded with zeros.
+	Numeric = "n"
+	// AlphaNumericSpecial fields hold printable characters. Fixed-length
+	// values are right-padded with spaces.
+	AlphaNumericSpecial = "ans"
+)
+
+// FieldSpec describes how a data element is encoded. Fields with
+// LengthDigits of zero are fixed at Length; otherwise Length is the maximum
+// and the value is prefixed with its length in LengthDigits ASCII digits
+// (LLVAR or LLLVAR).
+type FieldSpec struct {
+	Name         string
+	Type         string
+	Length       int
+	LengthDigits int
+}
+
+// Data elements used by authorization and reversal messages.
+const (
+	FieldPAN                    = 2
+	FieldProcessingCode         = 3
+	FieldAmount                 = 4
+	FieldTransmissionDateTime   = 7
+	FieldSTAN                   = 11
+	FieldLocalTime              = 12
+	FieldLocalDate              = 13
+	FieldMCC                    = 18
+	FieldAcquirerCountry        = 19
+	FieldAcquiringInstitutionID = 32
+	FieldRRN                    = 37
+	FieldAuthorizationID        = 38
+	FieldResponseCode           = 39
+	FieldTerminalID             = 41
+	FieldMerchantID             = 42
+	FieldMerchantNameLocation   = 43
+	FieldCurrencyCode           = 49
+	FieldOriginalDataElements   = 90
+	FieldAccountIdentification  = 102
+)
+
+// Spec holds the fields this package can encode, following ISO 8583:1987
+// with ASCII data elements and binary bitmaps. Field 1, the secondary
+// bitmap, is managed by the codec.
+var Spec = map[int]FieldSpec{
+	FieldPAN:                    {"Primary account number (token)", AlphaNumericSpecial, 19, 2},
+	FieldProcessingCode:         {"Processing code", Numeric, 6, 0},
+	FieldAmount:                 {"Amount, transaction", Numeric, 12, 0},
+	FieldTransmissionDateTime:   {"Transmission date and time", Numeric, 10, 0},
+	FieldSTAN:                   {"System trace audit number", Numeric, 6, 0},
+	FieldLocalTime:              {"Time, local transaction", Numeric, 6, 0},
+	FieldLocalDate:              {"Date, local transaction", Numeric, 4, 0},
+	FieldMCC:                    {"Merchant category code", Numeric, 4, 0},
+	FieldAcquirerCountry:        {"Acquiring institution country code", Numeric, 3, 0},
+	FieldAcquiringInstitutionID: {"Acquiring institution identification code", Numeric, 11, 2},
+	FieldRRN:                    {"Retrieval reference number", AlphaNumericSpecial, 12, 0},
+	FieldAuthorizationID:        {"Authorization identification response", AlphaNumericSpecial, 6, 0},
+	FieldResponseCode:           {"Response code", AlphaNumericSpecial, 2, 0},
+	FieldTerminalID:             {"Card acceptor terminal identification", AlphaNumericSpecial, 8, 0},
+	FieldMerchantID:             {"Card acceptor identification code", AlphaNumericSpecial, 15, 0},
+	FieldMerchantNameLocation:   {"Card acceptor name/location", AlphaNumericSpecial, 40, 0},
+	FieldCurrencyCode:           {"Currency code, transaction", Numeric, 3, 0},
+	FieldOriginalDataElements:   {"Original data elements", Numeric, 42, 0},
+	FieldAccountIdentification:  {"Account identification 1", AlphaNumericSpecial, 28, 2},
+}
@@ -0,0 +1,262 @@
+package kafka
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Option configures optional Consumer settings.
+type Option func(*Consumer)
+
+// WithPartitionWorkers handles each partition's messages on n workers
+// instead of handling one message at a time across all partitions.
+// Messages are assigned to a partition's workers by key, so messages with
+// the same key are still handled in order. A partition's offset is only
+// committed up to its lowest message not yet handled.
+func WithPartitionWorkers(n int) Option {
+	return func(c *Consumer) {
+		c.workers = n
+	}
+}
+
+// WithMaxInFlight bounds how many fetched messages may be queued or being
+// handled at once when partition workers are used (default 100). Fetching
+// pauses while the bound is reached.
+func WithMaxInFlight(n int) Option {
+	return func(c *Consumer) {
+		c.maxInFlight = n
+	}
+}
+
+// WithDrainTimeout bounds how long shutdown waits for fetched messages to
+// be handled when partition workers are used (default 30s). Handlers still
+// running are then cancelled, and those messages and any not yet started
+// are left uncommitted to be redelivered.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(c *Consumer) {
+		c.drainTimeout = d
+	}
+}
+
+// messageReader is the part of a kafka.Reader that a consumer uses. Stats
+// reports, among other things, the rebalances since it was last called.
+type messageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Stats() kafka.ReaderStats
+}
+
+type topicPartition struct {
+	topic     string
+	partition int
+}
+
+type lane struct {
+	topicPartition
+	worker int
+}
+
+// offsets tracks a partition's fetched messages until they are handled.
+// Offsets are counted rather than flagged, since a partition can be
+// fetched again from its last commit.
+type offsets struct {
+	pending []int64
+	handled map[int64]int
+	// dropped is set, under the offsets lock, once a rebalance may have
+	// revoked the partition, after which nothing more is committed for it.
+	dropped bool
+	// committed is guarded by the commit lock rather than the offsets lock.
+	committed int64
+}
+
+// fetched is a message with the offsets of the partition it was fetched
+// from.
+type fetched struct {
+	msg     kafka.Message
+	offsets *offsets
+}
+
+func newOffsets() *offsets {
+	return &offsets{handled: map[int64]int{}, committed: -1}
+}
+
+// handle records that offset was handled. It returns the highest offset
+// which, with every offset fetched before it, has been handled, or -1 if
+// that has not moved.
+func (o *offsets) handle(offset int64) int64 {
+	o.handled[offset]++
+	last := int64(-1)
+	for len(o.pending) > 0 && o.handled[o.pending[0]] > 0 {
+		last = o.pending[0]
+		if o.handled[last]--; o.handled[last] == 0 {
+			delete(o.handled, last)
+		}
+		o.pending = o.pending[1:]
+	}
+	return last
+}
+
+// consumeConcurrently fetches messages until ctx is done, handing each to
+// its lane's worker. It then stops fetching, waits up to drainTimeout for
+// the messages already fetched to be handled and commits them before
+// returning.
+//
+// After a rebalance, a partition may belong to another consumer that has
+// committed further, so committing an older offset would move it back. The
+// reader does not say which partitions were revoked, so every pending
+// commit is dropped. The partitions kept are fetched again from their last
+// commit, and their messages are tracked afresh.
+func consumeConcurrently(ctx context.Context, reader messageReader, handler MessageHandler, workers, maxInFlight int, drainTimeout time.Duration) {
+	var (
+		mu       sync.Mutex
+		tracked  = map[topicPartition]*offsets{}
+		commitMu sync.Mutex
+		wg       sync.WaitGroup
+		lanes    = map[lane]chan fetched{}
+		slots    = make(chan struct{}, maxInFlight)
+	)
+
+	// Handlers are not cancelled when ctx is, so that fetched messages are
+	// drained rather than abandoned, unless the drain times out.
+	handlerCtx, cancelHandlers := context.WithCancel(context.Background())
+	defer cancelHandlers()
+
+	// rebalanced drops the tracked partitions if the reader has rebalanced
+	// since it was last called. It must be called with mu held.
+	rebalanced := func() {
+		if reader.Stats().Rebalances == 0 {
+			return
+		}
+		if len(tracked) > 0 {
+			log.Printf("Consumer group rebalanced, dropping uncommitted offsets of %d partitions", len(tracked))
+		}
+		for tp, o := range tracked {
+			o.dropped = true
+			delete(tracked, tp)
+		}
+	}
+
+	handled := func(f fetched) {
+		msg, o := f.msg, f.offsets
+		mu.Lock()
+		last := o.handle(msg.Offset)
+		mu.Unlock()
+
+		if last >= 0 {
+			// Commits are serialized so that a slower, older commit cannot
+			// move the partition's offset back, and each checks first that
+			// the partition has not been rebalanced away.
+			commitMu.Lock()
+			mu.Lock()
+			rebalanced()
+			dropped := o.dropped
+			mu.Unlock()
+			if !dropped && last > o.committed {
+				if err := commit(reader, kafka.Message{Topic: msg.Topic, Partition: msg.Partition, Offset: last}); err != nil {
+					log.Printf("failed to commit offset %d of %s/%d: %v", last, msg.Topic, msg.Partition, err)
+				} else {
+					o.committed = last
+				}
+			}
+			commitMu.Unlock()
+		}
+		<-slots
+	}
+
+	work := func(messages <-chan fetched) {
+		defer wg.Done()
+		for f := range messages {
+			if handlerCtx.Err() != nil {
+				// The drain timed out, so the message is left uncommitted.
+				continue
+			}
+			err := handler(handlerCtx, f.msg)
+			if handlerCtx.Err() != nil {
+				continue
+			}
+			if err != nil {
+				log.Printf("error handling message: %v", err)
+			}
+			handled(f)
+		}
+	}
+
+fetching:
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			break fetching
+		}
+
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			<-slots
+			if ctx.Err() != nil {
+				break fetching
+			}
+			log.Printf("error fetching message: %v", err)
+			continue
+		}
+		log.Printf("message received: Topic=%s, Key=%s", msg.Topic, string(msg.Key))
+
+		tp := topicPartition{msg.Topic, msg.Partition}
+		mu.Lock()
+		// A message fetched after a rebalance must not be tracked with the
+		// offsets it dropped.
+		rebalanced()
+		o := tracked[tp]
+		if o == nil {
+			o = newOffsets()
+			tracked[tp] = o
+		}
+		o.pending = append(o.pending, msg.Offset)
+		mu.Unlock()
+
+		l := lane{tp, worker(msg.Key, workers)}
+		messages, ok := lanes[l]
+		if !ok {
+			// A lane never holds more than maxInFlight messages, so
+			// handing one over does not block.
+			messages = make(chan fetched, maxInFlight)
+			lanes[l] = messages
+			wg.Add(1)
+			go work(messages)
+		}
+		messages <- fetched{msg, o}
+	}
+
+	log.Println("Shutdown signal received, draining in-flight messages.")
+	timeout := time.AfterFunc(drainTimeout, func() {
+		log.Printf("In-flight messages not drained within %s, leaving the rest to be redelivered.", drainTimeout)
+		cancelHandlers()
+	})
+	defer timeout.Stop()
+	for _, messages := range lanes {
+		close(messages)
+	}
+	wg.Wait()
+}
+
+// worker picks which of n workers handles messages with key.
+func worker(key []byte, n int) int {
+	if n <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(n))
+}
+
+// commit commits offset msg.Offset+1 for msg's partition. It does not use
+// the consumer's context, which is already cancelled while draining.
+func commit(reader messageReader, msg kafka.Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return reader.CommitMessages(ctx, msg)
+}
@@ -0,0 +1,290 @@
+package kafka
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeReader serves a fixed list of messages, then blocks until the
+// context is done. It records the offsets committed for each partition.
+type fakeReader struct {
+	mu         sync.Mutex
+	messages   []kafka.Message
+	fetched    int
+	committed  map[int][]int64
+	rebalances int64
+}
+
+func newFakeReader(messages ...kafka.Message) *fakeReader {
+	return &fakeReader{messages: messages, committed: map[int][]int64{}}
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	r.mu.Lock()
+	if r.fetched < len(r.messages) {
+		msg := r.messages[r.fetched]
+		r.fetched++
+		r.mu.Unlock()
+		return msg, nil
+	}
+	r.mu.Unlock()
+	<-ctx.Done()
+	return kafka.Message{}, ctx.Err()
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, msg := range msgs {
+		r.committed[msg.Partition] = append(r.committed[msg.Partition], msg.Offset)
+	}
+	return nil
+}
+
+func (r *fakeReader) Stats() kafka.ReaderStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := kafka.ReaderStats{Rebalances: r.rebalances}
+	r.rebalances = 0
+	return stats
+}
+
+// rebalance simulates a new generation of the consumer group.
+func (r *fakeReader) rebalance() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rebalances++
+}
+
+func (r *fakeReader) commits(partition int) []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int64(nil), r.committed[partition]...)
+}
+
+func (r *fakeReader) fetchedCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.fetched
+}
+
+func message(partition int, offset int64, key string) kafka.Message {
+	return kafka.Message{Topic: "ledger-update-requests", Partition: partition, Offset: offset, Key: []byte(key)}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOffsetsHandle(t *testing.T) {
+	o := newOffsets()
+	o.pending = []int64{3, 4, 5, 6}
+	if last := o.handle(4); last != -1 {
+		t.Errorf("handle(4) = %d before 3 was handled, want -1", last)
+	}
+	if last := o.handle(3); last != 4 {
+		t.Errorf("handle(3) = %d, want 4", last)
+	}
+	if last := o.handle(6); last != -1 {
+		t.Errorf("handle(6) = %d before 5 was handled, want -1", last)
+	}
+	if last := o.handle(5); last != 6 {
+		t.Errorf("handle(5) = %d, want 6", last)
+	}
+
+	// A partition fetched again after a rebalance repeats offsets.
+	o.pending = []int64{7, 8, 7, 8}
+	o.handle(7)
+	o.handle(7)
+	if last := o.handle(8); last != 7 {
+		t.Errorf("handle(8) = %d, want 7", last)
+	}
+	if last := o.handle(8); last != 8 {
+		t.Errorf("handle(8) = %d, want 8", last)
+	}
+}
+
+func TestConsumeConcurrently(t *testing.T) {
+	reader := newFakeReader(
+		message(0, 0, "tx-1"),
+		message(0, 1, "tx-2"),
+		message(0, 2, "tx-1"),
+		message(0, 3, "tx-2"),
+		message(1, 0, "tx-3"),
+	)
+	// tx-1's first message is held until the others have been handled.
+	release := make(chan struct{})
+	var mu sync.Mutex
+	handled := map[string][]int64{}
+	handler := func(ctx context.Context, msg kafka.Message) error {
+		if string(msg.Key) == "tx-1" && msg.Offset == 0 {
+			<-release
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		handled[string(msg.Key)] = append(handled[string(msg.Key)], msg.Offset)
+		return nil
+	}
+	count := func(key string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(handled[key])
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumeConcurrently(ctx, reader, handler, 4, 10, time.Minute)
+	}()
+
+	waitFor(t, "the other keys", func() bool { return count("tx-2") == 2 && count("tx-3") == 1 })
+	if commits := reader.commits(1); !reflect.DeepEqual(commits, []int64{0}) {
+		t.Errorf("partition 1 commits = %v, want [0]", commits)
+	}
+	if commits := reader.commits(0); len(commits) != 0 {
+		t.Errorf("partition 0 commits = %v while offset 0 is in flight, want none", commits)
+	}
+	if n := count("tx-1"); n != 0 {
+		t.Errorf("handled %d tx-1 messages ahead of the first", n)
+	}
+
+	close(release)
+	waitFor(t, "tx-1", func() bool { return count("tx-1") == 2 })
+	cancel()
+	<-done
+
+	if !reflect.DeepEqual(handled["tx-1"], []int64{0, 2}) || !reflect.DeepEqual(handled["tx-2"], []int64{1, 3}) {
+		t.Errorf("handled %v, want each key in offset order", handled)
+	}
+	if commits := reader.commits(0); len(commits) == 0 || commits[len(commits)-1] != 3 {
+		t.Errorf("partition 0 commits = %v, want to end at 3", commits)
+	}
+}
+
+func TestConsumeConcurrentlyBoundsInFlight(t *testing.T) {
+	var messages []kafka.Message
+	for i := 0; i < 10; i++ {
+		messages = append(messages, message(i%2, int64(i/2), string(rune('a'+i))))
+	}
+	reader := newFakeReader(messages...)
+	release := make(chan struct{})
+	handler := func(ctx context.Context, msg kafka.Message) error {
+		<-release
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumeConcurrently(ctx, reader, handler, 4, 3, time.Minute)
+	}()
+
+	waitFor(t, "the first messages", func() bool { return reader.fetchedCount() == 3 })
+	time.Sleep(10 * time.Millisecond)
+	if n := reader.fetchedCount(); n != 3 {
+		t.Errorf("fetched %d messages with 3 in flight allowed", n)
+	}
+
+	// Shutting down drains the fetched messages and commits them.
+	cancel()
+	select {
+	case <-done:
+		t.Fatal("returned before in-flight messages were handled")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	<-done
+	if n := reader.fetchedCount(); n != 3 {
+		t.Errorf("fetched %d messages after shutdown, want 3", n)
+	}
+	if commits := reader.commits(0); len(commits) == 0 || commits[len(commits)-1] != 1 {
+		t.Errorf("partition 0 commits = %v, want to end at 1", commits)
+	}
+	if commits := reader.commits(1); len(commits) == 0 || commits[len(commits)-1] != 0 {
+		t.Errorf("partition 1 commits = %v, want to end at 0", commits)
+	}
+}
+
+func TestConsumeConcurrentlyDropsCommitsOnRebalance(t *testing.T) {
+	reader := newFakeReader(message(0, 5, "tx-1"), message(0, 6, "tx-2"))
+	release := make(chan struct{})
+	sixHandled := make(chan struct{})
+	handler := func(ctx context.Context, msg kafka.Message) error {
+		if msg.Offset == 5 {
+			<-release
+		} else {
+			close(sixHandled)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumeConcurrently(ctx, reader, handler, 4, 10, time.Minute)
+	}()
+
+	// Offset 5 finishes after the partition may have been revoked, so
+	// neither it nor 6 is committed.
+	<-sixHandled
+	reader.rebalance()
+	close(release)
+	cancel()
+	<-done
+	if commits := reader.commits(0); len(commits) != 0 {
+		t.Errorf("partition 0 commits = %v after a rebalance, want none", commits)
+	}
+}
+
+func TestConsumeConcurrentlyDrainTimeout(t *testing.T) {
+	reader := newFakeReader(message(0, 0, "tx-1"), message(0, 1, "tx-1"), message(1, 0, "tx-2"))
+	var mu sync.Mutex
+	var handled []int
+	handler := func(ctx context.Context, msg kafka.Message) error {
+		if string(msg.Key) == "tx-1" {
+			// Stuck until the drain times out.
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, msg.Partition)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumeConcurrently(ctx, reader, handler, 4, 10, 20*time.Millisecond)
+	}()
+
+	waitFor(t, "the messages", func() bool { return reader.fetchedCount() == 3 })
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain did not time out")
+	}
+	if commits := reader.commits(0); len(commits) != 0 {
+		t.Errorf("partition 0 commits = %v for messages cut off by the timeout, want none", commits)
+	}
+	if commits := reader.commits(1); !reflect.DeepEqual(commits, []int64{0}) {
+		t.Errorf("partition 1 commits = %v, want [0]", commits)
+	}
+}
@@ -3,6 +3,7 @@ package kafka
 import (
 	"context"
 	"log"
+	"time"
 
 	"github.com/segmentio/kafka-go"
 )
@@ -10,16 +11,25 @@ import (
 type MessageHandler func(ctx context.Context, msg kafka.Message) error
 
 type Consumer struct {
-	reader *kafka.Reader
+	brokers []string
+	groupID string
+	reader  *kafka.Reader
+
+	// workers, if set, is the number of workers per partition; see
+	// WithPartitionWorkers.
+	workers      int
+	maxInFlight  int
+	drainTimeout time.Duration
 }
 
-func NewConsumer(brokers []string, groupID string) *Consumer {
-	// Topics are now set in the Consume method
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: brokers,
-		GroupID: groupID,
-	})
-	return &Consumer{reader: reader}
+func NewConsumer(brokers []string, groupID string, opts ...Option) *Consumer {
+	// The reader is created by Consume, since a group reader needs its
+	// topics up front.
+	c := &Consumer{brokers: brokers, groupID: groupID, maxInFlight: 100, drainTimeout: 30 * time.Second}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 // Consume starts consuming messages from the given topics.
@@ -29,16 +39,24 @@ func (c *Consumer) Consume(ctx context.Context, topics []string, handler Message
 	// but a better pattern for distinct logic is separate consumers.
 	// For this SAGA orchestrator where one handler manages multiple topics, this is okay.
 	// We'll reconfigure the reader for the topics it needs to consume.
-	c.reader.Close() // Close previous config if any
+	if c.reader != nil {
+		c.reader.Close() // Close previous config if any
+	}
 	c.reader = kafka.NewReader(kafka.ReaderConfig{
-		Brokers: c.reader.Config().Brokers,
-		GroupID: c.reader.Config().GroupID,
+		Brokers: c.brokers,
+		GroupID: c.groupID,
 		GroupTopics: topics,
 		MinBytes:    10e3, // 10KB
 		MaxBytes:    10e6, // 10MB
 	})
 
 
+	if c.workers > 0 {
+		log.Printf("Consumer started for topics: %v with %d workers per partition", topics, c.workers)
+		consumeConcurrently(ctx, c.reader, handler, c.workers, c.maxInFlight, c.drainTimeout)
+		return
+	}
+
 	log.Printf("Consumer started for topics: %v", topics)
 	for {
 		select {
@@ -76,5 +94,8 @@ func (c *Consumer) Consume(ctx context.Context, topics []string, handler Message
 }
 
 func (c *Consumer) Close() error {
+	if c.reader == nil {
+		return nil
+	}
 	return c.reader.Close()
 }
\ No newline at end of file
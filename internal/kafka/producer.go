@@ -2,6 +2,9 @@ package kafka
 
 import (
 	"context"
+	"errors"
+	"sort"
+	"time"
 
 	"github.com/segmentio/kafka-go"
 )
@@ -10,10 +13,54 @@ type Producer struct {
 	writer *kafka.Writer
 }
 
-func NewProducer(brokers []string) (*Producer, error) {
+// Record is a message to publish with PublishBatch.
+type Record struct {
+	Topic   string
+	Key     string
+	Value   []byte
+	Headers map[string]string
+}
+
+// ErrHeldBack is reported for a record that was not written because an
+// earlier record with the same key failed.
+var ErrHeldBack = errors.New("kafka: held back behind an earlier record with the same key")
+
+// ProducerOption configures optional Producer settings.
+type ProducerOption func(*kafka.Writer)
+
+// WithRequireAll makes a write succeed only once every in-sync replica has
+// it, so callers such as the outbox know what was delivered.
+func WithRequireAll() ProducerOption {
+	return func(w *kafka.Writer) {
+		w.RequiredAcks = kafka.RequireAll
+	}
+}
+
+// WithOrderedDelivery writes each partition's share of a PublishBatch call
+// of up to batchSize records as one produce request, and leaves retries to
+// the caller. A failed request is not retried behind the back of requests
+// queued after it, so the caller can retry the failed records in order.
+//
+// kafka-go has no idempotent or transactional producer, so this is not
+// exactly once: a request that times out after reaching the broker is
+// written again on retry.
+func WithOrderedDelivery(batchSize int) ProducerOption {
+	return func(w *kafka.Writer) {
+		w.BatchSize = batchSize
+		w.MaxAttempts = 1
+	}
+}
+
+func NewProducer(brokers []string, opts ...ProducerOption) (*Producer, error) {
 	writer := &kafka.Writer{
-		Addr:     kafka.TCP(brokers...),
-		Balancer: &kafka.LeastBytes{},
+		Addr: kafka.TCP(brokers...),
+		// Hashing the key keeps a key's messages on one partition, in order.
+		Balancer: &kafka.Hash{},
+		// Writes are synchronous, so waiting to fill a batch only adds latency.
+		BatchTimeout: 10 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(writer)
 	}
 	return &Producer{writer: writer}, nil
 }
@@ -26,6 +73,77 @@ func (p *Producer) Publish(ctx context.Context, topic, key string, payload []byt
 	})
 }
 
+// PublishBatch writes records with one call and returns the outcome of each,
+// at the same index: nil if it was written, the error if it was not. Records
+// bound for the same partition are written in order.
+//
+// Some errors, such as an oversized record or an unknown topic, fail the
+// whole call before anything is written. The records are then written one
+// by one, so only the records at fault report an error. Once a record
+// fails, later records with the same key are not written and report
+// ErrHeldBack, so a key's records are never written out of order.
+func (p *Producer) PublishBatch(ctx context.Context, records []Record) []error {
+	return publishBatch(ctx, p.writer, records)
+}
+
+// messageWriter is the part of kafka.Writer PublishBatch uses.
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+func publishBatch(ctx context.Context, writer messageWriter, records []Record) []error {
+	results := make([]error, len(records))
+	if len(records) == 0 {
+		return results
+	}
+
+	messages := make([]kafka.Message, len(records))
+	for i, r := range records {
+		messages[i] = kafka.Message{Topic: r.Topic, Key: []byte(r.Key), Value: r.Value, Headers: headers(r.Headers)}
+	}
+
+	err := writer.WriteMessages(ctx, messages...)
+	var writeErrors kafka.WriteErrors
+	switch {
+	case err == nil:
+	case errors.As(err, &writeErrors):
+		copy(results, writeErrors)
+	case ctx.Err() != nil || len(records) == 1:
+		for i := range results {
+			results[i] = err
+		}
+	default:
+		failed := map[string]bool{}
+		for i := range messages {
+			if failed[records[i].Key] {
+				results[i] = ErrHeldBack
+				continue
+			}
+			if results[i] = writer.WriteMessages(ctx, messages[i]); results[i] != nil {
+				failed[records[i].Key] = true
+			}
+		}
+	}
+	return results
+}
+
+// headers converts record headers to Kafka headers, sorted by name.
+func headers(values map[string]string) []kafka.Header {
+	if len(values) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	headers := make([]kafka.Header, len(names))
+	for i, name := range names {
+		headers[i] = kafka.Header{Key: name, Value: []byte(values[name])}
+	}
+	return headers
+}
+
 func (p *Producer) Close() error {
 	return p.writer.Close()
-}
\ No newline at end of file
+}
@@ -0,0 +1,93 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeWriter records the calls made to it. write returns the outcome of
+// each call from the messages it was given.
+type fakeWriter struct {
+	calls [][]string
+	write func(msgs []kafka.Message) error
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	var values []string
+	for _, msg := range msgs {
+		values = append(values, string(msg.Value))
+	}
+	w.calls = append(w.calls, values)
+	return w.write(msgs)
+}
+
+func TestPublishBatch(t *testing.T) {
+	records := []Record{
+		{Topic: "t", Key: "a", Value: []byte("a1"), Headers: map[string]string{"outbox_id": "1"}},
+		{Topic: "t", Key: "b", Value: []byte("b1")},
+		{Topic: "t", Key: "a", Value: []byte("a2")},
+		{Topic: "t", Key: "b", Value: []byte("b2")},
+	}
+	rejected := errors.New("rejected")
+
+	t.Run("written", func(t *testing.T) {
+		var headers []kafka.Header
+		w := &fakeWriter{write: func(msgs []kafka.Message) error {
+			headers = msgs[0].Headers
+			return nil
+		}}
+		if results := publishBatch(context.Background(), w, records); !reflect.DeepEqual(results, make([]error, 4)) {
+			t.Errorf("results = %v, want no errors", results)
+		}
+		if len(w.calls) != 1 || !reflect.DeepEqual(headers, []kafka.Header{{Key: "outbox_id", Value: []byte("1")}}) {
+			t.Errorf("%d calls with headers %v, want one call with the outbox_id header", len(w.calls), headers)
+		}
+	})
+
+	t.Run("partial failure", func(t *testing.T) {
+		w := &fakeWriter{write: func(msgs []kafka.Message) error {
+			return kafka.WriteErrors{nil, rejected, nil, rejected}
+		}}
+		results := publishBatch(context.Background(), w, records)
+		if !reflect.DeepEqual(results, []error{nil, rejected, nil, rejected}) {
+			t.Errorf("results = %v, want the write errors", results)
+		}
+	})
+
+	t.Run("rejected as a whole", func(t *testing.T) {
+		// The batch fails before anything is written, then a1 fails alone.
+		// a2 is held back behind it, while b's records carry on.
+		w := &fakeWriter{write: func(msgs []kafka.Message) error {
+			if len(msgs) > 1 || string(msgs[0].Value) == "a1" {
+				return rejected
+			}
+			return nil
+		}}
+		results := publishBatch(context.Background(), w, records)
+		if !reflect.DeepEqual(results, []error{rejected, nil, ErrHeldBack, nil}) {
+			t.Errorf("results = %v, want a1 rejected and a2 held back", results)
+		}
+		want := [][]string{{"a1", "b1", "a2", "b2"}, {"a1"}, {"b1"}, {"b2"}}
+		if !reflect.DeepEqual(w.calls, want) {
+			t.Errorf("writes = %v, want %v", w.calls, want)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		w := &fakeWriter{write: func(msgs []kafka.Message) error { return ctx.Err() }}
+		for i, err := range publishBatch(ctx, w, records) {
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("record %d: %v, want context.Canceled", i, err)
+			}
+		}
+		if len(w.calls) != 1 {
+			t.Errorf("%d writes after cancellation, want 1", len(w.calls))
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// TailConsumer reads every partition of its topics from the newest message
+// on, outside any consumer group. Every instance sees every message
+// published after it starts, and nothing is committed, so instances come
+// and go without leaving groups or offsets behind. It suits instances that
+// only care about replies to requests they made themselves.
+type TailConsumer struct {
+	brokers []string
+
+	mu      sync.Mutex
+	readers []*kafka.Reader
+}
+
+// NewTailConsumer creates a consumer reading from brokers.
+func NewTailConsumer(brokers []string) *TailConsumer {
+	return &TailConsumer{brokers: brokers}
+}
+
+// Consume reads the partitions topics have when it is called until ctx is
+// cancelled. Messages the handler fails are logged and skipped.
+func (c *TailConsumer) Consume(ctx context.Context, topics []string, handler MessageHandler) error {
+	partitions, err := c.partitions(ctx, topics)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, p := range partitions {
+		r := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:   c.brokers,
+			Topic:     p.Topic,
+			Partition: p.ID,
+			MinBytes:  1,
+			MaxBytes:  10e6, // 10MB
+		})
+		if err := r.SetOffset(kafka.LastOffset); err != nil {
+			r.Close()
+			return err
+		}
+		c.mu.Lock()
+		c.readers = append(c.readers, r)
+		c.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				msg, err := r.ReadMessage(ctx)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					log.Printf("error reading %s: %v", r.Config().Topic, err)
+					continue
+				}
+				if err := handler(ctx, msg); err != nil {
+					log.Printf("error handling message: %v", err)
+				}
+			}
+		}()
+	}
+	log.Printf("Tailing %d partitions of topics: %v", len(partitions), topics)
+	wg.Wait()
+	return nil
+}
+
+// partitions looks up the partitions of topics from the first reachable
+// broker.
+func (c *TailConsumer) partitions(ctx context.Context, topics []string) ([]kafka.Partition, error) {
+	var lastErr error
+	for _, broker := range c.brokers {
+		conn, err := kafka.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		partitions, err := conn.ReadPartitions(topics...)
+		conn.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return partitions, nil
+	}
+	return nil, fmt.Errorf("looking up partitions of %v: %w", topics, lastErr)
+}
+
+// Close closes the readers Consume opened.
+func (c *TailConsumer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var firstErr error
+	for _, r := range c.readers {
+		if err := r.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	c.readers = nil
+	return firstErr
+}
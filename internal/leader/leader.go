@@ -0,0 +1,238 @@
+// Package leader elects one replica at a time to run a singleton job, such
+// as a sweeper or an accrual run, using Postgres advisory locks.
+//
+// The leader holds a session-level advisory lock on a dedicated connection
+// and checks it from a pooled one every keep-alive interval, so the check
+// never runs inside the job's transactions. If the check fails, or takes
+// longer than the interval, the lease is treated as lost and the job is
+// cancelled. The lock dies with its session, so a crashed leader is
+// replaced once Postgres notices. Until then, and for up to one keep-alive
+// interval after a partition, two replicas may both believe they lead, so
+// jobs must still be idempotent.
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+)
+
+// lockSpace is the first key of every leadership lock; the second is the
+// hash of the job's name.
+const lockSpace = 0x6c656164
+
+// metrics counts leadership changes for each job name under the expvar
+// "leader": acquired, lost and released, plus leader, which is 1 while this
+// process leads.
+var (
+	metrics   = expvar.NewMap("leader")
+	metricsMu sync.Mutex
+)
+
+func nameMetrics(name string) *expvar.Map {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if m, ok := metrics.Get(name).(*expvar.Map); ok {
+		return m
+	}
+	m := new(expvar.Map).Init()
+	metrics.Set(name, m)
+	return m
+}
+
+// Elector runs jobs on whichever replica holds their lock.
+type Elector struct {
+	db        *sql.DB
+	keepAlive time.Duration
+	retry     time.Duration
+}
+
+// Option configures optional Elector settings.
+type Option func(*Elector)
+
+// WithKeepAlive sets how often a leader checks it still holds its lock
+// (default 5s).
+func WithKeepAlive(d time.Duration) Option {
+	return func(e *Elector) {
+		e.keepAlive = d
+	}
+}
+
+// WithRetryInterval sets how often a follower tries to take the lock
+// (default 5s).
+func WithRetryInterval(d time.Duration) Option {
+	return func(e *Elector) {
+		e.retry = d
+	}
+}
+
+func New(db *sql.DB, opts ...Option) *Elector {
+	e := &Elector{db: db, keepAlive: 5 * time.Second, retry: 5 * time.Second}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// RunWhileLeader campaigns for the leadership of name and calls fn once
+// elected. fn's context is cancelled when the lease is lost or ctx is done,
+// and fn should then return promptly. After losing the lease RunWhileLeader
+// campaigns again, calling fn anew when re-elected. It returns, giving up
+// the leadership, once ctx is done or fn returns of its own accord.
+func (e *Elector) RunWhileLeader(ctx context.Context, name string, fn func(ctx context.Context)) {
+	e.RunWithSession(ctx, name, func(ctx context.Context, _ *sql.Conn) { fn(ctx) })
+}
+
+// RunWithSession is RunWhileLeader for jobs that write through conn, the
+// session holding the lock. Unlike a pooled connection, conn cannot commit
+// once its session, and with it the lock, is gone, so a leader cut off from
+// Postgres writes nothing after another replica has taken over. conn is
+// fn's alone. The lease can be lost between keep-alive checks, so fn should
+// also call Held in each transaction before committing it.
+func (e *Elector) RunWithSession(ctx context.Context, name string, fn func(ctx context.Context, conn *sql.Conn)) {
+	m := nameMetrics(name)
+	for {
+		conn, pid, err := e.acquire(ctx, name)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("error campaigning for leadership of %s: %v", name, err)
+		}
+		if conn == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(e.retry):
+				continue
+			}
+		}
+
+		log.Printf("Elected leader of %s", name)
+		m.Add("acquired", 1)
+		m.Add("leader", 1)
+		lost := e.lead(ctx, conn, pid, name, fn)
+		m.Add("leader", -1)
+
+		if !lost {
+			release(conn, name)
+			m.Add("released", 1)
+			log.Printf("Gave up leadership of %s", name)
+			return
+		}
+		m.Add("lost", 1)
+		discard(conn)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// acquire returns a connection holding name's lock and its backend PID, or
+// nil if another session holds it.
+func (e *Elector) acquire(ctx context.Context, name string) (*sql.Conn, int64, error) {
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	var locked bool
+	var pid int64
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1, hashtext($2)), pg_backend_pid()", lockSpace, name).Scan(&locked, &pid)
+	if err != nil || !locked {
+		conn.Close()
+		return nil, 0, err
+	}
+	return conn, pid, nil
+}
+
+// lead runs fn while checking every keep-alive interval that conn, whose
+// backend is pid, still holds name's lock. It reports whether the lease was
+// lost before fn returned.
+func (e *Elector) lead(ctx context.Context, conn *sql.Conn, pid int64, name string, fn func(ctx context.Context, conn *sql.Conn)) bool {
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(leaderCtx, conn)
+	}()
+
+	ticker := time.NewTicker(e.keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return false
+		case <-ticker.C:
+			if err := e.check(pid, name); err != nil {
+				log.Printf("Lost leadership of %s: %v", name, err)
+				cancel()
+				<-done
+				return true
+			}
+		}
+	}
+}
+
+// check confirms that the session with backend pid still holds name's
+// lock. It runs on a pooled connection, not the lock's, so it cannot land
+// in one of the job's transactions. It does not use the job's context, so
+// shutting down is not mistaken for a lost lease.
+func (e *Elector) check(pid int64, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), e.keepAlive)
+	defer cancel()
+	held, err := holds(ctx, e.db, pid, name)
+	if err == nil && !held {
+		err = errors.New("advisory lock is no longer held")
+	}
+	return err
+}
+
+// Queryer is satisfied by *sql.DB, *sql.Conn and *sql.Tx.
+type Queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Held reports whether the session q runs on holds name's lock. A job run
+// with RunWithSession calls it on conn, in the transaction that commits its
+// work, to fence the work: the keep-alive only notices a lost lease at its
+// next check.
+func Held(ctx context.Context, q Queryer, name string) (bool, error) {
+	return holds(ctx, q, 0, name)
+}
+
+// holds reports whether the session with backend pid, or q's own session if
+// pid is 0, holds name's lock.
+func holds(ctx context.Context, q Queryer, pid int64, name string) (bool, error) {
+	var held bool
+	err := q.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM pg_locks
+			WHERE locktype = 'advisory' AND granted
+			AND classid = $1::oid AND objid = hashtext($2)::oid AND objsubid = 2
+			AND pid = COALESCE(NULLIF($3::int, 0), pg_backend_pid())
+		)
+	`, lockSpace, name, pid).Scan(&held)
+	return held, err
+}
+
+// release unlocks name and returns conn to the pool. A connection that
+// cannot be unlocked is discarded instead, so the lock is not left behind
+// on a pooled session.
+func release(conn *sql.Conn, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1, hashtext($2))", lockSpace, name); err != nil {
+		discard(conn)
+		return
+	}
+	conn.Close()
+}
+
+// discard closes conn's session rather than returning it to the pool.
+func discard(conn *sql.Conn) {
+	conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	conn.Close()
+}
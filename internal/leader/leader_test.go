@@ -0,0 +1,287 @@
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"expvar"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"credit-authorization-ledger/internal/dbtest"
+)
+
+// fakeLocks serves an Elector's statements from dbtest.Locks. It counts the
+// keep-alive checks and, like Postgres, refuses a session's statements once
+// a statement in its transaction has failed.
+type fakeLocks struct {
+	dbtest.Locks
+	mu      sync.Mutex
+	checks  int
+	inTx    map[*dbtest.Session]bool
+	aborted map[*dbtest.Session]bool
+}
+
+func newFakeLocks() *fakeLocks {
+	return &fakeLocks{inTx: map[*dbtest.Session]bool{}, aborted: map[*dbtest.Session]bool{}}
+}
+
+var errAborted = errors.New("current transaction is aborted, commands ignored until end of transaction block")
+
+// fail fails a statement on s, aborting its transaction.
+func (f *fakeLocks) fail(s *dbtest.Session, query string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.aborted[s] {
+		return errAborted
+	}
+	f.aborted[s] = f.inTx[s]
+	return errors.New("unexpected statement " + query)
+}
+
+func (f *fakeLocks) Query(s *dbtest.Session, query string, args []driver.NamedValue) (driver.Rows, error) {
+	f.mu.Lock()
+	aborted := f.aborted[s]
+	if strings.Contains(query, "FROM pg_locks") && args[2].Value.(int64) != 0 {
+		f.checks++
+	}
+	f.mu.Unlock()
+	if aborted {
+		return nil, errAborted
+	}
+	if rows, ok := f.Locks.Query(s, query, args); ok {
+		return rows, nil
+	}
+	return nil, f.fail(s, query)
+}
+
+func (f *fakeLocks) Exec(s *dbtest.Session, query string, args []driver.NamedValue) (driver.Result, error) {
+	if res, ok := f.Locks.Exec(s, query, args); ok {
+		return res, nil
+	}
+	return nil, f.fail(s, query)
+}
+
+func (f *fakeLocks) Begin(s *dbtest.Session, opts driver.TxOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inTx[s] = true
+	return nil
+}
+
+func (f *fakeLocks) Commit(s *dbtest.Session) error { return f.Rollback(s) }
+
+func (f *fakeLocks) Rollback(s *dbtest.Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inTx[s], f.aborted[s] = false, false
+	return nil
+}
+
+func (f *fakeLocks) keepAlives() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.checks
+}
+
+func metric(name, key string) int64 {
+	v, _ := nameMetrics(name).Get(key).(*expvar.Int)
+	if v == nil {
+		return 0
+	}
+	return v.Value()
+}
+
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRunWhileLeaderReturnsWithFn(t *testing.T) {
+	locks := newFakeLocks()
+	elector := New(dbtest.Open(locks), WithKeepAlive(time.Millisecond), WithRetryInterval(time.Millisecond))
+	acquired, released := metric("statement-close", "acquired"), metric("statement-close", "released")
+
+	ran := false
+	elector.RunWhileLeader(context.Background(), "statement-close", func(ctx context.Context) {
+		ran = locks.Holder("statement-close") != nil
+	})
+
+	if !ran {
+		t.Error("fn did not run while holding the lock")
+	}
+	if locks.Holder("statement-close") != nil {
+		t.Error("the lock is still held after RunWhileLeader returned")
+	}
+	if metric("statement-close", "acquired") != acquired+1 || metric("statement-close", "released") != released+1 || metric("statement-close", "leader") != 0 {
+		t.Errorf("metrics = %s, want one acquisition and release", nameMetrics("statement-close"))
+	}
+}
+
+func TestRunWhileLeaderFailsOver(t *testing.T) {
+	locks := newFakeLocks()
+	db := dbtest.Open(locks)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lost := metric("interest-accrual", "lost")
+
+	// Two replicas campaign for the same job.
+	var mu sync.Mutex
+	leaders, elections := 0, 0
+	job := func(ctx context.Context) {
+		mu.Lock()
+		leaders++
+		elections++
+		mu.Unlock()
+		<-ctx.Done()
+		mu.Lock()
+		leaders--
+		mu.Unlock()
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			New(db, WithKeepAlive(time.Millisecond), WithRetryInterval(time.Millisecond)).RunWhileLeader(ctx, "interest-accrual", job)
+		}()
+	}
+
+	waitUntil(t, "a leader", func() bool { mu.Lock(); defer mu.Unlock(); return elections == 1 })
+	first := locks.Holder("interest-accrual")
+
+	// The leader's session is killed. A new leader is elected on another
+	// session, and the old one steps down at its next keep-alive.
+	locks.Terminate("interest-accrual")
+	waitUntil(t, "a new leader", func() bool {
+		holder := locks.Holder("interest-accrual")
+		mu.Lock()
+		defer mu.Unlock()
+		return elections == 2 && leaders == 1 && holder != nil && holder != first
+	})
+	waitUntil(t, "the lost lease to be counted", func() bool { return metric("interest-accrual", "lost") == lost+1 })
+
+	cancel()
+	wg.Wait()
+	if leaders != 0 {
+		t.Errorf("%d jobs still running after shutdown", leaders)
+	}
+	if locks.Holder("interest-accrual") != nil {
+		t.Error("the lock is still held after shutdown")
+	}
+	if metric("interest-accrual", "leader") != 0 {
+		t.Errorf("metrics = %s, want no leader after shutdown", nameMetrics("interest-accrual"))
+	}
+}
+
+func TestRunWithSession(t *testing.T) {
+	locks := newFakeLocks()
+	elector := New(dbtest.Open(locks), WithKeepAlive(time.Millisecond), WithRetryInterval(time.Millisecond))
+
+	var held, heldElsewhere bool
+	var err, errElsewhere error
+	elector.RunWithSession(context.Background(), "outbox_p0", func(ctx context.Context, conn *sql.Conn) {
+		held, err = Held(ctx, conn, "outbox_p0")
+		heldElsewhere, errElsewhere = Held(ctx, elector.db, "outbox_p0")
+	})
+	if err != nil || !held {
+		t.Errorf("Held on fn's session = %t, %v; want the session holding it", held, err)
+	}
+	if errElsewhere != nil || heldElsewhere {
+		t.Errorf("Held on another session = %t, %v; want false", heldElsewhere, errElsewhere)
+	}
+}
+
+func TestKeepAliveBesideTransaction(t *testing.T) {
+	locks := newFakeLocks()
+	elector := New(dbtest.Open(locks), WithKeepAlive(time.Millisecond), WithRetryInterval(time.Millisecond))
+	lost := metric("outbox_p1", "lost")
+
+	// fn's transaction fails and stays open across several keep-alive
+	// checks, which must not run in it.
+	elector.RunWithSession(context.Background(), "outbox_p1", func(ctx context.Context, conn *sql.Conn) {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			t.Errorf("BeginTx: %v", err)
+			return
+		}
+		defer tx.Rollback()
+		if _, err := tx.ExecContext(ctx, "SELECT 1 / 0"); err == nil {
+			t.Error("the failing statement succeeded")
+		}
+		for checks := locks.keepAlives(); locks.keepAlives() < checks+3; {
+			select {
+			case <-ctx.Done():
+				t.Error("the lease was lost while fn's transaction was open")
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	})
+	if metric("outbox_p1", "lost") != lost {
+		t.Errorf("metrics = %s, want no lost lease", nameMetrics("outbox_p1"))
+	}
+}
+
+func TestLeaseLossPostgres(t *testing.T) {
+	db := dbtest.Postgres(t)
+	name := fmt.Sprintf("lease-test-%d", time.Now().UnixNano())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lost := metric(name, "lost")
+
+	// Each replica reports the backend of the session it leads on.
+	elected := make(chan int64, 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			elector := New(db, WithKeepAlive(50*time.Millisecond), WithRetryInterval(50*time.Millisecond))
+			elector.RunWithSession(ctx, name, func(ctx context.Context, conn *sql.Conn) {
+				var pid int64
+				if err := conn.QueryRowContext(ctx, "SELECT pg_backend_pid()").Scan(&pid); err != nil {
+					t.Errorf("pg_backend_pid: %v", err)
+					return
+				}
+				if held, err := Held(ctx, conn, name); err != nil || !held {
+					t.Errorf("Held on the leader's session = %t, %v", held, err)
+				}
+				elected <- pid
+				<-ctx.Done()
+			})
+		}()
+	}
+
+	first := <-elected
+	if held, err := Held(ctx, db, name); err != nil || held {
+		t.Errorf("Held on a pooled session = %t, %v; want false", held, err)
+	}
+	// The leader's session is killed. The other replica takes over and the
+	// old leader counts a lost lease.
+	if _, err := db.Exec("SELECT pg_terminate_backend($1)", first); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case second := <-elected:
+		if second == first {
+			t.Errorf("re-elected on the terminated session %d", first)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a new leader")
+	}
+	waitUntil(t, "the lost lease to be counted", func() bool { return metric(name, "lost") == lost+1 })
+
+	cancel()
+	wg.Wait()
+}
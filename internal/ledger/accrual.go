@@ -0,0 +1,318 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"credit-authorization-ledger/internal/transport"
+	"credit-authorization-ledger/pkg/events"
+
+	"go.opentelemetry.io/otel"
+)
+
+// Accrual kinds, recorded in the accruals table.
+const (
+	AccrualInterest     = "INTEREST"
+	AccrualLateFee      = "LATE_FEE"
+	AccrualOverLimitFee = "OVER_LIMIT_FEE"
+	AccrualAnnualFee    = "ANNUAL_FEE"
+)
+
+// Day-count conventions for daily interest.
+const (
+	// DayCountActual365 divides the APR over 365 days every year.
+	DayCountActual365 = "ACT/365"
+	// DayCountActual360 divides the APR over 360 days.
+	DayCountActual360 = "ACT/360"
+	// DayCountActualActual divides the APR over the days in the accrual year.
+	DayCountActualActual = "ACT/ACT"
+)
+
+// dayFraction is the fraction of a year one day is worth under dayCount.
+func dayFraction(dayCount string, day time.Time) (float64, error) {
+	switch dayCount {
+	case DayCountActual365:
+		return 1.0 / 365, nil
+	case DayCountActual360:
+		return 1.0 / 360, nil
+	case DayCountActualActual:
+		start := time.Date(day.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+		days := start.AddDate(1, 0, 0).Sub(start).Hours() / 24
+		return 1 / days, nil
+	}
+	return 0, fmt.Errorf("unknown day-count convention %q", dayCount)
+}
+
+// AccrualPolicy configures the accrual job. Zero fees are not charged.
+type AccrualPolicy struct {
+	// APR applies to accounts without their own, e.g. 0.2499 for 24.99%.
+	APR      float64
+	DayCount string
+	// LateFee is charged when less than the minimum payment has been paid
+	// by a statement's due date.
+	LateFee float64
+	// OverLimitFee is charged at most once a month when the end-of-day
+	// balance exceeds the cardholder's credit limit.
+	OverLimitFee float64
+	// AnnualFee is charged on each anniversary of the account's enrollment.
+	AnnualFee float64
+	// LookbackDays is how many complete days each run accrues, so that days
+	// missed while the job was down are caught up.
+	LookbackDays int
+}
+
+// DefaultAccrualPolicy accrues 24.99% APR on an actual/365 basis with a 35
+// late fee and a 25 over-limit fee.
+var DefaultAccrualPolicy = AccrualPolicy{
+	APR:          0.2499,
+	DayCount:     DayCountActual365,
+	LateFee:      35,
+	OverLimitFee: 25,
+	LookbackDays: 7,
+}
+
+// round2 rounds to cents.
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// accrualAccount is a cardholder account due for accrual.
+type accrualAccount struct {
+	AccountID  string
+	APR        float64
+	EnrolledAt time.Time
+}
+
+// RunAccruals accrues interest and fees for every enrolled account over the
+// last LookbackDays complete UTC days and returns how many charges were
+// posted. Charges already made are skipped, so it is safe to rerun.
+func (s *Service) RunAccruals(ctx context.Context) (int, error) {
+	if _, err := dayFraction(s.accruals.DayCount, time.Now()); err != nil {
+		return 0, err
+	}
+
+	accounts, err := s.accrualAccounts(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	charged := 0
+	for days := s.accruals.LookbackDays; days >= 1; days-- {
+		day := today.AddDate(0, 0, -days)
+		for _, a := range accounts {
+			if day.Before(a.EnrolledAt.UTC().Truncate(24 * time.Hour)) {
+				continue
+			}
+			n, err := s.accrueDay(ctx, a, day)
+			if err != nil {
+				return charged, fmt.Errorf("accruing %s for %s: %w", day.Format("2006-01-02"), a.AccountID, err)
+			}
+			charged += n
+		}
+	}
+	return charged, nil
+}
+
+func (s *Service) accrualAccounts(ctx context.Context) ([]accrualAccount, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT account_id, COALESCE(apr, $1), created_at FROM billing_cycles ORDER BY account_id",
+		s.accruals.APR)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []accrualAccount
+	for rows.Next() {
+		var a accrualAccount
+		if err := rows.Scan(&a.AccountID, &a.APR, &a.EnrolledAt); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+// accrueDay makes every charge due on an account for day in one transaction
+// and returns how many were posted.
+func (s *Service) accrueDay(ctx context.Context, a accrualAccount, day time.Time) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	endOfDay := day.AddDate(0, 0, 1)
+	balance, err := balanceAt(ctx, tx, a.AccountID, endOfDay.Add(-time.Microsecond))
+	if err != nil {
+		return 0, err
+	}
+
+	var charges []accrualCharge
+
+	// Interest accrues on the revolving balance, i.e. whatever is owed at
+	// the end of the day.
+	if balance.Balance > 0 && a.APR > 0 {
+		fraction, _ := dayFraction(s.accruals.DayCount, day)
+		charges = append(charges, accrualCharge{
+			Kind:      AccrualInterest,
+			Date:      day,
+			EntryType: EntryInterest,
+			Amount:    round2(balance.Balance * a.APR * fraction),
+		})
+	}
+
+	if s.accruals.LateFee > 0 {
+		late, err := minimumPaymentMissed(ctx, tx, a.AccountID, day)
+		if err != nil {
+			return 0, err
+		}
+		if late {
+			charges = append(charges, accrualCharge{Kind: AccrualLateFee, Date: day, EntryType: EntryFee, Amount: s.accruals.LateFee})
+		}
+	}
+
+	if s.accruals.OverLimitFee > 0 {
+		over, err := overLimit(ctx, tx, a.AccountID, balance.Balance)
+		if err != nil {
+			return 0, err
+		}
+		if over {
+			month := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+			charges = append(charges, accrualCharge{Kind: AccrualOverLimitFee, Date: month, EntryType: EntryFee, Amount: s.accruals.OverLimitFee})
+		}
+	}
+
+	if s.accruals.AnnualFee > 0 {
+		enrolled := a.EnrolledAt.UTC().Truncate(24 * time.Hour)
+		years := day.Year() - enrolled.Year()
+		if years > 0 && enrolled.AddDate(years, 0, 0).Equal(day) {
+			charges = append(charges, accrualCharge{Kind: AccrualAnnualFee, Date: day, EntryType: EntryFee, Amount: s.accruals.AnnualFee})
+		}
+	}
+
+	posted := 0
+	for _, c := range charges {
+		ok, err := s.charge(ctx, tx, a.AccountID, c)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			posted++
+		}
+	}
+	return posted, tx.Commit()
+}
+
+// accrualCharge is one interest or fee charge. Date is the day the charge is
+// for, and with Kind identifies it.
+type accrualCharge struct {
+	Kind      string
+	Date      time.Time
+	EntryType string
+	Amount    float64
+}
+
+// charge records c in the accruals table and posts it to the income account,
+// reporting false if it was already charged or rounds to nothing.
+func (s *Service) charge(ctx context.Context, tx *sql.Tx, accountID string, c accrualCharge) (bool, error) {
+	if c.Amount < 0.01 {
+		return false, nil
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO accruals (account_id, kind, accrual_date, amount)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (account_id, kind, accrual_date) DO NOTHING
+	`, accountID, c.Kind, c.Date, c.Amount)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	income := AccountFeeIncome
+	if c.EntryType == EntryInterest {
+		income = AccountInterestIncome
+	}
+	date := c.Date.Format("2006-01-02")
+	log.Printf("Charging %s of %.2f to %s for %s", c.Kind, c.Amount, accountID, date)
+	err = postJournal(ctx, tx, journal{
+		TransactionID: fmt.Sprintf("%s:%s:%s", c.Kind, accountID, date),
+		EntryType:     c.EntryType,
+		Reference:     fmt.Sprintf("%s:%s:%s", c.Kind, accountID, date),
+		Postings: []Posting{
+			{AccountID: accountID, Amount: c.Amount},
+			{AccountID: income, Amount: -c.Amount},
+		},
+	})
+	return err == nil, err
+}
+
+// minimumPaymentMissed reports whether a statement of accountID fell due on
+// day without its minimum payment being paid since it closed. Returned
+// payments do not count.
+func minimumPaymentMissed(ctx context.Context, tx *sql.Tx, accountID string, day time.Time) (bool, error) {
+	var missed bool
+	err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(-SUM(l.amount), 0) < s.minimum_payment_due
+		FROM statements s
+		LEFT JOIN ledger l ON l.account_id = s.account_id
+		                  AND l.entry_type IN ($3, $4)
+		                  AND l.created_at > s.period_end
+		                  AND l.created_at < $2::date + 1
+		WHERE s.account_id = $1 AND s.payment_due_date = $2::date AND s.minimum_payment_due > 0
+		GROUP BY s.statement_id, s.minimum_payment_due
+	`, accountID, day.Format("2006-01-02"), EntryPayment, EntryPaymentReturned).Scan(&missed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return missed, err
+}
+
+// overLimit reports whether balance exceeds the cardholder's credit limit,
+// as last announced by the authorization service.
+func overLimit(ctx context.Context, tx *sql.Tx, accountID string, balance float64) (bool, error) {
+	var over bool
+	err := tx.QueryRowContext(ctx,
+		"SELECT credit_limit < $2 FROM ledger_credit_limits WHERE account_id = $1",
+		accountID, balance).Scan(&over)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return over, err
+}
+
+// HandleCreditLimitChanged records a cardholder's credit limit for the
+// over-limit fee. Announcements older than the recorded limit are ignored,
+// so redelivered or reordered events cannot roll it back.
+func (s *Service) HandleCreditLimitChanged(ctx context.Context, msg transport.Message) error {
+	tr := otel.Tracer("ledger-service")
+	ctx, span := tr.Start(ctx, "HandleCreditLimitChanged")
+	defer span.End()
+
+	var event events.CreditLimitChanged
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return err
+	}
+
+	log.Printf("Recording credit limit %.2f for %s", event.CreditLimit, event.UserID)
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO ledger_credit_limits (account_id, credit_limit, changed_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (account_id) DO UPDATE
+		SET credit_limit = EXCLUDED.credit_limit, changed_at = EXCLUDED.changed_at
+		WHERE ledger_credit_limits.changed_at < EXCLUDED.changed_at
+	`, event.UserID, event.CreditLimit, event.ChangedAt)
+	return err
+}
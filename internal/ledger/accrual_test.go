@@ -0,0 +1,54 @@
+package ledger
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDayFraction(t *testing.T) {
+	tests := []struct {
+		dayCount string
+		day      time.Time
+		want     float64
+	}{
+		{DayCountActual365, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), 1.0 / 365},
+		{DayCountActual360, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), 1.0 / 360},
+		// Actual/actual depends on whether the day's year is a leap year.
+		{DayCountActualActual, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), 1.0 / 366},
+		{DayCountActualActual, time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC), 1.0 / 365},
+		{DayCountActualActual, time.Date(2100, 6, 1, 0, 0, 0, 0, time.UTC), 1.0 / 365},
+	}
+	for _, tt := range tests {
+		got, err := dayFraction(tt.dayCount, tt.day)
+		if err != nil || math.Abs(got-tt.want) > 1e-15 {
+			t.Errorf("dayFraction(%s, %s) = %v, %v; want %v", tt.dayCount, tt.day.Format("2006-01-02"), got, err, tt.want)
+		}
+	}
+	if _, err := dayFraction("30/360", time.Now()); err == nil {
+		t.Error("dayFraction accepted an unknown convention")
+	}
+}
+
+func TestMinimumPayment(t *testing.T) {
+	p := StatementPolicy{MinimumPaymentPercent: 0.01, MinimumPaymentFloor: 25}
+	tests := []struct {
+		name                    string
+		closing, fees, interest float64
+		want                    float64
+	}{
+		{"nothing owed", 0, 0, 0, 0},
+		{"credit balance", -40, 0, 0, 0},
+		{"floor", 1000, 0, 0, 25},
+		{"percentage over the floor", 5000, 0, 0, 50},
+		{"fees and interest on top", 5000, 35, 12.345, 97.35},
+		{"fees lifting it over the floor", 1000, 20, 0, 30},
+		{"never more than the balance", 18.5, 0, 0, 18.5},
+		{"balance below fees", 40, 35, 10, 40},
+	}
+	for _, tt := range tests {
+		if got := p.minimumPayment(tt.closing, tt.fees, tt.interest); got != tt.want {
+			t.Errorf("%s: minimumPayment(%v, %v, %v) = %v, want %v", tt.name, tt.closing, tt.fees, tt.interest, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Balance is an account's balance at a point in time. Positive balances are
+// debits, i.e. what a cardholder owes.
+type Balance struct {
+	AccountID string    `json:"account_id"`
+	AsOf      time.Time `json:"as_of"`
+	Balance   float64   `json:"balance"`
+	// SnapshotAsOf is the snapshot the balance was computed from, if any.
+	SnapshotAsOf *time.Time `json:"snapshot_as_of,omitempty"`
+}
+
+// BalanceAt returns accountID's balance including every entry written at or
+// before at: the latest snapshot taken by then plus the entries after it.
+func (s *Service) BalanceAt(ctx context.Context, accountID string, at time.Time) (Balance, error) {
+	return balanceAt(ctx, s.db, accountID, at)
+}
+
+// rowQueryer is satisfied by both *sql.DB and *sql.Tx.
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func balanceAt(ctx context.Context, q rowQueryer, accountID string, at time.Time) (Balance, error) {
+	b := Balance{AccountID: accountID, AsOf: at}
+	var snapshotAsOf sql.NullTime
+	err := q.QueryRowContext(ctx, `
+		WITH snapshot AS (
+			SELECT through_entry_id, as_of, balance
+			FROM ledger_balance_snapshots
+			WHERE account_id = $1 AND as_of <= $2
+			ORDER BY through_entry_id DESC
+			LIMIT 1
+		)
+		SELECT COALESCE((SELECT balance FROM snapshot), 0) + COALESCE((
+		           SELECT SUM(amount) FROM ledger
+		           WHERE account_id = $1
+		             AND entry_id > COALESCE((SELECT through_entry_id FROM snapshot), 0)
+		             AND created_at <= $2
+		       ), 0),
+		       (SELECT as_of FROM snapshot)
+	`, accountID, at).Scan(&b.Balance, &snapshotAsOf)
+	if err != nil {
+		return b, err
+	}
+	if snapshotAsOf.Valid {
+		b.SnapshotAsOf = &snapshotAsOf.Time
+	}
+	return b, nil
+}
+
+// TakeSnapshots snapshots the balance of every account with entries since the
+// previous run and returns how many snapshots were written. Each snapshot
+// extends the account's previous one, so only new entries are read.
+func (s *Service) TakeSnapshots(ctx context.Context) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Holding the chain head means every entry up to it is committed and any
+	// entry appended afterwards is dated after asOf.
+	var headEntryID int64
+	err = tx.QueryRowContext(ctx, "SELECT last_entry_id FROM ledger_chain_head WHERE id = 1 FOR UPDATE").Scan(&headEntryID)
+	if err != nil {
+		return 0, err
+	}
+	asOf := time.Now().UTC().Truncate(time.Microsecond)
+
+	var lastEntryID int64
+	err = tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(through_entry_id), 0) FROM ledger_balance_snapshots").Scan(&lastEntryID)
+	if err != nil {
+		return 0, err
+	}
+	if headEntryID <= lastEntryID {
+		return 0, nil
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO ledger_balance_snapshots (account_id, through_entry_id, as_of, balance)
+		SELECT d.account_id, $2, $3, COALESCE(prev.balance, 0) + d.amount
+		FROM (
+			SELECT account_id, SUM(amount) AS amount
+			FROM ledger
+			WHERE entry_id > $1 AND entry_id <= $2 AND account_id IS NOT NULL
+			GROUP BY account_id
+		) d
+		LEFT JOIN LATERAL (
+			SELECT balance FROM ledger_balance_snapshots s
+			WHERE s.account_id = d.account_id
+			ORDER BY through_entry_id DESC
+			LIMIT 1
+		) prev ON true
+	`, lastEntryID, headEntryID, asOf)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), tx.Commit()
+}
+
+// SnapshotDrift is a snapshot whose balance differs from its raw entries.
+type SnapshotDrift struct {
+	AccountID      string
+	ThroughEntryID int64
+	AsOf           time.Time
+	Snapshot       float64
+	Recomputed     float64
+}
+
+// CheckSnapshots recomputes every balance snapshot from the raw ledger
+// entries and returns the ones that have drifted.
+func CheckSnapshots(ctx context.Context, db *sql.DB) ([]SnapshotDrift, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT s.account_id, s.through_entry_id, s.as_of, s.balance, COALESCE(SUM(l.amount), 0)
+		FROM ledger_balance_snapshots s
+		LEFT JOIN ledger l ON l.account_id = s.account_id AND l.entry_id <= s.through_entry_id
+		GROUP BY s.account_id, s.through_entry_id, s.as_of, s.balance
+		HAVING s.balance <> COALESCE(SUM(l.amount), 0)
+		ORDER BY s.through_entry_id ASC, s.account_id ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var drifts []SnapshotDrift
+	for rows.Next() {
+		var d SnapshotDrift
+		if err := rows.Scan(&d.AccountID, &d.ThroughEntryID, &d.AsOf, &d.Snapshot, &d.Recomputed); err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, d)
+	}
+	return drifts, rows.Err()
+}
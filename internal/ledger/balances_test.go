@@ -0,0 +1,217 @@
+package ledger
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"credit-authorization-ledger/internal/dbtest"
+)
+
+// endOfTime is later than any entry or snapshot in the tests.
+var endOfTime = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type fakeSnapshot struct {
+	accountID      string
+	throughEntryID int64
+	asOf           time.Time
+	balance        float64
+}
+
+// sum adds up accountID's entries with IDs in (after, through] written at
+// or before at.
+func (f *fakeLedger) sum(accountID string, after, through int64, at time.Time) float64 {
+	var total float64
+	for _, e := range f.rows {
+		if e.AccountID == accountID && e.EntryID > after && e.EntryID <= through && !e.CreatedAt.After(at) {
+			amount, _ := strconv.ParseFloat(e.Amount, 64)
+			total += amount
+		}
+	}
+	return total
+}
+
+// latestSnapshot returns accountID's snapshot with the highest entry ID
+// taken at or before at.
+func (f *fakeLedger) latestSnapshot(accountID string, at time.Time) *fakeSnapshot {
+	var latest *fakeSnapshot
+	for i, s := range f.snapshots {
+		if s.accountID == accountID && !s.asOf.After(at) && (latest == nil || s.throughEntryID > latest.throughEntryID) {
+			latest = &f.snapshots[i]
+		}
+	}
+	return latest
+}
+
+func (f *fakeLedger) querySnapshots(query string, args []driver.NamedValue) (driver.Rows, error) {
+	switch {
+	case strings.Contains(query, "WITH snapshot AS"):
+		accountID, at := args[0].Value.(string), args[1].Value.(time.Time)
+		var after int64
+		var balance float64
+		var asOf driver.Value
+		if s := f.latestSnapshot(accountID, at); s != nil {
+			after, balance, asOf = s.throughEntryID, s.balance, s.asOf
+		}
+		return dbtest.Rows([]driver.Value{balance + f.sum(accountID, after, f.entrySeq, at), asOf}), nil
+	case strings.Contains(query, "MAX(through_entry_id)"):
+		var last int64
+		for _, s := range f.snapshots {
+			if s.throughEntryID > last {
+				last = s.throughEntryID
+			}
+		}
+		return dbtest.Rows([]driver.Value{last}), nil
+	case strings.Contains(query, "HAVING s.balance <>"):
+		var values [][]driver.Value
+		for _, s := range f.snapshots {
+			if recomputed := f.sum(s.accountID, 0, s.throughEntryID, endOfTime); recomputed != s.balance {
+				values = append(values, []driver.Value{s.accountID, s.throughEntryID, s.asOf, s.balance, recomputed})
+			}
+		}
+		return dbtest.Rows(values...), nil
+	}
+	return nil, fmt.Errorf("unexpected query %q", query)
+}
+
+func (f *fakeLedger) takeSnapshots(after, through int64, asOf time.Time) int64 {
+	accounts := map[string]bool{}
+	for _, e := range f.rows {
+		if e.EntryID > after && e.EntryID <= through && e.AccountID != "" {
+			accounts[e.AccountID] = true
+		}
+	}
+	var ids []string
+	for id := range accounts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		var balance float64
+		if prev := f.latestSnapshot(id, endOfTime); prev != nil {
+			balance = prev.balance
+		}
+		f.snapshots = append(f.snapshots, fakeSnapshot{id, through, asOf, balance + f.sum(id, after, through, endOfTime)})
+	}
+	return int64(len(ids))
+}
+
+func TestBalanceSnapshots(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeLedger()
+	db := dbtest.Open(f)
+	s := NewService(db)
+
+	post := func(user string, amount float64, at time.Time) {
+		t.Helper()
+		j := journal{TransactionID: fmt.Sprintf("tx-%d", f.journalSeq+1), EntryType: EntryCreditAuthorized, Postings: cardholderDebit(user, amount)}
+		if err := postTx(t, db, j); err != nil {
+			t.Fatalf("postJournal: %v", err)
+		}
+		for i := len(f.rows) - 2; i < len(f.rows); i++ {
+			f.rows[i].CreatedAt = at
+		}
+	}
+	balance := func(account string, at time.Time) Balance {
+		t.Helper()
+		b, err := s.BalanceAt(ctx, account, at)
+		if err != nil {
+			t.Fatalf("BalanceAt(%s): %v", account, err)
+		}
+		return b
+	}
+	take := func(want int) {
+		t.Helper()
+		if n, err := s.TakeSnapshots(ctx); err != nil || n != want {
+			t.Fatalf("TakeSnapshots = %d, %v; want %d", n, err, want)
+		}
+	}
+
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	post("user-1", 10, day)
+	post("user-2", 5, day.Add(time.Hour))
+	post("user-1", 20, day.Add(2*time.Hour))
+	if b := balance("user-1", day.Add(time.Hour)); b.Balance != 10 || b.SnapshotAsOf != nil {
+		t.Errorf("balance before any snapshot = %+v, want 10 from raw entries", b)
+	}
+
+	take(3) // user-1, user-2 and the settlement account
+	take(0) // nothing new since
+	snapshotAsOf := f.snapshots[0].asOf
+
+	post("user-1", -12.5, snapshotAsOf.Add(time.Hour))
+	if b := balance("user-1", snapshotAsOf.Add(2*time.Hour)); b.Balance != 17.5 || b.SnapshotAsOf == nil || !b.SnapshotAsOf.Equal(snapshotAsOf) {
+		t.Errorf("balance after the snapshot = %+v, want 17.50 from the snapshot plus later entries", b)
+	}
+	if b := balance(AccountMerchantSettlement, snapshotAsOf.Add(2*time.Hour)); b.Balance != -22.5 {
+		t.Errorf("settlement balance = %+v, want -22.50", b)
+	}
+
+	// Later snapshots only cover accounts with new entries, extending the
+	// previous snapshot.
+	take(2)
+	if latest := f.latestSnapshot("user-1", endOfTime); latest.throughEntryID != 8 || latest.balance != 17.5 {
+		t.Errorf("latest user-1 snapshot = %+v, want 17.50 through entry 8", *latest)
+	}
+	if latest := f.latestSnapshot("user-2", endOfTime); latest.throughEntryID != 6 {
+		t.Errorf("latest user-2 snapshot = %+v, want the first one through entry 6", *latest)
+	}
+
+	if drifts, err := CheckSnapshots(ctx, db); err != nil || len(drifts) != 0 {
+		t.Fatalf("CheckSnapshots = %v, %v; want no drift", drifts, err)
+	}
+	f.latestSnapshot("user-2", endOfTime).balance = 6
+	drifts, err := CheckSnapshots(ctx, db)
+	if err != nil || len(drifts) != 1 {
+		t.Fatalf("CheckSnapshots = %v, %v; want one drift", drifts, err)
+	}
+	if d := drifts[0]; d.AccountID != "user-2" || d.Snapshot != 6 || d.Recomputed != 5 {
+		t.Errorf("drift = %+v, want user-2 snapshot 6 recomputed 5", d)
+	}
+}
+
+func TestBalanceSnapshotsPostgres(t *testing.T) {
+	ctx := context.Background()
+	db := dbtest.Postgres(t, dbtest.Ledger)
+	s := NewService(db)
+
+	post := func(tx, user string, amount float64) {
+		t.Helper()
+		if err := postTx(t, db, journal{TransactionID: tx, EntryType: EntryCreditAuthorized, Postings: cardholderDebit(user, amount)}); err != nil {
+			t.Fatalf("postJournal: %v", err)
+		}
+	}
+	post("tx-1", "user-1", 10)
+	post("tx-2", "user-2", 5)
+	if n, err := s.TakeSnapshots(ctx); err != nil || n != 3 {
+		t.Fatalf("TakeSnapshots = %d, %v; want 3", n, err)
+	}
+	post("tx-3", "user-1", -2.5)
+
+	b, err := s.BalanceAt(ctx, "user-1", time.Now())
+	if err != nil {
+		t.Fatalf("BalanceAt: %v", err)
+	}
+	if b.Balance != 7.5 || b.SnapshotAsOf == nil {
+		t.Errorf("balance = %+v, want 7.50 from the snapshot plus later entries", b)
+	}
+	if n, err := s.TakeSnapshots(ctx); err != nil || n != 2 {
+		t.Fatalf("TakeSnapshots = %d, %v; want user-1 and the settlement account", n, err)
+	}
+
+	if drifts, err := CheckSnapshots(ctx, db); err != nil || len(drifts) != 0 {
+		t.Fatalf("CheckSnapshots = %v, %v; want no drift", drifts, err)
+	}
+	if _, err := db.Exec("UPDATE ledger_balance_snapshots SET balance = 6 WHERE account_id = 'user-2'"); err != nil {
+		t.Fatal(err)
+	}
+	drifts, err := CheckSnapshots(ctx, db)
+	if err != nil || len(drifts) != 1 || drifts[0].AccountID != "user-2" || drifts[0].Recomputed != 5 {
+		t.Errorf("CheckSnapshots = %+v, %v; want user-2 recomputed at 5", drifts, err)
+	}
+}
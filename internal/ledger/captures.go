@@ -0,0 +1,44 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"credit-authorization-ledger/internal/transport"
+	"credit-authorization-ledger/pkg/events"
+
+	"go.opentelemetry.io/otel"
+)
+
+// HandleCapture posts the difference between a transaction's cleared and
+// authorized amounts. Force-posted transactions are posted in full.
+func (s *Service) HandleCapture(ctx context.Context, msg transport.Message) error {
+	tr := otel.Tracer("ledger-service")
+	ctx, span := tr.Start(ctx, "HandleCapture")
+	defer span.End()
+
+	var event events.CaptureSucceeded
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return err
+	}
+
+	entryType := EntryCaptureAdjustment
+	if event.ForcePosted {
+		entryType = EntryForcePost
+	}
+	if event.Adjustment == 0 {
+		log.Printf("Transaction %s cleared at its authorized amount; nothing to post", event.TransactionID)
+		return nil
+	}
+
+	log.Printf("Recording %s of %f for transaction %s", entryType, event.Adjustment, event.TransactionID)
+	return s.post(ctx, journal{
+		TransactionID: event.TransactionID,
+		EntryType:     entryType,
+		// A transaction clears once.
+		Reference: event.TransactionID,
+		Postings:  cardholderDebit(event.UserID, event.Adjustment),
+	})
+}
@@ -0,0 +1,170 @@
+package ledger
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// genesisHash is the previous hash of the first entry in the chain.
+var genesisHash = strings.Repeat("0", 64)
+
+// chainedEntry holds the columns of a ledger row covered by its hash.
+type chainedEntry struct {
+	EntryID       int64
+	JournalID     int64
+	TransactionID string
+	EntryType     string
+	AccountID     string
+	// Amount is the NUMERIC(12, 2) text form, e.g. "-12.50".
+	Amount    string
+	Reference string
+	CreatedAt time.Time
+	PrevHash  string
+	// The original amount and conversion of a foreign-currency posting, in
+	// NUMERIC text form. All are empty for billing-currency postings.
+	OriginalAmount   string
+	OriginalCurrency string
+	FXRate           string
+	FXMarkup         string
+}
+
+// entryHash is the SHA-256 of the entry's canonical form. The backfill in
+// migration 000003 builds the same form in SQL. Foreign-currency fields are
+// only appended when present, so earlier entries hash as before.
+func entryHash(e chainedEntry) string {
+	fields := []string{
+		strconv.FormatInt(e.EntryID, 10),
+		strconv.FormatInt(e.JournalID, 10),
+		e.TransactionID,
+		e.EntryType,
+		e.AccountID,
+		e.Amount,
+		e.Reference,
+		strconv.FormatInt(e.CreatedAt.UnixMicro(), 10),
+		e.PrevHash,
+	}
+	if e.OriginalCurrency != "" {
+		fields = append(fields, e.OriginalAmount, e.OriginalCurrency, e.FXRate, e.FXMarkup)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "|")))
+	return hex.EncodeToString(sum[:])
+}
+
+// formatAmount renders amount the way Postgres renders a NUMERIC(12, 2).
+func formatAmount(amount float64) string {
+	// Adding zero turns -0 into 0, which Postgres would print as "0.00".
+	return strconv.FormatFloat(amount+0, 'f', 2, 64)
+}
+
+// formatRate renders an exchange rate as a NUMERIC(18, 8) and a markup as a
+// NUMERIC(6, 4).
+func formatRate(rate, markup float64) (string, string) {
+	return strconv.FormatFloat(rate, 'f', 8, 64), strconv.FormatFloat(markup+0, 'f', 4, 64)
+}
+
+// lockChainHead locks the head of the hash chain until tx ends and returns
+// the hash of the newest entry. Every ledger write goes through it, which
+// keeps entry IDs and chain order identical.
+func lockChainHead(ctx context.Context, tx *sql.Tx) (string, error) {
+	var lastHash string
+	err := tx.QueryRowContext(ctx, "SELECT last_hash FROM ledger_chain_head WHERE id = 1 FOR UPDATE").Scan(&lastHash)
+	return lastHash, err
+}
+
+// appendEntry chains e onto prevHash, inserts it and returns its hash.
+func appendEntry(ctx context.Context, tx *sql.Tx, e chainedEntry, prevHash string) (string, error) {
+	if err := tx.QueryRowContext(ctx, "SELECT nextval('ledger_entry_id_seq')").Scan(&e.EntryID); err != nil {
+		return "", err
+	}
+	e.PrevHash = prevHash
+	hash := entryHash(e)
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO ledger (entry_id, journal_id, transaction_id, entry_type, account_id, amount, reference, created_at, prev_hash, hash,
+		                    original_amount, original_currency, fx_rate, fx_markup)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $9, $10,
+		        NULLIF($11, '')::numeric, NULLIF($12, ''), NULLIF($13, '')::numeric, NULLIF($14, '')::numeric)
+	`, e.EntryID, e.JournalID, e.TransactionID, e.EntryType, e.AccountID, e.Amount, e.Reference, e.CreatedAt, e.PrevHash, hash,
+		e.OriginalAmount, e.OriginalCurrency, e.FXRate, e.FXMarkup)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE ledger_chain_head SET last_entry_id = $1, last_hash = $2 WHERE id = 1",
+		e.EntryID, hash)
+	return hash, err
+}
+
+// ChainBreak describes the first entry at which the hash chain fails.
+type ChainBreak struct {
+	EntryID int64
+	Reason  string
+}
+
+func (b *ChainBreak) String() string {
+	return fmt.Sprintf("entry %d: %s", b.EntryID, b.Reason)
+}
+
+// VerifyChain walks the ledger in entry order, recomputing every hash. It
+// returns the number of entries checked and the first broken link, or a
+// nil break if the chain is intact.
+func VerifyChain(ctx context.Context, db *sql.DB) (int, *ChainBreak, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT entry_id, COALESCE(journal_id, 0), transaction_id, entry_type, COALESCE(account_id, ''),
+		       amount::text, COALESCE(reference, ''), created_at, prev_hash, hash,
+		       COALESCE(original_amount::text, ''), COALESCE(original_currency, ''),
+		       COALESCE(fx_rate::text, ''), COALESCE(fx_markup::text, '')
+		FROM ledger
+		ORDER BY entry_id ASC
+	`)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	checked := 0
+	prev := genesisHash
+	var lastEntryID int64
+	for rows.Next() {
+		var e chainedEntry
+		var storedHash string
+		err := rows.Scan(&e.EntryID, &e.JournalID, &e.TransactionID, &e.EntryType, &e.AccountID,
+			&e.Amount, &e.Reference, &e.CreatedAt, &e.PrevHash, &storedHash,
+			&e.OriginalAmount, &e.OriginalCurrency, &e.FXRate, &e.FXMarkup)
+		if err != nil {
+			return checked, nil, err
+		}
+		checked++
+
+		if e.PrevHash != prev {
+			return checked, &ChainBreak{EntryID: e.EntryID, Reason: "previous hash does not match the preceding entry; an entry was removed or reordered"}, nil
+		}
+		if entryHash(e) != storedHash {
+			return checked, &ChainBreak{EntryID: e.EntryID, Reason: "contents do not match the stored hash; the entry was modified"}, nil
+		}
+		prev = storedHash
+		lastEntryID = e.EntryID
+	}
+	if err := rows.Err(); err != nil {
+		return checked, nil, err
+	}
+
+	// Entries removed from the end of the chain leave the head pointing past them.
+	var headEntryID int64
+	var headHash string
+	err = db.QueryRowContext(ctx, "SELECT last_entry_id, last_hash FROM ledger_chain_head WHERE id = 1").Scan(&headEntryID, &headHash)
+	if err != nil {
+		return checked, nil, err
+	}
+	if headEntryID != lastEntryID || headHash != prev {
+		return checked, &ChainBreak{EntryID: headEntryID, Reason: "chain head does not match the last entry; trailing entries were removed"}, nil
+	}
+	return checked, nil, nil
+}
@@ -0,0 +1,128 @@
+package ledger
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"credit-authorization-ledger/internal/dbtest"
+)
+
+// backfillFields is the canonical form built by the backfill in migration
+// 000003, one concat_ws argument per line, in order.
+var backfillFields = []string{
+	"r.entry_id",
+	"COALESCE(r.journal_id, 0)",
+	"r.transaction_id",
+	"r.entry_type",
+	"COALESCE(r.account_id, '')",
+	"r.amount::text",
+	"COALESCE(r.reference, '')",
+	"EXTRACT(EPOCH FROM date_trunc('second', r.created_at))::bigint * 1000000 + EXTRACT(MICROSECONDS FROM r.created_at)::bigint % 1000000",
+	"prev",
+}
+
+func TestBackfillCanonicalForm(t *testing.T) {
+	data, err := os.ReadFile("migrations/000003_add_ledger_hash_chain.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := regexp.MustCompile(`(?s)concat_ws\('\|',(.*?)\), 'UTF8'\)`).FindSubmatch(data)
+	if m == nil {
+		t.Fatal("no concat_ws in the backfill")
+	}
+	var fields []string
+	for _, line := range strings.Split(string(m[1]), ",\n") {
+		fields = append(fields, strings.Join(strings.Fields(line), " "))
+	}
+	if strings.Join(fields, "\n") != strings.Join(backfillFields, "\n") {
+		t.Fatalf("backfill hashes\n%s\nwant\n%s\nChange entryHash and this test with it.", strings.Join(fields, "\n"), strings.Join(backfillFields, "\n"))
+	}
+}
+
+func TestEntryHashMatchesBackfill(t *testing.T) {
+	sqlHash := func(canonical string) string {
+		sum := sha256.Sum256([]byte(canonical))
+		return hex.EncodeToString(sum[:])
+	}
+	createdAt := time.Date(2024, 3, 1, 12, 30, 15, 123456000, time.UTC)
+
+	tests := []struct {
+		name string
+		e    chainedEntry
+		// sql is the string the backfill hashes for the same row, each
+		// field as Postgres renders it.
+		sql string
+	}{
+		{
+			"debit",
+			chainedEntry{EntryID: 7, JournalID: 3, TransactionID: "tx-1", EntryType: EntryCreditAuthorized,
+				AccountID: "user-1", Amount: "12.50", CreatedAt: createdAt, PrevHash: genesisHash},
+			"7|3|tx-1|CREDIT_AUTHORIZED|user-1|12.50||1709296215123456|" + genesisHash,
+		},
+		{
+			// Rows written before journals have a NULL journal, account and
+			// reference, which the backfill renders as 0 and empty strings.
+			"legacy row",
+			chainedEntry{EntryID: 1, TransactionID: "tx-0", EntryType: EntryCreditAuthorized,
+				Amount: "-0.01", CreatedAt: createdAt.Truncate(time.Second), PrevHash: strings.Repeat("a", 64)},
+			"1|0|tx-0|CREDIT_AUTHORIZED||-0.01||1709296215000000|" + strings.Repeat("a", 64),
+		},
+		{
+			"reference in another zone",
+			chainedEntry{EntryID: 12, JournalID: 9, TransactionID: "tx-2", EntryType: EntryRefund, AccountID: "settlement",
+				Amount: "25.00", Reference: "refund-1", CreatedAt: createdAt.In(time.FixedZone("CET", 3600)), PrevHash: genesisHash},
+			"12|9|tx-2|REFUND|settlement|25.00|refund-1|1709296215123456|" + genesisHash,
+		},
+	}
+	for _, tt := range tests {
+		if got, want := entryHash(tt.e), sqlHash(tt.sql); got != want {
+			t.Errorf("%s: entryHash = %s, backfill would store %s", tt.name, got, want)
+		}
+	}
+
+	if got := formatAmount(-0.0); got != "0.00" {
+		t.Errorf("formatAmount(-0) = %q, want 0.00 as Postgres prints it", got)
+	}
+}
+
+func TestVerifyChainDetectsTampering(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeLedger()
+	db := dbtest.Open(f)
+	for i, account := range []string{"user-1", "user-2", "user-3"} {
+		j := journal{TransactionID: "tx-" + account, EntryType: EntryCreditAuthorized, Postings: cardholderDebit(account, float64(10*(i+1)))}
+		if err := postTx(t, db, j); err != nil {
+			t.Fatalf("postJournal: %v", err)
+		}
+	}
+	if checked, broken, err := VerifyChain(ctx, db); err != nil || broken != nil || checked != 6 {
+		t.Fatalf("VerifyChain = %d, %v, %v; want 6 intact entries", checked, broken, err)
+	}
+
+	// A modified amount breaks the entry's own hash.
+	f.rows[2].Amount = "200.00"
+	if _, broken, err := VerifyChain(ctx, db); err != nil || broken == nil || broken.EntryID != 3 {
+		t.Errorf("after modifying entry 3, VerifyChain = %v, %v; want a break at entry 3", broken, err)
+	}
+	f.rows[2].Amount = "20.00"
+
+	// A removed entry breaks the link from the entry after it.
+	rows, hashes := f.rows, f.hashes
+	f.rows = append(append([]chainedEntry{}, rows[:1]...), rows[2:]...)
+	f.hashes = append(append([]string{}, hashes[:1]...), hashes[2:]...)
+	if _, broken, err := VerifyChain(ctx, db); err != nil || broken == nil || broken.EntryID != 3 {
+		t.Errorf("after removing entry 2, VerifyChain = %v, %v; want a break at entry 3", broken, err)
+	}
+
+	// Entries removed from the end are caught by the chain head.
+	f.rows, f.hashes = rows[:4], hashes[:4]
+	if _, broken, err := VerifyChain(ctx, db); err != nil || broken == nil || broken.EntryID != 6 {
+		t.Errorf("after truncating the ledger, VerifyChain = %v, %v; want a break at head entry 6", broken, err)
+	}
+}
@@ -0,0 +1,152 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"credit-authorization-ledger/internal/outbox"
+	"credit-authorization-ledger/internal/transport"
+	"credit-authorization-ledger/pkg/events"
+
+	"go.opentelemetry.io/otel"
+)
+
+// AccountDisputesProvisional holds amounts provisionally credited to
+// cardholders for open disputes until the dispute is decided.
+const AccountDisputesProvisional = "system:disputes-provisional"
+
+// Dispute failure reasons.
+const (
+	ReasonDisputeExceedsPosted    = "dispute exceeds posted amount"
+	ReasonDisputeNotProvisional   = "dispute has no provisional credit posted"
+	ReasonUnexpectedDisputeStatus = "unexpected dispute status"
+)
+
+// disputeEntryTypes maps each dispute transition that moves money to its
+// ledger entry type.
+var disputeEntryTypes = map[string]string{
+	"OPENED": EntryDisputeProvisionalCredit,
+	"WON":    EntryDisputeWon,
+	"LOST":   EntryDisputeLost,
+}
+
+// disputePostings builds the postings for a dispute transition. Opening
+// moves the amount from the cardholder to the provisional account; winning
+// charges it back to the merchant; losing returns it to the cardholder.
+func disputePostings(entryType, userID string, amount float64) []Posting {
+	switch entryType {
+	case EntryDisputeProvisionalCredit:
+		return []Posting{
+			{AccountID: userID, Amount: -amount},
+			{AccountID: AccountDisputesProvisional, Amount: amount},
+		}
+	case EntryDisputeWon:
+		return []Posting{
+			{AccountID: AccountDisputesProvisional, Amount: -amount},
+			{AccountID: AccountMerchantSettlement, Amount: amount},
+		}
+	}
+	return []Posting{
+		{AccountID: userID, Amount: amount},
+		{AccountID: AccountDisputesProvisional, Amount: -amount},
+	}
+}
+
+// HandleDispute posts a dispute transition. A provisional credit larger than
+// what the ledger holds for the transaction is rejected, and a decision on a
+// dispute whose provisional credit was never posted is rejected, so that the
+// saga compensates them.
+func (s *Service) HandleDispute(ctx context.Context, msg transport.Message) error {
+	tr := otel.Tracer("ledger-service")
+	ctx, span := tr.Start(ctx, "HandleDispute")
+	defer span.End()
+
+	var event events.DisputeTransitioned
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	entryType, ok := disputeEntryTypes[event.Status]
+	if !ok {
+		return fmt.Errorf("%s %q for dispute %s", ReasonUnexpectedDisputeStatus, event.Status, event.DisputeID)
+	}
+
+	posted, err := journalExists(ctx, tx, entryType, event.DisputeID)
+	if err != nil {
+		return err
+	}
+	if posted {
+		log.Printf("Dispute %s already posted as %s", event.DisputeID, event.Status)
+		return nil
+	}
+
+	var reason string
+	if entryType == EntryDisputeProvisionalCredit {
+		// Compare in SQL so the check runs on NUMERIC rather than floats.
+		var covered bool
+		err = tx.QueryRowContext(ctx, `
+			SELECT COALESCE(SUM(amount), 0) >= $3
+			FROM ledger
+			WHERE transaction_id = $1 AND account_id = $2
+		`, event.TransactionID, event.UserID, event.Amount).Scan(&covered)
+		if err != nil {
+			return err
+		}
+		if !covered {
+			reason = ReasonDisputeExceedsPosted
+		}
+	} else {
+		provisional, err := journalExists(ctx, tx, EntryDisputeProvisionalCredit, event.DisputeID)
+		if err != nil {
+			return err
+		}
+		if !provisional {
+			reason = ReasonDisputeNotProvisional
+		}
+	}
+
+	if reason != "" {
+		log.Printf("Rejecting %s posting for dispute %s: %s", event.Status, event.DisputeID, reason)
+		failedEvent := events.DisputeLedgerFailed{
+			DisputeID:     event.DisputeID,
+			TransactionID: event.TransactionID,
+			Status:        event.Status,
+			Reason:        reason,
+		}
+		if err := outbox.NewWriter(tx).Write(ctx, "dispute-ledger-failed", event.TransactionID, failedEvent); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	log.Printf("Recording %s of %f for dispute %s on transaction %s", entryType, event.Amount, event.DisputeID, event.TransactionID)
+	err = postJournal(ctx, tx, journal{
+		TransactionID: event.TransactionID,
+		EntryType:     entryType,
+		Reference:     event.DisputeID,
+		Postings:      disputePostings(entryType, event.UserID, event.Amount),
+	})
+	if errors.Is(err, errAlreadyPosted) {
+		log.Printf("Dispute %s already posted as %s", event.DisputeID, event.Status)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	postedEvent := events.DisputeLedgerPosted{DisputeID: event.DisputeID, TransactionID: event.TransactionID, Status: event.Status}
+	if err := outbox.NewWriter(tx).Write(ctx, "dispute-ledger-posted", event.TransactionID, postedEvent); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,95 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"credit-authorization-ledger/internal/dbtest"
+	"credit-authorization-ledger/internal/transport"
+	"credit-authorization-ledger/pkg/events"
+)
+
+func TestDisputePostings(t *testing.T) {
+	for status, entryType := range disputeEntryTypes {
+		var total float64
+		accounts := map[string]float64{}
+		for _, p := range disputePostings(entryType, "user-1", 40) {
+			total += p.Amount
+			accounts[p.AccountID] += p.Amount
+		}
+		if total != 0 {
+			t.Errorf("%s postings add up to %.2f, want a balanced journal", status, total)
+		}
+		// Opening credits the cardholder, losing charges them again, and
+		// winning leaves them alone.
+		want := map[string]float64{"OPENED": -40, "WON": 0, "LOST": 40}[status]
+		if accounts["user-1"] != want {
+			t.Errorf("%s posts %.2f to the cardholder, want %.2f", status, accounts["user-1"], want)
+		}
+	}
+}
+
+func TestHandleDispute(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeLedger()
+	db := dbtest.Open(f)
+	s := NewService(db)
+
+	purchase := journal{TransactionID: "tx-1", EntryType: EntryCreditAuthorized, Reference: "tx-1", Postings: cardholderDebit("user-1", 100)}
+	if err := postTx(t, db, purchase); err != nil {
+		t.Fatalf("postJournal: %v", err)
+	}
+
+	handle := func(disputeID, status string, amount float64) error {
+		t.Helper()
+		value, err := json.Marshal(events.DisputeTransitioned{
+			DisputeID: disputeID, TransactionID: "tx-1", UserID: "user-1", Amount: amount, Status: status,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.HandleDispute(ctx, transport.Message{Topic: "dispute-ledger-requests", Key: []byte("tx-1"), Value: value})
+	}
+	expect := func(step string, rows int, outbox ...string) {
+		t.Helper()
+		if len(f.rows) != rows || strings.Join(f.outbox, ",") != strings.Join(outbox, ",") {
+			t.Errorf("after %s: %d ledger rows and outbox %v; want %d rows and %v", step, len(f.rows), f.outbox, rows, outbox)
+		}
+	}
+
+	if err := handle("d-1", "OPENED", 40); err != nil {
+		t.Fatalf("HandleDispute: %v", err)
+	}
+	expect("opening", 4, "dispute-ledger-posted")
+
+	if err := handle("d-1", "OPENED", 40); err != nil {
+		t.Fatalf("redelivered HandleDispute: %v", err)
+	}
+	expect("a redelivered opening", 4, "dispute-ledger-posted")
+
+	// Only 60 of the transaction is left on the cardholder's account.
+	if err := handle("d-2", "OPENED", 80); err != nil {
+		t.Fatalf("HandleDispute: %v", err)
+	}
+	expect("an opening over the posted amount", 4, "dispute-ledger-posted", "dispute-ledger-failed")
+
+	// Its decision is rejected too, as no provisional credit was posted.
+	if err := handle("d-2", "LOST", 80); err != nil {
+		t.Fatalf("HandleDispute: %v", err)
+	}
+	expect("a decision without a provisional credit", 4, "dispute-ledger-posted", "dispute-ledger-failed", "dispute-ledger-failed")
+
+	if err := handle("d-1", "WON", 40); err != nil {
+		t.Fatalf("HandleDispute: %v", err)
+	}
+	expect("winning", 6, "dispute-ledger-posted", "dispute-ledger-failed", "dispute-ledger-failed", "dispute-ledger-posted")
+	if won := f.rows[4:]; won[0].AccountID != AccountDisputesProvisional || won[1].AccountID != AccountMerchantSettlement || won[1].Amount != "40.00" {
+		t.Errorf("won dispute postings = %+v, want the provisional credit charged back to the merchant", won)
+	}
+
+	if err := handle("d-1", "EVIDENCE_REQUESTED", 40); err == nil {
+		t.Error("HandleDispute accepted a status that moves no money")
+	}
+}
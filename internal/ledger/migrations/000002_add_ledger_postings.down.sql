@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_ledger_reference;
+DROP INDEX IF EXISTS idx_ledger_account_id;
+ALTER TABLE ledger DROP COLUMN IF EXISTS reference;
+ALTER TABLE ledger DROP COLUMN IF EXISTS amount;
+ALTER TABLE ledger DROP COLUMN IF EXISTS account_id;
+ALTER TABLE ledger DROP COLUMN IF EXISTS journal_id;
+DROP SEQUENCE IF EXISTS ledger_journal_id_seq;
@@ -0,0 +1,14 @@
+-- Entries become double-entry postings: every journal is a set of rows
+-- sharing a journal_id whose amounts sum to zero. Positive amounts are
+-- debits, so a cardholder account's balance is the amount they owe.
+CREATE SEQUENCE IF NOT EXISTS ledger_journal_id_seq;
+
+ALTER TABLE ledger ADD COLUMN IF NOT EXISTS journal_id BIGINT;
+ALTER TABLE ledger ADD COLUMN IF NOT EXISTS account_id VARCHAR(255);
+ALTER TABLE ledger ADD COLUMN IF NOT EXISTS amount NUMERIC(12, 2) NOT NULL DEFAULT 0;
+-- Identifies the business operation behind a journal (e.g. a refund ID) so
+-- redelivered commands are not posted twice.
+ALTER TABLE ledger ADD COLUMN IF NOT EXISTS reference VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_account_id ON ledger (account_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_ledger_reference ON ledger (entry_type, reference);
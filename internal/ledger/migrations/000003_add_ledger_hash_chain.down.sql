@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS ledger_no_truncate ON ledger;
+DROP TRIGGER IF EXISTS ledger_no_update_delete ON ledger;
+DROP FUNCTION IF EXISTS ledger_append_only();
+DROP TABLE IF EXISTS ledger_chain_head;
+ALTER TABLE ledger DROP COLUMN IF EXISTS hash;
+ALTER TABLE ledger DROP COLUMN IF EXISTS prev_hash;
@@ -0,0 +1,62 @@
+-- Tamper evidence: every row carries the hash of its contents and of the
+-- previous row, forming one chain over the whole ledger in entry_id order.
+ALTER TABLE ledger ADD COLUMN IF NOT EXISTS prev_hash CHAR(64);
+ALTER TABLE ledger ADD COLUMN IF NOT EXISTS hash CHAR(64);
+
+-- The newest link of the chain. Writers lock this row to append.
+CREATE TABLE IF NOT EXISTS ledger_chain_head (
+    id INT PRIMARY KEY CHECK (id = 1),
+    last_entry_id BIGINT NOT NULL,
+    last_hash CHAR(64) NOT NULL
+);
+
+-- Chain the rows written before this migration. The canonical form must
+-- match entryHash in internal/ledger/chain.go.
+DO $$
+DECLARE
+    r RECORD;
+    prev CHAR(64) := repeat('0', 64);
+    h CHAR(64);
+    last_id BIGINT := 0;
+BEGIN
+    FOR r IN SELECT * FROM ledger WHERE hash IS NULL ORDER BY entry_id LOOP
+        h := encode(sha256(convert_to(concat_ws('|',
+            r.entry_id,
+            COALESCE(r.journal_id, 0),
+            r.transaction_id,
+            r.entry_type,
+            COALESCE(r.account_id, ''),
+            r.amount::text,
+            COALESCE(r.reference, ''),
+            EXTRACT(EPOCH FROM date_trunc('second', r.created_at))::bigint * 1000000
+                + EXTRACT(MICROSECONDS FROM r.created_at)::bigint % 1000000,
+            prev), 'UTF8')), 'hex');
+        UPDATE ledger SET prev_hash = prev, hash = h WHERE entry_id = r.entry_id;
+        prev := h;
+        last_id := r.entry_id;
+    END LOOP;
+
+    INSERT INTO ledger_chain_head (id, last_entry_id, last_hash)
+    VALUES (1, last_id, prev)
+    ON CONFLICT (id) DO NOTHING;
+END $$;
+
+ALTER TABLE ledger ALTER COLUMN prev_hash SET NOT NULL;
+ALTER TABLE ledger ALTER COLUMN hash SET NOT NULL;
+
+-- Ledger rows can only ever be inserted.
+CREATE OR REPLACE FUNCTION ledger_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger is append-only: % is not allowed', TG_OP;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_no_update_delete ON ledger;
+CREATE TRIGGER ledger_no_update_delete
+    BEFORE UPDATE OR DELETE ON ledger
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+DROP TRIGGER IF EXISTS ledger_no_truncate ON ledger;
+CREATE TRIGGER ledger_no_truncate
+    BEFORE TRUNCATE ON ledger
+    FOR EACH STATEMENT EXECUTE FUNCTION ledger_append_only();
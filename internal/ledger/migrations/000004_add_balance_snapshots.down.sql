@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_ledger_account_entry;
+DROP TABLE IF EXISTS ledger_balance_snapshots;
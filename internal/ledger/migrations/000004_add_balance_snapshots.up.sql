@@ -0,0 +1,17 @@
+-- Periodic per-account balances. A snapshot holds the sum of every entry for
+-- the account up to and including through_entry_id, all of which were
+-- written no later than as_of.
+CREATE TABLE IF NOT EXISTS ledger_balance_snapshots (
+    account_id VARCHAR(255) NOT NULL,
+    through_entry_id BIGINT NOT NULL,
+    as_of TIMESTAMPTZ NOT NULL,
+    balance NUMERIC(14, 2) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (account_id, through_entry_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_balance_snapshots_as_of ON ledger_balance_snapshots (account_id, as_of);
+CREATE INDEX IF NOT EXISTS idx_ledger_balance_snapshots_through ON ledger_balance_snapshots (through_entry_id);
+
+-- Balances add up an account's entries after its latest snapshot.
+CREATE INDEX IF NOT EXISTS idx_ledger_account_entry ON ledger (account_id, entry_id);
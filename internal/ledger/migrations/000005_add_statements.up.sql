@@ -0,0 +1,54 @@
+-- Billing cycle of each cardholder account. A cycle closes at midnight UTC on
+-- statement_day; the statement is due payment_due_days later.
+CREATE TABLE IF NOT EXISTS billing_cycles (
+    account_id VARCHAR(255) PRIMARY KEY,
+    statement_day SMALLINT NOT NULL CHECK (statement_day BETWEEN 1 AND 28),
+    payment_due_days INT NOT NULL CHECK (payment_due_days > 0),
+    -- Start of the open cycle: the end of the last statement, or when the
+    -- account was enrolled.
+    cycle_start TIMESTAMPTZ NOT NULL,
+    next_close_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Cardholders posted to before billing cycles existed close on the 1st.
+INSERT INTO billing_cycles (account_id, statement_day, payment_due_days, cycle_start, next_close_at)
+SELECT account_id, 1, 25, MIN(created_at),
+       (date_trunc('month', MIN(created_at) AT TIME ZONE 'UTC') + INTERVAL '1 month') AT TIME ZONE 'UTC'
+FROM ledger
+WHERE account_id IS NOT NULL AND account_id NOT LIKE 'system:%'
+GROUP BY account_id
+ON CONFLICT (account_id) DO NOTHING;
+
+CREATE INDEX IF NOT EXISTS idx_billing_cycles_next_close_at ON billing_cycles (next_close_at);
+
+-- A closed billing cycle. Balances follow the ledger convention: positive is
+-- owed by the cardholder.
+CREATE TABLE IF NOT EXISTS statements (
+    statement_id VARCHAR(255) PRIMARY KEY,
+    account_id VARCHAR(255) NOT NULL,
+    period_start TIMESTAMPTZ NOT NULL,
+    period_end TIMESTAMPTZ NOT NULL,
+    opening_balance NUMERIC(14, 2) NOT NULL,
+    closing_balance NUMERIC(14, 2) NOT NULL,
+    total_debits NUMERIC(14, 2) NOT NULL,
+    total_credits NUMERIC(14, 2) NOT NULL,
+    fees NUMERIC(14, 2) NOT NULL,
+    interest NUMERIC(14, 2) NOT NULL,
+    minimum_payment_due NUMERIC(14, 2) NOT NULL,
+    payment_due_date DATE NOT NULL,
+    generated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (account_id, period_end)
+);
+
+-- The ledger entries listed on a statement.
+CREATE TABLE IF NOT EXISTS statement_lines (
+    statement_id VARCHAR(255) NOT NULL REFERENCES statements (statement_id),
+    entry_id BIGINT NOT NULL,
+    posted_at TIMESTAMPTZ NOT NULL,
+    transaction_id VARCHAR(255) NOT NULL,
+    entry_type VARCHAR(100) NOT NULL,
+    amount NUMERIC(12, 2) NOT NULL,
+    PRIMARY KEY (statement_id, entry_id)
+);
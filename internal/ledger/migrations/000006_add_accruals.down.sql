@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS accruals;
+ALTER TABLE billing_cycles DROP COLUMN IF EXISTS apr;
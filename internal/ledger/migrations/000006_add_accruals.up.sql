@@ -0,0 +1,13 @@
+-- Per-account APR; accounts without one accrue at the configured default.
+ALTER TABLE billing_cycles ADD COLUMN IF NOT EXISTS apr NUMERIC(6, 4);
+
+-- Interest and fees charged by the accrual job. The primary key makes each
+-- charge happen once per account, kind and day however often the job runs.
+CREATE TABLE IF NOT EXISTS accruals (
+    account_id VARCHAR(255) NOT NULL,
+    kind VARCHAR(50) NOT NULL,
+    accrual_date DATE NOT NULL,
+    amount NUMERIC(12, 2) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (account_id, kind, accrual_date)
+);
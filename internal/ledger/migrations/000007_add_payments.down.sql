@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS payment_allocations;
+DROP TABLE IF EXISTS payments;
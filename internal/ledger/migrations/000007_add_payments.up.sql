@@ -0,0 +1,19 @@
+-- Cardholder payments and how each was allocated across balance buckets.
+CREATE TABLE IF NOT EXISTS payments (
+    payment_id VARCHAR(255) PRIMARY KEY,
+    account_id VARCHAR(255) NOT NULL,
+    amount NUMERIC(12, 2) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    return_reason TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    returned_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_payments_account_id ON payments (account_id, status);
+
+CREATE TABLE IF NOT EXISTS payment_allocations (
+    payment_id VARCHAR(255) NOT NULL REFERENCES payments (payment_id),
+    bucket VARCHAR(50) NOT NULL,
+    amount NUMERIC(12, 2) NOT NULL,
+    PRIMARY KEY (payment_id, bucket)
+);
@@ -0,0 +1,4 @@
+ALTER TABLE ledger DROP COLUMN IF EXISTS fx_markup;
+ALTER TABLE ledger DROP COLUMN IF EXISTS fx_rate;
+ALTER TABLE ledger DROP COLUMN IF EXISTS original_currency;
+ALTER TABLE ledger DROP COLUMN IF EXISTS original_amount;
@@ -0,0 +1,7 @@
+-- Ledger amounts are in the billing currency. Postings converted from a
+-- foreign transaction currency also keep the original amount and the rate
+-- and markup they were converted at.
+ALTER TABLE ledger ADD COLUMN IF NOT EXISTS original_amount NUMERIC(12, 2);
+ALTER TABLE ledger ADD COLUMN IF NOT EXISTS original_currency CHAR(3);
+ALTER TABLE ledger ADD COLUMN IF NOT EXISTS fx_rate NUMERIC(18, 8);
+ALTER TABLE ledger ADD COLUMN IF NOT EXISTS fx_markup NUMERIC(6, 4);
@@ -0,0 +1,16 @@
+-- One row per posted journal reference. The primary key makes a second
+-- posting of the same operation fail even when two deliveries race, which
+-- a lookup in ledger cannot do: a journal spans several ledger rows.
+CREATE TABLE IF NOT EXISTS ledger_references (
+    entry_type VARCHAR(50) NOT NULL,
+    reference VARCHAR(255) NOT NULL,
+    journal_id BIGINT NOT NULL,
+    PRIMARY KEY (entry_type, reference)
+);
+
+INSERT INTO ledger_references (entry_type, reference, journal_id)
+SELECT entry_type, reference, COALESCE(MIN(journal_id), 0)
+FROM ledger
+WHERE reference IS NOT NULL AND reference <> ''
+GROUP BY entry_type, reference
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,7 @@
+-- Cardholder credit limits as announced by the authorization service, for
+-- the over-limit fee. Only a newer announcement replaces a limit.
+CREATE TABLE IF NOT EXISTS ledger_credit_limits (
+    account_id VARCHAR(255) PRIMARY KEY,
+    credit_limit NUMERIC(12, 2) NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL
+);
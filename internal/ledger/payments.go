@@ -0,0 +1,308 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"credit-authorization-ledger/internal/outbox"
+	"credit-authorization-ledger/internal/transport"
+	"credit-authorization-ledger/pkg/events"
+
+	"go.opentelemetry.io/otel"
+)
+
+// AccountPaymentsClearing is the contra account for cardholder payments
+// until they settle with the paying bank.
+const AccountPaymentsClearing = "system:payments-clearing"
+
+// Payment statuses stored in the payments table.
+const (
+	PaymentStatusPosted   = "POSTED"
+	PaymentStatusReturned = "RETURNED"
+)
+
+// Balance buckets a payment is allocated across.
+const (
+	BucketFees      = "FEES"
+	BucketInterest  = "INTEREST"
+	BucketPrincipal = "PRINCIPAL"
+)
+
+// Payment failure reasons.
+const (
+	ReasonInvalidPaymentAmount = "payment amount must be positive"
+	ReasonPaymentNotFound      = "payment not found"
+)
+
+// DefaultAllocationOrder pays off fees, then interest, then principal.
+var DefaultAllocationOrder = []string{BucketFees, BucketInterest, BucketPrincipal}
+
+// WithAllocationOrder overrides DefaultAllocationOrder for payments.
+func WithAllocationOrder(order []string) Option {
+	return func(s *Service) {
+		s.allocation = order
+	}
+}
+
+// ParseAllocationOrder reads a comma-separated bucket order such as
+// "INTEREST,FEES,PRINCIPAL". Every bucket must appear exactly once.
+func ParseAllocationOrder(v string) ([]string, error) {
+	order := strings.Split(v, ",")
+	seen := map[string]bool{}
+	for i, bucket := range order {
+		bucket = strings.ToUpper(strings.TrimSpace(bucket))
+		if bucket != BucketFees && bucket != BucketInterest && bucket != BucketPrincipal {
+			return nil, fmt.Errorf("unknown balance bucket %q", bucket)
+		}
+		if seen[bucket] {
+			return nil, fmt.Errorf("balance bucket %s listed twice", bucket)
+		}
+		seen[bucket] = true
+		order[i] = bucket
+	}
+	if len(order) != len(DefaultAllocationOrder) {
+		return nil, fmt.Errorf("allocation order must list %s", strings.Join(DefaultAllocationOrder, ", "))
+	}
+	return order, nil
+}
+
+// allocatePayment splits amount across the buckets in order, paying each
+// up to what is outstanding. Whatever is left over goes to principal, giving
+// the cardholder a credit balance.
+func allocatePayment(amount float64, outstanding map[string]float64, order []string) []events.PaymentAllocation {
+	applied := map[string]float64{}
+	remaining := amount
+	for _, bucket := range order {
+		pay := round2(math.Min(remaining, math.Max(0, outstanding[bucket])))
+		applied[bucket] = pay
+		remaining = round2(remaining - pay)
+	}
+	applied[BucketPrincipal] = round2(applied[BucketPrincipal] + remaining)
+
+	var allocations []events.PaymentAllocation
+	for _, bucket := range order {
+		if applied[bucket] > 0 {
+			allocations = append(allocations, events.PaymentAllocation{Bucket: bucket, Amount: applied[bucket]})
+		}
+	}
+	return allocations
+}
+
+// outstandingBuckets splits accountID's balance into fees and interest not
+// yet paid off, with the rest counted as principal.
+func outstandingBuckets(ctx context.Context, tx *sql.Tx, accountID string) (map[string]float64, error) {
+	balance, err := balanceAt(ctx, tx, accountID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var fees, interest float64
+	err = tx.QueryRowContext(ctx, `
+		WITH charged AS (
+			SELECT COALESCE(SUM(amount) FILTER (WHERE entry_type = $2), 0) AS fees,
+			       COALESCE(SUM(amount) FILTER (WHERE entry_type = $3), 0) AS interest
+			FROM ledger
+			WHERE account_id = $1 AND entry_type IN ($2, $3)
+		),
+		paid AS (
+			SELECT COALESCE(SUM(a.amount) FILTER (WHERE a.bucket = $4), 0) AS fees,
+			       COALESCE(SUM(a.amount) FILTER (WHERE a.bucket = $5), 0) AS interest
+			FROM payment_allocations a
+			JOIN payments p ON p.payment_id = a.payment_id
+			WHERE p.account_id = $1 AND p.status = $6
+		)
+		SELECT GREATEST(charged.fees - paid.fees, 0), GREATEST(charged.interest - paid.interest, 0)
+		FROM charged, paid
+	`, accountID, EntryFee, EntryInterest, BucketFees, BucketInterest, PaymentStatusPosted).Scan(&fees, &interest)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]float64{
+		BucketFees:      fees,
+		BucketInterest:  interest,
+		BucketPrincipal: round2(balance.Balance - fees - interest),
+	}, nil
+}
+
+// HandlePayment credits a cardholder's payment to the ledger, allocated
+// across their balance buckets.
+func (s *Service) HandlePayment(ctx context.Context, msg transport.Message) error {
+	tr := otel.Tracer("ledger-service")
+	ctx, span := tr.Start(ctx, "HandlePayment")
+	defer span.End()
+
+	var req events.PaymentRequested
+	if err := json.Unmarshal(msg.Value, &req); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Redelivered requests are ignored once the payment has been recorded.
+	var existing string
+	err = tx.QueryRowContext(ctx, "SELECT status FROM payments WHERE payment_id = $1", req.PaymentID).Scan(&existing)
+	if err == nil {
+		log.Printf("Payment %s already recorded with status %s", req.PaymentID, existing)
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if req.Amount <= 0 {
+		log.Printf("Rejecting payment %s: %s", req.PaymentID, ReasonInvalidPaymentAmount)
+		failedEvent := events.PaymentFailed{PaymentID: req.PaymentID, UserID: req.UserID, Reason: ReasonInvalidPaymentAmount}
+		if err := outbox.NewWriter(tx).Write(ctx, "payment-failed", req.UserID, failedEvent); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	j := journal{
+		TransactionID: req.PaymentID,
+		EntryType:     EntryPayment,
+		Reference:     req.PaymentID,
+		Postings: []Posting{
+			{AccountID: req.UserID, Amount: -req.Amount},
+			{AccountID: AccountPaymentsClearing, Amount: req.Amount},
+		},
+	}
+	if err := s.enrollCardholders(ctx, tx, j); err != nil {
+		return err
+	}
+
+	// Payments for one cardholder are allocated one at a time.
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM billing_cycles WHERE account_id = $1 FOR UPDATE", req.UserID); err != nil {
+		return err
+	}
+	outstanding, err := outstandingBuckets(ctx, tx, req.UserID)
+	if err != nil {
+		return err
+	}
+	allocations := allocatePayment(req.Amount, outstanding, s.allocation)
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO payments (payment_id, account_id, amount, status) VALUES ($1, $2, $3, $4)",
+		req.PaymentID, req.UserID, req.Amount, PaymentStatusPosted)
+	if err != nil {
+		return err
+	}
+	posted := events.PaymentPosted{PaymentID: req.PaymentID, UserID: req.UserID, Amount: req.Amount, Allocations: allocations}
+	for _, a := range allocations {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO payment_allocations (payment_id, bucket, amount) VALUES ($1, $2, $3)",
+			req.PaymentID, a.Bucket, a.Amount)
+		if err != nil {
+			return err
+		}
+		if a.Bucket == BucketPrincipal {
+			posted.PrincipalAmount = a.Amount
+		}
+	}
+
+	log.Printf("Recording payment %s of %f for %s: %+v", req.PaymentID, req.Amount, req.UserID, allocations)
+	if err := postJournal(ctx, tx, j); err != nil {
+		return err
+	}
+
+	if err := outbox.NewWriter(tx).Write(ctx, "payment-posted", req.UserID, posted); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// HandlePaymentReturn reverses a returned payment's postings. Its
+// allocations stop counting, so the fees and interest it paid are
+// outstanding again.
+func (s *Service) HandlePaymentReturn(ctx context.Context, msg transport.Message) error {
+	tr := otel.Tracer("ledger-service")
+	ctx, span := tr.Start(ctx, "HandlePaymentReturn")
+	defer span.End()
+
+	var req events.PaymentReturnRequested
+	if err := json.Unmarshal(msg.Value, &req); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var accountID, status string
+	var amount float64
+	err = tx.QueryRowContext(ctx,
+		"SELECT account_id, amount, status FROM payments WHERE payment_id = $1 FOR UPDATE",
+		req.PaymentID).Scan(&accountID, &amount, &status)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Rejecting return of payment %s: %s", req.PaymentID, ReasonPaymentNotFound)
+		failedEvent := events.PaymentReturnFailed{PaymentID: req.PaymentID, Reason: ReasonPaymentNotFound}
+		if err := outbox.NewWriter(tx).Write(ctx, "payment-return-failed", req.UserID, failedEvent); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	if err != nil {
+		return err
+	}
+	if status == PaymentStatusReturned {
+		log.Printf("Payment %s already returned", req.PaymentID)
+		return nil
+	}
+
+	var principal float64
+	err = tx.QueryRowContext(ctx,
+		"SELECT COALESCE(SUM(amount), 0) FROM payment_allocations WHERE payment_id = $1 AND bucket = $2",
+		req.PaymentID, BucketPrincipal).Scan(&principal)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE payments SET status = $2, return_reason = $3, returned_at = NOW() WHERE payment_id = $1",
+		req.PaymentID, PaymentStatusReturned, req.Reason)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Reversing returned payment %s of %f for %s: %s", req.PaymentID, amount, accountID, req.Reason)
+	err = postJournal(ctx, tx, journal{
+		TransactionID: req.PaymentID,
+		EntryType:     EntryPaymentReturned,
+		Reference:     req.PaymentID,
+		Postings: []Posting{
+			{AccountID: accountID, Amount: amount},
+			{AccountID: AccountPaymentsClearing, Amount: -amount},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	returnEvent := events.PaymentReturnPosted{
+		PaymentID:       req.PaymentID,
+		UserID:          accountID,
+		Amount:          amount,
+		PrincipalAmount: principal,
+		Reason:          req.Reason,
+	}
+	if err := outbox.NewWriter(tx).Write(ctx, "payment-return-posted", accountID, returnEvent); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
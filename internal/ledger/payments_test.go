@@ -0,0 +1,60 @@
+package ledger
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseAllocationOrder(t *testing.T) {
+	order, err := ParseAllocationOrder(" interest, Fees ,PRINCIPAL")
+	if err != nil || strings.Join(order, ",") != "INTEREST,FEES,PRINCIPAL" {
+		t.Errorf("ParseAllocationOrder = %v, %v; want INTEREST,FEES,PRINCIPAL", order, err)
+	}
+
+	for _, v := range []string{
+		"",
+		"FEES,INTEREST",
+		"FEES,INTEREST,PRINCIPAL,FEES",
+		"FEES,FEES,PRINCIPAL",
+		"FEES,INTEREST,CASH",
+	} {
+		if order, err := ParseAllocationOrder(v); err == nil {
+			t.Errorf("ParseAllocationOrder(%q) = %v, want an error", v, order)
+		}
+	}
+}
+
+func TestAllocatePayment(t *testing.T) {
+	outstanding := map[string]float64{BucketFees: 35, BucketInterest: 12.34, BucketPrincipal: 500}
+	tests := []struct {
+		name        string
+		amount      float64
+		outstanding map[string]float64
+		order       []string
+		want        string
+	}{
+		{"fees first", 40, outstanding, DefaultAllocationOrder, "FEES=35.00 INTEREST=5.00"},
+		{"across every bucket", 100, outstanding, DefaultAllocationOrder, "FEES=35.00 INTEREST=12.34 PRINCIPAL=52.66"},
+		{"configured order", 40, outstanding, []string{BucketInterest, BucketPrincipal, BucketFees}, "INTEREST=12.34 PRINCIPAL=27.66"},
+		{"overpayment goes to principal", 600, outstanding, DefaultAllocationOrder, "FEES=35.00 INTEREST=12.34 PRINCIPAL=552.66"},
+		{"overpayment with principal first", 600, outstanding, []string{BucketPrincipal, BucketFees, BucketInterest}, "PRINCIPAL=552.66 FEES=35.00 INTEREST=12.34"},
+		{"credit balance", 20, map[string]float64{BucketPrincipal: -50}, DefaultAllocationOrder, "PRINCIPAL=20.00"},
+		{"nothing outstanding", 0.01, map[string]float64{}, DefaultAllocationOrder, "PRINCIPAL=0.01"},
+		{"rounded to cents", 0.3, map[string]float64{BucketFees: 0.1, BucketInterest: 0.1}, DefaultAllocationOrder, "FEES=0.10 INTEREST=0.10 PRINCIPAL=0.10"},
+	}
+	for _, tt := range tests {
+		var got []string
+		var total float64
+		for _, a := range allocatePayment(tt.amount, tt.outstanding, tt.order) {
+			got = append(got, fmt.Sprintf("%s=%.2f", a.Bucket, a.Amount))
+			total += a.Amount
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("%s: allocatePayment = %s, want %s", tt.name, strings.Join(got, " "), tt.want)
+		}
+		if round2(total) != tt.amount {
+			t.Errorf("%s: allocations add up to %.2f, want %.2f", tt.name, total, tt.amount)
+		}
+	}
+}
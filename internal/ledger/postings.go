@@ -0,0 +1,167 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// AccountMerchantSettlement is the contra account for card purchases and
+// refunds. Cardholder accounts are identified by their user ID.
+const AccountMerchantSettlement = "system:merchant-settlement"
+
+// Income accounts credited when interest and fees are charged to cardholders.
+const (
+	AccountInterestIncome = "system:interest-income"
+	AccountFeeIncome      = "system:fee-income"
+)
+
+// Entry types recorded in the ledger.
+const (
+	EntryCreditAuthorized         = "CREDIT_AUTHORIZED"
+	EntryAuthorizationIncremented = "AUTHORIZATION_INCREMENTED"
+	EntryAuthorizationReversed    = "AUTHORIZATION_REVERSED"
+	EntryRefund                   = "REFUND"
+	// Fees and interest charged to a cardholder are listed separately on
+	// statements.
+	EntryFee      = "FEE"
+	EntryInterest = "INTEREST"
+	// EntryPayment credits a cardholder with a payment towards their balance.
+	EntryPayment = "PAYMENT"
+	// EntryPaymentReturned debits a returned payment back.
+	EntryPaymentReturned = "PAYMENT_RETURNED"
+	// Disputes provisionally credit the cardholder when opened, then charge
+	// the merchant if won or re-debit the cardholder if lost.
+	EntryDisputeProvisionalCredit = "DISPUTE_PROVISIONAL_CREDIT"
+	EntryDisputeWon               = "DISPUTE_WON"
+	EntryDisputeLost              = "DISPUTE_LOST"
+	// EntryCaptureAdjustment posts the difference between a transaction's
+	// cleared and authorized amounts; EntryForcePost a cleared transaction
+	// with no open authorization.
+	EntryCaptureAdjustment = "CAPTURE_ADJUSTMENT"
+	EntryForcePost         = "FORCE_POST"
+)
+
+// Posting is one leg of a journal. Positive amounts are debits, in the
+// billing currency.
+type Posting struct {
+	AccountID string
+	Amount    float64
+	// Original is set on postings converted from a foreign currency.
+	Original *OriginalAmount
+}
+
+// OriginalAmount is a posting's amount in the transaction currency and the
+// conversion that turned it into the billing currency.
+type OriginalAmount struct {
+	Amount   float64
+	Currency string
+	FXRate   float64
+	FXMarkup float64
+}
+
+// cardholderDebit builds the postings that move amount from the merchant
+// settlement account onto a cardholder's balance. A negative amount moves it
+// back.
+func cardholderDebit(userID string, amount float64) []Posting {
+	return []Posting{
+		{AccountID: userID, Amount: amount},
+		{AccountID: AccountMerchantSettlement, Amount: -amount},
+	}
+}
+
+// journal is a balanced set of postings for one business operation.
+type journal struct {
+	TransactionID string
+	EntryType     string
+	// Reference optionally identifies the operation, e.g. a refund ID.
+	Reference string
+	Postings  []Posting
+}
+
+// errAlreadyPosted is returned by postJournal for a journal whose reference
+// has already been posted.
+var errAlreadyPosted = errors.New("journal already posted")
+
+// postJournal appends j to the ledger's hash chain. The postings must
+// balance. A journal with a reference is posted at most once: a duplicate,
+// even one racing the first in another transaction, gets errAlreadyPosted.
+func postJournal(ctx context.Context, tx *sql.Tx, j journal) error {
+	var total float64
+	for _, p := range j.Postings {
+		total += p.Amount
+	}
+	if math.Abs(total) >= 0.005 {
+		return fmt.Errorf("unbalanced %s journal for transaction %s: postings sum to %.2f", j.EntryType, j.TransactionID, total)
+	}
+
+	var journalID int64
+	if err := tx.QueryRowContext(ctx, "SELECT nextval('ledger_journal_id_seq')").Scan(&journalID); err != nil {
+		return err
+	}
+	if j.Reference != "" {
+		claimed, err := claimReference(ctx, tx, j.EntryType, j.Reference, journalID)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			return errAlreadyPosted
+		}
+	}
+
+	prevHash, err := lockChainHead(ctx, tx)
+	if err != nil {
+		return err
+	}
+	created := time.Now().UTC().Truncate(time.Microsecond)
+	for _, p := range j.Postings {
+		e := chainedEntry{
+			JournalID:     journalID,
+			TransactionID: j.TransactionID,
+			EntryType:     j.EntryType,
+			AccountID:     p.AccountID,
+			Amount:        formatAmount(p.Amount),
+			Reference:     j.Reference,
+			CreatedAt:     created,
+		}
+		if o := p.Original; o != nil {
+			e.OriginalAmount, e.OriginalCurrency = formatAmount(o.Amount), o.Currency
+			e.FXRate, e.FXMarkup = formatRate(o.FXRate, o.FXMarkup)
+		}
+		prevHash, err = appendEntry(ctx, tx, e, prevHash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimReference records that journalID posts entryType's reference. It
+// reports false if another journal already has, waiting for a concurrent
+// claim to commit or roll back first.
+func claimReference(ctx context.Context, tx *sql.Tx, entryType, reference string, journalID int64) (bool, error) {
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO ledger_references (entry_type, reference, journal_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (entry_type, reference) DO NOTHING
+	`, entryType, reference, journalID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// journalExists reports whether a journal of entryType with reference has
+// already been posted. It is a shortcut for callers that would otherwise
+// do work first; postJournal still rejects a duplicate that races it.
+func journalExists(ctx context.Context, tx *sql.Tx, entryType, reference string) (bool, error) {
+	var exists bool
+	err := tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM ledger WHERE entry_type = $1 AND reference = $2)",
+		entryType, reference).Scan(&exists)
+	return exists, err
+}
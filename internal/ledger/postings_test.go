@@ -0,0 +1,230 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"credit-authorization-ledger/internal/dbtest"
+)
+
+// fakeLedger serves the statements postJournal, VerifyChain, the balance
+// snapshots and the dispute handler run against an in-memory ledger.
+// Transactions apply immediately.
+type fakeLedger struct {
+	mu         sync.Mutex
+	rows       []chainedEntry
+	hashes     []string
+	references map[string]int64
+	snapshots  []fakeSnapshot
+	journalSeq int64
+	entrySeq   int64
+	headID     int64
+	headHash   string
+	// outbox holds the topics written to the outbox, in order.
+	outbox []string
+}
+
+func newFakeLedger() *fakeLedger {
+	return &fakeLedger{references: map[string]int64{}, headHash: genesisHash}
+}
+
+func (f *fakeLedger) Query(s *dbtest.Session, query string, args []driver.NamedValue) (driver.Rows, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case query == "SELECT nextval('ledger_journal_id_seq')":
+		f.journalSeq++
+		return dbtest.Rows([]driver.Value{f.journalSeq}), nil
+	case query == "SELECT nextval('ledger_entry_id_seq')":
+		f.entrySeq++
+		return dbtest.Rows([]driver.Value{f.entrySeq}), nil
+	case strings.HasPrefix(query, "SELECT last_hash FROM ledger_chain_head"):
+		return dbtest.Rows([]driver.Value{f.headHash}), nil
+	case strings.HasPrefix(query, "SELECT last_entry_id, last_hash FROM ledger_chain_head"):
+		return dbtest.Rows([]driver.Value{f.headID, f.headHash}), nil
+	case strings.HasPrefix(query, "SELECT last_entry_id FROM ledger_chain_head"):
+		return dbtest.Rows([]driver.Value{f.headID}), nil
+	case strings.Contains(query, "ledger_balance_snapshots"):
+		return f.querySnapshots(query, args)
+	case strings.HasPrefix(query, "SELECT EXISTS (SELECT 1 FROM ledger WHERE entry_type"):
+		exists := false
+		for _, e := range f.rows {
+			exists = exists || e.EntryType == args[0].Value && e.Reference == args[1].Value
+		}
+		return dbtest.Rows([]driver.Value{exists}), nil
+	case strings.Contains(query, "SELECT COALESCE(SUM(amount), 0) >= $3"):
+		var total float64
+		for _, e := range f.rows {
+			if e.TransactionID == args[0].Value && e.AccountID == args[1].Value {
+				amount, _ := strconv.ParseFloat(e.Amount, 64)
+				total += amount
+			}
+		}
+		return dbtest.Rows([]driver.Value{total >= args[2].Value.(float64)}), nil
+	case strings.Contains(query, "FROM ledger\n") && strings.Contains(query, "ORDER BY entry_id"):
+		var values [][]driver.Value
+		for i, e := range f.rows {
+			values = append(values, []driver.Value{e.EntryID, e.JournalID, e.TransactionID, e.EntryType, e.AccountID,
+				e.Amount, e.Reference, e.CreatedAt, e.PrevHash, f.hashes[i],
+				e.OriginalAmount, e.OriginalCurrency, e.FXRate, e.FXMarkup})
+		}
+		return dbtest.Rows(values...), nil
+	}
+	return nil, fmt.Errorf("unexpected query %q", query)
+}
+
+func (f *fakeLedger) Exec(s *dbtest.Session, query string, args []driver.NamedValue) (driver.Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	arg := func(i int) string { return fmt.Sprint(args[i].Value) }
+	switch {
+	case strings.Contains(query, "INSERT INTO ledger_references"):
+		key := arg(0) + "/" + arg(1)
+		if _, ok := f.references[key]; ok {
+			return driver.RowsAffected(0), nil
+		}
+		f.references[key] = args[2].Value.(int64)
+	case strings.Contains(query, "INSERT INTO ledger ("):
+		e := chainedEntry{
+			EntryID:          args[0].Value.(int64),
+			JournalID:        args[1].Value.(int64),
+			TransactionID:    arg(2),
+			EntryType:        arg(3),
+			AccountID:        arg(4),
+			Amount:           arg(5),
+			Reference:        arg(6),
+			CreatedAt:        args[7].Value.(time.Time),
+			PrevHash:         arg(8),
+			OriginalAmount:   arg(10),
+			OriginalCurrency: arg(11),
+			FXRate:           arg(12),
+			FXMarkup:         arg(13),
+		}
+		f.rows = append(f.rows, e)
+		f.hashes = append(f.hashes, arg(9))
+	case strings.HasPrefix(query, "UPDATE ledger_chain_head"):
+		f.headID, f.headHash = args[0].Value.(int64), arg(1)
+	case strings.Contains(query, "INSERT INTO outbox"):
+		f.outbox = append(f.outbox, arg(0))
+	case strings.Contains(query, "INSERT INTO ledger_balance_snapshots"):
+		return driver.RowsAffected(f.takeSnapshots(args[0].Value.(int64), args[1].Value.(int64), args[2].Value.(time.Time))), nil
+	default:
+		return nil, fmt.Errorf("unexpected statement %q", query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+// postTx posts j in its own transaction.
+func postTx(t *testing.T, db *sql.DB, j journal) error {
+	t.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := postJournal(context.Background(), tx, j); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func TestPostJournal(t *testing.T) {
+	f := newFakeLedger()
+	db := dbtest.Open(f)
+
+	refund := journal{TransactionID: "tx-1", EntryType: EntryRefund, Reference: "refund-1", Postings: cardholderDebit("user-1", -25)}
+	if err := postTx(t, db, refund); err != nil {
+		t.Fatalf("postJournal: %v", err)
+	}
+	if len(f.rows) != 2 || f.rows[0].Amount != "-25.00" || f.rows[1].Amount != "25.00" {
+		t.Fatalf("ledger = %+v, want a cardholder credit and a settlement debit", f.rows)
+	}
+
+	// A redelivered refund is rejected rather than posted again.
+	if err := postTx(t, db, refund); !errors.Is(err, errAlreadyPosted) {
+		t.Errorf("second postJournal = %v, want errAlreadyPosted", err)
+	}
+	if len(f.rows) != 2 {
+		t.Errorf("ledger has %d rows after a duplicate, want 2", len(f.rows))
+	}
+
+	// Journals without a reference are not deduplicated.
+	purchase := journal{TransactionID: "tx-2", EntryType: EntryCreditAuthorized, Postings: cardholderDebit("user-1", 10)}
+	for i := 0; i < 2; i++ {
+		if err := postTx(t, db, purchase); err != nil {
+			t.Fatalf("postJournal without reference: %v", err)
+		}
+	}
+
+	unbalanced := journal{TransactionID: "tx-3", EntryType: EntryFee, Postings: []Posting{{AccountID: "user-1", Amount: 5}}}
+	if err := postTx(t, db, unbalanced); err == nil || !strings.Contains(err.Error(), "unbalanced") {
+		t.Errorf("postJournal of an unbalanced journal = %v, want an error", err)
+	}
+
+	checked, broken, err := VerifyChain(context.Background(), db)
+	if err != nil || broken != nil || checked != 6 {
+		t.Errorf("VerifyChain = %d, %v, %v; want 6 intact entries", checked, broken, err)
+	}
+}
+
+func TestPostJournalPostgres(t *testing.T) {
+	db := dbtest.Postgres(t, dbtest.Ledger)
+	ctx := context.Background()
+	refund := journal{TransactionID: "tx-1", EntryType: EntryRefund, Reference: "refund-1", Postings: cardholderDebit("user-1", -25)}
+
+	// Two deliveries of the refund race: the second waits on the first's
+	// claim and is rejected once it commits.
+	first, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Rollback()
+	if err := postJournal(ctx, first, refund); err != nil {
+		t.Fatalf("postJournal: %v", err)
+	}
+	second := make(chan error, 1)
+	go func() {
+		tx, err := db.BeginTx(ctx, nil)
+		if err == nil {
+			defer tx.Rollback()
+			if err = postJournal(ctx, tx, refund); err == nil {
+				err = tx.Commit()
+			}
+		}
+		second <- err
+	}()
+	select {
+	case err := <-second:
+		t.Fatalf("the second posting returned %v before the first committed", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := first.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-second; !errors.Is(err, errAlreadyPosted) {
+		t.Errorf("racing postJournal = %v, want errAlreadyPosted", err)
+	}
+	if err := postTx(t, db, refund); !errors.Is(err, errAlreadyPosted) {
+		t.Errorf("redelivered postJournal = %v, want errAlreadyPosted", err)
+	}
+
+	var rows int
+	if err := db.QueryRow("SELECT COUNT(*) FROM ledger WHERE reference = 'refund-1'").Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 2 {
+		t.Errorf("ledger has %d rows for refund-1, want 2", rows)
+	}
+	checked, broken, err := VerifyChain(ctx, db)
+	if err != nil || broken != nil || checked != 2 {
+		t.Errorf("VerifyChain = %d, %v, %v; want 2 intact entries", checked, broken, err)
+	}
+}
@@ -0,0 +1,101 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"credit-authorization-ledger/internal/outbox"
+	"credit-authorization-ledger/internal/transport"
+	"credit-authorization-ledger/pkg/events"
+
+	"go.opentelemetry.io/otel"
+)
+
+// ReasonRefundExceedsPosted is reported when a refund is larger than what the
+// ledger still holds on the cardholder's account for the transaction.
+const ReasonRefundExceedsPosted = "refund exceeds posted amount"
+
+// HandleRefund reverses the postings of a transaction by the refunded amount.
+// The refund is rejected, so that the saga compensates it, if the ledger does
+// not hold enough for the transaction. A refund that was already posted is
+// confirmed again, as the authorization service asks again for refunds it
+// has not heard back about.
+func (s *Service) HandleRefund(ctx context.Context, msg transport.Message) error {
+	tr := otel.Tracer("ledger-service")
+	ctx, span := tr.Start(ctx, "HandleRefund")
+	defer span.End()
+
+	var event events.RefundAuthorized
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	posted, err := journalExists(ctx, tx, EntryRefund, event.RefundID)
+	if err != nil {
+		return err
+	}
+	if posted {
+		log.Printf("Refund %s already posted, confirming it again", event.RefundID)
+		return confirmRefund(ctx, tx, event)
+	}
+
+	// Compare in SQL so the check runs on NUMERIC rather than floats.
+	var covered bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(amount), 0) >= $3
+		FROM ledger
+		WHERE transaction_id = $1 AND account_id = $2
+	`, event.TransactionID, event.UserID, event.Amount).Scan(&covered)
+	if err != nil {
+		return err
+	}
+
+	if !covered {
+		log.Printf("Rejecting refund %s for transaction %s: %s", event.RefundID, event.TransactionID, ReasonRefundExceedsPosted)
+		failedEvent := events.RefundLedgerFailed{
+			RefundID:      event.RefundID,
+			TransactionID: event.TransactionID,
+			Reason:        ReasonRefundExceedsPosted,
+		}
+		if err := outbox.NewWriter(tx).Write(ctx, "refund-ledger-failed", event.TransactionID, failedEvent); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	log.Printf("Recording refund %s of %f for transaction %s", event.RefundID, event.Amount, event.TransactionID)
+	err = postJournal(ctx, tx, journal{
+		TransactionID: event.TransactionID,
+		EntryType:     EntryRefund,
+		Reference:     event.RefundID,
+		Postings:      cardholderDebit(event.UserID, -event.Amount),
+	})
+	if errors.Is(err, errAlreadyPosted) {
+		log.Printf("Refund %s already posted", event.RefundID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return confirmRefund(ctx, tx, event)
+}
+
+// confirmRefund emits refund-ledger-posted for a posted refund.
+func confirmRefund(ctx context.Context, tx *sql.Tx, event events.RefundAuthorized) error {
+	postedEvent := events.RefundLedgerPosted{RefundID: event.RefundID, TransactionID: event.TransactionID}
+	if err := outbox.NewWriter(tx).Write(ctx, "refund-ledger-posted", event.TransactionID, postedEvent); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,49 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"credit-authorization-ledger/internal/dbtest"
+	"credit-authorization-ledger/internal/transport"
+	"credit-authorization-ledger/pkg/events"
+)
+
+func TestHandleRefund(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeLedger()
+	db := dbtest.Open(f)
+	s := NewService(db)
+
+	purchase := journal{TransactionID: "tx-1", EntryType: EntryCreditAuthorized, Reference: "tx-1", Postings: cardholderDebit("user-1", 100)}
+	if err := postTx(t, db, purchase); err != nil {
+		t.Fatalf("postJournal: %v", err)
+	}
+
+	handle := func(refundID string, amount float64) {
+		t.Helper()
+		value, err := json.Marshal(events.RefundAuthorized{RefundID: refundID, TransactionID: "tx-1", UserID: "user-1", Amount: amount})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.HandleRefund(ctx, transport.Message{Topic: "refund-ledger-requests", Key: []byte("tx-1"), Value: value}); err != nil {
+			t.Fatalf("HandleRefund: %v", err)
+		}
+	}
+
+	handle("r-1", 30)
+	// A refund asked for again after a saga timeout is confirmed, not
+	// posted twice.
+	handle("r-1", 30)
+	handle("r-2", 90)
+
+	if len(f.rows) != 4 {
+		t.Errorf("ledger has %d rows, want the purchase and one refund", len(f.rows))
+	}
+	want := "refund-ledger-posted,refund-ledger-posted,refund-ledger-failed"
+	if got := strings.Join(f.outbox, ","); got != want {
+		t.Errorf("outbox = %s, want %s", got, want)
+	}
+}
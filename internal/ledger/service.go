@@ -0,0 +1,207 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"credit-authorization-ledger/internal/outbox"
+	"credit-authorization-ledger/internal/transport"
+	"credit-authorization-ledger/pkg/events"
+
+	"go.opentelemetry.io/otel"
+)
+
+type Service struct {
+	db         *sql.DB
+	statements StatementPolicy
+	accruals   AccrualPolicy
+	allocation []string
+}
+
+// Option configures optional Service settings.
+type Option func(*Service)
+
+// WithStatementPolicy overrides DefaultStatementPolicy for billing cycles and statements.
+func WithStatementPolicy(p StatementPolicy) Option {
+	return func(s *Service) {
+		s.statements = p
+	}
+}
+
+// WithAccrualPolicy overrides DefaultAccrualPolicy for interest and fees.
+func WithAccrualPolicy(p AccrualPolicy) Option {
+	return func(s *Service) {
+		s.accruals = p
+	}
+}
+
+func NewService(db *sql.DB, opts ...Option) *Service {
+	s := &Service{db: db, statements: DefaultStatementPolicy, accruals: DefaultAccrualPolicy, allocation: DefaultAllocationOrder}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// HandleMessage dispatches a message to the handler for its topic.
+func (s *Service) HandleMessage(ctx context.Context, msg transport.Message) error {
+	switch msg.Topic {
+	case "ledger-update-requests":
+		return s.HandleCreditLedger(ctx, msg)
+	case "ledger-increment-requests":
+		return s.HandleAuthorizationIncrement(ctx, msg)
+	case "ledger-reversal-requests":
+		return s.HandleAuthorizationReversal(ctx, msg)
+	case "refund-ledger-requests":
+		return s.HandleRefund(ctx, msg)
+	case "payment-ledger-requests":
+		return s.HandlePayment(ctx, msg)
+	case "capture-ledger-requests":
+		return s.HandleCapture(ctx, msg)
+	case "dispute-ledger-requests":
+		return s.HandleDispute(ctx, msg)
+	case "payment-return-ledger-requests":
+		return s.HandlePaymentReturn(ctx, msg)
+	case "credit-limit-changed":
+		return s.HandleCreditLimitChanged(ctx, msg)
+	}
+	return fmt.Errorf("unexpected topic %q", msg.Topic)
+}
+
+func (s *Service) HandleCreditLedger(ctx context.Context, msg transport.Message) error {
+	tr := otel.Tracer("ledger-service")
+	ctx, span := tr.Start(ctx, "HandleCreditLedger")
+	defer span.End()
+
+	var event events.AuthorizationSucceeded
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return err
+	}
+
+	log.Printf("Recording ledger entry for transaction %s", event.TransactionID)
+	j := journal{
+		TransactionID: event.TransactionID,
+		EntryType:     EntryCreditAuthorized,
+		// An authorization is posted once, even if reconciliation re-emits it.
+		Reference: event.TransactionID,
+		Postings:  cardholderDebit(event.UserID, event.Amount),
+	}
+	if event.TransactionCurrency == "" || event.TransactionCurrency == event.BillingCurrency {
+		return s.post(ctx, j)
+	}
+
+	// Foreign-currency authorizations are posted at the rate they were
+	// authorized at; clearing re-converts them at the settlement date's rate.
+	debit := OriginalAmount{
+		Amount:   event.TransactionAmount,
+		Currency: event.TransactionCurrency,
+		FXRate:   event.FXRate,
+		FXMarkup: event.FXMarkup,
+	}
+	credit := debit
+	credit.Amount = -debit.Amount
+	j.Postings = []Posting{
+		{AccountID: event.UserID, Amount: event.Amount, Original: &debit},
+		{AccountID: AccountMerchantSettlement, Amount: -event.Amount, Original: &credit},
+	}
+	return s.post(ctx, j)
+}
+
+// HandleAuthorizationIncrement posts the additional amount of an incremental authorization.
+func (s *Service) HandleAuthorizationIncrement(ctx context.Context, msg transport.Message) error {
+	tr := otel.Tracer("ledger-service")
+	ctx, span := tr.Start(ctx, "HandleAuthorizationIncrement")
+	defer span.End()
+
+	var event events.IncrementalAuthorizationSucceeded
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return err
+	}
+
+	log.Printf("Recording increment of %f for transaction %s", event.IncrementAmount, event.TransactionID)
+	return s.post(ctx, journal{
+		TransactionID: event.TransactionID,
+		EntryType:     EntryAuthorizationIncremented,
+		Reference:     changeReference(event.ChangeID),
+		Postings:      cardholderDebit(event.UserID, event.IncrementAmount),
+	})
+}
+
+// HandleAuthorizationReversal takes a reversed amount back off the cardholder's balance.
+func (s *Service) HandleAuthorizationReversal(ctx context.Context, msg transport.Message) error {
+	tr := otel.Tracer("ledger-service")
+	ctx, span := tr.Start(ctx, "HandleAuthorizationReversal")
+	defer span.End()
+
+	var event events.AuthorizationReversalSucceeded
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		log.Printf("failed to unmarshal message: %v", err)
+		return err
+	}
+
+	log.Printf("Recording reversal of %f for transaction %s", event.ReversedAmount, event.TransactionID)
+	return s.post(ctx, journal{
+		TransactionID: event.TransactionID,
+		EntryType:     EntryAuthorizationReversed,
+		Reference:     changeReference(event.ChangeID),
+		Postings:      cardholderDebit(event.UserID, -event.ReversedAmount),
+	})
+}
+
+// changeReference is the journal reference of an amount change from the
+// authorization's audit trail. Events published before changes carried an
+// ID have none, and are posted without deduplication.
+func changeReference(changeID int64) string {
+	if changeID == 0 {
+		return ""
+	}
+	return "change-" + strconv.FormatInt(changeID, 10)
+}
+
+// post writes a journal and the ledger-update-succeeded event in one
+// transaction. A journal with a reference that is already posted is skipped.
+func (s *Service) post(ctx context.Context, j journal) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if j.Reference != "" {
+		posted, err := journalExists(ctx, tx, j.EntryType, j.Reference)
+		if err != nil {
+			return err
+		}
+		if posted {
+			log.Printf("%s journal %s already posted", j.EntryType, j.Reference)
+			return nil
+		}
+	}
+
+	if err := s.enrollCardholders(ctx, tx, j); err != nil {
+		return err
+	}
+
+	if err := postJournal(ctx, tx, j); errors.Is(err, errAlreadyPosted) {
+		log.Printf("%s journal %s already posted", j.EntryType, j.Reference)
+		return nil
+	} else if err != nil {
+		// Here you would add a `ledger-update-failed` event to the outbox
+		return err
+	}
+
+	// Add ledger update success event to outbox
+	ledgerEvent := events.LedgerUpdateSucceeded{TransactionID: j.TransactionID}
+	if err := outbox.NewWriter(tx).Write(ctx, "ledger-update-succeeded", j.TransactionID, ledgerEvent); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,13 @@
+package ledger
+
+import "testing"
+
+func TestChangeReference(t *testing.T) {
+	if ref := changeReference(42); ref != "change-42" {
+		t.Errorf("changeReference(42) = %q, want change-42", ref)
+	}
+	// Events from before change IDs are posted without a reference.
+	if ref := changeReference(0); ref != "" {
+		t.Errorf("changeReference(0) = %q, want none", ref)
+	}
+}
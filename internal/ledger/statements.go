@@ -0,0 +1,447 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"credit-authorization-ledger/internal/outbox"
+	"credit-authorization-ledger/pkg/events"
+)
+
+var (
+	ErrStatementNotFound   = errors.New("statement not found")
+	ErrInvalidBillingCycle = errors.New("statement day must be between 1 and 28, payment due days positive and APR not negative")
+)
+
+// StatementPolicy sets the billing cycle given to new cardholder accounts and
+// how the minimum payment is computed.
+type StatementPolicy struct {
+	StatementDay   int
+	PaymentDueDays int
+	// The minimum payment is MinimumPaymentPercent of the closing balance
+	// plus the cycle's fees and interest, at least MinimumPaymentFloor and at
+	// most the closing balance.
+	MinimumPaymentPercent float64
+	MinimumPaymentFloor   float64
+}
+
+// DefaultStatementPolicy closes cycles on the 1st with payment due 25 days
+// later, and a minimum payment of 1% plus fees and interest, at least 25.
+var DefaultStatementPolicy = StatementPolicy{
+	StatementDay:          1,
+	PaymentDueDays:        25,
+	MinimumPaymentPercent: 0.01,
+	MinimumPaymentFloor:   25,
+}
+
+// minimumPayment applies the policy to a closed cycle.
+func (p StatementPolicy) minimumPayment(closing, fees, interest float64) float64 {
+	if closing <= 0 {
+		return 0
+	}
+	due := math.Max(closing*p.MinimumPaymentPercent+fees+interest, p.MinimumPaymentFloor)
+	return math.Round(math.Min(due, closing)*100) / 100
+}
+
+// BillingCycle is the statement schedule of a cardholder account.
+type BillingCycle struct {
+	AccountID      string `json:"account_id"`
+	StatementDay   int    `json:"statement_day"`
+	PaymentDueDays int    `json:"payment_due_days"`
+	// APR overrides the accrual policy's APR for the account when set.
+	APR         *float64  `json:"apr,omitempty"`
+	CycleStart  time.Time `json:"cycle_start"`
+	NextCloseAt time.Time `json:"next_close_at"`
+}
+
+// nextClose returns the first midnight UTC on statementDay after t.
+func nextClose(t time.Time, statementDay int) time.Time {
+	t = t.UTC()
+	c := time.Date(t.Year(), t.Month(), statementDay, 0, 0, 0, 0, time.UTC)
+	if !c.After(t) {
+		c = c.AddDate(0, 1, 0)
+	}
+	return c
+}
+
+// enrollCardholders gives the cardholder accounts of j the policy's billing
+// cycle if they do not have one yet.
+func (s *Service) enrollCardholders(ctx context.Context, tx *sql.Tx, j journal) error {
+	now := time.Now().UTC()
+	for _, p := range j.Postings {
+		if strings.HasPrefix(p.AccountID, "system:") {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO billing_cycles (account_id, statement_day, payment_due_days, cycle_start, next_close_at)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (account_id) DO NOTHING
+		`, p.AccountID, s.statements.StatementDay, s.statements.PaymentDueDays, now, nextClose(now, s.statements.StatementDay))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetBillingCycle changes an account's statement day, payment terms and,
+// if given, APR. The open cycle keeps its start and closes on the new
+// statement day.
+func (s *Service) SetBillingCycle(ctx context.Context, c BillingCycle) (BillingCycle, error) {
+	if c.StatementDay < 1 || c.StatementDay > 28 || c.PaymentDueDays <= 0 || (c.APR != nil && *c.APR < 0) {
+		return c, ErrInvalidBillingCycle
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return c, err
+	}
+	defer tx.Rollback()
+
+	c.CycleStart = time.Now().UTC()
+	err = tx.QueryRowContext(ctx,
+		"SELECT cycle_start FROM billing_cycles WHERE account_id = $1 FOR UPDATE",
+		c.AccountID).Scan(&c.CycleStart)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return c, err
+	}
+	c.NextCloseAt = nextClose(c.CycleStart, c.StatementDay)
+
+	var apr sql.NullFloat64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO billing_cycles (account_id, statement_day, payment_due_days, cycle_start, next_close_at, apr)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (account_id) DO UPDATE
+		SET statement_day = EXCLUDED.statement_day,
+		    payment_due_days = EXCLUDED.payment_due_days,
+		    next_close_at = EXCLUDED.next_close_at,
+		    apr = COALESCE(EXCLUDED.apr, billing_cycles.apr),
+		    updated_at = NOW()
+		RETURNING apr
+	`, c.AccountID, c.StatementDay, c.PaymentDueDays, c.CycleStart, c.NextCloseAt, c.APR).Scan(&apr)
+	if err != nil {
+		return c, err
+	}
+	if apr.Valid {
+		c.APR = &apr.Float64
+	}
+	return c, tx.Commit()
+}
+
+// StatementLine is a ledger entry listed on a statement. Positive amounts
+// are charges, negative amounts are credits.
+type StatementLine struct {
+	EntryID       int64     `json:"entry_id"`
+	PostedAt      time.Time `json:"posted_at"`
+	TransactionID string    `json:"transaction_id"`
+	EntryType     string    `json:"entry_type"`
+	Amount        float64   `json:"amount"`
+}
+
+// Statement is a closed billing cycle. The period runs from just after
+// PeriodStart up to and including PeriodEnd.
+type Statement struct {
+	StatementID       string          `json:"statement_id"`
+	AccountID         string          `json:"account_id"`
+	PeriodStart       time.Time       `json:"period_start"`
+	PeriodEnd         time.Time       `json:"period_end"`
+	OpeningBalance    float64         `json:"opening_balance"`
+	ClosingBalance    float64         `json:"closing_balance"`
+	TotalDebits       float64         `json:"total_debits"`
+	TotalCredits      float64         `json:"total_credits"`
+	Fees              float64         `json:"fees"`
+	Interest          float64         `json:"interest"`
+	MinimumPaymentDue float64         `json:"minimum_payment_due"`
+	PaymentDueDate    string          `json:"payment_due_date"`
+	GeneratedAt       time.Time       `json:"generated_at"`
+	Lines             []StatementLine `json:"lines"`
+}
+
+// cycleCloseBatch is how many accounts with due cycles CloseDueCycles reads
+// at a time.
+const cycleCloseBatch = 100
+
+// CloseDueCycles generates a statement for every billing cycle that has
+// ended and returns how many were generated. Accounts several cycles behind
+// get one statement per cycle. Each cycle is closed in its own transaction
+// and locked with SKIP LOCKED, so several replicas can run it at once. An
+// account whose cycle fails to close is logged and skipped rather than
+// holding up the accounts behind it.
+func (s *Service) CloseDueCycles(ctx context.Context) (int, error) {
+	closed, failed := 0, 0
+	lastAccountID := ""
+	for {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT account_id FROM billing_cycles
+			WHERE next_close_at <= NOW() AND account_id > $1
+			ORDER BY account_id
+			LIMIT $2
+		`, lastAccountID, cycleCloseBatch)
+		if err != nil {
+			return closed, err
+		}
+		var accounts []string
+		for rows.Next() {
+			if err := rows.Scan(&lastAccountID); err != nil {
+				rows.Close()
+				return closed, err
+			}
+			accounts = append(accounts, lastAccountID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return closed, err
+		}
+
+		for _, accountID := range accounts {
+			for {
+				if err := ctx.Err(); err != nil {
+					return closed, err
+				}
+				done, err := s.closeCycle(ctx, accountID)
+				if err != nil {
+					log.Printf("failed to close billing cycle for account %s: %v", accountID, err)
+					failed++
+					break
+				}
+				if done {
+					break
+				}
+				closed++
+			}
+		}
+		if len(accounts) < cycleCloseBatch {
+			break
+		}
+	}
+	if failed > 0 {
+		return closed, fmt.Errorf("%d billing cycles could not be closed", failed)
+	}
+	return closed, nil
+}
+
+// closeCycle closes the account's billing cycle if it has ended. It reports
+// true once no cycle is due, or the account is locked by someone else.
+func (s *Service) closeCycle(ctx context.Context, accountID string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var c BillingCycle
+	err = tx.QueryRowContext(ctx, `
+		SELECT account_id, statement_day, payment_due_days, cycle_start, next_close_at
+		FROM billing_cycles
+		WHERE account_id = $1 AND next_close_at <= NOW()
+		FOR UPDATE SKIP LOCKED
+	`, accountID).Scan(&c.AccountID, &c.StatementDay, &c.PaymentDueDays, &c.CycleStart, &c.NextCloseAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Blocking appends until commit means every entry dated inside the cycle
+	// is already committed.
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM ledger_chain_head WHERE id = 1 FOR SHARE"); err != nil {
+		return false, err
+	}
+
+	st, err := s.generateStatement(ctx, tx, c)
+	if err != nil {
+		return false, fmt.Errorf("generating statement for %s: %w", c.AccountID, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE billing_cycles SET cycle_start = $2, next_close_at = $3, updated_at = NOW()
+		WHERE account_id = $1
+	`, c.AccountID, c.NextCloseAt, nextClose(c.NextCloseAt, c.StatementDay))
+	if err != nil {
+		return false, err
+	}
+
+	generatedEvent := events.StatementGenerated{
+		StatementID:       st.StatementID,
+		AccountID:         st.AccountID,
+		PeriodStart:       st.PeriodStart,
+		PeriodEnd:         st.PeriodEnd,
+		OpeningBalance:    st.OpeningBalance,
+		ClosingBalance:    st.ClosingBalance,
+		Fees:              st.Fees,
+		Interest:          st.Interest,
+		MinimumPaymentDue: st.MinimumPaymentDue,
+		PaymentDueDate:    st.PaymentDueDate,
+	}
+	if err := outbox.NewWriter(tx).Write(ctx, "statement-generated", st.AccountID, generatedEvent); err != nil {
+		return false, err
+	}
+
+	log.Printf("Generated statement %s: closing balance %.2f, minimum payment %.2f due %s",
+		st.StatementID, st.ClosingBalance, st.MinimumPaymentDue, st.PaymentDueDate)
+	return false, tx.Commit()
+}
+
+// generateStatement computes and stores the statement closing c.
+func (s *Service) generateStatement(ctx context.Context, tx *sql.Tx, c BillingCycle) (*Statement, error) {
+	st := &Statement{
+		StatementID:    fmt.Sprintf("%s:%s", c.AccountID, c.NextCloseAt.Format("2006-01-02")),
+		AccountID:      c.AccountID,
+		PeriodStart:    c.CycleStart,
+		PeriodEnd:      c.NextCloseAt,
+		PaymentDueDate: c.NextCloseAt.AddDate(0, 0, c.PaymentDueDays).Format("2006-01-02"),
+		GeneratedAt:    time.Now().UTC(),
+	}
+
+	opening, err := balanceAt(ctx, tx, c.AccountID, c.CycleStart)
+	if err != nil {
+		return nil, err
+	}
+	closing, err := balanceAt(ctx, tx, c.AccountID, c.NextCloseAt)
+	if err != nil {
+		return nil, err
+	}
+	st.OpeningBalance = opening.Balance
+	st.ClosingBalance = closing.Balance
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(amount) FILTER (WHERE amount > 0), 0),
+		       COALESCE(SUM(amount) FILTER (WHERE amount < 0), 0),
+		       COALESCE(SUM(amount) FILTER (WHERE entry_type = $4), 0),
+		       COALESCE(SUM(amount) FILTER (WHERE entry_type = $5), 0)
+		FROM ledger
+		WHERE account_id = $1 AND created_at > $2 AND created_at <= $3
+	`, c.AccountID, c.CycleStart, c.NextCloseAt, EntryFee, EntryInterest).Scan(&st.TotalDebits, &st.TotalCredits, &st.Fees, &st.Interest)
+	if err != nil {
+		return nil, err
+	}
+	st.MinimumPaymentDue = s.statements.minimumPayment(st.ClosingBalance, st.Fees, st.Interest)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO statements (statement_id, account_id, period_start, period_end, opening_balance, closing_balance,
+		                        total_debits, total_credits, fees, interest, minimum_payment_due, payment_due_date, generated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	`, st.StatementID, st.AccountID, st.PeriodStart, st.PeriodEnd, st.OpeningBalance, st.ClosingBalance,
+		st.TotalDebits, st.TotalCredits, st.Fees, st.Interest, st.MinimumPaymentDue, st.PaymentDueDate, st.GeneratedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO statement_lines (statement_id, entry_id, posted_at, transaction_id, entry_type, amount)
+		SELECT $1, entry_id, created_at, transaction_id, entry_type, amount
+		FROM ledger
+		WHERE account_id = $2 AND created_at > $3 AND created_at <= $4
+	`, st.StatementID, c.AccountID, c.CycleStart, c.NextCloseAt)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// GetStatement loads a statement with its lines.
+func (s *Service) GetStatement(ctx context.Context, statementID string) (*Statement, error) {
+	st := &Statement{StatementID: statementID, Lines: []StatementLine{}}
+	var dueDate time.Time
+	err := s.db.QueryRowContext(ctx, `
+		SELECT account_id, period_start, period_end, opening_balance, closing_balance, total_debits, total_credits,
+		       fees, interest, minimum_payment_due, payment_due_date, generated_at
+		FROM statements
+		WHERE statement_id = $1
+	`, statementID).Scan(&st.AccountID, &st.PeriodStart, &st.PeriodEnd, &st.OpeningBalance, &st.ClosingBalance,
+		&st.TotalDebits, &st.TotalCredits, &st.Fees, &st.Interest, &st.MinimumPaymentDue, &dueDate, &st.GeneratedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrStatementNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	st.PaymentDueDate = dueDate.Format("2006-01-02")
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT entry_id, posted_at, transaction_id, entry_type, amount
+		FROM statement_lines
+		WHERE statement_id = $1
+		ORDER BY entry_id ASC
+	`, statementID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line StatementLine
+		if err := rows.Scan(&line.EntryID, &line.PostedAt, &line.TransactionID, &line.EntryType, &line.Amount); err != nil {
+			return nil, err
+		}
+		st.Lines = append(st.Lines, line)
+	}
+	return st, rows.Err()
+}
+
+// WriteJSON encodes the statement with its lines as JSON.
+func (st *Statement) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(st)
+}
+
+// statementCSVHeader lists the statement columns followed by the line columns.
+var statementCSVHeader = []string{
+	"statement_id", "account_id", "period_start", "period_end", "opening_balance", "closing_balance",
+	"total_debits", "total_credits", "fees", "interest", "minimum_payment_due", "payment_due_date",
+	"entry_id", "posted_at", "transaction_id", "entry_type", "amount",
+}
+
+// WriteCSV writes one row per statement line, each repeating the statement
+// totals so rows can be merged into mailings directly. A statement without
+// lines is written as a single row with empty line columns.
+func (st *Statement) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(statementCSVHeader); err != nil {
+		return err
+	}
+
+	summary := []string{
+		st.StatementID,
+		st.AccountID,
+		st.PeriodStart.UTC().Format(time.RFC3339),
+		st.PeriodEnd.UTC().Format(time.RFC3339),
+		formatAmount(st.OpeningBalance),
+		formatAmount(st.ClosingBalance),
+		formatAmount(st.TotalDebits),
+		formatAmount(st.TotalCredits),
+		formatAmount(st.Fees),
+		formatAmount(st.Interest),
+		formatAmount(st.MinimumPaymentDue),
+		st.PaymentDueDate,
+	}
+	if len(st.Lines) == 0 {
+		if err := cw.Write(append(summary, "", "", "", "", "")); err != nil {
+			return err
+		}
+	}
+	for _, line := range st.Lines {
+		row := append(append([]string{}, summary...),
+			strconv.FormatInt(line.EntryID, 10),
+			line.PostedAt.UTC().Format(time.RFC3339),
+			line.TransactionID,
+			line.EntryType,
+			formatAmount(line.Amount),
+		)
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
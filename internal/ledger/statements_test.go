@@ -0,0 +1,86 @@
+package ledger
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextClose(t *testing.T) {
+	tests := []struct {
+		t    time.Time
+		day  int
+		want time.Time
+	}{
+		{time.Date(2024, 3, 10, 15, 0, 0, 0, time.UTC), 1, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 3, 10, 15, 0, 0, 0, time.UTC), 15, time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)},
+		// A cycle closing exactly at t closes again a month later.
+		{time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC), 15, time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 12, 20, 0, 0, 0, 0, time.UTC), 5, time.Date(2025, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC), 28, time.Date(2024, 2, 28, 0, 0, 0, 0, time.UTC)},
+		// Midnight is in UTC whatever zone t is in.
+		{time.Date(2024, 3, 15, 1, 0, 0, 0, time.FixedZone("CET", 3600)), 15, time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := nextClose(tt.t, tt.day); !got.Equal(tt.want) || got.Location() != time.UTC {
+			t.Errorf("nextClose(%v, %d) = %v, want %v", tt.t, tt.day, got, tt.want)
+		}
+	}
+}
+
+func TestStatementWriteCSV(t *testing.T) {
+	st := &Statement{
+		StatementID:       "user-1:2024-04-01",
+		AccountID:         "user-1",
+		PeriodStart:       time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		PeriodEnd:         time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		OpeningBalance:    100,
+		ClosingBalance:    137.5,
+		TotalDebits:       57.5,
+		TotalCredits:      -20,
+		Fees:              5,
+		Interest:          2.5,
+		MinimumPaymentDue: 25,
+		PaymentDueDate:    "2024-04-26",
+		Lines: []StatementLine{
+			{EntryID: 7, PostedAt: time.Date(2024, 3, 2, 9, 30, 0, 0, time.FixedZone("CET", 3600)), TransactionID: "tx-1", EntryType: EntryCreditAuthorized, Amount: 50},
+			{EntryID: 9, PostedAt: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC), TransactionID: "tx-1, partial", EntryType: EntryRefund, Amount: -20},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := st.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("reading CSV: %v", err)
+	}
+	summary := "user-1:2024-04-01|user-1|2024-03-01T00:00:00Z|2024-04-01T00:00:00Z|100.00|137.50|57.50|-20.00|5.00|2.50|25.00|2024-04-26"
+	want := []string{
+		strings.Join(statementCSVHeader, "|"),
+		summary + "|7|2024-03-02T08:30:00Z|tx-1|CREDIT_AUTHORIZED|50.00",
+		summary + "|9|2024-03-05T00:00:00Z|tx-1, partial|REFUND|-20.00",
+	}
+	if len(records) != len(want) {
+		t.Fatalf("CSV has %d records, want %d", len(records), len(want))
+	}
+	for i, record := range records {
+		if got := strings.Join(record, "|"); got != want[i] {
+			t.Errorf("record %d = %s, want %s", i, got, want[i])
+		}
+	}
+
+	// A statement without lines still gets its summary row.
+	st.Lines = nil
+	buf.Reset()
+	if err := st.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	records, err = csv.NewReader(&buf).ReadAll()
+	if err != nil || len(records) != 2 || strings.Join(records[1], "|") != summary+"|||||" {
+		t.Errorf("CSV without lines = %q, %v; want the header and one summary row", records, err)
+	}
+}
@@ -0,0 +1,233 @@
+package outbox
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel"
+)
+
+// archiveBatchSize is how many messages go into one archive file.
+const archiveBatchSize = 1000
+
+// archivedMessage is one line of an archive file. Payloads are written as
+// they were stored, which is JSON for everything a Writer writes. Payloads
+// that are not JSON are kept base64-encoded in RawPayload instead.
+type archivedMessage struct {
+	ID          int64           `json:"id"`
+	Topic       string          `json:"topic"`
+	Key         string          `json:"key"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	RawPayload  []byte          `json:"raw_payload,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	PublishedAt time.Time       `json:"published_at"`
+}
+
+// setPayload stores payload in whichever field can hold it.
+func (m *archivedMessage) setPayload(payload []byte) {
+	if json.Valid(payload) {
+		m.Payload = payload
+		return
+	}
+	log.Printf("Archiving outbox message %d with a payload that is not JSON as raw bytes", m.ID)
+	m.RawPayload = payload
+}
+
+// payload returns the payload as it was stored.
+func (m *archivedMessage) payload() []byte {
+	if m.RawPayload != nil {
+		return m.RawPayload
+	}
+	return m.Payload
+}
+
+// Archiver moves published messages out of the outbox table once they are
+// older than the retention period. Each batch is written to a gzipped
+// NDJSON file named after its first and last IDs, then deleted.
+type Archiver struct {
+	db        *sql.DB
+	dir       string
+	retention time.Duration
+}
+
+func NewArchiver(db *sql.DB, dir string, retention time.Duration) *Archiver {
+	return &Archiver{db: db, dir: dir, retention: retention}
+}
+
+// Archive archives and purges every message published before the retention
+// period and returns how many it archived. Several processors may archive
+// at once; each batch is locked by the one writing it.
+func (a *Archiver) Archive(ctx context.Context) (int, error) {
+	tr := otel.Tracer("outbox-processor")
+	ctx, span := tr.Start(ctx, "ArchiveOutbox")
+	defer span.End()
+
+	if err := os.MkdirAll(a.dir, 0o755); err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-a.retention)
+	archived := 0
+	for {
+		n, err := a.archiveBatch(ctx, cutoff)
+		archived += n
+		if err != nil || n < archiveBatchSize {
+			return archived, err
+		}
+	}
+}
+
+func (a *Archiver) archiveBatch(ctx context.Context, cutoff time.Time) (int, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, topic, key, payload, created_at, published_at FROM outbox
+		WHERE published_at < $1
+		ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED
+	`, cutoff, archiveBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var messages []archivedMessage
+	var ids []int64
+	for rows.Next() {
+		var m archivedMessage
+		var payload []byte
+		if err := rows.Scan(&m.ID, &m.Topic, &m.Key, &payload, &m.CreatedAt, &m.PublishedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		m.setPayload(payload)
+		messages = append(messages, m)
+		ids = append(ids, m.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(messages) == 0 {
+		return 0, nil
+	}
+
+	// The file is in place before the rows go, so a failure in between only
+	// leaves rows to archive again.
+	name := archiveName(ids[0], ids[len(ids)-1])
+	if err := writeArchiveFile(filepath.Join(a.dir, name), messages); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM outbox WHERE id = ANY($1)", pq.Array(ids)); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	log.Printf("Archived %d outbox messages to %s", len(messages), name)
+	return len(messages), nil
+}
+
+// archiveName names the archive of the messages first to last. IDs are
+// zero-padded so files sort in ID order.
+func archiveName(first, last int64) string {
+	return fmt.Sprintf("outbox-%020d-%020d.ndjson.gz", first, last)
+}
+
+// writeArchiveFile writes messages to path atomically, through a temporary
+// file in the same directory.
+func writeArchiveFile(path string, messages []archivedMessage) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".outbox-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := writeArchive(f, messages); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func writeArchive(w io.Writer, messages []archivedMessage) error {
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	for _, m := range messages {
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// readArchive returns the messages of an archive that match f.
+func readArchive(r io.Reader, f ReplayFilter) ([]OutboxMessage, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var messages []OutboxMessage
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var m archivedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		msg := OutboxMessage{ID: m.ID, Topic: m.Topic, Key: m.Key, Payload: m.payload(), CreatedAt: m.CreatedAt}
+		if f.Matches(msg) {
+			messages = append(messages, msg)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// ReadArchives returns the archived messages in dir that match f.
+func ReadArchives(dir string, f ReplayFilter) ([]OutboxMessage, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "outbox-*.ndjson.gz"))
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []OutboxMessage
+	for _, path := range paths {
+		var first, last int64
+		if _, err := fmt.Sscanf(filepath.Base(path), "outbox-%d-%d.ndjson.gz", &first, &last); err == nil && !f.overlaps(first, last) {
+			continue
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		found, err := readArchive(file, f)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		messages = append(messages, found...)
+	}
+	return messages, nil
+}
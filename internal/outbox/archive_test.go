@@ -0,0 +1,114 @@
+package outbox
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	messages := []archivedMessage{
+		{ID: 1, Topic: "authorization-succeeded", Key: "tx-1", Payload: []byte(`{"transaction_id":"tx-1"}`), CreatedAt: created, PublishedAt: created},
+		{ID: 2, Topic: "capture-requested", Key: "tx-1", Payload: []byte(`{}`), CreatedAt: created.Add(time.Hour), PublishedAt: created.Add(time.Hour)},
+		{ID: 3, Topic: "authorization-succeeded", Key: "tx-2", Payload: []byte(`{"transaction_id":"tx-2"}`), CreatedAt: created.Add(2 * time.Hour), PublishedAt: created.Add(2 * time.Hour)},
+	}
+
+	var buf bytes.Buffer
+	if err := writeArchive(&buf, messages); err != nil {
+		t.Fatalf("writeArchive: %v", err)
+	}
+	archive := buf.Bytes()
+
+	tests := []struct {
+		name   string
+		filter ReplayFilter
+		want   []int64
+	}{
+		{"everything", ReplayFilter{}, []int64{1, 2, 3}},
+		{"id range", ReplayFilter{FromID: 2, ToID: 3}, []int64{2, 3}},
+		{"topic", ReplayFilter{Topic: "authorization-succeeded"}, []int64{1, 3}},
+		{"key", ReplayFilter{Key: "tx-1"}, []int64{1, 2}},
+		{"time window", ReplayFilter{Since: created.Add(time.Hour), Until: created.Add(2 * time.Hour)}, []int64{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, err := readArchive(bytes.NewReader(archive), tt.filter)
+			if err != nil {
+				t.Fatalf("readArchive: %v", err)
+			}
+			var ids []int64
+			for _, msg := range found {
+				ids = append(ids, msg.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("readArchive returned IDs %v, want %v", ids, tt.want)
+			}
+		})
+	}
+
+	found, _ := readArchive(bytes.NewReader(archive), ReplayFilter{FromID: 1, ToID: 1})
+	want := OutboxMessage{ID: 1, Topic: "authorization-succeeded", Key: "tx-1", Payload: []byte(`{"transaction_id":"tx-1"}`), CreatedAt: created}
+	if len(found) != 1 || !reflect.DeepEqual(found[0], want) {
+		t.Errorf("readArchive = %+v, want %+v", found, want)
+	}
+}
+
+func TestArchiveNonJSONPayload(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var messages []archivedMessage
+	for i, payload := range []string{`{"transaction_id":"tx-1"}`, "not json \xff", `{"transaction_id":"tx-2"}`} {
+		m := archivedMessage{ID: int64(i + 1), Topic: "authorization-succeeded", CreatedAt: created, PublishedAt: created}
+		m.setPayload([]byte(payload))
+		messages = append(messages, m)
+	}
+
+	// A payload that is not JSON is archived with the rest of its batch.
+	var buf bytes.Buffer
+	if err := writeArchive(&buf, messages); err != nil {
+		t.Fatalf("writeArchive: %v", err)
+	}
+	found, err := readArchive(&buf, ReplayFilter{})
+	if err != nil {
+		t.Fatalf("readArchive: %v", err)
+	}
+	if len(found) != 3 || string(found[1].Payload) != "not json \xff" || string(found[2].Payload) != `{"transaction_id":"tx-2"}` {
+		t.Errorf("readArchive = %+v, want every payload as stored", found)
+	}
+}
+
+func TestReadArchives(t *testing.T) {
+	dir := t.TempDir()
+	for _, batch := range [][]archivedMessage{
+		{{ID: 1, Payload: []byte(`1`)}, {ID: 2, Payload: []byte(`2`)}},
+		{{ID: 10, Payload: []byte(`10`)}},
+	} {
+		name := filepath.Join(dir, archiveName(batch[0].ID, batch[len(batch)-1].ID))
+		if err := writeArchiveFile(name, batch); err != nil {
+			t.Fatalf("writeArchiveFile: %v", err)
+		}
+	}
+	// A file outside the requested range is not even opened.
+	if err := os.WriteFile(filepath.Join(dir, archiveName(20, 30)), []byte("corrupt"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := ReadArchives(dir, ReplayFilter{FromID: 2, ToID: 10})
+	if err != nil {
+		t.Fatalf("ReadArchives: %v", err)
+	}
+	merged := MergeByID(found, []OutboxMessage{{ID: 2}, {ID: 5}})
+	var ids []int64
+	for _, msg := range merged {
+		ids = append(ids, msg.ID)
+	}
+	if !reflect.DeepEqual(ids, []int64{2, 5, 10}) {
+		t.Errorf("merged IDs = %v, want [2 5 10]", ids)
+	}
+	if string(merged[0].Payload) != "2" {
+		t.Errorf("merged message 2 has payload %q, want the archived copy", merged[0].Payload)
+	}
+}
@@ -0,0 +1,196 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"credit-authorization-ledger/internal/kafka"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// Publisher delivers outbox messages to a destination. PublishBatch returns
+// the outcome of each message at the same index: nil if it was delivered,
+// the error if it was not.
+type Publisher interface {
+	PublishBatch(ctx context.Context, messages []OutboxMessage) []error
+}
+
+// KafkaPublisher publishes messages to Topic, or to each message's own
+// topic if Topic is empty. The outbox ID is the outbox_id header, which
+// consumers can use to discard redelivered messages.
+type KafkaPublisher struct {
+	Producer *kafka.Producer
+	Topic    string
+}
+
+func (p KafkaPublisher) PublishBatch(ctx context.Context, messages []OutboxMessage) []error {
+	records := make([]kafka.Record, len(messages))
+	for i, msg := range messages {
+		topic := p.Topic
+		if topic == "" {
+			topic = msg.Topic
+		}
+		records[i] = kafka.Record{
+			Topic:   topic,
+			Key:     msg.Key,
+			Value:   msg.Payload,
+			Headers: map[string]string{"outbox_id": strconv.FormatInt(msg.ID, 10)},
+		}
+	}
+	return p.Producer.PublishBatch(ctx, records)
+}
+
+// SQSPublisher sends messages to the queue at QueueURL. The payload is the
+// message body; the outbox topic, key and ID are message attributes. On a
+// FIFO queue the key is the message group and the outbox ID deduplicates.
+type SQSPublisher struct {
+	Client   sqsiface.SQSAPI
+	QueueURL string
+}
+
+func (p SQSPublisher) PublishBatch(ctx context.Context, messages []OutboxMessage) []error {
+	fifo := strings.HasSuffix(p.QueueURL, ".fifo")
+	return publishInChunks(ctx, messages, func(ctx context.Context, chunk []OutboxMessage) ([]error, error) {
+		input := &sqs.SendMessageBatchInput{QueueUrl: aws.String(p.QueueURL)}
+		for i, msg := range chunk {
+			entry := &sqs.SendMessageBatchRequestEntry{
+				Id:                aws.String(strconv.Itoa(i)),
+				MessageBody:       aws.String(string(msg.Payload)),
+				MessageAttributes: map[string]*sqs.MessageAttributeValue{},
+			}
+			for name, value := range messageAttributes(msg) {
+				entry.MessageAttributes[name] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+			}
+			if fifo {
+				entry.MessageGroupId = aws.String(messageGroup(msg))
+				entry.MessageDeduplicationId = aws.String(strconv.FormatInt(msg.ID, 10))
+			}
+			input.Entries = append(input.Entries, entry)
+		}
+
+		output, err := p.Client.SendMessageBatchWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		results := make([]error, len(chunk))
+		for _, failed := range output.Failed {
+			i, err := strconv.Atoi(aws.StringValue(failed.Id))
+			if err != nil || i < 0 || i >= len(chunk) {
+				return nil, fmt.Errorf("sqs reported a failure for unknown entry %q", aws.StringValue(failed.Id))
+			}
+			results[i] = fmt.Errorf("sqs: %s: %s", aws.StringValue(failed.Code), aws.StringValue(failed.Message))
+		}
+		return results, nil
+	})
+}
+
+// SNSPublisher publishes messages to the topic TopicARN, with the same body
+// and attributes as SQSPublisher. On a FIFO topic the key is the message
+// group and the outbox ID deduplicates.
+type SNSPublisher struct {
+	Client   snsiface.SNSAPI
+	TopicARN string
+}
+
+func (p SNSPublisher) PublishBatch(ctx context.Context, messages []OutboxMessage) []error {
+	fifo := strings.HasSuffix(p.TopicARN, ".fifo")
+	return publishInChunks(ctx, messages, func(ctx context.Context, chunk []OutboxMessage) ([]error, error) {
+		input := &sns.PublishBatchInput{TopicArn: aws.String(p.TopicARN)}
+		for i, msg := range chunk {
+			entry := &sns.PublishBatchRequestEntry{
+				Id:                aws.String(strconv.Itoa(i)),
+				Message:           aws.String(string(msg.Payload)),
+				MessageAttributes: map[string]*sns.MessageAttributeValue{},
+			}
+			for name, value := range messageAttributes(msg) {
+				entry.MessageAttributes[name] = &sns.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+			}
+			if fifo {
+				entry.MessageGroupId = aws.String(messageGroup(msg))
+				entry.MessageDeduplicationId = aws.String(strconv.FormatInt(msg.ID, 10))
+			}
+			input.PublishBatchRequestEntries = append(input.PublishBatchRequestEntries, entry)
+		}
+
+		output, err := p.Client.PublishBatchWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		results := make([]error, len(chunk))
+		for _, failed := range output.Failed {
+			i, err := strconv.Atoi(aws.StringValue(failed.Id))
+			if err != nil || i < 0 || i >= len(chunk) {
+				return nil, fmt.Errorf("sns reported a failure for unknown entry %q", aws.StringValue(failed.Id))
+			}
+			results[i] = fmt.Errorf("sns: %s: %s", aws.StringValue(failed.Code), aws.StringValue(failed.Message))
+		}
+		return results, nil
+	})
+}
+
+// awsBatchSize is the most entries SQS and SNS accept in one batch call.
+const awsBatchSize = 10
+
+// publishInChunks sends messages awsBatchSize at a time. send returns either
+// an error for the whole chunk or the outcome of each message. A chunk
+// rejected as a whole, for example for exceeding the total size limit, is
+// sent again one message at a time, so only the messages at fault fail.
+func publishInChunks(ctx context.Context, messages []OutboxMessage, send func(context.Context, []OutboxMessage) ([]error, error)) []error {
+	results := make([]error, len(messages))
+	for start := 0; start < len(messages); start += awsBatchSize {
+		end := start + awsBatchSize
+		if end > len(messages) {
+			end = len(messages)
+		}
+		chunk := messages[start:end]
+
+		chunkResults, err := send(ctx, chunk)
+		if err == nil {
+			copy(results[start:end], chunkResults)
+			continue
+		}
+		if ctx.Err() != nil || len(chunk) == 1 {
+			for i := start; i < end; i++ {
+				results[i] = err
+			}
+			continue
+		}
+		for i := range chunk {
+			single, err := send(ctx, chunk[i:i+1])
+			if err == nil {
+				err = single[0]
+			}
+			results[start+i] = err
+		}
+	}
+	return results
+}
+
+// messageAttributes describes where an outbox message came from. Empty
+// values are left out, as SQS and SNS reject them.
+func messageAttributes(msg OutboxMessage) map[string]string {
+	attributes := map[string]string{
+		"outbox_id": strconv.FormatInt(msg.ID, 10),
+		"topic":     msg.Topic,
+	}
+	if msg.Key != "" {
+		attributes["key"] = msg.Key
+	}
+	return attributes
+}
+
+// messageGroup is the FIFO message group of msg: its key, or its topic if
+// it has none.
+func messageGroup(msg OutboxMessage) string {
+	if msg.Key != "" {
+		return msg.Key
+	}
+	return msg.Topic
+}
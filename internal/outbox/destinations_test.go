@@ -0,0 +1,153 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// localstack returns a session for the LocalStack endpoint in
+// LOCALSTACK_ENDPOINT, e.g. http://localhost:4566 with the compose file's
+// localstack service, and skips the test if it is not set.
+func localstack(t *testing.T) *session.Session {
+	endpoint := os.Getenv("LOCALSTACK_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("LOCALSTACK_ENDPOINT is not set")
+	}
+	return session.Must(session.NewSession(aws.NewConfig().
+		WithEndpoint(endpoint).
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials("test", "test", ""))))
+}
+
+// createQueue creates a queue that is deleted when the test ends.
+func createQueue(t *testing.T, client *sqs.SQS, name string) string {
+	out, err := client.CreateQueue(&sqs.CreateQueueInput{QueueName: aws.String(fmt.Sprintf("%s-%d", name, time.Now().UnixNano()))})
+	if err != nil {
+		t.Fatalf("CreateQueue: %v", err)
+	}
+	t.Cleanup(func() { client.DeleteQueue(&sqs.DeleteQueueInput{QueueUrl: out.QueueUrl}) })
+	return aws.StringValue(out.QueueUrl)
+}
+
+// receive reads n messages from queueURL.
+func receive(t *testing.T, client *sqs.SQS, queueURL string, n int) []*sqs.Message {
+	var messages []*sqs.Message
+	deadline := time.Now().Add(10 * time.Second)
+	for len(messages) < n && time.Now().Before(deadline) {
+		out, err := client.ReceiveMessage(&sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(queueURL),
+			MaxNumberOfMessages:   aws.Int64(10),
+			WaitTimeSeconds:       aws.Int64(1),
+			MessageAttributeNames: []*string{aws.String("All")},
+		})
+		if err != nil {
+			t.Fatalf("ReceiveMessage: %v", err)
+		}
+		messages = append(messages, out.Messages...)
+	}
+	if len(messages) != n {
+		t.Fatalf("received %d messages, want %d", len(messages), n)
+	}
+	return messages
+}
+
+func testMessages(n int) []OutboxMessage {
+	messages := make([]OutboxMessage, n)
+	for i := range messages {
+		messages[i] = OutboxMessage{
+			ID:      int64(i + 1),
+			Topic:   "authorization-succeeded",
+			Key:     fmt.Sprintf("tx-%d", i+1),
+			Payload: []byte(fmt.Sprintf(`{"transaction_id":"tx-%d"}`, i+1)),
+		}
+	}
+	return messages
+}
+
+func TestSQSPublisher(t *testing.T) {
+	client := sqs.New(localstack(t))
+	queueURL := createQueue(t, client, "outbox-sqs")
+
+	// More than one batch call's worth.
+	messages := testMessages(12)
+	for i, err := range (SQSPublisher{Client: client, QueueURL: queueURL}).PublishBatch(context.Background(), messages) {
+		if err != nil {
+			t.Fatalf("message %d: %v", messages[i].ID, err)
+		}
+	}
+
+	bodies := map[string]bool{}
+	for _, msg := range receive(t, client, queueURL, len(messages)) {
+		bodies[aws.StringValue(msg.Body)] = true
+		if topic := msg.MessageAttributes["topic"]; topic == nil || aws.StringValue(topic.StringValue) != "authorization-succeeded" {
+			t.Errorf("message %s has topic attribute %v", aws.StringValue(msg.MessageId), topic)
+		}
+	}
+	for _, msg := range messages {
+		if !bodies[string(msg.Payload)] {
+			t.Errorf("message %d was not delivered", msg.ID)
+		}
+	}
+}
+
+func TestSNSPublisher(t *testing.T) {
+	sess := localstack(t)
+	snsClient, sqsClient := sns.New(sess), sqs.New(sess)
+
+	topic, err := snsClient.CreateTopic(&sns.CreateTopicInput{Name: aws.String(fmt.Sprintf("outbox-sns-%d", time.Now().UnixNano()))})
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	t.Cleanup(func() { snsClient.DeleteTopic(&sns.DeleteTopicInput{TopicArn: topic.TopicArn}) })
+
+	queueURL := createQueue(t, sqsClient, "outbox-sns")
+	attributes, err := sqsClient.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(queueURL),
+		AttributeNames: []*string{aws.String(sqs.QueueAttributeNameQueueArn)},
+	})
+	if err != nil {
+		t.Fatalf("GetQueueAttributes: %v", err)
+	}
+	_, err = snsClient.Subscribe(&sns.SubscribeInput{
+		TopicArn:   topic.TopicArn,
+		Protocol:   aws.String("sqs"),
+		Endpoint:   attributes.Attributes[sqs.QueueAttributeNameQueueArn],
+		Attributes: map[string]*string{"RawMessageDelivery": aws.String("true")},
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	messages := testMessages(3)
+	for i, err := range (SNSPublisher{Client: snsClient, TopicARN: aws.StringValue(topic.TopicArn)}).PublishBatch(context.Background(), messages) {
+		if err != nil {
+			t.Fatalf("message %d: %v", messages[i].ID, err)
+		}
+	}
+
+	ids := map[string]bool{}
+	for _, msg := range receive(t, sqsClient, queueURL, len(messages)) {
+		var payload struct {
+			TransactionID string `json:"transaction_id"`
+		}
+		if err := json.Unmarshal([]byte(aws.StringValue(msg.Body)), &payload); err != nil {
+			t.Fatalf("message body %q: %v", aws.StringValue(msg.Body), err)
+		}
+		ids[payload.TransactionID] = true
+	}
+	for _, msg := range messages {
+		if !ids[msg.Key] {
+			t.Errorf("message %d was not delivered", msg.ID)
+		}
+	}
+}
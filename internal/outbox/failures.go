@@ -0,0 +1,59 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// The delay before retrying a message doubles with each failed attempt,
+// from retryBaseDelay up to retryMaxDelay.
+const (
+	retryBaseDelay = 2 * time.Second
+	retryMaxDelay  = 10 * time.Minute
+)
+
+// retryDelay is how long to wait after a message's attempts-th failure.
+func retryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay
+}
+
+// recordFailure counts a failed attempt to publish message id. Once the
+// message has failed maxAttempts times it is moved to outbox_dead and
+// quarantined is true; otherwise retryAt is when it may next be tried.
+func recordFailure(ctx context.Context, db dbtx, id int64, cause error, maxAttempts int) (quarantined bool, retryAt time.Time, err error) {
+	var attempts int
+	err = db.QueryRowContext(ctx,
+		"UPDATE outbox SET attempts = attempts + 1, last_error = $2 WHERE id = $1 RETURNING attempts",
+		id, cause.Error()).Scan(&attempts)
+	if err != nil {
+		return false, retryAt, err
+	}
+
+	if attempts >= maxAttempts {
+		_, err = db.ExecContext(ctx, `
+			WITH dead AS (
+				DELETE FROM outbox WHERE id = $1
+				RETURNING id, topic, key, payload, created_at, attempts, last_error
+			)
+			INSERT INTO outbox_dead (id, topic, key, payload, created_at, attempts, last_error)
+			SELECT id, topic, key, payload, created_at, attempts, last_error FROM dead
+		`, id)
+		if err != nil {
+			return false, retryAt, err
+		}
+		log.Printf("Quarantined outbox message %d after %d failed attempts: %v", id, attempts, cause)
+		return true, retryAt, nil
+	}
+
+	retryAt = time.Now().Add(retryDelay(attempts))
+	_, err = db.ExecContext(ctx, "UPDATE outbox SET next_attempt_at = $2 WHERE id = $1", id, retryAt)
+	return false, retryAt, err
+}
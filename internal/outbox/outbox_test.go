@@ -0,0 +1,50 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeExecer records the statements executed through it.
+type fakeExecer struct {
+	ctx   context.Context
+	query string
+	args  []interface{}
+}
+
+func (e *fakeExecer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	e.ctx, e.query, e.args = ctx, query, args
+	return nil, nil
+}
+
+func TestWriter(t *testing.T) {
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+	tx := &fakeExecer{}
+
+	event := struct {
+		TransactionID string  `json:"transaction_id"`
+		Amount        float64 `json:"amount"`
+	}{"tx-1", 12.5}
+	if err := NewWriter(tx).Write(ctx, "authorization-succeeded", "tx-1", event); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	if tx.ctx != ctx {
+		t.Error("Write did not pass its context to the transaction")
+	}
+	if !strings.Contains(tx.query, "INSERT INTO outbox") {
+		t.Errorf("Write executed %q, want an outbox insert", tx.query)
+	}
+	want := []interface{}{"authorization-succeeded", "tx-1", []byte(`{"transaction_id":"tx-1","amount":12.5}`)}
+	if !reflect.DeepEqual(tx.args, want) {
+		t.Errorf("Write inserted %q, want %q", tx.args, want)
+	}
+
+	if err := NewWriter(tx).Write(ctx, "authorization-succeeded", "tx-1", func() {}); err == nil {
+		t.Error("Write accepted a payload that cannot be encoded")
+	}
+}
@@ -0,0 +1,56 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"credit-authorization-ledger/internal/leader"
+)
+
+// Partitions returns the names of the outbox table's hash partitions, or
+// none if it is not partitioned. A key always hashes to the same partition.
+func Partitions(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'outbox'::regclass ORDER BY c.relname
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var partitions []string
+	for rows.Next() {
+		var partition string
+		if err := rows.Scan(&partition); err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, partition)
+	}
+	return partitions, rows.Err()
+}
+
+// runPartitioned relays each partition in its own worker until ctx is done.
+// A worker only relays while this processor leads its partition, so each
+// partition has one worker across all processors. A partition whose leader
+// dies is taken over by another processor. Batches are relayed on the
+// session holding the partition's lock, and only committed while it still
+// holds it, so a worker that loses it cannot mark messages published behind
+// its successor's back.
+func (r *Relay) runPartitioned(ctx context.Context, partitions []string) {
+	elector := leader.New(r.db, leader.WithRetryInterval(r.opts.PollInterval))
+	var wg sync.WaitGroup
+	for _, partition := range partitions {
+		wg.Add(1)
+		go func(partition string) {
+			defer wg.Done()
+			elector.RunWithSession(ctx, partition, func(ctx context.Context, conn *sql.Conn) {
+				run(ctx, r.opts.PollInterval, func(ctx context.Context) (int, error) {
+					return r.relayFrom(ctx, conn, partition, true)
+				})
+			})
+		}(partition)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,144 @@
+package outbox
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Messages of the pgoutput logical decoding protocol (version 1) the
+// replication relay acts on. Other message types are skipped.
+type (
+	beginMessage struct {
+		FinalLSN uint64
+		XID      uint32
+	}
+	commitMessage struct {
+		CommitLSN uint64
+		// EndLSN is where decoding resumes once this transaction is confirmed.
+		EndLSN uint64
+	}
+	relationMessage struct {
+		ID        uint32
+		Namespace string
+		Name      string
+		Columns   []string
+	}
+	insertMessage struct {
+		RelationID uint32
+		// Values are in text format, nil for NULL columns.
+		Values []*string
+	}
+)
+
+// parsePgoutput decodes one pgoutput message. It returns nil for message
+// types the relay does not need.
+func parsePgoutput(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty pgoutput message")
+	}
+	r := &pgoutputReader{data: data, pos: 1}
+	var msg interface{}
+	switch data[0] {
+	case 'B':
+		begin := beginMessage{FinalLSN: r.uint64()}
+		r.uint64() // commit timestamp
+		begin.XID = r.uint32()
+		msg = begin
+	case 'C':
+		r.byte() // flags, unused
+		msg = commitMessage{CommitLSN: r.uint64(), EndLSN: r.uint64()}
+	case 'R':
+		rel := relationMessage{ID: r.uint32(), Namespace: r.string(), Name: r.string()}
+		r.byte() // replica identity
+		n := int(r.uint16())
+		for i := 0; i < n && r.err == nil; i++ {
+			r.byte() // flags
+			rel.Columns = append(rel.Columns, r.string())
+			r.uint32() // type OID
+			r.uint32() // type modifier
+		}
+		msg = rel
+	case 'I':
+		ins := insertMessage{RelationID: r.uint32()}
+		if kind := r.byte(); kind != 'N' && r.err == nil {
+			return nil, fmt.Errorf("insert carries tuple type %q, want 'N'", kind)
+		}
+		ins.Values = r.tuple()
+		msg = ins
+	default:
+		return nil, nil
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("pgoutput message %q: %w", data[0], r.err)
+	}
+	return msg, nil
+}
+
+// pgoutputReader reads big-endian protocol fields, recording the first
+// error so a message can be decoded without checking every field.
+type pgoutputReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *pgoutputReader) next(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	if r.pos+n > len(r.data) {
+		r.err = errors.New("message truncated")
+		return make([]byte, n)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *pgoutputReader) byte() byte     { return r.next(1)[0] }
+func (r *pgoutputReader) uint16() uint16 { return binary.BigEndian.Uint16(r.next(2)) }
+func (r *pgoutputReader) uint32() uint32 { return binary.BigEndian.Uint32(r.next(4)) }
+func (r *pgoutputReader) uint64() uint64 { return binary.BigEndian.Uint64(r.next(8)) }
+
+func (r *pgoutputReader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	end := r.pos
+	for end < len(r.data) && r.data[end] != 0 {
+		end++
+	}
+	if end == len(r.data) {
+		r.err = errors.New("unterminated string")
+		return ""
+	}
+	s := string(r.data[r.pos:end])
+	r.pos = end + 1
+	return s
+}
+
+func (r *pgoutputReader) tuple() []*string {
+	n := int(r.uint16())
+	values := make([]*string, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		switch kind := r.byte(); kind {
+		case 'n', 'u':
+			// NULL, or an unchanged TOAST value, which inserts never carry.
+			values = append(values, nil)
+		case 't':
+			v := string(r.next(int(r.uint32())))
+			values = append(values, &v)
+		default:
+			if r.err == nil {
+				r.err = fmt.Errorf("column %d has unknown kind %q", i+1, kind)
+			}
+		}
+	}
+	return values
+}
+
+// formatLSN renders a WAL location in the X/Y form Postgres accepts.
+func formatLSN(lsn uint64) string {
+	return fmt.Sprintf("%X/%X", lsn>>32, uint32(lsn))
+}
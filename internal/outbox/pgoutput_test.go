@@ -0,0 +1,114 @@
+package outbox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// pgoutputBuilder writes protocol fields the way Postgres sends them.
+type pgoutputBuilder struct{ bytes.Buffer }
+
+func (b *pgoutputBuilder) uint16(v uint16) { binary.Write(b, binary.BigEndian, v) }
+func (b *pgoutputBuilder) uint32(v uint32) { binary.Write(b, binary.BigEndian, v) }
+func (b *pgoutputBuilder) uint64(v uint64) { binary.Write(b, binary.BigEndian, v) }
+func (b *pgoutputBuilder) string(s string) { b.WriteString(s); b.WriteByte(0) }
+
+func TestParsePgoutput(t *testing.T) {
+	var begin pgoutputBuilder
+	begin.WriteByte('B')
+	begin.uint64(0x1_0000_0200)
+	begin.uint64(0) // commit timestamp
+	begin.uint32(731)
+
+	var relation pgoutputBuilder
+	relation.WriteByte('R')
+	relation.uint32(16384)
+	relation.string("public")
+	relation.string("outbox")
+	relation.WriteByte('d')
+	relation.uint16(3)
+	for _, column := range []string{"id", "topic", "payload"} {
+		relation.WriteByte(0)
+		relation.string(column)
+		relation.uint32(25)
+		relation.uint32(0xFFFFFFFF)
+	}
+
+	var insert pgoutputBuilder
+	insert.WriteByte('I')
+	insert.uint32(16384)
+	insert.WriteByte('N')
+	insert.uint16(3)
+	for _, v := range []string{"42", "authorization-succeeded"} {
+		insert.WriteByte('t')
+		insert.uint32(uint32(len(v)))
+		insert.WriteString(v)
+	}
+	insert.WriteByte('n')
+
+	var commit pgoutputBuilder
+	commit.WriteByte('C')
+	commit.WriteByte(0)
+	commit.uint64(0x1_0000_0200)
+	commit.uint64(0x1_0000_0230)
+	commit.uint64(0) // commit timestamp
+
+	topic, id := "authorization-succeeded", "42"
+	tests := []struct {
+		name string
+		data []byte
+		want interface{}
+	}{
+		{"begin", begin.Bytes(), beginMessage{FinalLSN: 0x1_0000_0200, XID: 731}},
+		{"relation", relation.Bytes(), relationMessage{ID: 16384, Namespace: "public", Name: "outbox", Columns: []string{"id", "topic", "payload"}}},
+		{"insert", insert.Bytes(), insertMessage{RelationID: 16384, Values: []*string{&id, &topic, nil}}},
+		{"commit", commit.Bytes(), commitMessage{CommitLSN: 0x1_0000_0200, EndLSN: 0x1_0000_0230}},
+		{"skipped type", []byte("O"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePgoutput(tt.data)
+			if err != nil {
+				t.Fatalf("parsePgoutput: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePgoutput = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := parsePgoutput(insert.Bytes()[:12]); err == nil {
+		t.Error("parsePgoutput accepted a truncated insert")
+	}
+}
+
+func TestOutboxMessageFromTuple(t *testing.T) {
+	rel := relationMessage{Name: "outbox", Columns: []string{"id", "topic", "key", "payload", "created_at"}}
+	values := []string{"7", "capture-requested", "tx-1", `\x7b7d`, "2024-03-01 12:00:00+00"}
+	ptrs := make([]*string, len(values))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	got, err := outboxMessageFromTuple(rel, ptrs)
+	if err != nil {
+		t.Fatalf("outboxMessageFromTuple: %v", err)
+	}
+	want := OutboxMessage{ID: 7, Topic: "capture-requested", Key: "tx-1", Payload: []byte("{}")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("outboxMessageFromTuple = %+v, want %+v", got, want)
+	}
+
+	values[3] = "{}"
+	if _, err := outboxMessageFromTuple(rel, ptrs); err == nil {
+		t.Error("outboxMessageFromTuple accepted an escape-format payload")
+	}
+}
+
+func TestFormatLSN(t *testing.T) {
+	if got := formatLSN(0x16_B374_D848); got != "16/B374D848" {
+		t.Errorf("formatLSN = %s, want 16/B374D848", got)
+	}
+}
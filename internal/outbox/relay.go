@@ -0,0 +1,258 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"credit-authorization-ledger/internal/kafka"
+	"credit-authorization-ledger/internal/leader"
+
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel"
+)
+
+// RelayOptions tunes a relay. Zero fields take their defaults.
+type RelayOptions struct {
+	// BatchSize is how many messages are published per write (default 100).
+	// In replication mode it is roughly how many changes are decoded at once;
+	// Postgres only returns whole transactions, so a batch may run over.
+	BatchSize int
+	// Isolation is the isolation level of the transaction each polled batch
+	// is selected, published and marked in (default serializable).
+	Isolation sql.IsolationLevel
+	// PollInterval is how often Run looks for new messages (default 2s).
+	PollInterval time.Duration
+	// MaxAttempts is how many times a message may fail to publish before it
+	// is quarantined (default 10).
+	MaxAttempts int
+}
+
+func (o RelayOptions) withDefaults() RelayOptions {
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+	if o.Isolation == sql.LevelDefault {
+		o.Isolation = sql.LevelSerializable
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = 2 * time.Second
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 10
+	}
+	return o
+}
+
+// Relay publishes the messages written to the outbox by polling the outbox
+// table.
+type Relay struct {
+	db        *sql.DB
+	publisher Publisher
+	opts      RelayOptions
+}
+
+func NewRelay(db *sql.DB, publisher Publisher, opts RelayOptions) *Relay {
+	return &Relay{db: db, publisher: publisher, opts: opts.withDefaults()}
+}
+
+// Run relays messages every poll interval until ctx is done. If the outbox
+// table is partitioned, each partition is relayed by its own worker, elected
+// across all processors, so per-key order holds while partitions are
+// relayed in parallel.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		partitions, err := Partitions(ctx, r.db)
+		if err == nil {
+			if len(partitions) == 0 {
+				run(ctx, r.opts.PollInterval, r.RelayOnce)
+			} else {
+				r.runPartitioned(ctx, partitions)
+			}
+			return
+		}
+		log.Printf("error listing outbox partitions: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayOnce publishes a batch of messages from the outbox table with one
+// write and returns how many were published. A message that fails to
+// publish is retried after a backoff, and holds back later messages with
+// the same key until it is published or quarantined.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	return r.relayFrom(ctx, r.db, "outbox", false)
+}
+
+// txBeginner is satisfied by *sql.DB and *sql.Conn.
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// relayFrom relays a batch from table, the outbox or one of its partitions,
+// in a transaction on db. If leased, db is the session holding table's
+// leader lock, and the batch is only committed while it still does.
+func (r *Relay) relayFrom(ctx context.Context, db txBeginner, table string, leased bool) (int, error) {
+	tr := otel.Tracer("outbox-processor")
+	ctx, span := tr.Start(ctx, "RelayOnce")
+	defer span.End()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: r.opts.Isolation})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Select and lock rows to prevent other processor instances from picking
+	// them up. A key's messages are all in the same partition. They are
+	// taken in ID order, the order they were inserted in: created_at is the
+	// start of the writing transaction, so it ties within a transaction and
+	// can run backwards across overlapping ones.
+	quoted := pq.QuoteIdentifier(table)
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, topic, key, payload FROM `+quoted+` o
+		WHERE published_at IS NULL AND next_attempt_at <= NOW()
+		AND NOT EXISTS (
+			SELECT 1 FROM `+quoted+` earlier
+			WHERE earlier.key = o.key AND earlier.id < o.id
+			AND earlier.published_at IS NULL AND earlier.next_attempt_at > NOW()
+		)
+		ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED
+	`, r.opts.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var messages []OutboxMessage
+
+	for rows.Next() {
+		var msg OutboxMessage
+		if err := rows.Scan(&msg.ID, &msg.Topic, &msg.Key, &msg.Payload); err != nil {
+			log.Printf("error scanning outbox message: %v", err)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	if rows.Err() != nil {
+		return 0, rows.Err()
+	}
+
+	if len(messages) == 0 {
+		return 0, nil // Nothing to process
+	}
+
+	log.Printf("Processing %d messages from outbox", len(messages))
+
+	outcome, err := publishBatch(ctx, r.publisher, tx, messages, r.opts.MaxAttempts)
+	if err != nil {
+		return 0, err
+	}
+	if outcome.Err != nil {
+		log.Printf("Published %d of %d messages; the rest will be retried", len(outcome.Published), len(messages))
+	}
+
+	// The messages are only marked published when tx commits, so check the
+	// lease last: a worker that has lost it must not commit behind its
+	// successor's back.
+	if leased {
+		held, err := leader.Held(ctx, tx, table)
+		if err != nil {
+			return 0, err
+		}
+		if !held {
+			return 0, fmt.Errorf("lost the lease on %s", table)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(outcome.Published), nil
+}
+
+// run calls relay at once and then every interval until ctx is done.
+func run(ctx context.Context, interval time.Duration, relay func(context.Context) (int, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := relay(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("error relaying outbox messages: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// batchOutcome is the result of publishing a batch of outbox messages.
+type batchOutcome struct {
+	Published []int64
+	// Err is the first failure of a message that will be retried, at RetryAt.
+	// Quarantined messages do not count.
+	Err     error
+	RetryAt time.Time
+}
+
+// publishBatch publishes messages with one write, marks those written as
+// published and records the failure of each of the others. A failure holds
+// back later messages with the same key: they are not marked published,
+// even if written, so they are published again after the failed message.
+func publishBatch(ctx context.Context, publisher Publisher, db dbtx, messages []OutboxMessage, maxAttempts int) (batchOutcome, error) {
+	var outcome batchOutcome
+	blocked := map[string]bool{}
+	for i, err := range publisher.PublishBatch(ctx, messages) {
+		if blocked[messages[i].Key] || errors.Is(err, kafka.ErrHeldBack) {
+			log.Printf("holding back message %d behind an earlier failure of key %q", messages[i].ID, messages[i].Key)
+			if outcome.Err == nil {
+				// The message it waited for was quarantined; it may go now.
+				outcome.Err, outcome.RetryAt = kafka.ErrHeldBack, time.Now()
+			}
+			continue
+		}
+		if err == nil {
+			outcome.Published = append(outcome.Published, messages[i].ID)
+			continue
+		}
+		log.Printf("failed to publish message %d: %v", messages[i].ID, err)
+		quarantined, retryAt, ferr := recordFailure(ctx, db, messages[i].ID, err, maxAttempts)
+		if ferr != nil {
+			return outcome, ferr
+		}
+		blocked[messages[i].Key] = !quarantined
+		if !quarantined && outcome.Err == nil {
+			outcome.Err, outcome.RetryAt = err, retryAt
+		}
+	}
+
+	// Published messages stay in the outbox, for replay, until the retention
+	// job archives them.
+	return outcome, markPublished(ctx, db, outcome.Published)
+}
+
+// dbtx is satisfied by *sql.DB and *sql.Tx.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// markPublished records that the messages with the given IDs were relayed.
+func markPublished(ctx context.Context, db dbtx, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := db.ExecContext(ctx, "UPDATE outbox SET published_at = NOW() WHERE id = ANY($1)", pq.Array(ids))
+	return err
+}
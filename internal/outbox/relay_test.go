@@ -0,0 +1,449 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"credit-authorization-ledger/internal/dbtest"
+	"credit-authorization-ledger/internal/kafka"
+)
+
+// fakeOutbox serves the statements a Relay runs against an in-memory
+// outbox table. If partitions are set, keys are spread across them by
+// hash.
+type fakeOutbox struct {
+	mu         sync.Mutex
+	rows       []OutboxMessage
+	partitions []string
+	locks      dbtest.Locks
+	attempts   map[int64]int
+	published  map[int64]bool
+	dead       map[int64]bool
+	limits     []int64
+	isolation  []driver.IsolationLevel
+	commits    int
+}
+
+func newFakeOutbox(rows ...OutboxMessage) *fakeOutbox {
+	return &fakeOutbox{
+		rows:      rows,
+		attempts:  map[int64]int{},
+		published: map[int64]bool{},
+		dead:      map[int64]bool{},
+	}
+}
+
+// partition returns the partition holding key.
+func (f *fakeOutbox) partition(key string) string {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return f.partitions[h.Sum32()%uint32(len(f.partitions))]
+}
+
+var selectFrom = regexp.MustCompile(`SELECT id, topic, key, payload FROM "([^"]+)" o`)
+
+func (f *fakeOutbox) Query(s *dbtest.Session, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if rows, ok := f.locks.Query(s, query, args); ok {
+		return rows, nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case strings.Contains(query, "FROM pg_inherits"):
+		var values [][]driver.Value
+		for _, partition := range f.partitions {
+			values = append(values, []driver.Value{partition})
+		}
+		return dbtest.Rows(values...), nil
+	case selectFrom.MatchString(query):
+		table := selectFrom.FindStringSubmatch(query)[1]
+		if table != "outbox" && f.locks.Holder(table) != s {
+			return nil, fmt.Errorf("%s selected on a session that does not hold its lock", table)
+		}
+		limit := args[0].Value.(int64)
+		f.limits = append(f.limits, limit)
+		var values [][]driver.Value
+		for _, msg := range f.rows {
+			if table != "outbox" && f.partition(msg.Key) != table {
+				continue
+			}
+			if !f.published[msg.ID] && !f.dead[msg.ID] && int64(len(values)) < limit {
+				values = append(values, []driver.Value{msg.ID, msg.Topic, msg.Key, msg.Payload})
+			}
+		}
+		return dbtest.Rows(values...), nil
+	case strings.HasPrefix(query, "UPDATE outbox SET attempts"):
+		id := args[0].Value.(int64)
+		f.attempts[id]++
+		return dbtest.Rows([]driver.Value{int64(f.attempts[id])}), nil
+	}
+	return nil, fmt.Errorf("unexpected query %q", query)
+}
+
+func (f *fakeOutbox) Exec(s *dbtest.Session, query string, args []driver.NamedValue) (driver.Result, error) {
+	if res, ok := f.locks.Exec(s, query, args); ok {
+		return res, nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case strings.HasPrefix(query, "UPDATE outbox SET published_at"):
+		for _, id := range strings.Split(strings.Trim(args[0].Value.(string), "{}"), ",") {
+			n, _ := strconv.ParseInt(id, 10, 64)
+			f.published[n] = true
+		}
+	case strings.HasPrefix(query, "UPDATE outbox SET next_attempt_at"):
+	case strings.Contains(query, "INSERT INTO outbox_dead"):
+		f.dead[args[0].Value.(int64)] = true
+	default:
+		return nil, fmt.Errorf("unexpected statement %q", query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (f *fakeOutbox) Begin(s *dbtest.Session, opts driver.TxOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.isolation = append(f.isolation, opts.Isolation)
+	return nil
+}
+
+func (f *fakeOutbox) Commit(s *dbtest.Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commits++
+	return nil
+}
+
+func (f *fakeOutbox) Rollback(s *dbtest.Session) error { return nil }
+
+func (f *fakeOutbox) Close(s *dbtest.Session) { f.locks.Close(s) }
+
+func ids(set map[int64]bool) []int64 {
+	var ids []int64
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func TestRelayOnce(t *testing.T) {
+	table := newFakeOutbox(
+		OutboxMessage{ID: 1, Topic: "authorization-succeeded", Key: "tx-1", Payload: []byte(`{}`)},
+		OutboxMessage{ID: 2, Topic: "authorization-succeeded", Key: "tx-2", Payload: []byte(`{}`)},
+		OutboxMessage{ID: 3, Topic: "capture-requested", Key: "tx-1", Payload: []byte(`{}`)},
+	)
+	publisher := &fakePublisher{fail: map[int64]bool{2: true}}
+	relay := NewRelay(dbtest.Open(table), publisher, RelayOptions{BatchSize: 2, MaxAttempts: 2})
+
+	published, err := relay.RelayOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RelayOnce: %v", err)
+	}
+	if published != 1 || !reflect.DeepEqual(publisher.published, []int64{1, 2}) {
+		t.Errorf("RelayOnce published %d of %v, want message 1 of the first two", published, publisher.published)
+	}
+	if !reflect.DeepEqual(ids(table.published), []int64{1}) || table.attempts[2] != 1 {
+		t.Errorf("published %v with %d attempts at message 2, want [1] and 1 attempt", ids(table.published), table.attempts[2])
+	}
+
+	// Message 2 fails its last attempt and is quarantined.
+	if _, err := relay.RelayOnce(context.Background()); err != nil {
+		t.Fatalf("RelayOnce: %v", err)
+	}
+	if !reflect.DeepEqual(ids(table.published), []int64{1, 3}) || !reflect.DeepEqual(ids(table.dead), []int64{2}) {
+		t.Errorf("published %v and quarantined %v, want [1 3] and [2]", ids(table.published), ids(table.dead))
+	}
+
+	if published, err := relay.RelayOnce(context.Background()); published != 0 || err != nil {
+		t.Errorf("RelayOnce on an empty outbox = %d, %v", published, err)
+	}
+	if table.commits != 2 {
+		t.Errorf("committed %d times, want 2", table.commits)
+	}
+	if !reflect.DeepEqual(table.limits, []int64{2, 2, 2}) {
+		t.Errorf("selected batches of %v, want 2", table.limits)
+	}
+	if table.isolation[0] != driver.IsolationLevel(sql.LevelSerializable) {
+		t.Errorf("relayed at isolation level %d, want serializable", table.isolation[0])
+	}
+}
+
+// batchPublisher returns results from every PublishBatch call.
+type batchPublisher []error
+
+func (p batchPublisher) PublishBatch(ctx context.Context, messages []OutboxMessage) []error {
+	return p
+}
+
+func TestPublishBatchHoldsBack(t *testing.T) {
+	messages := []OutboxMessage{
+		{ID: 1, Topic: "authorization-succeeded", Key: "tx-1"},
+		{ID: 2, Topic: "authorization-succeeded", Key: "tx-2"},
+		{ID: 3, Topic: "capture-requested", Key: "tx-1"},
+		{ID: 4, Topic: "authorization-succeeded", Key: "tx-3"},
+	}
+	rejected := errors.New("rejected")
+	// Message 3 was written after message 1 failed, and the producer held
+	// back message 4 behind a message that is not in this batch.
+	publisher := batchPublisher{rejected, nil, nil, kafka.ErrHeldBack}
+
+	table := newFakeOutbox(messages...)
+	outcome, err := publishBatch(context.Background(), publisher, dbtest.Open(table), messages, 2)
+	if err != nil {
+		t.Fatalf("publishBatch: %v", err)
+	}
+	if !reflect.DeepEqual(outcome.Published, []int64{2}) || outcome.Err != rejected {
+		t.Errorf("published %v with error %v, want [2] and the failure of message 1", outcome.Published, outcome.Err)
+	}
+	if !reflect.DeepEqual(ids(table.published), []int64{2}) || table.attempts[1] != 1 || table.attempts[3] != 0 {
+		t.Errorf("published %v with attempts %v, want [2] and one failed attempt at message 1", ids(table.published), table.attempts)
+	}
+
+	// Once message 1 is quarantined, nothing waits behind it.
+	outcome, err = publishBatch(context.Background(), publisher, dbtest.Open(table), messages, 2)
+	if err != nil {
+		t.Fatalf("publishBatch: %v", err)
+	}
+	if !reflect.DeepEqual(outcome.Published, []int64{2, 3}) || !reflect.DeepEqual(ids(table.dead), []int64{1}) {
+		t.Errorf("published %v and quarantined %v, want [2 3] and [1]", outcome.Published, ids(table.dead))
+	}
+	if outcome.Err != kafka.ErrHeldBack {
+		t.Errorf("outcome error = %v, want message 4 held back for a retry", outcome.Err)
+	}
+}
+
+func TestRelayOptions(t *testing.T) {
+	opts := RelayOptions{Isolation: sql.LevelReadCommitted}.withDefaults()
+	want := RelayOptions{BatchSize: 100, Isolation: sql.LevelReadCommitted, PollInterval: 2 * time.Second, MaxAttempts: 10}
+	if opts != want {
+		t.Errorf("withDefaults = %+v, want %+v", opts, want)
+	}
+
+	table := newFakeOutbox()
+	relay := NewRelay(dbtest.Open(table), &fakePublisher{}, RelayOptions{Isolation: sql.LevelReadCommitted})
+	if _, err := relay.RelayOnce(context.Background()); err != nil {
+		t.Fatalf("RelayOnce: %v", err)
+	}
+	if table.isolation[0] != driver.IsolationLevel(sql.LevelReadCommitted) {
+		t.Errorf("relayed at isolation level %d, want read committed", table.isolation[0])
+	}
+}
+
+func TestRelayRun(t *testing.T) {
+	table := newFakeOutbox(OutboxMessage{ID: 1, Topic: "authorization-succeeded", Key: "tx-1", Payload: []byte(`{}`)})
+	relay := NewRelay(dbtest.Open(table), &fakePublisher{}, RelayOptions{PollInterval: time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		relay.Run(ctx)
+		close(done)
+	}()
+
+	waitFor(t, table, "the message to be relayed", func() bool { return table.published[1] })
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return once its context was done")
+	}
+}
+
+// waitFor polls until cond, called with table locked, holds.
+func waitFor(t *testing.T, table *fakeOutbox, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		table.mu.Lock()
+		ok := cond()
+		table.mu.Unlock()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRelayPartitions(t *testing.T) {
+	table := newFakeOutbox()
+	table.partitions = []string{"outbox_p0", "outbox_p1"}
+	byPartition := map[string][]int64{}
+	for id := int64(1); id <= 20; id++ {
+		key := fmt.Sprintf("tx-%d", id%7)
+		table.rows = append(table.rows, OutboxMessage{ID: id, Topic: "authorization-succeeded", Key: key, Payload: []byte(`{}`)})
+		byPartition[table.partition(key)] = append(byPartition[table.partition(key)], id)
+	}
+	if len(byPartition["outbox_p0"]) == 0 || len(byPartition["outbox_p1"]) == 0 {
+		t.Fatalf("test keys all hash to one partition: %v", byPartition)
+	}
+	// Another processor is relaying outbox_p1.
+	table.locks.Take("outbox_p1", &dbtest.Session{})
+
+	publisher := &syncPublisher{}
+	relay := NewRelay(dbtest.Open(table), publisher, RelayOptions{BatchSize: 3, PollInterval: time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		relay.Run(ctx)
+		close(done)
+	}()
+
+	waitFor(t, table, "outbox_p0 to be relayed", func() bool { return len(table.published) == len(byPartition["outbox_p0"]) })
+	for _, id := range byPartition["outbox_p1"] {
+		if table.published[id] {
+			t.Errorf("message %d was relayed while another processor held its partition", id)
+		}
+	}
+
+	// The other processor goes away and this one takes over its partition.
+	table.locks.Release("outbox_p1")
+	waitFor(t, table, "outbox_p1 to be relayed", func() bool { return len(table.published) == len(table.rows) })
+
+	cancel()
+	<-done
+	if held := table.locks.Held(); len(held) != 0 {
+		t.Errorf("partition locks %v are still held after Run returned", held)
+	}
+
+	// Each key's messages were published in order.
+	last := map[string]int64{}
+	for _, msg := range publisher.messages() {
+		if msg.ID < last[msg.Key] {
+			t.Errorf("message %d of key %s was published after message %d", msg.ID, msg.Key, last[msg.Key])
+		}
+		last[msg.Key] = msg.ID
+	}
+}
+
+// losingPublisher publishes a batch and, meanwhile, loses its worker's
+// lease.
+type losingPublisher struct {
+	locks     *dbtest.Locks
+	partition string
+}
+
+func (p losingPublisher) PublishBatch(ctx context.Context, messages []OutboxMessage) []error {
+	p.locks.Release(p.partition)
+	return make([]error, len(messages))
+}
+
+func TestRelayFencesLostLease(t *testing.T) {
+	ctx := context.Background()
+	table := newFakeOutbox(OutboxMessage{ID: 1, Topic: "authorization-succeeded", Key: "tx-1", Payload: []byte(`{}`)})
+	table.partitions = []string{"outbox_p0"}
+	db := dbtest.Open(table)
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var locked bool
+	var pid int64
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1, hashtext($2)), pg_backend_pid()", 0, "outbox_p0").Scan(&locked, &pid); err != nil || !locked {
+		t.Fatalf("locking outbox_p0 = %t, %v", locked, err)
+	}
+
+	relay := NewRelay(db, losingPublisher{&table.locks, "outbox_p0"}, RelayOptions{})
+	if _, err := relay.relayFrom(ctx, conn, "outbox_p0", true); err == nil || !strings.Contains(err.Error(), "lost the lease") {
+		t.Errorf("relayFrom after losing the lease = %v, want an error", err)
+	}
+	if table.commits != 0 {
+		t.Errorf("committed %d times after losing the lease, want 0", table.commits)
+	}
+}
+
+// syncPublisher records what it publishes from several workers at once.
+type syncPublisher struct {
+	mu        sync.Mutex
+	published []OutboxMessage
+}
+
+func (p *syncPublisher) PublishBatch(ctx context.Context, messages []OutboxMessage) []error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.published = append(p.published, messages...)
+	return make([]error, len(messages))
+}
+
+func (p *syncPublisher) messages() []OutboxMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]OutboxMessage(nil), p.published...)
+}
+
+func TestRelayOrderPostgres(t *testing.T) {
+	ctx := context.Background()
+	db := dbtest.Postgres(t, dbtest.Outbox)
+	write := func(tx *sql.Tx, topic, key string) {
+		t.Helper()
+		if err := NewWriter(tx).Write(ctx, topic, key, struct{}{}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	begin := func() *sql.Tx {
+		t.Helper()
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+
+	// tx-1's messages are written in one transaction, so they have the same
+	// created_at.
+	tx := begin()
+	write(tx, "authorization-succeeded", "tx-1")
+	write(tx, "capture-requested", "tx-1")
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// tx-2's second message comes from a transaction that began first, so
+	// it has the later ID but the earlier created_at.
+	early := begin()
+	defer early.Rollback()
+	if _, err := early.Exec("SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	late := begin()
+	write(late, "authorization-succeeded", "tx-2")
+	if err := late.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	write(early, "capture-requested", "tx-2")
+	if err := early.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	publisher := &syncPublisher{}
+	if published, err := NewRelay(db, publisher, RelayOptions{}).RelayOnce(ctx); err != nil || published != 4 {
+		t.Fatalf("RelayOnce = %d, %v; want 4 published", published, err)
+	}
+	var order []string
+	for _, msg := range publisher.messages() {
+		order = append(order, msg.Key+" "+msg.Topic)
+	}
+	want := "tx-1 authorization-succeeded, tx-1 capture-requested, tx-2 authorization-succeeded, tx-2 capture-requested"
+	if got := strings.Join(order, ", "); got != want {
+		t.Errorf("published %s, want %s", got, want)
+	}
+}
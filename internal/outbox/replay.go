@@ -0,0 +1,110 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ReplayFilter selects messages to republish. Zero fields do not filter.
+// ID bounds are inclusive; the time window covers Since up to, but not
+// including, Until, by when the message was written.
+type ReplayFilter struct {
+	FromID int64
+	ToID   int64
+	Topic  string
+	Key    string
+	Since  time.Time
+	Until  time.Time
+}
+
+// IsZero reports whether the filter selects every message.
+func (f ReplayFilter) IsZero() bool {
+	return f == ReplayFilter{}
+}
+
+// Matches reports whether msg is selected by the filter.
+func (f ReplayFilter) Matches(msg OutboxMessage) bool {
+	switch {
+	case f.FromID != 0 && msg.ID < f.FromID,
+		f.ToID != 0 && msg.ID > f.ToID,
+		f.Topic != "" && msg.Topic != f.Topic,
+		f.Key != "" && msg.Key != f.Key,
+		!f.Since.IsZero() && msg.CreatedAt.Before(f.Since),
+		!f.Until.IsZero() && !msg.CreatedAt.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// overlaps reports whether the ID range first..last can hold a match.
+func (f ReplayFilter) overlaps(first, last int64) bool {
+	return (f.FromID == 0 || last >= f.FromID) && (f.ToID == 0 || first <= f.ToID)
+}
+
+// FindPublished returns the published messages still in the outbox table
+// that match f, in ID order. Unpublished messages are left to the relay.
+func FindPublished(ctx context.Context, db *sql.DB, f ReplayFilter) ([]OutboxMessage, error) {
+	conditions := []string{"published_at IS NOT NULL"}
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if f.FromID != 0 {
+		add("id >= $%d", f.FromID)
+	}
+	if f.ToID != 0 {
+		add("id <= $%d", f.ToID)
+	}
+	if f.Topic != "" {
+		add("topic = $%d", f.Topic)
+	}
+	if f.Key != "" {
+		add("key = $%d", f.Key)
+	}
+	if !f.Since.IsZero() {
+		add("created_at >= $%d", f.Since)
+	}
+	if !f.Until.IsZero() {
+		add("created_at < $%d", f.Until)
+	}
+
+	rows, err := db.QueryContext(ctx,
+		"SELECT id, topic, key, payload, created_at FROM outbox WHERE "+strings.Join(conditions, " AND ")+" ORDER BY id",
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []OutboxMessage
+	for rows.Next() {
+		var msg OutboxMessage
+		if err := rows.Scan(&msg.ID, &msg.Topic, &msg.Key, &msg.Payload, &msg.CreatedAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// MergeByID combines message sets into one in ID order, keeping the first
+// copy of a message found in more than one.
+func MergeByID(sets ...[]OutboxMessage) []OutboxMessage {
+	seen := map[int64]bool{}
+	var merged []OutboxMessage
+	for _, set := range sets {
+		for _, msg := range set {
+			if !seen[msg.ID] {
+				seen[msg.ID] = true
+				merged = append(merged, msg)
+			}
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].ID < merged[j].ID })
+	return merged
+}
@@ -0,0 +1,317 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel"
+)
+
+// Publication is the publication of outbox inserts the replication relay
+// subscribes to. It is created by the outbox migrations.
+const Publication = "outbox_publication"
+
+// ReplicationRelay publishes outbox rows from the inserts logical decoding
+// reports for them, in commit order, instead of polling and deleting rows.
+// Its position is the confirmed LSN of a pgoutput replication slot, advanced
+// once each transaction's rows are published, so a restart resumes after
+// the last confirmed transaction. Rows are marked published, as in polling
+// mode.
+//
+// Commit order leaves nothing to publish around a message that fails, so
+// the whole relay backs off until it is retried. After MaxAttempts failures
+// the message is quarantined and skipped.
+//
+// A slot serves one consumer at a time, so only one replication relay may
+// run per slot.
+type ReplicationRelay struct {
+	db        *sql.DB
+	publisher Publisher
+	slot      string
+	opts      RelayOptions
+	relations map[uint32]relationMessage
+	retryAt   time.Time
+}
+
+// replicatedTransaction is the outbox rows one committed transaction inserted.
+type replicatedTransaction struct {
+	XID      uint32
+	EndLSN   uint64
+	Messages []OutboxMessage
+}
+
+// NewReplicationRelay returns a relay reading from slot. Each transaction's
+// rows are published as one batch, so opts.Isolation does not apply.
+func NewReplicationRelay(db *sql.DB, publisher Publisher, slot string, opts RelayOptions) *ReplicationRelay {
+	return &ReplicationRelay{
+		db:        db,
+		publisher: publisher,
+		slot:      slot,
+		opts:      opts.withDefaults(),
+		relations: map[uint32]relationMessage{},
+	}
+}
+
+// Run relays changes every poll interval until ctx is done.
+func (r *ReplicationRelay) Run(ctx context.Context) {
+	run(ctx, r.opts.PollInterval, r.RelayChanges)
+}
+
+// EnsureSlot creates the replication slot on first start. Creating a slot
+// requires wal_level = logical. Rows inserted before the slot existed are
+// not relayed.
+func (r *ReplicationRelay) EnsureSlot(ctx context.Context) error {
+	var confirmed sql.NullString
+	err := r.db.QueryRowContext(ctx,
+		"SELECT confirmed_flush_lsn::text FROM pg_replication_slots WHERE slot_name = $1", r.slot).Scan(&confirmed)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = r.db.QueryRowContext(ctx,
+			"SELECT lsn::text FROM pg_create_logical_replication_slot($1, 'pgoutput')", r.slot).Scan(&confirmed)
+		if err != nil {
+			return fmt.Errorf("creating replication slot %s: %w", r.slot, err)
+		}
+		log.Printf("Created replication slot %s at %s", r.slot, confirmed.String)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Resuming replication slot %s from %s", r.slot, confirmed.String)
+	return nil
+}
+
+// RelayChanges publishes the outbox rows of every transaction committed
+// since the confirmed LSN and returns how many were published. A failure
+// leaves the slot at the last fully published transaction. Once the backoff
+// has passed, the rows of its successor that were not published are tried
+// again.
+func (r *ReplicationRelay) RelayChanges(ctx context.Context) (int, error) {
+	if time.Now().Before(r.retryAt) {
+		return 0, nil
+	}
+
+	tr := otel.Tracer("outbox-processor")
+	ctx, span := tr.Start(ctx, "RelayChanges")
+	defer span.End()
+
+	published := 0
+	for {
+		txns, changes, err := r.peek(ctx)
+		if err != nil {
+			return published, err
+		}
+
+		// Transactions without outbox rows are only confirmed, once, at the end.
+		var unconfirmed uint64
+		for _, txn := range txns {
+			if len(txn.Messages) == 0 {
+				unconfirmed = txn.EndLSN
+				continue
+			}
+			messages, err := r.unpublished(ctx, txn.Messages)
+			if err != nil {
+				return published, err
+			}
+			outcome, err := publishBatch(ctx, r.publisher, r.db, messages, r.opts.MaxAttempts)
+			published += len(outcome.Published)
+			if err != nil {
+				return published, err
+			}
+			if outcome.Err != nil {
+				log.Printf("failed to publish transaction %d: %v", txn.XID, outcome.Err)
+				r.retryAt = outcome.RetryAt
+				return published, outcome.Err
+			}
+			if err := r.confirm(ctx, txn.EndLSN); err != nil {
+				return published, err
+			}
+			unconfirmed = 0
+		}
+		if unconfirmed != 0 {
+			if err := r.confirm(ctx, unconfirmed); err != nil {
+				return published, err
+			}
+		}
+
+		if changes < r.opts.BatchSize {
+			if published > 0 {
+				log.Printf("Relayed %d messages from the replication slot", published)
+			}
+			return published, nil
+		}
+	}
+}
+
+// peek decodes the next batch of committed transactions without consuming
+// them, and reports how many changes the batch held.
+func (r *ReplicationRelay) peek(ctx context.Context) ([]replicatedTransaction, int, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT data FROM pg_logical_slot_peek_binary_changes($1, NULL, $2, 'proto_version', '1', 'publication_names', $3)
+	`, r.slot, r.opts.BatchSize, Publication)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var txns []replicatedTransaction
+	var current *replicatedTransaction
+	changes := 0
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, 0, err
+		}
+		changes++
+		msg, err := parsePgoutput(data)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		switch m := msg.(type) {
+		case beginMessage:
+			current = &replicatedTransaction{XID: m.XID}
+		case relationMessage:
+			r.relations[m.ID] = m
+		case insertMessage:
+			if current == nil {
+				return nil, 0, fmt.Errorf("insert into relation %d outside a transaction", m.RelationID)
+			}
+			rel, err := r.relation(ctx, m.RelationID)
+			if err != nil {
+				return nil, 0, err
+			}
+			if rel.Name != "outbox" {
+				continue
+			}
+			out, err := outboxMessageFromTuple(rel, m.Values)
+			if err != nil {
+				return nil, 0, fmt.Errorf("transaction %d: %w", current.XID, err)
+			}
+			current.Messages = append(current.Messages, out)
+		case commitMessage:
+			if current == nil {
+				return nil, 0, fmt.Errorf("commit at %s outside a transaction", formatLSN(m.CommitLSN))
+			}
+			current.EndLSN = m.EndLSN
+			txns = append(txns, *current)
+			current = nil
+		}
+	}
+	return txns, changes, rows.Err()
+}
+
+// unpublished returns the messages still waiting in the outbox. A
+// transaction that failed part way is decoded again on retry; its rows that
+// were published or quarantined meanwhile are left out.
+func (r *ReplicationRelay) unpublished(ctx context.Context, messages []OutboxMessage) ([]OutboxMessage, error) {
+	ids := make([]int64, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT id FROM outbox WHERE id = ANY($1) AND published_at IS NULL", pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	pending := make(map[int64]bool, len(ids))
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		pending[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var unpublished []OutboxMessage
+	for _, msg := range messages {
+		if pending[msg.ID] {
+			unpublished = append(unpublished, msg)
+		}
+	}
+	return unpublished, nil
+}
+
+// confirm advances the slot past the transaction ending at lsn.
+func (r *ReplicationRelay) confirm(ctx context.Context, lsn uint64) error {
+	_, err := r.db.ExecContext(ctx, "SELECT pg_replication_slot_advance($1, $2::pg_lsn)", r.slot, formatLSN(lsn))
+	return err
+}
+
+// relation returns the columns of a relation. pgoutput describes each
+// relation once per decoding session, so ones described in an earlier
+// session are looked up in the catalog.
+func (r *ReplicationRelay) relation(ctx context.Context, id uint32) (relationMessage, error) {
+	if rel, ok := r.relations[id]; ok {
+		return rel, nil
+	}
+
+	rel := relationMessage{ID: id}
+	err := r.db.QueryRowContext(ctx, `
+		SELECT n.nspname, c.relname FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace WHERE c.oid = $1
+	`, int64(id)).Scan(&rel.Namespace, &rel.Name)
+	if err != nil {
+		return rel, fmt.Errorf("looking up relation %d: %w", id, err)
+	}
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT attname FROM pg_attribute WHERE attrelid = $1 AND attnum > 0 AND NOT attisdropped ORDER BY attnum", int64(id))
+	if err != nil {
+		return rel, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return rel, err
+		}
+		rel.Columns = append(rel.Columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return rel, err
+	}
+	r.relations[id] = rel
+	return rel, nil
+}
+
+// outboxMessageFromTuple builds a message from the text-format column
+// values of an outbox insert.
+func outboxMessageFromTuple(rel relationMessage, values []*string) (OutboxMessage, error) {
+	var msg OutboxMessage
+	if len(values) != len(rel.Columns) {
+		return msg, fmt.Errorf("outbox insert has %d values for %d columns", len(values), len(rel.Columns))
+	}
+	columns := map[string]string{}
+	for i, v := range values {
+		if v != nil {
+			columns[rel.Columns[i]] = *v
+		}
+	}
+
+	id, err := strconv.ParseInt(columns["id"], 10, 64)
+	if err != nil {
+		return msg, fmt.Errorf("outbox insert has invalid id %q", columns["id"])
+	}
+	payload, ok := columns["payload"]
+	if !ok || !strings.HasPrefix(payload, `\x`) {
+		return msg, fmt.Errorf("outbox message %d: payload is not hex-encoded bytea", id)
+	}
+	msg.Payload, err = hex.DecodeString(payload[2:])
+	if err != nil {
+		return msg, fmt.Errorf("outbox message %d: %w", id, err)
+	}
+	msg.ID = id
+	msg.Topic = columns["topic"]
+	msg.Key = columns["key"]
+	return msg, nil
+}
@@ -0,0 +1,169 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"credit-authorization-ledger/internal/kafka"
+
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// Destination is where a route sends messages. Kind is "kafka", "sqs" or
+// "sns", and Target the Kafka topic, SQS queue URL or SNS topic ARN. A Kafka
+// destination without a target keeps each message's own topic.
+type Destination struct {
+	Kind   string
+	Target string
+}
+
+func (d Destination) String() string {
+	if d.Target == "" {
+		return d.Kind
+	}
+	return d.Kind + ":" + d.Target
+}
+
+// ParseRoutes parses outbox routes of the form
+// "topic=destination,destination;topic=destination". Each destination is
+// kafka, kafka:<topic>, sqs:<queue URL> or sns:<topic ARN>.
+func ParseRoutes(spec string) (map[string][]Destination, error) {
+	routes := make(map[string][]Destination)
+	for _, rule := range strings.Split(spec, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		topic, destinations, ok := strings.Cut(rule, "=")
+		topic = strings.TrimSpace(topic)
+		if !ok || topic == "" {
+			return nil, fmt.Errorf("invalid outbox route %q: want topic=destination,...", rule)
+		}
+		if _, ok := routes[topic]; ok {
+			return nil, fmt.Errorf("outbox topic %q is routed twice", topic)
+		}
+
+		for _, destination := range strings.Split(destinations, ",") {
+			kind, target, _ := strings.Cut(strings.TrimSpace(destination), ":")
+			d := Destination{Kind: kind, Target: target}
+			switch {
+			case kind != "kafka" && kind != "sqs" && kind != "sns":
+				return nil, fmt.Errorf("outbox topic %q has unknown destination %q", topic, destination)
+			case kind != "kafka" && target == "":
+				return nil, fmt.Errorf("outbox topic %q has %s destination without a target", topic, kind)
+			}
+			routes[topic] = append(routes[topic], d)
+		}
+	}
+	return routes, nil
+}
+
+// Destinations holds the clients destinations are published with.
+type Destinations struct {
+	Kafka *kafka.Producer
+	SQS   sqsiface.SQSAPI
+	SNS   snsiface.SNSAPI
+}
+
+// NewDestinations publishes with producer, and with SQS and SNS clients for
+// sess.
+func NewDestinations(producer *kafka.Producer, sess client.ConfigProvider) Destinations {
+	return Destinations{Kafka: producer, SQS: sqs.New(sess), SNS: sns.New(sess)}
+}
+
+// Open returns the publisher for d.
+func (c Destinations) Open(d Destination) (Publisher, error) {
+	switch d.Kind {
+	case "kafka":
+		return KafkaPublisher{Producer: c.Kafka, Topic: d.Target}, nil
+	case "sqs":
+		return SQSPublisher{Client: c.SQS, QueueURL: d.Target}, nil
+	case "sns":
+		return SNSPublisher{Client: c.SNS, TopicARN: d.Target}, nil
+	}
+	return nil, fmt.Errorf("unknown outbox destination %q", d)
+}
+
+// Router is a Publisher that publishes each message to every destination
+// routed for its topic. Topics without a route are published to Kafka
+// under their own name. A message is only delivered once all of its
+// destinations have accepted it; a retry publishes it to all of them again.
+type Router struct {
+	publishers   []Publisher
+	destinations []Destination
+	routes       map[string][]int
+	fallback     int
+}
+
+// NewRouter builds a router for routes, opening each distinct destination
+// once with open.
+func NewRouter(routes map[string][]Destination, open func(Destination) (Publisher, error)) (*Router, error) {
+	r := &Router{routes: make(map[string][]int)}
+	opened := make(map[Destination]int)
+	index := func(d Destination) (int, error) {
+		if i, ok := opened[d]; ok {
+			return i, nil
+		}
+		publisher, err := open(d)
+		if err != nil {
+			return 0, err
+		}
+		opened[d] = len(r.publishers)
+		r.publishers = append(r.publishers, publisher)
+		r.destinations = append(r.destinations, d)
+		return opened[d], nil
+	}
+
+	var err error
+	if r.fallback, err = index(Destination{Kind: "kafka"}); err != nil {
+		return nil, err
+	}
+	for topic, destinations := range routes {
+		for _, d := range destinations {
+			i, err := index(d)
+			if err != nil {
+				return nil, err
+			}
+			r.routes[topic] = append(r.routes[topic], i)
+		}
+	}
+	return r, nil
+}
+
+// PublishBatch publishes each destination's share of messages with one
+// call, in order. A message that fails at any destination reports the
+// first such error.
+func (r *Router) PublishBatch(ctx context.Context, messages []OutboxMessage) []error {
+	shares := make([][]int, len(r.publishers))
+	for i, msg := range messages {
+		destinations, ok := r.routes[msg.Topic]
+		if !ok {
+			destinations = []int{r.fallback}
+		}
+		for _, d := range destinations {
+			shares[d] = append(shares[d], i)
+		}
+	}
+
+	results := make([]error, len(messages))
+	for d, share := range shares {
+		if len(share) == 0 {
+			continue
+		}
+		batch := make([]OutboxMessage, len(share))
+		for j, i := range share {
+			batch[j] = messages[i]
+		}
+		for j, err := range r.publishers[d].PublishBatch(ctx, batch) {
+			if err != nil && results[share[j]] == nil {
+				results[share[j]] = fmt.Errorf("%s: %w", r.destinations[d], err)
+			}
+		}
+	}
+	return results
+}
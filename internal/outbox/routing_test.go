@@ -0,0 +1,143 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ParseRoutes("authorization-succeeded=kafka, sns:arn:aws:sns:us-east-1:000000000000:authorizations; statement-generated=sqs:http://localstack:4566/000000000000/statements;capture-requested=kafka:captures")
+	if err != nil {
+		t.Fatalf("ParseRoutes: %v", err)
+	}
+	want := map[string][]Destination{
+		"authorization-succeeded": {{Kind: "kafka"}, {Kind: "sns", Target: "arn:aws:sns:us-east-1:000000000000:authorizations"}},
+		"statement-generated":     {{Kind: "sqs", Target: "http://localstack:4566/000000000000/statements"}},
+		"capture-requested":       {{Kind: "kafka", Target: "captures"}},
+	}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("ParseRoutes = %v, want %v", routes, want)
+	}
+
+	if routes, err := ParseRoutes(""); err != nil || len(routes) != 0 {
+		t.Errorf("ParseRoutes(\"\") = %v, %v, want no routes", routes, err)
+	}
+	for _, spec := range []string{
+		"authorization-succeeded",
+		"=kafka",
+		"authorization-succeeded=rabbitmq:events",
+		"authorization-succeeded=sqs",
+		"authorization-succeeded=kafka;authorization-succeeded=sns:arn",
+	} {
+		if _, err := ParseRoutes(spec); err == nil {
+			t.Errorf("ParseRoutes(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+// fakePublisher records what it is asked to publish and fails the
+// messages with IDs in fail.
+type fakePublisher struct {
+	published []int64
+	fail      map[int64]bool
+}
+
+func (p *fakePublisher) PublishBatch(ctx context.Context, messages []OutboxMessage) []error {
+	results := make([]error, len(messages))
+	for i, msg := range messages {
+		p.published = append(p.published, msg.ID)
+		if p.fail[msg.ID] {
+			results[i] = errors.New("rejected")
+		}
+	}
+	return results
+}
+
+func TestRouter(t *testing.T) {
+	publishers := map[Destination]*fakePublisher{}
+	open := func(d Destination) (Publisher, error) {
+		publishers[d] = &fakePublisher{}
+		return publishers[d], nil
+	}
+	queue := Destination{Kind: "sqs", Target: "http://localstack:4566/000000000000/statements"}
+	router, err := NewRouter(map[string][]Destination{
+		"authorization-succeeded": {{Kind: "kafka"}, queue},
+		"statement-generated":     {queue},
+	}, open)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	if len(publishers) != 2 {
+		t.Fatalf("opened %d destinations, want 2", len(publishers))
+	}
+	publishers[queue].fail = map[int64]bool{1: true}
+
+	results := router.PublishBatch(context.Background(), []OutboxMessage{
+		{ID: 1, Topic: "authorization-succeeded"},
+		{ID: 2, Topic: "statement-generated"},
+		{ID: 3, Topic: "capture-requested"},
+		{ID: 4, Topic: "authorization-succeeded"},
+	})
+
+	if got := publishers[Destination{Kind: "kafka"}].published; !reflect.DeepEqual(got, []int64{1, 3, 4}) {
+		t.Errorf("published %v to kafka, want [1 3 4]", got)
+	}
+	if got := publishers[queue].published; !reflect.DeepEqual(got, []int64{1, 2, 4}) {
+		t.Errorf("published %v to sqs, want [1 2 4]", got)
+	}
+	if results[0] == nil || results[0].Error() != "sqs:http://localstack:4566/000000000000/statements: rejected" {
+		t.Errorf("message 1 failed with %v, want the sqs error", results[0])
+	}
+	for i, err := range results[1:] {
+		if err != nil {
+			t.Errorf("message %d failed: %v", i+2, err)
+		}
+	}
+}
+
+func TestPublishInChunks(t *testing.T) {
+	messages := make([]OutboxMessage, 23)
+	for i := range messages {
+		messages[i] = OutboxMessage{ID: int64(i + 1)}
+	}
+
+	var chunks [][]int64
+	tooLarge := errors.New("batch too large")
+	results := publishInChunks(context.Background(), messages, func(ctx context.Context, chunk []OutboxMessage) ([]error, error) {
+		var ids []int64
+		for _, msg := range chunk {
+			ids = append(ids, msg.ID)
+		}
+		chunks = append(chunks, ids)
+
+		// The second chunk is rejected as a whole because of message 15.
+		results := make([]error, len(chunk))
+		for i, msg := range chunk {
+			switch msg.ID {
+			case 15:
+				return nil, tooLarge
+			case 22:
+				results[i] = errors.New("throttled")
+			}
+		}
+		return results, nil
+	})
+
+	if len(chunks) != 3+10 {
+		t.Errorf("sent %d chunks, want 3 and 10 retries: %v", len(chunks), chunks)
+	}
+	for i, err := range results {
+		var want error
+		switch i + 1 {
+		case 15:
+			want = tooLarge
+		case 22:
+			want = errors.New("throttled")
+		}
+		if !reflect.DeepEqual(err, want) {
+			t.Errorf("message %d: got %v, want %v", i+1, err, want)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package reconciliation
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"credit-authorization-ledger/internal/authorization"
+	"credit-authorization-ledger/internal/outbox"
+	"credit-authorization-ledger/pkg/events"
+)
+
+// Break kinds.
+const (
+	// BreakMissingLedgerEntry is a succeeded authorization with nothing posted.
+	BreakMissingLedgerEntry = "MISSING_LEDGER_ENTRY"
+	// BreakOrphanLedgerEntry is a posted transaction without a succeeded or
+	// reversed authorization.
+	BreakOrphanLedgerEntry = "ORPHAN_LEDGER_ENTRY"
+	// BreakAmountMismatch is a transaction whose cardholder postings differ
+	// from the authorized amount less succeeded refunds and disputes the
+	// cardholder has not lost.
+	BreakAmountMismatch = "AMOUNT_MISMATCH"
+	// BreakStalePending is an authorization or refund left pending too long.
+	BreakStalePending = "STALE_PENDING"
+)
+
+// Break is one disagreement between the authorizations and the ledger.
+type Break struct {
+	Kind                string  `json:"kind"`
+	TransactionID       string  `json:"transaction_id"`
+	UserID              string  `json:"user_id,omitempty"`
+	AuthorizationStatus string  `json:"authorization_status,omitempty"`
+	ExpectedAmount      float64 `json:"expected_amount"`
+	LedgerAmount        float64 `json:"ledger_amount"`
+	Detail              string  `json:"detail"`
+	Healed              bool    `json:"healed,omitempty"`
+}
+
+// Report is the outcome of one reconciliation run.
+type Report struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	// Cutoff excludes transactions with activity after it, which may still
+	// be in flight between the services.
+	Cutoff time.Time      `json:"cutoff"`
+	Counts map[string]int `json:"counts"`
+	Breaks []Break        `json:"breaks"`
+}
+
+// Write encodes the report as indented JSON.
+func (r *Report) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Reconciler compares the authorizations table with the ledger.
+type Reconciler struct {
+	db *sql.DB
+	// grace is how long a transaction must be quiet before it is reconciled.
+	grace time.Duration
+	// staleAfter is how long an authorization or refund may stay pending.
+	staleAfter time.Duration
+	now        func() time.Time
+}
+
+func NewReconciler(db *sql.DB, grace, staleAfter time.Duration) *Reconciler {
+	return &Reconciler{db: db, grace: grace, staleAfter: staleAfter, now: time.Now}
+}
+
+// Run reconciles every transaction quiet since the grace period and
+// returns the breaks found.
+func (r *Reconciler) Run(ctx context.Context) (*Report, error) {
+	now := r.now()
+	report := &Report{
+		GeneratedAt: now,
+		Cutoff:      now.Add(-r.grace),
+		Counts:      map[string]int{},
+		Breaks:      []Break{},
+	}
+
+	matched, err := r.matchTransactions(ctx, report.Cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("matching transactions: %w", err)
+	}
+	stale, err := r.stalePending(ctx, now.Add(-r.staleAfter))
+	if err != nil {
+		return nil, fmt.Errorf("finding stale pending: %w", err)
+	}
+
+	for _, b := range append(matched, stale...) {
+		report.Counts[b.Kind]++
+		report.Breaks = append(report.Breaks, b)
+	}
+	return report, nil
+}
+
+// matchTransactions joins authorizations with the ledger postings for the
+// same transaction, in both directions.
+func (r *Reconciler) matchTransactions(ctx context.Context, cutoff time.Time) ([]Break, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		WITH posted AS (
+			SELECT transaction_id,
+			       COALESCE(SUM(amount) FILTER (WHERE account_id NOT LIKE 'system:%'), 0) AS cardholder_amount,
+			       COUNT(*) AS entries,
+			       MAX(created_at) AS last_posted_at
+			FROM ledger
+			WHERE account_id IS NOT NULL
+			GROUP BY transaction_id
+		),
+		refunded AS (
+			SELECT transaction_id,
+			       COALESCE(SUM(amount) FILTER (WHERE status = $2), 0) AS amount,
+			       MAX(updated_at) AS last_refund_at
+			FROM refunds
+			GROUP BY transaction_id
+		),
+		disputed AS (
+			SELECT transaction_id, MAX(updated_at) AS last_dispute_at
+			FROM disputes
+			GROUP BY transaction_id
+		),
+		changed AS (
+			SELECT transaction_id, MAX(created_at) AS last_changed_at
+			FROM authorization_amount_changes
+			GROUP BY transaction_id
+		)
+		SELECT COALESCE(a.transaction_id, p.transaction_id),
+		       COALESCE(a.user_id, ''),
+		       COALESCE(a.status, ''),
+		       COALESCE(a.amount - a.disputed_amount - COALESCE(f.amount, 0), 0),
+		       COALESCE(p.cardholder_amount, 0),
+		       COALESCE(p.entries, 0),
+		       COALESCE(p.cardholder_amount, 0) <> COALESCE(a.amount - a.disputed_amount - COALESCE(f.amount, 0), 0)
+		FROM authorizations a
+		LEFT JOIN refunded f ON f.transaction_id = a.transaction_id
+		LEFT JOIN changed c ON c.transaction_id = a.transaction_id
+		LEFT JOIN disputed d ON d.transaction_id = a.transaction_id
+		FULL OUTER JOIN posted p ON p.transaction_id = a.transaction_id
+		WHERE (a.status IN ($3, $4) OR p.transaction_id IS NOT NULL)
+		  AND GREATEST(a.created_at, c.last_changed_at, f.last_refund_at, d.last_dispute_at, p.last_posted_at) < $1
+		ORDER BY 1
+	`, cutoff, authorization.RefundStatusSucceeded, authorization.StatusSucceeded, authorization.StatusReversed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var breaks []Break
+	for rows.Next() {
+		var b Break
+		var entries int
+		var differs bool
+		err := rows.Scan(&b.TransactionID, &b.UserID, &b.AuthorizationStatus, &b.ExpectedAmount, &b.LedgerAmount, &entries, &differs)
+		if err != nil {
+			return nil, err
+		}
+
+		if classify(&b, entries, differs) {
+			breaks = append(breaks, b)
+		}
+	}
+	return breaks, rows.Err()
+}
+
+// classify sets the kind and detail of a matched transaction with entries
+// ledger postings and reports whether it is a break. differs says whether
+// the postings add up to something other than the expected amount.
+func classify(b *Break, entries int, differs bool) bool {
+	switch {
+	case b.AuthorizationStatus == "":
+		b.Kind = BreakOrphanLedgerEntry
+		b.Detail = "ledger entries without an authorization"
+	case b.AuthorizationStatus != authorization.StatusSucceeded && b.AuthorizationStatus != authorization.StatusReversed:
+		b.Kind = BreakOrphanLedgerEntry
+		b.Detail = fmt.Sprintf("ledger entries for an authorization in status %s", b.AuthorizationStatus)
+	case entries == 0 && b.AuthorizationStatus == authorization.StatusSucceeded && b.ExpectedAmount > 0:
+		b.Kind = BreakMissingLedgerEntry
+		b.Detail = "succeeded authorization has no ledger entries"
+	case entries > 0 && differs:
+		b.Kind = BreakAmountMismatch
+		b.Detail = fmt.Sprintf("ledger holds %.2f, authorization less succeeded refunds and disputes is %.2f", b.LedgerAmount, b.ExpectedAmount)
+	default:
+		return false
+	}
+	return true
+}
+
+// stalePending finds authorizations parked for review and refunds still in
+// flight since before the cutoff.
+func (r *Reconciler) stalePending(ctx context.Context, cutoff time.Time) ([]Break, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT transaction_id, COALESCE(user_id, ''), status, amount, 'authorization pending review since ' || to_char(created_at, 'YYYY-MM-DD"T"HH24:MI:SSOF')
+		FROM authorizations
+		WHERE status = $2 AND created_at < $1
+		UNION ALL
+		SELECT r.transaction_id, r.user_id, COALESCE(a.status, ''), r.amount, 'refund ' || r.refund_id || ' pending since ' || to_char(r.created_at, 'YYYY-MM-DD"T"HH24:MI:SSOF')
+		FROM refunds r
+		LEFT JOIN authorizations a ON a.transaction_id = r.transaction_id
+		WHERE r.status = $3 AND r.created_at < $1
+		ORDER BY 1
+	`, cutoff, authorization.StatusPendingReview, authorization.RefundStatusPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var breaks []Break
+	for rows.Next() {
+		b := Break{Kind: BreakStalePending}
+		if err := rows.Scan(&b.TransactionID, &b.UserID, &b.AuthorizationStatus, &b.ExpectedAmount, &b.Detail); err != nil {
+			return nil, err
+		}
+		breaks = append(breaks, b)
+	}
+	return breaks, rows.Err()
+}
+
+// Heal re-emits authorization-succeeded through the outbox for every missing
+// ledger entry in report, so the saga posts it again, and marks those breaks
+// healed. Other breaks need an operator and are left as they are.
+func (r *Reconciler) Heal(ctx context.Context, report *Report) (int, error) {
+	healed := 0
+	for i := range report.Breaks {
+		b := &report.Breaks[i]
+		if b.Kind != BreakMissingLedgerEntry {
+			continue
+		}
+		if err := r.reemitAuthorization(ctx, b.TransactionID); err != nil {
+			return healed, fmt.Errorf("re-emitting %s: %w", b.TransactionID, err)
+		}
+		log.Printf("Re-emitted authorization-succeeded for transaction %s", b.TransactionID)
+		b.Healed = true
+		healed++
+	}
+	return healed, nil
+}
+
+func (r *Reconciler) reemitAuthorization(ctx context.Context, transactionID string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Re-read under lock so the event carries the current amount and the
+	// authorization is still succeeded.
+	var ev events.AuthorizationSucceeded
+	err = tx.QueryRowContext(ctx, `
+		SELECT transaction_id, COALESCE(user_id, ''), amount, risk_score
+		FROM authorizations
+		WHERE transaction_id = $1 AND status = $2
+		FOR UPDATE
+	`, transactionID, authorization.StatusSucceeded).Scan(&ev.TransactionID, &ev.UserID, &ev.Amount, &ev.RiskScore)
+	if err != nil {
+		return err
+	}
+
+	if err := outbox.NewWriter(tx).Write(ctx, "authorization-succeeded", ev.TransactionID, ev); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,211 @@
+package reconciliation
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"credit-authorization-ledger/internal/authorization"
+	"credit-authorization-ledger/internal/dbtest"
+	"credit-authorization-ledger/pkg/events"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		amount  float64
+		entries int
+		differs bool
+		want    string // break kind, or "" if the transaction matches
+	}{
+		{"posted and matching", authorization.StatusSucceeded, 10, 2, false, ""},
+		{"posted with a different amount", authorization.StatusSucceeded, 10, 2, true, BreakAmountMismatch},
+		{"succeeded and never posted", authorization.StatusSucceeded, 10, 0, true, BreakMissingLedgerEntry},
+		{"fully refunded and never posted", authorization.StatusSucceeded, 0, 0, false, ""},
+		{"reversed with postings netting to zero", authorization.StatusReversed, 0, 4, false, ""},
+		{"reversed with postings left over", authorization.StatusReversed, 0, 2, true, BreakAmountMismatch},
+		{"reversed and never posted", authorization.StatusReversed, 0, 0, false, ""},
+		{"posted without an authorization", "", 0, 2, true, BreakOrphanLedgerEntry},
+		{"posted for a declined authorization", authorization.StatusDeclined, 10, 2, false, BreakOrphanLedgerEntry},
+		{"posted while pending review", authorization.StatusPendingReview, 10, 2, false, BreakOrphanLedgerEntry},
+	}
+	for _, tt := range tests {
+		b := Break{TransactionID: "tx-1", AuthorizationStatus: tt.status, ExpectedAmount: tt.amount}
+		isBreak := classify(&b, tt.entries, tt.differs)
+		if isBreak != (tt.want != "") || b.Kind != tt.want {
+			t.Errorf("%s: classify = %t, %q; want %q", tt.name, isBreak, b.Kind, tt.want)
+		}
+		if isBreak && b.Detail == "" {
+			t.Errorf("%s: break has no detail", tt.name)
+		}
+	}
+}
+
+// fakeDB answers the reconciliation queries with canned rows and records
+// outbox writes.
+type fakeDB struct {
+	matched [][]driver.Value
+	stale   [][]driver.Value
+	// succeeded are the authorizations Heal can re-read, by transaction ID.
+	succeeded map[string][]driver.Value
+	outbox    []events.AuthorizationSucceeded
+	args      map[string][]driver.NamedValue
+}
+
+func (f *fakeDB) Query(s *dbtest.Session, query string, args []driver.NamedValue) (driver.Rows, error) {
+	switch {
+	case strings.Contains(query, "FULL OUTER JOIN posted"):
+		f.args["match"] = args
+		return dbtest.Rows(f.matched...), nil
+	case strings.Contains(query, "UNION ALL"):
+		f.args["stale"] = args
+		return dbtest.Rows(f.stale...), nil
+	case strings.Contains(query, "FOR UPDATE"):
+		if row, ok := f.succeeded[args[0].Value.(string)]; ok {
+			return dbtest.Rows(row), nil
+		}
+		return dbtest.Rows(), nil
+	}
+	return nil, fmt.Errorf("unexpected query %q", query)
+}
+
+func (f *fakeDB) Exec(s *dbtest.Session, query string, args []driver.NamedValue) (driver.Result, error) {
+	if !strings.Contains(query, "INSERT INTO outbox") || args[0].Value != "authorization-succeeded" {
+		return nil, fmt.Errorf("unexpected statement %q", query)
+	}
+	var ev events.AuthorizationSucceeded
+	if err := json.Unmarshal(args[2].Value.([]byte), &ev); err != nil {
+		return nil, err
+	}
+	f.outbox = append(f.outbox, ev)
+	return driver.RowsAffected(1), nil
+}
+
+func TestRunAndHeal(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	f := &fakeDB{
+		matched: [][]driver.Value{
+			{"tx-1", "user-1", authorization.StatusSucceeded, 10.0, 10.0, int64(2), false},
+			{"tx-2", "user-1", authorization.StatusSucceeded, 25.0, 0.0, int64(0), true},
+			{"tx-3", "user-2", authorization.StatusSucceeded, 40.0, 30.0, int64(2), true},
+			{"tx-4", "", "", 0.0, 5.0, int64(2), true},
+		},
+		stale: [][]driver.Value{
+			{"tx-5", "user-3", authorization.StatusPendingReview, 99.0, "authorization pending review since 2024-02-28T09:00:00+00"},
+		},
+		succeeded: map[string][]driver.Value{"tx-2": {"tx-2", "user-1", 25.0, int64(12)}},
+		args:      map[string][]driver.NamedValue{},
+	}
+	r := NewReconciler(dbtest.Open(f), 10*time.Minute, 24*time.Hour)
+	r.now = func() time.Time { return now }
+
+	report, err := r.Run(ctx)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if cutoff := f.args["match"][0].Value; cutoff != now.Add(-10*time.Minute) {
+		t.Errorf("matched transactions quiet since %v, want the grace period", cutoff)
+	}
+	if cutoff := f.args["stale"][0].Value; cutoff != now.Add(-24*time.Hour) {
+		t.Errorf("stale pending since %v, want staleAfter", cutoff)
+	}
+
+	var kinds []string
+	for _, b := range report.Breaks {
+		kinds = append(kinds, b.TransactionID+":"+b.Kind)
+	}
+	want := "tx-2:MISSING_LEDGER_ENTRY tx-3:AMOUNT_MISMATCH tx-4:ORPHAN_LEDGER_ENTRY tx-5:STALE_PENDING"
+	if got := strings.Join(kinds, " "); got != want {
+		t.Errorf("breaks = %s, want %s", got, want)
+	}
+	if report.Counts[BreakStalePending] != 1 || len(report.Counts) != 4 {
+		t.Errorf("counts = %v, want one of each kind", report.Counts)
+	}
+
+	// Only the missing ledger entry can be healed, by re-emitting the
+	// authorization so the saga posts it.
+	healed, err := r.Heal(ctx, report)
+	if err != nil || healed != 1 {
+		t.Fatalf("Heal = %d, %v; want 1", healed, err)
+	}
+	if len(f.outbox) != 1 || f.outbox[0] != (events.AuthorizationSucceeded{TransactionID: "tx-2", UserID: "user-1", Amount: 25, RiskScore: 12}) {
+		t.Errorf("outbox = %+v, want authorization-succeeded for tx-2", f.outbox)
+	}
+	for _, b := range report.Breaks {
+		if b.Healed != (b.TransactionID == "tx-2") {
+			t.Errorf("%s healed = %t", b.TransactionID, b.Healed)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := report.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"healed": true`) || !strings.Contains(buf.String(), `"MISSING_LEDGER_ENTRY": 1`) {
+		t.Errorf("report JSON is missing the healed break or counts:\n%s", buf.String())
+	}
+
+	// An authorization that is no longer succeeded is not re-emitted.
+	report.Breaks[0].Healed = false
+	report.Breaks[0].TransactionID = "tx-gone"
+	if _, err := r.Heal(ctx, report); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Heal of a changed authorization = %v, want ErrNoRows", err)
+	}
+}
+
+func TestRunPostgres(t *testing.T) {
+	ctx := context.Background()
+	db := dbtest.Postgres(t, dbtest.Authorization, dbtest.Ledger, dbtest.Outbox)
+	for _, stmt := range []string{`
+		INSERT INTO authorizations (transaction_id, user_id, amount, status, created_at) VALUES
+		('tx-1', 'user-1', 10, 'SUCCEEDED', NOW() - INTERVAL '1 hour'),
+		('tx-2', 'user-1', 25, 'SUCCEEDED', NOW() - INTERVAL '1 hour'),
+		('tx-3', 'user-1', 30, 'SUCCEEDED', NOW() - INTERVAL '1 hour'),
+		('tx-5', 'user-3', 99, 'PENDING_REVIEW', NOW() - INTERVAL '1 hour'),
+		('tx-6', 'user-3', 15, 'SUCCEEDED', NOW())
+	`, `
+		INSERT INTO ledger (transaction_id, entry_type, account_id, amount, created_at) VALUES
+		('tx-1', 'CREDIT_AUTHORIZED', 'user-1', 10, NOW() - INTERVAL '1 hour'),
+		('tx-1', 'CREDIT_AUTHORIZED', 'system:merchant-settlement', -10, NOW() - INTERVAL '1 hour'),
+		('tx-3', 'CREDIT_AUTHORIZED', 'user-1', 20, NOW() - INTERVAL '1 hour'),
+		('tx-3', 'CREDIT_AUTHORIZED', 'system:merchant-settlement', -20, NOW() - INTERVAL '1 hour'),
+		('tx-4', 'CREDIT_AUTHORIZED', 'user-2', 5, NOW() - INTERVAL '1 hour'),
+		('tx-4', 'CREDIT_AUTHORIZED', 'system:merchant-settlement', -5, NOW() - INTERVAL '1 hour')
+	`} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// tx-6 is still within the grace period.
+	r := NewReconciler(db, 10*time.Minute, 30*time.Minute)
+	report, err := r.Run(ctx)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	var kinds []string
+	for _, b := range report.Breaks {
+		kinds = append(kinds, b.TransactionID+":"+b.Kind)
+	}
+	want := "tx-2:MISSING_LEDGER_ENTRY tx-3:AMOUNT_MISMATCH tx-4:ORPHAN_LEDGER_ENTRY tx-5:STALE_PENDING"
+	if got := strings.Join(kinds, " "); got != want {
+		t.Errorf("breaks = %s, want %s", got, want)
+	}
+
+	if healed, err := r.Heal(ctx, report); err != nil || healed != 1 {
+		t.Fatalf("Heal = %d, %v; want 1", healed, err)
+	}
+	var key string
+	if err := db.QueryRow("SELECT key FROM outbox WHERE topic = 'authorization-succeeded'").Scan(&key); err != nil || key != "tx-2" {
+		t.Errorf("outbox holds authorization-succeeded for %q, %v; want tx-2", key, err)
+	}
+}
@@ -5,22 +5,21 @@ import (
 	"encoding/json"
 	"log"
 
-	"credit-authorization-ledger/internal/kafka"
+	"credit-authorization-ledger/internal/transport"
 	"credit-authorization-ledger/pkg/events"
 
-	k "github.com/segmentio/kafka-go"
 	"go.opentelemetry.io/otel"
 )
 
 type Orchestrator struct {
-	producer *kafka.Producer
+	producer transport.Publisher
 }
 
-func NewOrchestrator(p *kafka.Producer) *Orchestrator {
+func NewOrchestrator(p transport.Publisher) *Orchestrator {
 	return &Orchestrator{producer: p}
 }
 
-func (o *Orchestrator) HandleMessage(ctx context.Context, msg k.Message) error {
+func (o *Orchestrator) HandleMessage(ctx context.Context, msg transport.Message) error {
 	tr := otel.Tracer("saga-orchestrator")
 	ctx, span := tr.Start(ctx, "HandleSagaMessage")
 	defer span.End()
@@ -35,26 +34,101 @@ func (o *Orchestrator) HandleMessage(ctx context.Context, msg k.Message) error {
 	case "ledger-update-succeeded":
 		log.Printf("SAGA completed successfully for transaction: %s", string(msg.Key))
 		// Optionally publish a "SagaCompleted" event
+	case "authorization-review-required":
+		// Nothing to do until a reviewer decides; the decision arrives as
+		// authorization-succeeded or authorization-failed.
+		log.Printf("SAGA parked for manual review for transaction: %s", string(msg.Key))
 	case "authorization-failed":
 		fallthrough
 	case "ledger-update-failed":
 		log.Printf("SAGA failed for transaction: %s. Starting compensation.", string(msg.Key))
 		// Here you would trigger compensating transactions
+	case "incremental-authorization-requested":
+		return o.producer.Publish(ctx, "incremental-authorization-requests", string(msg.Key), msg.Value)
+	case "incremental-authorization-succeeded":
+		// The ledger follows every change to the held amount.
+		return o.producer.Publish(ctx, "ledger-increment-requests", string(msg.Key), msg.Value)
+	case "incremental-authorization-failed":
+		// The hold is unchanged, so there is nothing to compensate.
+		log.Printf("Incremental authorization failed for transaction: %s", string(msg.Key))
+	case "authorization-reversal-requested":
+		return o.producer.Publish(ctx, "authorization-reversal-requests", string(msg.Key), msg.Value)
+	case "authorization-reversal-succeeded":
+		return o.producer.Publish(ctx, "ledger-reversal-requests", string(msg.Key), msg.Value)
+	case "authorization-reversal-failed":
+		log.Printf("Authorization reversal failed for transaction: %s", string(msg.Key))
+	case "refund-requested":
+		return o.producer.Publish(ctx, "refund-authorization-requests", string(msg.Key), msg.Value)
+	case "refund-authorized":
+		// Credit has been restored; now reverse the ledger postings.
+		return o.producer.Publish(ctx, "refund-ledger-requests", string(msg.Key), msg.Value)
+	case "refund-ledger-posted":
+		return o.producer.Publish(ctx, "refund-completion-requests", string(msg.Key), msg.Value)
+	case "refund-ledger-failed":
+		log.Printf("Refund ledger step failed for transaction: %s. Starting compensation.", string(msg.Key))
+		return o.producer.Publish(ctx, "refund-compensation-requests", string(msg.Key), msg.Value)
+	case "refund-succeeded":
+		log.Printf("Refund SAGA completed successfully for transaction: %s", string(msg.Key))
+	case "refund-failed":
+		log.Printf("Refund SAGA failed for transaction: %s", string(msg.Key))
+	case "payment-requested":
+		return o.producer.Publish(ctx, "payment-ledger-requests", string(msg.Key), msg.Value)
+	case "payment-posted":
+		// The ledger has allocated the payment; restore credit for its principal.
+		return o.producer.Publish(ctx, "payment-credit-requests", string(msg.Key), msg.Value)
+	case "payment-succeeded":
+		log.Printf("Payment SAGA completed successfully for cardholder: %s", string(msg.Key))
+	case "payment-failed":
+		log.Printf("Payment SAGA failed for cardholder: %s", string(msg.Key))
+	case "payment-return-requested":
+		return o.producer.Publish(ctx, "payment-return-ledger-requests", string(msg.Key), msg.Value)
+	case "payment-return-posted":
+		return o.producer.Publish(ctx, "payment-return-credit-requests", string(msg.Key), msg.Value)
+	case "payment-returned":
+		log.Printf("Payment return SAGA completed for cardholder: %s", string(msg.Key))
+	case "payment-return-failed":
+		log.Printf("Payment return SAGA failed for payment: %s", string(msg.Key))
+	case "capture-requested":
+		return o.producer.Publish(ctx, "capture-requests", string(msg.Key), msg.Value)
+	case "capture-succeeded":
+		// The hold now matches the cleared amount; post the difference.
+		return o.producer.Publish(ctx, "capture-ledger-requests", string(msg.Key), msg.Value)
+	case "capture-failed":
+		log.Printf("Capture failed for transaction: %s", string(msg.Key))
+	case "dispute-opened", "dispute-won", "dispute-lost":
+		// Every decided step of a dispute moves money in the ledger.
+		return o.producer.Publish(ctx, "dispute-ledger-requests", string(msg.Key), msg.Value)
+	case "dispute-evidence-requested":
+		log.Printf("Dispute awaiting cardholder evidence for transaction: %s", string(msg.Key))
+	case "dispute-ledger-posted":
+		log.Printf("Dispute ledger step completed for transaction: %s", string(msg.Key))
+	case "dispute-ledger-failed":
+		log.Printf("Dispute ledger step failed for transaction: %s. Starting compensation.", string(msg.Key))
+		return o.producer.Publish(ctx, "dispute-compensation-requests", string(msg.Key), msg.Value)
+	case "dispute-failed":
+		log.Printf("Dispute SAGA failed for transaction: %s", string(msg.Key))
 	}
 	return nil
 }
 
-func (o *Orchestrator) startAuthorization(ctx context.Context, msg k.Message) error {
-	// The API gateway should have sent this. We forward it to the auth service.
-	return o.producer.Publish(ctx, "authorization-requests", string(msg.Key), msg.Value)
+func (o *Orchestrator) startAuthorization(ctx context.Context, msg transport.Message) error {
+	var req events.AuthorizationRequested
+	if err := json.Unmarshal(msg.Value, &req); err != nil {
+		return err
+	}
+	// The API gateway should have sent this. We forward it to the auth service,
+	// keyed by cardholder so that a cardholder's authorizations are handled
+	// in order, on one partition worker. Only this topic is; the others
+	// stay keyed by transaction.
+	return o.producer.Publish(ctx, "authorization-requests", req.UserID, msg.Value)
 }
 
-func (o *Orchestrator) startLedgerUpdate(ctx context.Context, msg k.Message) error {
+func (o *Orchestrator) startLedgerUpdate(ctx context.Context, msg transport.Message) error {
 	var authEvent events.AuthorizationSucceeded
 	if err := json.Unmarshal(msg.Value, &authEvent); err != nil {
 		return err
 	}
 	// The authorization service succeeded. Now we tell the ledger service.
 	// The message is already the correct one, so we just forward it.
-	return o.producer.Publish(ctx, "authorization-succeeded", authEvent.TransactionID, msg.Value)
+	return o.producer.Publish(ctx, "ledger-update-requests", authEvent.TransactionID, msg.Value)
 }
\ No newline at end of file
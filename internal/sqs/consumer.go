@@ -55,7 +55,12 @@ func (c *Consumer) StartPolling() {
 	}
 
 	// Start the poller loop
-	c.poller(messages)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-c.shutdownSignal
+		cancel()
+	}()
+	c.poller(ctx, messages)
 
 	// Wait for all workers to finish after shutdown is initiated
 	c.wg.Wait()
@@ -63,7 +68,7 @@ func (c *Consumer) StartPolling() {
 }
 
 // poller is the main loop that receives messages from SQS.
-func (c *Consumer) poller(messages chan<- *sqs.Message) {
+func (c *Consumer) poller(ctx context.Context, messages chan<- *sqs.Message) {
 	for {
 		select {
 		case <-c.shutdownSignal:
@@ -80,10 +85,16 @@ func (c *Consumer) poller(messages chan<- *sqs.Message) {
 				AttributeNames: []*string{
 					aws.String(sqs.MessageSystemAttributeNameApproximateReceiveCount),
 				},
+				// Publishers such as the outbox processor send the topic and key as attributes.
+				MessageAttributeNames: []*string{aws.String(sqs.QueueAttributeNameAll)},
 			}
 
-			output, err := c.client.ReceiveMessage(receiveInput)
+			// Shutting down interrupts a long poll rather than waiting it out.
+			output, err := c.client.ReceiveMessageWithContext(ctx, receiveInput)
 			if err != nil {
+				if ctx.Err() != nil {
+					continue
+				}
 				log.Printf("ERROR: Failed to receive messages from SQS: %v", err)
 				// Backoff before retrying to avoid spamming AWS API on error
 				time.Sleep(5 * time.Second)
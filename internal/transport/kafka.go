@@ -0,0 +1,31 @@
+package transport
+
+import (
+	"context"
+
+	"credit-authorization-ledger/internal/kafka"
+
+	k "github.com/segmentio/kafka-go"
+)
+
+// KafkaConsumer consumes topics as a member of a Kafka consumer group. A
+// message's offset is committed once it, and every earlier message on its
+// partition, is handled, even if the handler fails, so a failed message is
+// not redelivered.
+type KafkaConsumer struct {
+	consumer *kafka.Consumer
+}
+
+func NewKafkaConsumer(brokers []string, groupID string, opts ...kafka.Option) *KafkaConsumer {
+	return &KafkaConsumer{consumer: kafka.NewConsumer(brokers, groupID, opts...)}
+}
+
+func (c *KafkaConsumer) Consume(ctx context.Context, topics []string, handler Handler) {
+	c.consumer.Consume(ctx, topics, func(ctx context.Context, msg k.Message) error {
+		return handler(ctx, Message{Topic: msg.Topic, Key: msg.Key, Value: msg.Value})
+	})
+}
+
+func (c *KafkaConsumer) Close() error {
+	return c.consumer.Close()
+}
@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+// SNSPublisher publishes every topic to one SNS topic, which fans out to a
+// queue per service. The topic and key travel as the "topic" and "key"
+// attributes, as they do from the outbox processor, so each queue's
+// subscription can filter on the topics its service handles. On a FIFO
+// topic the key is the message group, and a hash of the message
+// deduplicates retries.
+type SNSPublisher struct {
+	client   snsiface.SNSAPI
+	topicARN string
+}
+
+func NewSNSPublisher(region, endpoint, topicARN string) (*SNSPublisher, error) {
+	sess, err := NewAWSSession(region, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &SNSPublisher{client: sns.New(sess), topicARN: topicARN}, nil
+}
+
+func (p *SNSPublisher) Publish(ctx context.Context, topic, key string, value []byte) error {
+	input := &sns.PublishInput{
+		TopicArn: aws.String(p.topicARN),
+		Message:  aws.String(string(value)),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{
+			"topic": {DataType: aws.String("String"), StringValue: aws.String(topic)},
+		},
+	}
+	// SNS rejects empty attribute values.
+	if key != "" {
+		input.MessageAttributes["key"] = &sns.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(key)}
+	}
+	if strings.HasSuffix(p.topicARN, ".fifo") {
+		group := key
+		if group == "" {
+			group = topic
+		}
+		sum := sha256.Sum256([]byte(topic + "\x00" + key + "\x00" + string(value)))
+		input.MessageGroupId = aws.String(group)
+		input.MessageDeduplicationId = aws.String(hex.EncodeToString(sum[:]))
+	}
+	_, err := p.client.PublishWithContext(ctx, input)
+	return err
+}
+
+func (p *SNSPublisher) Close() error {
+	return nil
+}
@@ -0,0 +1,75 @@
+package transport
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+type fakeSNS struct {
+	snsiface.SNSAPI
+	published []*sns.PublishInput
+}
+
+func (f *fakeSNS) PublishWithContext(ctx aws.Context, input *sns.PublishInput, opts ...request.Option) (*sns.PublishOutput, error) {
+	f.published = append(f.published, input)
+	return &sns.PublishOutput{}, nil
+}
+
+func TestSNSPublisher(t *testing.T) {
+	ctx := context.Background()
+
+	client := &fakeSNS{}
+	p := &SNSPublisher{client: client, topicARN: "arn:aws:sns:us-east-1:000000000000:events"}
+	if err := p.Publish(ctx, "authorization-requests", "tx-1", []byte(`{"transaction_id":"tx-1"}`)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if err := p.Publish(ctx, "statement-closed", "", []byte(`{}`)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	input := client.published[0]
+	if got := aws.StringValue(input.MessageAttributes["topic"].StringValue); got != "authorization-requests" {
+		t.Errorf("topic attribute = %q", got)
+	}
+	if got := aws.StringValue(input.MessageAttributes["key"].StringValue); got != "tx-1" {
+		t.Errorf("key attribute = %q", got)
+	}
+	if aws.StringValue(input.Message) != `{"transaction_id":"tx-1"}` || input.MessageGroupId != nil {
+		t.Errorf("published %v to a standard topic", input)
+	}
+	if _, ok := client.published[1].MessageAttributes["key"]; ok {
+		t.Error("published an empty key attribute")
+	}
+
+	client = &fakeSNS{}
+	p = &SNSPublisher{client: client, topicARN: "arn:aws:sns:us-east-1:000000000000:events.fifo"}
+	p.Publish(ctx, "authorization-requests", "tx-1", []byte(`{}`))
+	p.Publish(ctx, "authorization-requests", "tx-1", []byte(`{}`))
+	p.Publish(ctx, "statement-closed", "", []byte(`{}`))
+	first, retry, unkeyed := client.published[0], client.published[1], client.published[2]
+	if aws.StringValue(first.MessageGroupId) != "tx-1" || aws.StringValue(unkeyed.MessageGroupId) != "statement-closed" {
+		t.Errorf("message groups %q and %q, want the key, else the topic", aws.StringValue(first.MessageGroupId), aws.StringValue(unkeyed.MessageGroupId))
+	}
+	if aws.StringValue(first.MessageDeduplicationId) != aws.StringValue(retry.MessageDeduplicationId) {
+		t.Error("a retried message has a different deduplication ID")
+	}
+	if aws.StringValue(first.MessageDeduplicationId) == aws.StringValue(unkeyed.MessageDeduplicationId) {
+		t.Error("different messages share a deduplication ID")
+	}
+}
+
+func TestNewPublisher(t *testing.T) {
+	if _, err := NewPublisher(Config{Kind: "kafka", Brokers: []string{"localhost:9092"}}); err != nil {
+		t.Errorf("kafka: %v", err)
+	}
+	if _, err := NewPublisher(Config{Kind: "sqs", Region: "us-east-1", TopicARN: "arn:aws:sns:us-east-1:000000000000:events"}); err != nil {
+		t.Errorf("sqs: %v", err)
+	}
+	if _, err := NewPublisher(Config{Kind: "rabbitmq"}); err == nil {
+		t.Error("NewPublisher accepted an unknown transport")
+	}
+}
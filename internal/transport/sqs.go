@@ -0,0 +1,94 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+
+	"credit-authorization-ledger/internal/sqs"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awssqs "github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// SQSConsumer consumes a queue fed by the outbox processor, which sends
+// each message's topic and key as the "topic" and "key" attributes. A
+// queue subscribed to an SNS topic must use raw message delivery. A message
+// is deleted once it is handled; if the handler fails it is redelivered
+// after the queue's visibility timeout, until its redrive policy moves it
+// to a dead-letter queue. Messages are handled concurrently, so unlike a
+// Kafka partition a queue does not keep messages with the same key in
+// order.
+type SQSConsumer struct {
+	sess     *session.Session
+	queueURL string
+}
+
+func NewSQSConsumer(region, endpoint, queueURL string) (*SQSConsumer, error) {
+	sess, err := NewAWSSession(region, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &SQSConsumer{sess: sess, queueURL: queueURL}, nil
+}
+
+// NewAWSSession returns a session for region. If endpoint is set it
+// overrides the AWS endpoint, e.g. to use LocalStack.
+func NewAWSSession(region, endpoint string) (*session.Session, error) {
+	awsConfig := aws.NewConfig().WithRegion(region)
+	if endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(endpoint)
+	}
+	return session.NewSession(awsConfig)
+}
+
+// Consume polls the queue until ctx is done. The queue should carry only
+// the topics listed, for example through a filter policy on its SNS
+// subscription. A message for any other topic is misrouted, so it is left
+// in the queue for the redrive policy rather than deleted unhandled.
+func (c *SQSConsumer) Consume(ctx context.Context, topics []string, handler Handler) {
+	consumer := sqs.NewConsumer(c.sess, c.queueURL, sqsHandler(topics, handler))
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			consumer.Shutdown()
+		case <-stopped:
+		}
+	}()
+	consumer.StartPolling()
+}
+
+func (c *SQSConsumer) Close() error {
+	return nil
+}
+
+// sqsHandler adapts handler to SQS messages on the given topics.
+func sqsHandler(topics []string, handler Handler) sqs.MessageHandler {
+	subscribed := make(map[string]bool, len(topics))
+	for _, topic := range topics {
+		subscribed[topic] = true
+	}
+	return func(ctx context.Context, msg *awssqs.Message) error {
+		topic := attribute(msg, "topic")
+		if topic == "" {
+			return fmt.Errorf("message %s has no topic attribute", aws.StringValue(msg.MessageId))
+		}
+		if !subscribed[topic] {
+			return fmt.Errorf("message %s is for unsubscribed topic %s", aws.StringValue(msg.MessageId), topic)
+		}
+		return handler(ctx, Message{
+			Topic: topic,
+			Key:   []byte(attribute(msg, "key")),
+			Value: []byte(aws.StringValue(msg.Body)),
+		})
+	}
+}
+
+func attribute(msg *awssqs.Message, name string) string {
+	if value, ok := msg.MessageAttributes[name]; ok {
+		return aws.StringValue(value.StringValue)
+	}
+	return ""
+}
@@ -0,0 +1,81 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awssqs "github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func sqsMessage(body string, attributes map[string]string) *awssqs.Message {
+	msg := &awssqs.Message{
+		MessageId:         aws.String("msg-1"),
+		Body:              aws.String(body),
+		MessageAttributes: map[string]*awssqs.MessageAttributeValue{},
+	}
+	for name, value := range attributes {
+		msg.MessageAttributes[name] = &awssqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+	}
+	return msg
+}
+
+func TestSQSHandler(t *testing.T) {
+	var handled []Message
+	failure := errors.New("ledger unavailable")
+	handler := sqsHandler([]string{"ledger-update-requests", "capture-ledger-requests"}, func(ctx context.Context, msg Message) error {
+		handled = append(handled, msg)
+		if msg.Topic == "capture-ledger-requests" {
+			return failure
+		}
+		return nil
+	})
+	ctx := context.Background()
+
+	msg := sqsMessage(`{"transaction_id":"tx-1"}`, map[string]string{"outbox_id": "7", "topic": "ledger-update-requests", "key": "tx-1"})
+	if err := handler(ctx, msg); err != nil {
+		t.Fatalf("handler: %v", err)
+	}
+	want := []Message{{Topic: "ledger-update-requests", Key: []byte("tx-1"), Value: []byte(`{"transaction_id":"tx-1"}`)}}
+	if !reflect.DeepEqual(handled, want) {
+		t.Errorf("handled %+v, want %+v", handled, want)
+	}
+
+	// A failure is returned so that the message is not deleted.
+	if err := handler(ctx, sqsMessage(`{}`, map[string]string{"topic": "capture-ledger-requests", "key": "tx-2"})); err != failure {
+		t.Errorf("handler returned %v, want %v", err, failure)
+	}
+
+	// Messages for other topics were misrouted, so they are left for the
+	// dead-letter queue without being handled.
+	handled = nil
+	if err := handler(ctx, sqsMessage(`{}`, map[string]string{"topic": "payment-ledger-requests"})); err == nil {
+		t.Error("handler accepted a message for an unsubscribed topic")
+	}
+	if len(handled) != 0 {
+		t.Errorf("handled %+v for an unsubscribed topic", handled)
+	}
+
+	// A message without a topic cannot be dispatched, so it is left for the
+	// dead-letter queue.
+	if err := handler(ctx, sqsMessage(`{}`, nil)); err == nil {
+		t.Error("handler accepted a message without a topic")
+	}
+	if len(handled) != 0 {
+		t.Errorf("handled %+v without a topic", handled)
+	}
+}
+
+func TestNewConsumer(t *testing.T) {
+	if _, err := NewConsumer(Config{Kind: "kafka", Brokers: []string{"localhost:9092"}, GroupID: "ledger-group"}); err != nil {
+		t.Errorf("kafka: %v", err)
+	}
+	if _, err := NewConsumer(Config{Kind: "sqs", Region: "us-east-1", QueueURL: "http://localhost:4566/000000000000/ledger"}); err != nil {
+		t.Errorf("sqs: %v", err)
+	}
+	if _, err := NewConsumer(Config{Kind: "rabbitmq"}); err == nil {
+		t.Error("NewConsumer accepted an unknown transport")
+	}
+}
@@ -0,0 +1,106 @@
+// Package transport decouples message handlers from the broker that
+// delivers their messages. Services handle a Message whichever Consumer
+// feeds them, so the same handler can be driven by Kafka or SQS, and send
+// through a Publisher, so they publish to whichever broker they consume
+// from.
+//
+// The transports differ in what happens to a failed message and in order.
+// Kafka commits a failed message's offset and moves on, and handles a
+// key's messages in order. SQS leaves a failed message to be redelivered
+// after the visibility timeout and then dead-lettered, and handles
+// messages concurrently in no guaranteed order.
+package transport
+
+import (
+	"context"
+	"fmt"
+
+	"credit-authorization-ledger/internal/kafka"
+)
+
+// Message is a message received on a topic. Key is the ordering key it was
+// published with, typically a transaction or cardholder ID.
+type Message struct {
+	Topic string
+	Key   []byte
+	Value []byte
+}
+
+// Handler processes one message. Whether a message whose handler returns
+// an error is redelivered depends on the transport: SQS redelivers it and
+// Kafka does not. Either may deliver a message more than once, so handlers
+// must tolerate duplicates.
+type Handler func(ctx context.Context, msg Message) error
+
+// Consumer delivers messages from a set of topics to a Handler.
+type Consumer interface {
+	// Consume calls handler for each message on topics until ctx is done,
+	// then returns once the messages being handled are finished.
+	Consume(ctx context.Context, topics []string, handler Handler)
+	Close() error
+}
+
+// Publisher publishes a message on a topic. Messages with the same key
+// are delivered in order where the transport keeps order.
+type Publisher interface {
+	Publish(ctx context.Context, topic, key string, value []byte) error
+	Close() error
+}
+
+// Config selects a transport and says where to consume from and publish to.
+type Config struct {
+	// Kind is "kafka" or "sqs".
+	Kind string
+
+	// Brokers and GroupID configure a Kafka consumer group. If
+	// PartitionWorkers is set, each partition is handled by that many
+	// workers with at most MaxInFlight messages outstanding; see
+	// kafka.WithPartitionWorkers.
+	Brokers          []string
+	GroupID          string
+	PartitionWorkers int
+	MaxInFlight      int
+
+	// QueueURL is the SQS queue to consume, which should be the
+	// service's own. TopicARN is the SNS topic to publish to, which fans
+	// out to the services' queues. Region and Endpoint configure the AWS
+	// session; Endpoint may be empty.
+	QueueURL string
+	TopicARN string
+	Region   string
+	Endpoint string
+}
+
+// NewConsumer returns a consumer for the transport named by cfg.Kind.
+func NewConsumer(cfg Config) (Consumer, error) {
+	switch cfg.Kind {
+	case "kafka":
+		var opts []kafka.Option
+		if cfg.PartitionWorkers > 0 {
+			opts = append(opts, kafka.WithPartitionWorkers(cfg.PartitionWorkers))
+		}
+		if cfg.MaxInFlight > 0 {
+			opts = append(opts, kafka.WithMaxInFlight(cfg.MaxInFlight))
+		}
+		return NewKafkaConsumer(cfg.Brokers, cfg.GroupID, opts...), nil
+	case "sqs":
+		return NewSQSConsumer(cfg.Region, cfg.Endpoint, cfg.QueueURL)
+	}
+	return nil, fmt.Errorf("unknown transport %q", cfg.Kind)
+}
+
+// NewPublisher returns a publisher for the transport named by cfg.Kind.
+// With SQS, messages are published to the SNS topic cfg.TopicARN.
+func NewPublisher(cfg Config) (Publisher, error) {
+	switch cfg.Kind {
+	case "kafka":
+		producer, err := kafka.NewProducer(cfg.Brokers)
+		if err != nil {
+			return nil, err
+		}
+		return producer, nil
+	case "sqs":
+		return NewSNSPublisher(cfg.Region, cfg.Endpoint, cfg.TopicARN)
+	}
+	return nil, fmt.Errorf("unknown transport %q", cfg.Kind)
+}
@@ -1,16 +1,131 @@
 package events
 
+import "time"
+
+// AuthorizationRequested asks to hold Amount, given in Currency. An empty
+// Currency means the cardholder's billing currency.
 type AuthorizationRequested struct {
+	TransactionID        string  `json:"transaction_id"`
+	UserID               string  `json:"user_id"`
+	Amount               float64 `json:"amount"`
+	Currency             string  `json:"currency,omitempty"`
+	MerchantID           string  `json:"merchant_id,omitempty"`
+	MerchantCategoryCode string  `json:"merchant_category_code,omitempty"`
+	MerchantCountry      string  `json:"merchant_country,omitempty"`
+}
+
+// AuthorizationSucceeded reports a held Amount in the billing currency.
+// Foreign-currency authorizations also carry the original amount and the
+// rate and markup it was converted at.
+type AuthorizationSucceeded struct {
+	TransactionID       string  `json:"transaction_id"`
+	UserID              string  `json:"user_id"`
+	Amount              float64 `json:"amount"`
+	RiskScore           int     `json:"risk_score"`
+	TransactionCurrency string  `json:"transaction_currency,omitempty"`
+	TransactionAmount   float64 `json:"transaction_amount,omitempty"`
+	BillingCurrency     string  `json:"billing_currency,omitempty"`
+	FXRate              float64 `json:"fx_rate,omitempty"`
+	FXMarkup            float64 `json:"fx_markup,omitempty"`
+}
+
+type AuthorizationFailed struct {
+	TransactionID string `json:"transaction_id"`
+	Reason        string `json:"reason"`
+	RiskScore     int    `json:"risk_score"`
+}
+
+// AuthorizationReviewRequired parks an authorization until a reviewer
+// approves or declines it. The amount is already held.
+type AuthorizationReviewRequired struct {
 	TransactionID string  `json:"transaction_id"`
 	UserID        string  `json:"user_id"`
 	Amount        float64 `json:"amount"`
+	RiskScore     int     `json:"risk_score"`
+	Reason        string  `json:"reason"`
 }
 
-type AuthorizationSucceeded struct {
+// IncrementalAuthorizationRequested asks to raise the held amount of an
+// existing authorization by Amount.
+type IncrementalAuthorizationRequested struct {
+	TransactionID string  `json:"transaction_id"`
+	Amount        float64 `json:"amount"`
+}
+
+// IncrementalAuthorizationSucceeded reports a raised hold. ChangeID
+// identifies the increment in the authorization's audit trail, so the
+// ledger posts it once however often it is delivered.
+type IncrementalAuthorizationSucceeded struct {
+	TransactionID   string  `json:"transaction_id"`
+	ChangeID        int64   `json:"change_id"`
+	UserID          string  `json:"user_id"`
+	IncrementAmount float64 `json:"increment_amount"`
+	TotalAmount     float64 `json:"total_amount"`
+}
+
+type IncrementalAuthorizationFailed struct {
 	TransactionID string `json:"transaction_id"`
+	Reason        string `json:"reason"`
 }
 
-type AuthorizationFailed struct {
+// AuthorizationReversalRequested asks to void part of an authorization.
+// A zero Amount reverses whatever is still held.
+type AuthorizationReversalRequested struct {
+	TransactionID string  `json:"transaction_id"`
+	Amount        float64 `json:"amount,omitempty"`
+}
+
+// AuthorizationReversalSucceeded reports a reversal. ChangeID identifies
+// it in the authorization's audit trail.
+type AuthorizationReversalSucceeded struct {
+	TransactionID   string  `json:"transaction_id"`
+	ChangeID        int64   `json:"change_id"`
+	UserID          string  `json:"user_id"`
+	ReversedAmount  float64 `json:"reversed_amount"`
+	RemainingAmount float64 `json:"remaining_amount"`
+}
+
+type AuthorizationReversalFailed struct {
+	TransactionID string `json:"transaction_id"`
+	Reason        string `json:"reason"`
+}
+
+// RefundRequested asks to credit back part or all of a captured transaction.
+type RefundRequested struct {
+	RefundID      string  `json:"refund_id"`
+	TransactionID string  `json:"transaction_id"`
+	Amount        float64 `json:"amount"`
+}
+
+// RefundAuthorized is emitted once the authorization service has accepted a
+// refund and restored the cardholder's available credit.
+type RefundAuthorized struct {
+	RefundID      string  `json:"refund_id"`
+	TransactionID string  `json:"transaction_id"`
+	UserID        string  `json:"user_id"`
+	Amount        float64 `json:"amount"`
+}
+
+type RefundLedgerPosted struct {
+	RefundID      string `json:"refund_id"`
+	TransactionID string `json:"transaction_id"`
+}
+
+type RefundLedgerFailed struct {
+	RefundID      string `json:"refund_id"`
+	TransactionID string `json:"transaction_id"`
+	Reason        string `json:"reason"`
+}
+
+type RefundSucceeded struct {
+	RefundID      string  `json:"refund_id"`
+	TransactionID string  `json:"transaction_id"`
+	UserID        string  `json:"user_id"`
+	Amount        float64 `json:"amount"`
+}
+
+type RefundFailed struct {
+	RefundID      string `json:"refund_id"`
 	TransactionID string `json:"transaction_id"`
 	Reason        string `json:"reason"`
 }
@@ -22,4 +137,153 @@ type LedgerUpdateSucceeded struct {
 type LedgerUpdateFailed struct {
 	TransactionID string `json:"transaction_id"`
 	Reason        string `json:"reason"`
-}
\ No newline at end of file
+}
+
+// StatementGenerated announces a closed billing cycle. Balances are positive
+// when owed by the cardholder.
+type StatementGenerated struct {
+	StatementID       string    `json:"statement_id"`
+	AccountID         string    `json:"account_id"`
+	PeriodStart       time.Time `json:"period_start"`
+	PeriodEnd         time.Time `json:"period_end"`
+	OpeningBalance    float64   `json:"opening_balance"`
+	ClosingBalance    float64   `json:"closing_balance"`
+	Fees              float64   `json:"fees"`
+	Interest          float64   `json:"interest"`
+	MinimumPaymentDue float64   `json:"minimum_payment_due"`
+	// PaymentDueDate is a calendar date, e.g. "2024-02-25".
+	PaymentDueDate string `json:"payment_due_date"`
+}
+
+// CreditLimitChanged announces a cardholder's credit limit, when their
+// credit account is opened and whenever the limit changes.
+type CreditLimitChanged struct {
+	UserID      string    `json:"user_id"`
+	CreditLimit float64   `json:"credit_limit"`
+	ChangedAt   time.Time `json:"changed_at"`
+}
+
+// PaymentRequested starts the payment saga for a cardholder paying down
+// their balance.
+type PaymentRequested struct {
+	PaymentID string  `json:"payment_id"`
+	UserID    string  `json:"user_id"`
+	Amount    float64 `json:"amount"`
+}
+
+// PaymentAllocation is the part of a payment applied to one balance bucket.
+type PaymentAllocation struct {
+	Bucket string  `json:"bucket"`
+	Amount float64 `json:"amount"`
+}
+
+// PaymentPosted reports a payment credited to the ledger. Only the
+// principal part restores available credit.
+type PaymentPosted struct {
+	PaymentID       string              `json:"payment_id"`
+	UserID          string              `json:"user_id"`
+	Amount          float64             `json:"amount"`
+	PrincipalAmount float64             `json:"principal_amount"`
+	Allocations     []PaymentAllocation `json:"allocations"`
+}
+
+type PaymentFailed struct {
+	PaymentID string `json:"payment_id"`
+	UserID    string `json:"user_id"`
+	Reason    string `json:"reason"`
+}
+
+type PaymentSucceeded struct {
+	PaymentID      string  `json:"payment_id"`
+	UserID         string  `json:"user_id"`
+	Amount         float64 `json:"amount"`
+	RestoredCredit float64 `json:"restored_credit"`
+}
+
+// PaymentReturnRequested reverses a payment that was returned, e.g. for
+// insufficient funds at the paying bank.
+// PaymentReturnRequested reverses a payment returned by the paying bank.
+// UserID is the cardholder who made it, so the return is ordered with their
+// other payments.
+type PaymentReturnRequested struct {
+	PaymentID string `json:"payment_id"`
+	UserID    string `json:"user_id"`
+	Reason    string `json:"reason"`
+}
+
+type PaymentReturnPosted struct {
+	PaymentID       string  `json:"payment_id"`
+	UserID          string  `json:"user_id"`
+	Amount          float64 `json:"amount"`
+	PrincipalAmount float64 `json:"principal_amount"`
+	Reason          string  `json:"reason"`
+}
+
+type PaymentReturnFailed struct {
+	PaymentID string `json:"payment_id"`
+	Reason    string `json:"reason"`
+}
+
+type PaymentReturned struct {
+	PaymentID       string  `json:"payment_id"`
+	UserID          string  `json:"user_id"`
+	Amount          float64 `json:"amount"`
+	ReclaimedCredit float64 `json:"reclaimed_credit"`
+	Reason          string  `json:"reason"`
+}
+
+// DisputeTransitioned reports a dispute entering Status: OPENED,
+// EVIDENCE_REQUESTED, WON, LOST or FAILED. It is published on the topic of
+// that transition, e.g. dispute-opened.
+type DisputeTransitioned struct {
+	DisputeID     string  `json:"dispute_id"`
+	TransactionID string  `json:"transaction_id"`
+	UserID        string  `json:"user_id"`
+	Amount        float64 `json:"amount"`
+	Status        string  `json:"status"`
+	Reason        string  `json:"reason,omitempty"`
+}
+
+type DisputeLedgerPosted struct {
+	DisputeID     string `json:"dispute_id"`
+	TransactionID string `json:"transaction_id"`
+	Status        string `json:"status"`
+}
+
+type DisputeLedgerFailed struct {
+	DisputeID     string `json:"dispute_id"`
+	TransactionID string `json:"transaction_id"`
+	Status        string `json:"status"`
+	Reason        string `json:"reason"`
+}
+
+// CaptureRequested finalizes a transaction at the Amount a card network
+// cleared it for, in the billing currency. Transactions without an open
+// authorization are force-posted. A foreign-currency transaction cleared in
+// its transaction currency also carries the cleared TransactionAmount and
+// the settlement date's FXRate it was converted at.
+type CaptureRequested struct {
+	TransactionID       string    `json:"transaction_id"`
+	UserID              string    `json:"user_id"`
+	Amount              float64   `json:"amount"`
+	ClearingFile        string    `json:"clearing_file"`
+	SettlementDate      time.Time `json:"settlement_date"`
+	TransactionCurrency string    `json:"transaction_currency,omitempty"`
+	TransactionAmount   float64   `json:"transaction_amount,omitempty"`
+	FXRate              float64   `json:"fx_rate,omitempty"`
+}
+
+// CaptureSucceeded reports a captured transaction. Adjustment is how much
+// the captured Amount differs from what was held.
+type CaptureSucceeded struct {
+	TransactionID string  `json:"transaction_id"`
+	UserID        string  `json:"user_id"`
+	Amount        float64 `json:"amount"`
+	Adjustment    float64 `json:"adjustment"`
+	ForcePosted   bool    `json:"force_posted"`
+}
+
+type CaptureFailed struct {
+	TransactionID string `json:"transaction_id"`
+	Reason        string `json:"reason"`
+}